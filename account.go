@@ -0,0 +1,81 @@
+package lambda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// STSClient client to communicate with the AWS STS.
+type STSClient interface {
+	GetCallerIdentity(
+		ctx context.Context, input *sts.GetCallerIdentityInput, optFns ...func(*sts.Options),
+	) (*sts.GetCallerIdentityOutput, error)
+}
+
+// callerAccount resolves the lambda's account ID, the ID is cached after the first successful call.
+type callerAccount struct {
+	client STSClient
+
+	mu sync.Mutex
+	id string
+}
+
+func (a *callerAccount) accountID(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.id != "" {
+		return a.id, nil
+	}
+
+	v, err := a.client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", fmt.Errorf("get caller identity: %w", err)
+	}
+
+	id := aws.ToString(v.Account)
+	if id == "" {
+		return "", errors.New("caller identity has no account ID")
+	}
+	a.id = id
+
+	return id, nil
+}
+
+// checkSecretAccount checks that the secret belongs to the lambda's account.
+// The check is skipped if the secret is identified by its name instead of the ARN.
+func (a *callerAccount) checkSecretAccount(ctx context.Context, secretARN string) error {
+	secretAccount, ok := arnAccountID(secretARN)
+	if !ok {
+		return nil
+	}
+
+	id, err := a.accountID(ctx)
+	if err != nil {
+		return err
+	}
+
+	if secretAccount != id {
+		return errors.New(
+			"secret " + secretARN + " belongs to the account " + secretAccount + ", the lambda runs in the account " +
+				id + "; set AllowCrossAccount to rotate secrets of other accounts",
+		)
+	}
+
+	return nil
+}
+
+// arnAccountID extracts the account ID from the ARN, e.g. arn:aws:secretsmanager:us-east-1:000000000000:secret:foo.
+func arnAccountID(arn string) (string, bool) {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 || parts[0] != "arn" {
+		return "", false
+	}
+	return parts[4], true
+}
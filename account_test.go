@@ -0,0 +1,141 @@
+package lambda
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+type mockSTSClient struct {
+	account string
+	err     error
+	calls   int
+}
+
+func (m *mockSTSClient) GetCallerIdentity(
+	context.Context, *sts.GetCallerIdentityInput, ...func(*sts.Options),
+) (*sts.GetCallerIdentityOutput, error) {
+	m.calls++
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &sts.GetCallerIdentityOutput{Account: aws.String(m.account)}, nil
+}
+
+func Test_callerAccount_checkSecretAccount(t *testing.T) {
+	tests := []struct {
+		name      string
+		client    *mockSTSClient
+		secretARN string
+		wantErr   bool
+		wantCalls int
+	}{
+		{
+			name:      "happy path: same account",
+			client:    &mockSTSClient{account: "000000000000"},
+			secretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			wantCalls: 1,
+		},
+		{
+			name:      "happy path: secret name is not checked",
+			client:    &mockSTSClient{account: "000000000000"},
+			secretARN: "foo/bar",
+		},
+		{
+			name:      "unhappy path: account mismatch",
+			client:    &mockSTSClient{account: "111111111111"},
+			secretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			wantErr:   true,
+			wantCalls: 1,
+		},
+		{
+			name:      "unhappy path: STS error",
+			client:    &mockSTSClient{err: errors.New("foo")},
+			secretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			wantErr:   true,
+			wantCalls: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				a := &callerAccount{client: tt.client}
+				if err := a.checkSecretAccount(context.TODO(), tt.secretARN); (err != nil) != tt.wantErr {
+					t.Errorf("checkSecretAccount() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if tt.client.calls != tt.wantCalls {
+					t.Errorf("checkSecretAccount() called STS %d times, want %d", tt.client.calls, tt.wantCalls)
+				}
+			},
+		)
+	}
+}
+
+func Test_callerAccount_cached(t *testing.T) {
+	client := &mockSTSClient{account: "000000000000"}
+	a := &callerAccount{client: client}
+	for i := 0; i < 2; i++ {
+		if err := a.checkSecretAccount(
+			context.TODO(), "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+		); err != nil {
+			t.Fatalf("checkSecretAccount() unexpected error = %v", err)
+		}
+	}
+	if client.calls != 1 {
+		t.Errorf("account ID shall be cached, STS called %d times", client.calls)
+	}
+}
+
+func TestNewHandler_crossAccount(t *testing.T) {
+	tests := []struct {
+		name              string
+		allowCrossAccount bool
+		wantErr           bool
+	}{
+		{
+			name:    "unhappy path: secret of other account",
+			wantErr: true,
+		},
+		{
+			name:              "happy path: cross account rotation is allowed",
+			allowCrossAccount: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				h, err := NewHandler(
+					Config{
+						SecretsmanagerClient: &mockSecretsmanagerClient{
+							secretAWSCurrent: placeholderSecretUserStr,
+							secretByID: map[string]map[string]string{
+								"foo": {"AWSCURRENT": placeholderSecretUserStr},
+							},
+						},
+						ServiceClient:     &mockDBClient{},
+						SecretObj:         &mockObj{},
+						STSClient:         &mockSTSClient{account: "111111111111"},
+						AllowCrossAccount: tt.allowCrossAccount,
+						Metrics:           NoopMetrics{},
+					},
+				)
+				if err != nil {
+					t.Fatalf("NewHandler() unexpected error = %v", err)
+				}
+
+				err = h(
+					context.TODO(), SecretsmanagerTriggerPayload{
+						SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+						Step:      "validateCurrent",
+					},
+				)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("handler() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			},
+		)
+	}
+}
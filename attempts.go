@@ -0,0 +1,95 @@
+package lambda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+// TagAttempts the secret's tag to track the rotation attempts, its value is formatted as "<token>:<attempts>".
+const TagAttempts = "aws-lambda-secret-rotation:attempts"
+
+// metricRotationGaveUp the counter of the rotations abandoned after MaxAttempts.
+const metricRotationGaveUp = "RotationGaveUp"
+
+// ErrMaxAttemptsExceeded the rotation is abandoned because the number of attempts exceeded Config.MaxAttempts.
+var ErrMaxAttemptsExceeded = errors.New("maximum number of rotation attempts exceeded")
+
+// SecretsmanagerTaggingClient client to tag the secrets in AWS Secretsmanager, it's required for Config.MaxAttempts.
+type SecretsmanagerTaggingClient interface {
+	TagResource(
+		ctx context.Context, input *secretsmanager.TagResourceInput, optFns ...func(*secretsmanager.Options),
+	) (*secretsmanager.TagResourceOutput, error)
+}
+
+// countAttempt increments the number of the rotation attempts for the token stored in the secret's tag,
+// and fails with ErrMaxAttemptsExceeded once the attempts exceed Config.MaxAttempts. The give-up is reported
+// by the metric and the DLQClient once, the following attempts fail silently.
+func countAttempt(ctx context.Context, event SecretsmanagerTriggerPayload, cfg Config) error {
+	v, err := cfg.SecretsmanagerClient.DescribeSecret(
+		ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(event.SecretARN)},
+	)
+	if err != nil {
+		return fmt.Errorf("describe secret %s: %w", event.SecretARN, err)
+	}
+
+	errGaveUp := fmt.Errorf(
+		"%w: %d attempts to rotate the version %s of the secret %s", ErrMaxAttemptsExceeded, cfg.MaxAttempts,
+		event.Token, event.SecretARN,
+	)
+
+	attempt := parseAttempts(v.Tags, event.Token) + 1
+	if attempt > cfg.MaxAttempts+1 {
+		return errGaveUp
+	}
+
+	if _, err := cfg.SecretsmanagerClient.(SecretsmanagerTaggingClient).TagResource(
+		ctx, &secretsmanager.TagResourceInput{
+			SecretId: aws.String(event.SecretARN),
+			Tags: []types.Tag{
+				{
+					Key:   aws.String(TagAttempts),
+					Value: aws.String(event.Token + ":" + strconv.Itoa(attempt)),
+				},
+			},
+		},
+	); err != nil {
+		return fmt.Errorf("tag the secret %s: %w", event.SecretARN, err)
+	}
+
+	if attempt > cfg.MaxAttempts {
+		cfg.metrics().IncCounter(metricRotationGaveUp, map[string]string{"Step": event.Step})
+		notifyDLQ(ctx, cfg.DLQClient, event, FailureCategoryMaxAttemptsExceeded, errGaveUp)
+		return errGaveUp
+	}
+
+	return nil
+}
+
+// parseAttempts returns the number of the rotation attempts for the token, the attempts of other tokens are ignored.
+func parseAttempts(tags []types.Tag, token string) int {
+	for _, tag := range tags {
+		if aws.ToString(tag.Key) != TagAttempts {
+			continue
+		}
+
+		v := aws.ToString(tag.Value)
+		i := strings.LastIndex(v, ":")
+		if i < 0 || v[:i] != token {
+			return 0
+		}
+
+		o, err := strconv.Atoi(v[i+1:])
+		if err != nil {
+			return 0
+		}
+		return o
+	}
+	return 0
+}
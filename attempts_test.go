@@ -0,0 +1,153 @@
+package lambda
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+func Test_parseAttempts(t *testing.T) {
+	tests := []struct {
+		name  string
+		tags  []types.Tag
+		token string
+		want  int
+	}{
+		{
+			name:  "no tags",
+			token: "foo",
+		},
+		{
+			name:  "attempts of the token",
+			tags:  []types.Tag{{Key: aws.String(TagAttempts), Value: aws.String("foo:2")}},
+			token: "foo",
+			want:  2,
+		},
+		{
+			name:  "attempts of other token",
+			tags:  []types.Tag{{Key: aws.String(TagAttempts), Value: aws.String("bar:2")}},
+			token: "foo",
+		},
+		{
+			name:  "faulty tag",
+			tags:  []types.Tag{{Key: aws.String(TagAttempts), Value: aws.String("foo:bar")}},
+			token: "foo",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if got := parseAttempts(tt.tags, tt.token); got != tt.want {
+					t.Errorf("parseAttempts() = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestNewHandler_MaxAttempts(t *testing.T) {
+	const maxAttempts = 2
+
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		secretByID: map[string]map[string]string{
+			"foo": {"AWSCURRENT": placeholderSecretUserStr},
+			"bar": {"AWSPENDING": placeholderSecretUserStr},
+		},
+		rotationEnabled: aws.Bool(true),
+		// the stages are not returned with the secret value, hence createSecret does not skip
+		emptyVersionStages: true,
+	}
+	metrics := &mockMetrics{}
+
+	h, err := NewHandler(
+		Config{
+			SecretsmanagerClient: &mockFailingPutClient{mockSecretsmanagerClient: client, failures: maxAttempts + 1},
+			ServiceClient:        &mockDBClient{},
+			SecretObj:            &mockObj{},
+			MaxAttempts:          maxAttempts,
+			Metrics:              metrics,
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewHandler() unexpected error = %v", err)
+	}
+
+	event := SecretsmanagerTriggerPayload{
+		SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+		Token:     "bar",
+		Step:      "createSecret",
+	}
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		err := h(context.TODO(), event)
+		if err == nil || errors.Is(err, ErrMaxAttemptsExceeded) {
+			t.Fatalf("attempt %d: handler() error = %v, want the staging error", attempt, err)
+		}
+	}
+
+	if err := h(context.TODO(), event); !errors.Is(err, ErrMaxAttemptsExceeded) {
+		t.Errorf("handler() error = %v, want %v", err, ErrMaxAttemptsExceeded)
+	}
+	if got := metrics.countCounter(metricRotationGaveUp); got != 1 {
+		t.Errorf("handler() recorded %d %s metrics, want 1", got, metricRotationGaveUp)
+	}
+}
+
+func Test_createSecret_MaxAttemptsPendingExists(t *testing.T) {
+	const maxAttempts = 2
+
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		secretByID: map[string]map[string]string{
+			"foo": {"AWSCURRENT": placeholderSecretUserStr},
+			"bar": {"AWSPENDING": placeholderSecretUserNewStr},
+		},
+	}
+
+	event := SecretsmanagerTriggerPayload{
+		SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+		Token:     "bar",
+		Step:      "createSecret",
+	}
+	cfg := Config{
+		SecretsmanagerClient: client,
+		ServiceClient:        &mockDBClient{},
+		SecretObj:            &mockObj{},
+		MaxAttempts:          maxAttempts,
+		Metrics:              NoopMetrics{},
+	}
+
+	// the rotation is retried from createSecret when the following steps fail
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if err := createSecret(context.TODO(), event, cfg); err != nil {
+			t.Fatalf("attempt %d: createSecret() unexpected error = %v", attempt, err)
+		}
+		if got := parseAttempts(client.tags, event.Token); got != attempt {
+			t.Errorf("attempt %d: createSecret() counted %d attempts, want %d", attempt, got, attempt)
+		}
+	}
+
+	if err := createSecret(context.TODO(), event, cfg); !errors.Is(err, ErrMaxAttemptsExceeded) {
+		t.Errorf("createSecret() error = %v, want %v", err, ErrMaxAttemptsExceeded)
+	}
+}
+
+// mockNoTaggingClient hides the mock's TagResource method.
+type mockNoTaggingClient struct {
+	SecretsmanagerClient
+}
+
+func TestNewHandler_MaxAttemptsWithoutTaggingClient(t *testing.T) {
+	if _, err := NewHandler(
+		Config{
+			SecretsmanagerClient: mockNoTaggingClient{&mockSecretsmanagerClient{}},
+			SecretObj:            &mockObj{},
+			MaxAttempts:          1,
+		},
+	); err == nil {
+		t.Errorf("NewHandler() expected error")
+	}
+}
@@ -0,0 +1,145 @@
+package lambda
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+)
+
+// Outcomes of the audited steps.
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+)
+
+// AuditRecord defines the tamper-evident record of the rotation, e.g. the evidence for the compliance audit.
+// The record is appended when finishSecret promotes the new version to the stage AWSCURRENT.
+// The record never includes the secret's values. The records are chained: every record includes the hash
+// of the preceding record, hence the modification, or removal of any record breaks the chain, see VerifyAuditChain.
+type AuditRecord struct {
+	// SecretARN the secret's ARN, or name.
+	SecretARN string `json:"secret_arn"`
+	// Token the rotation token, i.e. the version ID.
+	Token string `json:"token"`
+	// Step the rotation step.
+	Step string `json:"step"`
+	// Outcome the step's outcome, AuditOutcomeSuccess, or AuditOutcomeFailure if the promotion succeeded,
+	// but the step failed afterwards, e.g. when the promotion is verified.
+	Outcome string `json:"outcome"`
+	// StartedAt the time the step started in RFC3339 format with nanoseconds.
+	StartedAt string `json:"started_at"`
+	// FinishedAt the time the step finished in RFC3339 format with nanoseconds.
+	FinishedAt string `json:"finished_at"`
+	// PrevHash the hash of the preceding record, empty for the first record of the chain.
+	PrevHash string `json:"prev_hash"`
+	// Hash the hex-encoded SHA-256 hash of the record's attributes other than Hash.
+	Hash string `json:"hash"`
+}
+
+// AuditStore stores the chain of the audit records, e.g. in the S3 bucket with the object lock.
+// The store must serialise the appends, e.g. by the conditional write which fails unless the record's PrevHash
+// is the hash of the last stored record, to keep the chain linear when the rotations run concurrently.
+type AuditStore interface {
+	// LastHash returns the hash of the last stored record, or empty string if no records are stored.
+	LastHash(ctx context.Context) (string, error)
+
+	// Append stores the record as the last record of the chain.
+	Append(ctx context.Context, record AuditRecord) error
+}
+
+// computeAuditHash returns the hash of the record's attributes other than Hash.
+func computeAuditHash(record AuditRecord) (string, error) {
+	record.Hash = ""
+	b, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// appendAuditRecord chains the record to the last record of the store, and appends it.
+func appendAuditRecord(ctx context.Context, store AuditStore, record AuditRecord) error {
+	prevHash, err := store.LastHash(ctx)
+	if err != nil {
+		return err
+	}
+	record.PrevHash = prevHash
+
+	if record.Hash, err = computeAuditHash(record); err != nil {
+		return err
+	}
+	return store.Append(ctx, record)
+}
+
+// recordPromotion records that the invocation promoted the pending version to the stage AWSCURRENT.
+func recordPromotion(ctx context.Context) {
+	r, ok := ctx.Value(featureRecorderCtxKey{}).(*featureRecorder)
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.promoted = true
+}
+
+// isPromoted reports whether the invocation promoted the pending version to the stage AWSCURRENT.
+func (r *featureRecorder) isPromoted() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.promoted
+}
+
+// recordAudit appends the audit record of the rotation if the invocation promoted the pending version,
+// i.e. once per rotation, the outcome reports whether the steps which follow the promotion succeeded.
+// The store's error is logged only to report the step's outcome.
+func recordAudit(
+	ctx context.Context, store AuditStore, event SecretsmanagerTriggerPayload, features *featureRecorder,
+	start time.Time, d time.Duration, err error,
+) {
+	if !features.isPromoted() {
+		return
+	}
+
+	outcome := AuditOutcomeSuccess
+	if err != nil {
+		outcome = AuditOutcomeFailure
+	}
+
+	if errAudit := appendAuditRecord(
+		ctx, store, AuditRecord{
+			SecretARN:  event.SecretARN,
+			Token:      event.Token,
+			Step:       event.Step,
+			Outcome:    outcome,
+			StartedAt:  start.UTC().Format(time.RFC3339Nano),
+			FinishedAt: start.Add(d).UTC().Format(time.RFC3339Nano),
+		},
+	); errAudit != nil {
+		log.Println("[ERROR] failed to append the audit record: " + errAudit.Error())
+	}
+}
+
+// VerifyAuditChain verifies that the records form the unbroken chain in the given order, and that none of them
+// was modified. The first record may be preceded by the records not given, e.g. to verify the part of the chain.
+func VerifyAuditChain(records []AuditRecord) error {
+	for i, record := range records {
+		hash, err := computeAuditHash(record)
+		if err != nil {
+			return err
+		}
+		if hash != record.Hash {
+			return errors.New("audit record " + strconv.Itoa(i) + " was modified")
+		}
+		if i > 0 && record.PrevHash != records[i-1].Hash {
+			return errors.New("audit record " + strconv.Itoa(i) + " is not linked to the preceding record")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package lambda
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// mockAuditStore stores the audit records in memory.
+type mockAuditStore struct {
+	records []AuditRecord
+}
+
+func (m *mockAuditStore) LastHash(context.Context) (string, error) {
+	if len(m.records) == 0 {
+		return "", nil
+	}
+	return m.records[len(m.records)-1].Hash, nil
+}
+
+func (m *mockAuditStore) Append(_ context.Context, record AuditRecord) error {
+	m.records = append(m.records, record)
+	return nil
+}
+
+func TestNewHandler_AuditStore(t *testing.T) {
+	store := &mockAuditStore{}
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		secretByID: map[string]map[string]string{
+			"foo": {"AWSCURRENT": placeholderSecretUserStr},
+		},
+		rotationEnabled: aws.Bool(true),
+		// the stages are not returned with the secret value, hence createSecret does not skip
+		emptyVersionStages: true,
+	}
+
+	h, err := NewHandler(
+		Config{
+			SecretsmanagerClient: client,
+			ServiceClient:        &mockPasswordsClient{passwords: []string{"foo-password", "bar-password"}},
+			SecretObj:            &mockObj{},
+			Metrics:              NoopMetrics{},
+			AuditStore:           store,
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewHandler() unexpected error = %v", err)
+	}
+
+	steps := []string{"createSecret", "setSecret", "testSecret", "finishSecret"}
+	for _, token := range []string{"bar", "baz"} {
+		// Secrets Manager stages the version to rotate before the rotation starts
+		client.secretByID[token] = map[string]string{"AWSPENDING": placeholderSecretUserStr}
+		for _, step := range steps {
+			if err := h(
+				context.TODO(), SecretsmanagerTriggerPayload{
+					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+					Token:     token,
+					Step:      step,
+				},
+			); err != nil {
+				t.Fatalf("handler() token %s step %s unexpected error = %v", token, step, err)
+			}
+		}
+	}
+
+	// the repeated finishSecret does not promote the version
+	if err := h(
+		context.TODO(), SecretsmanagerTriggerPayload{
+			SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			Token:     "baz",
+			Step:      "finishSecret",
+		},
+	); err != nil {
+		t.Fatalf("handler() unexpected error = %v", err)
+	}
+
+	// the record is appended once per rotation
+	tokens := []string{"bar", "baz"}
+	if len(store.records) != len(tokens) {
+		t.Fatalf("audit records = %d, want %d", len(store.records), len(tokens))
+	}
+	if store.records[0].PrevHash != "" {
+		t.Errorf("the first audit record shall not be linked, PrevHash = %s", store.records[0].PrevHash)
+	}
+	for i, record := range store.records {
+		if record.Outcome != AuditOutcomeSuccess || record.Step != "finishSecret" || record.Token != tokens[i] {
+			t.Errorf("audit record %d = %+v", i, record)
+		}
+		if i > 0 && record.PrevHash != store.records[i-1].Hash {
+			t.Errorf("audit record %d is not linked to the preceding record", i)
+		}
+	}
+	if err := VerifyAuditChain(store.records); err != nil {
+		t.Errorf("VerifyAuditChain() unexpected error = %v", err)
+	}
+}
+
+func TestVerifyAuditChain(t *testing.T) {
+	store := &mockAuditStore{}
+	for _, token := range []string{"bar", "baz"} {
+		if err := appendAuditRecord(
+			context.TODO(), store, AuditRecord{
+				SecretARN: "foo", Token: token, Step: "finishSecret", Outcome: AuditOutcomeSuccess,
+			},
+		); err != nil {
+			t.Fatalf("appendAuditRecord() unexpected error = %v", err)
+		}
+	}
+
+	t.Run(
+		"modified record", func(t *testing.T) {
+			records := append([]AuditRecord(nil), store.records...)
+			records[0].Outcome = AuditOutcomeFailure
+			if err := VerifyAuditChain(records); err == nil || !strings.Contains(err.Error(), "modified") {
+				t.Errorf("VerifyAuditChain() error = %v, want the modified record", err)
+			}
+		},
+	)
+
+	t.Run(
+		"removed record", func(t *testing.T) {
+			records := []AuditRecord{store.records[1], store.records[1]}
+			if err := VerifyAuditChain(records); err == nil || !strings.Contains(err.Error(), "not linked") {
+				t.Errorf("VerifyAuditChain() error = %v, want the broken link", err)
+			}
+		},
+	)
+}
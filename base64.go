@@ -0,0 +1,78 @@
+package lambda
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// base64SecretClient base64-encodes the secret's value before it's stored, and decodes it on read,
+// hence the secret is deserialised from, and serialised to JSON regardless of the encoding.
+// The values which are JSON objects already are read as is, e.g. the versions stored before the encoding was activated.
+type base64SecretClient struct {
+	SecretsmanagerClient
+}
+
+func (c base64SecretClient) GetSecretValue(
+	ctx context.Context, input *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.GetSecretValueOutput, error) {
+	o, err := c.SecretsmanagerClient.GetSecretValue(ctx, input, optFns...)
+	if err != nil || o == nil || o.SecretString == nil {
+		return o, err
+	}
+
+	v, err := decodeSecretString(*o.SecretString)
+	if err != nil {
+		return nil, fmt.Errorf("decode secret: %w", err)
+	}
+
+	out := *o
+	out.SecretString = aws.String(v)
+	return &out, nil
+}
+
+func (c base64SecretClient) PutSecretValue(
+	ctx context.Context, input *secretsmanager.PutSecretValueInput, optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.PutSecretValueOutput, error) {
+	if input.SecretString == nil {
+		return c.SecretsmanagerClient.PutSecretValue(ctx, input, optFns...)
+	}
+
+	in := *input
+	in.SecretString = aws.String(encodeSecretString(*input.SecretString))
+	return c.SecretsmanagerClient.PutSecretValue(ctx, &in, optFns...)
+}
+
+func (c base64SecretClient) TagResource(
+	ctx context.Context, input *secretsmanager.TagResourceInput, optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.TagResourceOutput, error) {
+	client, ok := c.SecretsmanagerClient.(SecretsmanagerTaggingClient)
+	if !ok {
+		return nil, errors.New("SecretsmanagerClient does not implement SecretsmanagerTaggingClient")
+	}
+	return client.TagResource(ctx, input, optFns...)
+}
+
+// encodeSecretString encodes the serialised secret with the standard base64 encoding.
+func encodeSecretString(secret string) string {
+	return base64.StdEncoding.EncodeToString([]byte(secret))
+}
+
+// decodeSecretString decodes the base64-encoded secret, the secret which is the JSON object is returned as is.
+func decodeSecretString(secret string) (string, error) {
+	var v map[string]json.RawMessage
+	if json.Unmarshal([]byte(secret), &v) == nil {
+		return secret, nil
+	}
+
+	o, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return "", errors.New("the secret is neither JSON object, nor base64-encoded: " + err.Error())
+	}
+	return string(o), nil
+}
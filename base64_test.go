@@ -0,0 +1,138 @@
+package lambda
+
+import (
+	"context"
+	"encoding/base64"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+func Test_decodeSecretString(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "base64-encoded secret",
+			secret: encodeSecretString(placeholderSecretUserStr),
+			want:   placeholderSecretUserStr,
+		},
+		{
+			name:   "JSON secret is read as is",
+			secret: placeholderSecretUserStr,
+			want:   placeholderSecretUserStr,
+		},
+		{
+			name:    "neither JSON, nor base64",
+			secret:  "{foo",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := decodeSecretString(tt.secret)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("decodeSecretString() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if got != tt.want {
+					t.Errorf("decodeSecretString() got = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func Test_base64SecretClient_roundTrip(t *testing.T) {
+	mock := &mockSecretsmanagerClient{secretAWSCurrent: placeholderSecretUserStr}
+	c := base64SecretClient{SecretsmanagerClient: mock}
+
+	if _, err := c.PutSecretValue(
+		context.TODO(), &secretsmanager.PutSecretValueInput{
+			SecretId:           aws.String("foo"),
+			ClientRequestToken: aws.String("bar"),
+			SecretString:       aws.String(placeholderSecretUserNewStr),
+			VersionStages:      []string{StagePending},
+		},
+	); err != nil {
+		t.Fatalf("PutSecretValue() unexpected error = %v", err)
+	}
+
+	stored := mock.secretByID["bar"][StagePending]
+	if want := base64.StdEncoding.EncodeToString([]byte(placeholderSecretUserNewStr)); stored != want {
+		t.Errorf("stored secret = %v, want %v", stored, want)
+	}
+
+	o, err := c.GetSecretValue(
+		context.TODO(), &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String("foo"), VersionId: aws.String("bar"), VersionStage: aws.String(StagePending),
+		},
+	)
+	if err != nil {
+		t.Fatalf("GetSecretValue() unexpected error = %v", err)
+	}
+	if got := aws.ToString(o.SecretString); got != placeholderSecretUserNewStr {
+		t.Errorf("GetSecretValue() got = %v, want %v", got, placeholderSecretUserNewStr)
+	}
+}
+
+func TestNewHandler_SecretBase64Encoded(t *testing.T) {
+	current := encodeSecretString(placeholderSecretUserStr)
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: current,
+		secretByID: map[string]map[string]string{
+			"foo": {StageCurrent: current},
+			"bar": {StagePending: current},
+		},
+		rotationEnabled: aws.Bool(true),
+		// the stages are not returned with the secret value, hence createSecret does not skip
+		emptyVersionStages: true,
+	}
+	serviceClient := &mockCapturingClient{}
+
+	h, err := NewHandler(
+		Config{
+			SecretsmanagerClient: client,
+			ServiceClient:        serviceClient,
+			SecretObj:            &mockObj{},
+			Metrics:              NoopMetrics{},
+			SecretBase64Encoded:  true,
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewHandler() unexpected error = %v", err)
+	}
+
+	event := SecretsmanagerTriggerPayload{
+		SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+		Token:     "bar",
+	}
+	for _, step := range []string{"createSecret", "testSecret"} {
+		event.Step = step
+		if err := h(context.TODO(), event); err != nil {
+			t.Fatalf("handler() step %s unexpected error = %v", step, err)
+		}
+	}
+
+	stored, err := base64.StdEncoding.DecodeString(client.secretByID["bar"][StagePending])
+	if err != nil {
+		t.Fatalf("stored secret is not base64-encoded: %v", err)
+	}
+	var got mockObj
+	if err := extractSecretObject(
+		&secretsmanager.GetSecretValueOutput{SecretString: aws.String(string(stored))}, &got, false,
+	); err != nil {
+		t.Fatalf("stored secret is not JSON: %v", err)
+	}
+	if got.Password != placeholderSecretUserNewStr {
+		t.Errorf("stored password = %v, want %v", got.Password, placeholderSecretUserNewStr)
+	}
+	if !reflect.DeepEqual(serviceClient.tested, []string{placeholderSecretUserNewStr}) {
+		t.Errorf("tested passwords = %v, want %v", serviceClient.tested, []string{placeholderSecretUserNewStr})
+	}
+}
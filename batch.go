@@ -0,0 +1,68 @@
+package lambda
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BatchError defines the errors of the batch rotation by the secret ARN.
+type BatchError struct {
+	Errors map[string]error
+}
+
+// arns returns the sorted ARNs of the failed secrets.
+func (e *BatchError) arns() []string {
+	arns := make([]string, 0, len(e.Errors))
+	for arn := range e.Errors {
+		arns = append(arns, arn)
+	}
+	sort.Strings(arns)
+	return arns
+}
+
+func (e *BatchError) Error() string {
+	arns := e.arns()
+	o := make([]string, len(arns))
+	for i, arn := range arns {
+		o[i] = "secret " + arn + ": " + e.Errors[arn].Error()
+	}
+	return strconv.Itoa(len(arns)) + " secrets failed to rotate: " + strings.Join(o, "; ")
+}
+
+// Unwrap returns the errors of the secrets ordered by the secret ARN, so the errors are matched
+// by errors.Is and errors.As built with Go 1.20, or later, e.g. errors.Is(err, ErrCircuitOpen).
+func (e *BatchError) Unwrap() []error {
+	arns := e.arns()
+	o := make([]error, len(arns))
+	for i, arn := range arns {
+		o[i] = e.Errors[arn]
+	}
+	return o
+}
+
+// RotateBatch processes the payloads in order, e.g. submitted by a custom scheduler, isolating the failures by secret:
+// once the payload of a secret fails, the remaining payloads of the secret are skipped, while other secrets proceed.
+// It returns *BatchError with the failures by the secret ARN.
+func RotateBatch(ctx context.Context, events []SecretsmanagerTriggerPayload, cfg Config) error {
+	handler, err := NewHandler(cfg)
+	if err != nil {
+		return err
+	}
+
+	errs := map[string]error{}
+	for _, event := range events {
+		if _, ok := errs[event.SecretARN]; ok {
+			continue
+		}
+		if err := handler(ctx, event); err != nil {
+			errs[event.SecretARN] = err
+		}
+	}
+
+	if len(errs) > 0 {
+		return &BatchError{Errors: errs}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package lambda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestRotateBatch(t *testing.T) {
+	const (
+		arnOK     = "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/ok-5BKPC8"
+		arnFailed = "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/failed-5BKPC8"
+	)
+	errSentinel := errors.New("sentinel")
+
+	serviceClient := &mockCallsRecordingClient{}
+	failingClient := &mockCallsRecordingClient{mockDBClient: mockDBClient{testErr: errSentinel}}
+
+	err := RotateBatch(
+		context.TODO(), []SecretsmanagerTriggerPayload{
+			{SecretARN: arnFailed, Step: "validateCurrent"},
+			{SecretARN: arnOK, Step: "validateCurrent"},
+			{SecretARN: arnFailed, Step: "validateCurrent"},
+		}, Config{
+			SecretsmanagerClient: &mockSecretsmanagerClient{
+				secretAWSCurrent: placeholderSecretUserStr,
+				secretByID: map[string]map[string]string{
+					"foo": {"AWSCURRENT": placeholderSecretUserStr},
+				},
+			},
+			ServiceClients: map[string]ServiceClient{
+				"/ok-":     serviceClient,
+				"/failed-": failingClient,
+			},
+			SecretObj: &mockObj{},
+			Metrics:   NoopMetrics{},
+		},
+	)
+
+	var errBatch *BatchError
+	if !errors.As(err, &errBatch) {
+		t.Fatalf("RotateBatch() error = %v, want *BatchError", err)
+	}
+	if len(errBatch.Errors) != 1 {
+		t.Fatalf("RotateBatch() failed secrets = %v, want 1", errBatch.Errors)
+	}
+	if !errors.Is(errBatch.Errors[arnFailed], errSentinel) {
+		t.Errorf(
+			"RotateBatch() error of the secret %s = %v, want %v", arnFailed, errBatch.Errors[arnFailed], errSentinel,
+		)
+	}
+
+	if len(serviceClient.calls) != 1 {
+		t.Errorf("the secret %s shall be tested despite the failure of other secret", arnOK)
+	}
+	if len(failingClient.calls) != 1 {
+		t.Errorf("the payloads of the secret %s shall be skipped after the failure", arnFailed)
+	}
+}
+
+func TestRotateBatch_noFailures(t *testing.T) {
+	if err := RotateBatch(
+		context.TODO(), []SecretsmanagerTriggerPayload{
+			{SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8", Step: "validateCurrent"},
+		}, Config{
+			SecretsmanagerClient: &mockSecretsmanagerClient{secretAWSCurrent: placeholderSecretUserStr},
+			ServiceClient:        &mockDBClient{},
+			SecretObj:            &mockObj{},
+			Metrics:              NoopMetrics{},
+		},
+	); err != nil {
+		t.Errorf("RotateBatch() unexpected error = %v", err)
+	}
+}
+
+func TestBatchError_Unwrap(t *testing.T) {
+	errFoo, errBar, errBaz := errors.New("foo"), errors.New("bar"), errors.New("baz")
+	err := error(
+		&BatchError{Errors: map[string]error{"secret:bar": errBar, "secret:foo": fmt.Errorf("wrapped: %w", errFoo)}},
+	)
+
+	if !errors.Is(err, errFoo) || !errors.Is(err, errBar) {
+		t.Errorf("errors.Is() shall match the errors of every secret, err = %v", err)
+	}
+	if errors.Is(err, errBaz) {
+		t.Errorf("errors.Is() shall not match the error of no secret")
+	}
+
+	got := err.(interface{ Unwrap() []error }).Unwrap()
+	if len(got) != 2 || got[0] != errBar {
+		t.Errorf("Unwrap() = %v, want the errors ordered by the secret ARN", got)
+	}
+}
@@ -0,0 +1,206 @@
+package lambda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen the calls to the service are short-circuited because the service failed repeatedly,
+// e.g. the database was deleted.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// CircuitBreaker defines the circuit breaker around the ServiceClient's calls which connect to the service,
+// i.e. Set, Test and Finish. The circuit's state is kept by the handler, hence it's shared by the invocations
+// of the warm lambda's container. The test of the revoked password is not counted, because its failure
+// is expected, see Config.VerifyOldPasswordRevoked. Every secret has its own circuit, because one ServiceClient
+// usually serves the secrets of many targets, e.g. of different databases.
+type CircuitBreaker struct {
+	// FailureThreshold the number of the consecutive failures which open the circuit.
+	FailureThreshold int
+
+	// Window (optional) the period to count the consecutive failures in, the count is restarted by the failure
+	// which occurs after the period elapses since the first counted failure. The failures are counted
+	// regardless of the time between them if not set.
+	Window time.Duration
+
+	// OpenDuration the period the circuit stays open, the calls fail with ErrCircuitOpen meanwhile.
+	// The call after the period is let through: the circuit is closed if it succeeds, and reopened otherwise.
+	OpenDuration time.Duration
+}
+
+func (b CircuitBreaker) validate() error {
+	if b.FailureThreshold <= 0 {
+		return errors.New("CircuitBreaker.FailureThreshold must be positive")
+	}
+	if b.OpenDuration <= 0 {
+		return errors.New("CircuitBreaker.OpenDuration must be positive")
+	}
+	return nil
+}
+
+// circuitBreaker the state of the circuit.
+type circuitBreaker struct {
+	cfg CircuitBreaker
+	now func() time.Time
+
+	mu           sync.Mutex
+	failures     int
+	firstFailure time.Time
+	openedAt     time.Time
+}
+
+func newCircuitBreaker(cfg CircuitBreaker) *circuitBreaker {
+	return &circuitBreaker{cfg: cfg, now: time.Now}
+}
+
+// circuitBreakers the circuits keyed by the secret's ARN.
+type circuitBreakers struct {
+	cfg CircuitBreaker
+
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+func newCircuitBreakers(cfg CircuitBreaker) *circuitBreakers {
+	return &circuitBreakers{cfg: cfg, breakers: map[string]*circuitBreaker{}}
+}
+
+// get returns the circuit of the secret, it's created on the secret's first invocation.
+func (b *circuitBreakers) get(secretARN string) *circuitBreaker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	breaker, ok := b.breakers[secretARN]
+	if !ok {
+		breaker = newCircuitBreaker(b.cfg)
+		b.breakers[secretARN] = breaker
+	}
+	return breaker
+}
+
+// allow returns ErrCircuitOpen if the circuit is open.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openedAt.IsZero() {
+		return nil
+	}
+
+	if retryIn := b.cfg.OpenDuration - b.now().Sub(b.openedAt); retryIn > 0 {
+		return fmt.Errorf(
+			"%w: the service failed %d consecutive times, retry in %s", ErrCircuitOpen, b.failures,
+			retryIn.Round(time.Second),
+		)
+	}
+	return nil
+}
+
+// record records the outcome of the call to the service.
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		if !b.openedAt.IsZero() {
+			log.Println("[INFO] the service call succeeded, the circuit is closed")
+		}
+		b.failures, b.firstFailure, b.openedAt = 0, time.Time{}, time.Time{}
+		return
+	}
+
+	now := b.now()
+	if b.cfg.Window > 0 && b.failures > 0 && now.Sub(b.firstFailure) > b.cfg.Window {
+		b.failures = 0
+	}
+	if b.failures == 0 {
+		b.firstFailure = now
+	}
+	b.failures++
+
+	// the failed call after the open period reopens the circuit
+	if b.failures >= b.cfg.FailureThreshold || !b.openedAt.IsZero() {
+		b.openedAt = now
+		log.Println(
+			"[WARN] the service failed " + strconv.Itoa(b.failures) + " consecutive times, the circuit is open for " +
+				b.cfg.OpenDuration.String(),
+		)
+	}
+}
+
+// call calls the service if the circuit is closed, and records the outcome.
+func (b *circuitBreaker) call(ctx context.Context, fn func() error) error {
+	if err := b.allow(); err != nil {
+		RecordFeature(ctx, featureCircuitBreaker)
+		return err
+	}
+	err := fn()
+	b.record(err)
+	return err
+}
+
+// withCircuitBreaker wraps the ServiceClient's calls which connect to the service with the circuit breaker.
+// The client's implementation of ServiceFinisher is preserved.
+func withCircuitBreaker(client ServiceClient, breaker *circuitBreaker) ServiceClient {
+	c := circuitBreakerClient{client: client, breaker: breaker}
+	if _, ok := client.(ServiceFinisher); ok {
+		return circuitBreakerFinisherClient{c}
+	}
+	return c
+}
+
+type circuitBreakerClient struct {
+	client  ServiceClient
+	breaker *circuitBreaker
+}
+
+// withoutCircuitBreaker returns the ServiceClient wrapped by the circuit breaker, e.g. to call the service
+// when the failure is expected and must not open the circuit.
+func withoutCircuitBreaker(client ServiceClient) ServiceClient {
+	switch c := client.(type) {
+	case circuitBreakerClient:
+		return c.client
+	case circuitBreakerFinisherClient:
+		return c.client
+	default:
+		return client
+	}
+}
+
+// Create generates the secret without connecting to the service, hence it's not short-circuited.
+func (c circuitBreakerClient) Create(ctx context.Context, secret any) error {
+	return c.client.Create(ctx, secret)
+}
+
+func (c circuitBreakerClient) Set(ctx context.Context, secretCurrent, secretPending, secretPrevious any) error {
+	return c.breaker.call(
+		ctx, func() error {
+			return c.client.Set(ctx, secretCurrent, secretPending, secretPrevious)
+		},
+	)
+}
+
+func (c circuitBreakerClient) Test(ctx context.Context, secret any) error {
+	return c.breaker.call(
+		ctx, func() error {
+			return c.client.Test(ctx, secret)
+		},
+	)
+}
+
+type circuitBreakerFinisherClient struct {
+	circuitBreakerClient
+}
+
+func (c circuitBreakerFinisherClient) Finish(ctx context.Context, secretCurrent, secretPrevious any) error {
+	return c.breaker.call(
+		ctx, func() error {
+			return c.client.(ServiceFinisher).Finish(ctx, secretCurrent, secretPrevious)
+		},
+	)
+}
@@ -0,0 +1,206 @@
+package lambda
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// mockFlakyClient fails Test with err if set.
+type mockFlakyClient struct {
+	mockDBClient
+	err   error
+	calls int
+}
+
+func (m *mockFlakyClient) Test(context.Context, any) error {
+	m.calls++
+	return m.err
+}
+
+func Test_circuitBreakerClient(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	breaker := newCircuitBreaker(CircuitBreaker{FailureThreshold: 2, Window: time.Minute, OpenDuration: time.Minute})
+	breaker.now = func() time.Time { return now }
+
+	service := &mockFlakyClient{err: errors.New("project not found")}
+	client := withCircuitBreaker(service, breaker)
+
+	for i := 0; i < 2; i++ {
+		if err := client.Test(context.TODO(), &mockObj{}); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("Test() call %d error = %v, want the service's error", i+1, err)
+		}
+	}
+
+	// the circuit is open
+	if err := client.Test(context.TODO(), &mockObj{}); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Test() error = %v, want %v", err, ErrCircuitOpen)
+	}
+	if service.calls != 2 {
+		t.Errorf("the service is called %d times, want 2", service.calls)
+	}
+
+	// the failed call after the open period reopens the circuit
+	now = now.Add(time.Minute)
+	if err := client.Test(context.TODO(), &mockObj{}); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Test() error = %v, want the service's error", err)
+	}
+	if err := client.Test(context.TODO(), &mockObj{}); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Test() error = %v, want %v", err, ErrCircuitOpen)
+	}
+
+	// the succeeded call after the open period closes the circuit
+	now = now.Add(time.Minute)
+	service.err = nil
+	if err := client.Test(context.TODO(), &mockObj{}); err != nil {
+		t.Fatalf("Test() unexpected error = %v", err)
+	}
+
+	// the failures are counted from scratch
+	service.err = errors.New("project not found")
+	if err := client.Test(context.TODO(), &mockObj{}); errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Test() error = %v, want the service's error", err)
+	}
+	if service.calls != 5 {
+		t.Errorf("the service is called %d times, want 5", service.calls)
+	}
+}
+
+func Test_circuitBreaker_Window(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	breaker := newCircuitBreaker(CircuitBreaker{FailureThreshold: 2, Window: time.Minute, OpenDuration: time.Minute})
+	breaker.now = func() time.Time { return now }
+
+	breaker.record(errors.New("foo"))
+	now = now.Add(2 * time.Minute)
+	breaker.record(errors.New("foo"))
+
+	if err := breaker.allow(); err != nil {
+		t.Errorf("allow() error = %v, the failures outside the window shall not open the circuit", err)
+	}
+}
+
+func Test_withCircuitBreaker_ServiceFinisher(t *testing.T) {
+	breaker := newCircuitBreaker(CircuitBreaker{FailureThreshold: 1, OpenDuration: time.Minute})
+
+	if _, ok := withCircuitBreaker(&mockDBClient{}, breaker).(ServiceFinisher); ok {
+		t.Errorf("the client shall not implement ServiceFinisher")
+	}
+	if _, ok := withCircuitBreaker(&mockFinisherClient{}, breaker).(ServiceFinisher); !ok {
+		t.Errorf("the client shall implement ServiceFinisher")
+	}
+}
+
+func TestNewHandler_CircuitBreaker_perSecret(t *testing.T) {
+	failing := &mockFlakyClient{err: errors.New("project not found")}
+	h, err := NewHandler(
+		Config{
+			SecretsmanagerClient: &mockSecretsmanagerClient{
+				secretAWSCurrent: placeholderSecretUserStr,
+				secretByID: map[string]map[string]string{
+					"foo": {"AWSCURRENT": placeholderSecretUserStr},
+					"bar": {"AWSPENDING": placeholderSecretUserNewStr},
+				},
+				rotationEnabled: aws.Bool(true),
+			},
+			ServiceClient:  failing,
+			SecretObj:      &mockObj{},
+			Metrics:        NoopMetrics{},
+			CircuitBreaker: &CircuitBreaker{FailureThreshold: 1, OpenDuration: time.Minute},
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewHandler() unexpected error = %v", err)
+	}
+
+	event := SecretsmanagerTriggerPayload{
+		SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+		Token:     "bar",
+		Step:      "testSecret",
+	}
+	if err := h(context.TODO(), event); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("handler() error = %v, want the service's error", err)
+	}
+	if err := h(context.TODO(), event); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("handler() error = %v, want %v", err, ErrCircuitOpen)
+	}
+
+	// the other secrets served by the same client are not blocked
+	event.SecretARN = "arn:aws:secretsmanager:us-east-1:000000000000:secret:baz/bar-5BKPC8"
+	if err := h(context.TODO(), event); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("handler() error = %v, want the service's error", err)
+	}
+	if failing.calls != 2 {
+		t.Errorf("the service is called %d times, want 2", failing.calls)
+	}
+}
+
+// mockCircuitOpenClient fails Test with ErrCircuitOpen.
+type mockCircuitOpenClient struct {
+	mockDBClient
+}
+
+func (*mockCircuitOpenClient) Test(context.Context, any) error {
+	return ErrCircuitOpen
+}
+
+func Test_finishSecret_VerifyOldPasswordRevoked_circuitBreaker(t *testing.T) {
+	tests := []struct {
+		name          string
+		serviceClient ServiceClient
+		breaker       *circuitBreaker
+		wantErr       error
+	}{
+		{
+			name:          "happy path: the expected failure does not open the circuit",
+			serviceClient: &mockRevokingClient{revoked: placeholderPassword},
+			breaker:       newCircuitBreaker(CircuitBreaker{FailureThreshold: 1, OpenDuration: time.Minute}),
+		},
+		{
+			name:          "unhappy path: the open circuit is not the proof of revocation",
+			serviceClient: &mockCircuitOpenClient{},
+			wantErr:       ErrCircuitOpen,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				serviceClient := tt.serviceClient
+				if tt.breaker != nil {
+					serviceClient = withCircuitBreaker(serviceClient, tt.breaker)
+				}
+
+				err := finishSecret(
+					context.TODO(), SecretsmanagerTriggerPayload{
+						SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+						Token:     "bar",
+						Step:      "finishSecret",
+					}, Config{
+						SecretsmanagerClient: &mockSecretsmanagerClient{
+							secretAWSCurrent: placeholderSecretUserStr,
+							secretByID: map[string]map[string]string{
+								"foo": {"AWSCURRENT": placeholderSecretUserStr},
+								"bar": {"AWSPENDING": placeholderSecretUserNewStr},
+							},
+						},
+						ServiceClient:            serviceClient,
+						SecretObj:                &mockObj{},
+						VerifyOldPasswordRevoked: true,
+						Metrics:                  NoopMetrics{},
+					},
+				)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("finishSecret() error = %v, want %v", err, tt.wantErr)
+				}
+				if tt.breaker != nil {
+					if err := tt.breaker.allow(); err != nil {
+						t.Errorf("the test of the revoked password opened the circuit: %v", err)
+					}
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,115 @@
+package lambda
+
+import (
+	"log"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// summaryRedactedFields the fields of the scalar types which may carry the secrets, they're reported as "set".
+var summaryRedactedFields = map[string]struct{}{
+	"PlaceholderPasswords": {},
+}
+
+// Summary returns the effective configuration as the space separated list of the "field=value" pairs,
+// e.g. to confirm that the configured settings take effect. It never contains the secrets: the clients, the hooks
+// and other values of non-scalar types are reported as "set", as well as the placeholder passwords,
+// the credentials in the URLs are redacted.
+// The fields with the zero values are omitted.
+func (cfg Config) Summary() string {
+	v := reflect.ValueOf(cfg)
+	t := v.Type()
+
+	var o []string
+	for i := 0; i < t.NumField(); i++ {
+		field, value := t.Field(i), v.Field(i)
+		if !field.IsExported() || value.IsZero() {
+			continue
+		}
+		if _, ok := summaryRedactedFields[field.Name]; ok {
+			o = append(o, field.Name+"=set")
+			continue
+		}
+		o = append(o, field.Name+"="+summaryValue(value))
+	}
+
+	return strings.Join(o, " ")
+}
+
+// loggedSummaries the configurations' summaries logged by the process.
+var loggedSummaries sync.Map
+
+// logConfigSummary logs the configuration's summary once per process, e.g. the handler initialised per secret
+// by RunFullRotation does not repeat the summary of the same configuration.
+func logConfigSummary(cfg Config) {
+	summary := cfg.Summary()
+	if _, logged := loggedSummaries.LoadOrStore(summary, struct{}{}); logged {
+		if cfg.Debug {
+			log.Println("[DEBUG] configuration: " + summary)
+		}
+		return
+	}
+	log.Println("[INFO] configuration: " + summary)
+}
+
+var typeDuration = reflect.TypeOf(time.Duration(0))
+
+func summaryValue(v reflect.Value) string {
+	if v.Type() == typeDuration {
+		return time.Duration(v.Int()).String()
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.String:
+		return redactURL(v.String())
+	case reflect.Slice:
+		if el := v.Type().Elem(); el.Kind() != reflect.String && el != typeDuration {
+			return "set"
+		}
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = summaryValue(v.Index(i))
+		}
+		return "[" + strings.Join(items, ",") + "]"
+	default:
+		return "set"
+	}
+}
+
+// redactedValue replaces the URL's user info and the query parameters' values.
+const redactedValue = "xxxxx"
+
+// redactURL redacts the URL's user info and the query parameters' values which may carry the credentials,
+// other strings are returned as is.
+func redactURL(s string) string {
+	if !strings.Contains(s, "://") {
+		return s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return redactedValue
+	}
+
+	if u.User != nil {
+		u.User = url.User(redactedValue)
+	}
+	if u.RawQuery != "" {
+		q := u.Query()
+		for k := range q {
+			q.Set(k, redactedValue)
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
@@ -0,0 +1,49 @@
+package lambda
+
+import (
+	"context"
+	"log"
+)
+
+// FailureCategoryMaxAttemptsExceeded the category of the rotation abandoned after Config.MaxAttempts.
+const FailureCategoryMaxAttemptsExceeded = "MaxAttemptsExceeded"
+
+// FailureNotification defines the notification about the terminal rotation failure.
+type FailureNotification struct {
+	// SecretARN the secret's ARN, or name.
+	SecretARN string `json:"secret_arn"`
+	// Token the rotation token, i.e. the version ID.
+	Token string `json:"token"`
+	// Step the rotation step which failed.
+	Step string `json:"step"`
+	// Category the failure's category, e.g. FailureCategoryMaxAttemptsExceeded.
+	Category string `json:"category"`
+	// Error the error's message.
+	Error string `json:"error"`
+}
+
+// DLQClient publishes the notifications about the terminal rotation failures, e.g. to SQS dead-letter queue,
+// or SNS topic to page the on-call.
+type DLQClient interface {
+	Notify(ctx context.Context, notification FailureNotification) error
+}
+
+// notifyDLQ publishes the notification about the terminal failure, the publishing error is logged only
+// to report the step's failure.
+func notifyDLQ(ctx context.Context, client DLQClient, event SecretsmanagerTriggerPayload, category string, err error) {
+	if client == nil {
+		return
+	}
+
+	if errNotify := client.Notify(
+		ctx, FailureNotification{
+			SecretARN: event.SecretARN,
+			Token:     event.Token,
+			Step:      event.Step,
+			Category:  category,
+			Error:     err.Error(),
+		},
+	); errNotify != nil {
+		log.Println("[ERROR] failed to notify about the terminal failure: " + errNotify.Error())
+	}
+}
@@ -0,0 +1,90 @@
+package lambda
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+type mockDLQClient struct {
+	notifications []FailureNotification
+	err           error
+}
+
+func (m *mockDLQClient) Notify(_ context.Context, notification FailureNotification) error {
+	m.notifications = append(m.notifications, notification)
+	return m.err
+}
+
+func TestNewHandler_DLQClient(t *testing.T) {
+	const maxAttempts = 2
+
+	for _, errNotify := range []error{nil, errors.New("foo")} {
+		client := &mockSecretsmanagerClient{
+			secretAWSCurrent: placeholderSecretUserStr,
+			secretByID: map[string]map[string]string{
+				"foo": {"AWSCURRENT": placeholderSecretUserStr},
+				"bar": {"AWSPENDING": placeholderSecretUserStr},
+			},
+			rotationEnabled:    aws.Bool(true),
+			emptyVersionStages: true,
+		}
+		dlq := &mockDLQClient{err: errNotify}
+
+		h, err := NewHandler(
+			Config{
+				SecretsmanagerClient: &mockFailingPutClient{mockSecretsmanagerClient: client, failures: 2 * maxAttempts},
+				ServiceClient:        &mockDBClient{},
+				SecretObj:            &mockObj{},
+				MaxAttempts:          maxAttempts,
+				Metrics:              NoopMetrics{},
+				DLQClient:            dlq,
+			},
+		)
+		if err != nil {
+			t.Fatalf("NewHandler() unexpected error = %v", err)
+		}
+
+		event := SecretsmanagerTriggerPayload{
+			SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			Token:     "bar",
+			Step:      "createSecret",
+		}
+
+		for attempt := 1; attempt <= maxAttempts; attempt++ {
+			_ = h(context.TODO(), event)
+			if len(dlq.notifications) > 0 {
+				t.Fatalf("attempt %d: unexpected notification on the retried failure", attempt)
+			}
+		}
+
+		// the give-up is notified once
+		for attempt := 0; attempt < 3; attempt++ {
+			if err := h(context.TODO(), event); !errors.Is(err, ErrMaxAttemptsExceeded) {
+				t.Fatalf("handler() error = %v, want %v", err, ErrMaxAttemptsExceeded)
+			}
+		}
+
+		if len(dlq.notifications) != 1 {
+			t.Fatalf("handler() sent %d notifications, want 1", len(dlq.notifications))
+		}
+
+		got := dlq.notifications[0]
+		if got.Error == "" {
+			t.Errorf("notification's error is empty")
+		}
+		got.Error = ""
+		want := FailureNotification{
+			SecretARN: event.SecretARN,
+			Token:     event.Token,
+			Step:      event.Step,
+			Category:  FailureCategoryMaxAttemptsExceeded,
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("notification = %+v, want %+v", got, want)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+// Package lambda defines the AWS Lambda function's logic to rotate the secrets stored in AWS Secretsmanager.
+//
+// The package is meant to be imported by the rotation lambdas, which provide the ServiceClient implementation
+// for the system which the secret belongs to, and call NewHandler in their main package:
+//
+//	handler, err := lambda.NewHandler(
+//		lambda.Config{
+//			SecretsmanagerClient: secretsmanager.NewFromConfig(cfg),
+//			ServiceClient:        serviceClient,
+//			SecretObj:            &secret,
+//		},
+//	)
+//	if err != nil {
+//		log.Fatalln(err)
+//	}
+//
+//	awsLambda.Start(handler)
+//
+// See the plugins, e.g. github.com/kislerdm/aws-lambda-secret-rotation/plugin/neon.
+package lambda
@@ -0,0 +1,48 @@
+package lambda
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// DownstreamSyncer propagates the secret promoted to the stage AWSCURRENT to the downstream store
+// which mirrors the credentials, e.g. CI secrets, or Kubernetes secret.
+// The secret can be propagated more than once, e.g. when finishSecret is retried, hence Sync must be idempotent.
+type DownstreamSyncer interface {
+	Sync(ctx context.Context, secretARN string, secret any) error
+}
+
+// syncDownstream propagates the secret of the event's version to the downstream stores.
+func syncDownstream(ctx context.Context, event SecretsmanagerTriggerPayload, cfg Config) error {
+	if len(cfg.DownstreamSyncers) == 0 {
+		return nil
+	}
+	RecordFeature(ctx, featureDownstreamSync)
+
+	if cfg.Debug {
+		log.Println("[DEBUG] propagate the version " + event.Token + " to the downstream stores")
+	}
+
+	v, err := getSecretValue(ctx, cfg.SecretsmanagerClient, event.SecretARN, StageCurrent, event.Token)
+	if err != nil {
+		return fmt.Errorf("get AWSCURRENT of the secret %s: %w", event.SecretARN, err)
+	}
+
+	secret := initNewSecretObj(cfg.SecretObj)
+	if err := cfg.decodeSecret(v, secret); err != nil {
+		return fmt.Errorf("deserialize AWSCURRENT: %w", err)
+	}
+
+	for i, syncer := range cfg.DownstreamSyncers {
+		if err := syncer.Sync(ctx, event.SecretARN, secret); err != nil {
+			if !cfg.DownstreamSyncNonFatal {
+				return fmt.Errorf("sync downstream store %d: %w", i, err)
+			}
+			log.Println("[WARN] failed to sync downstream store " + strconv.Itoa(i) + ": " + err.Error())
+		}
+	}
+
+	return nil
+}
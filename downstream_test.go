@@ -0,0 +1,135 @@
+package lambda
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// mockDownstreamSyncer records the passwords and whether the version was promoted when Sync was called.
+type mockDownstreamSyncer struct {
+	client    *mockSecretsmanagerClient
+	token     string
+	passwords []string
+	promoted  []bool
+	err       error
+}
+
+func (m *mockDownstreamSyncer) Sync(ctx context.Context, _ string, secret any) error {
+	m.passwords = append(m.passwords, secret.(*mockObj).Password)
+
+	v, _ := m.client.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String("foo")})
+	m.promoted = append(m.promoted, hasStage(v.VersionIdsToStages[m.token], StageCurrent))
+
+	return m.err
+}
+
+func Test_finishSecret_DownstreamSyncers(t *testing.T) {
+	const token = "bar"
+
+	tests := []struct {
+		name     string
+		errSync  error
+		nonFatal bool
+		wantErr  bool
+	}{
+		{
+			name: "happy path",
+		},
+		{
+			name:    "unhappy path: sync failed",
+			errSync: errors.New("foo"),
+			wantErr: true,
+		},
+		{
+			name:     "happy path: non-fatal sync failure",
+			errSync:  errors.New("foo"),
+			nonFatal: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				client := &mockSecretsmanagerClient{
+					secretAWSCurrent: placeholderSecretUserStr,
+					secretByID: map[string]map[string]string{
+						"foo": {"AWSCURRENT": placeholderSecretUserStr},
+						token: {"AWSPENDING": placeholderSecretUserNewStr},
+					},
+				}
+				syncer := &mockDownstreamSyncer{client: client, token: token, err: tt.errSync}
+
+				err := finishSecret(
+					context.TODO(), SecretsmanagerTriggerPayload{
+						SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+						Token:     token,
+						Step:      "finishSecret",
+					}, Config{
+						SecretsmanagerClient:   client,
+						ServiceClient:          &mockDBClient{},
+						SecretObj:              &mockObj{},
+						DownstreamSyncers:      []DownstreamSyncer{syncer},
+						DownstreamSyncNonFatal: tt.nonFatal,
+						Metrics:                NoopMetrics{},
+					},
+				)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("finishSecret() error = %v, wantErr %v", err, tt.wantErr)
+				}
+
+				if len(syncer.passwords) != 1 {
+					t.Fatalf("Sync called %d times, want 1", len(syncer.passwords))
+				}
+				if want := placeholderPassword + "new"; syncer.passwords[0] != want {
+					t.Errorf("synced password = %v, want %v", syncer.passwords[0], want)
+				}
+				if !syncer.promoted[0] {
+					t.Errorf("Sync called before the promotion")
+				}
+			},
+		)
+	}
+}
+
+func Test_finishSecret_DownstreamSyncersRetried(t *testing.T) {
+	const token = "bar"
+
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		secretByID: map[string]map[string]string{
+			"foo": {"AWSCURRENT": placeholderSecretUserStr},
+			token: {"AWSPENDING": placeholderSecretUserNewStr},
+		},
+	}
+	syncer := &mockDownstreamSyncer{client: client, token: token, err: errors.New("foo")}
+
+	event := SecretsmanagerTriggerPayload{
+		SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+		Token:     token,
+		Step:      "finishSecret",
+	}
+	cfg := Config{
+		SecretsmanagerClient: client,
+		ServiceClient:        &mockDBClient{},
+		SecretObj:            &mockObj{},
+		DownstreamSyncers:    []DownstreamSyncer{syncer},
+		Metrics:              NoopMetrics{},
+	}
+
+	if err := finishSecret(context.TODO(), event, cfg); err == nil {
+		t.Fatalf("finishSecret() expected error")
+	}
+
+	// the version is promoted already, the retried finishSecret repeats the propagation only
+	syncer.err = nil
+	if err := finishSecret(context.TODO(), event, cfg); err != nil {
+		t.Fatalf("finishSecret() unexpected error = %v", err)
+	}
+
+	if len(syncer.passwords) != 2 {
+		t.Errorf("Sync called %d times, want 2", len(syncer.passwords))
+	}
+}
@@ -0,0 +1,231 @@
+package lambda
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmsTypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// FieldEncryptionAttribute the secret's attribute with the wrapped data key and the names of the encrypted fields.
+const FieldEncryptionAttribute = "_field_encryption"
+
+// FieldEncryptor provides the data keys to encrypt the secret's fields, i.e. the envelope encryption.
+// The fields are encrypted with AES-GCM using the data key, the wrapped data key is stored alongside the fields.
+type FieldEncryptor interface {
+	// GenerateDataKey returns the data key to encrypt the fields and the data key wrapped by the master key.
+	GenerateDataKey(ctx context.Context) (key, wrappedKey []byte, err error)
+
+	// DecryptDataKey unwraps the data key.
+	DecryptDataKey(ctx context.Context, wrappedKey []byte) ([]byte, error)
+}
+
+// KMSDataKeyClient defines the AWS KMS client to generate and decrypt the data keys.
+type KMSDataKeyClient interface {
+	GenerateDataKey(
+		ctx context.Context, input *kms.GenerateDataKeyInput, optFns ...func(*kms.Options),
+	) (*kms.GenerateDataKeyOutput, error)
+
+	Decrypt(ctx context.Context, input *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+// NewKMSFieldEncryptor initialises the FieldEncryptor which generates the data keys using the AWS KMS key.
+func NewKMSFieldEncryptor(client KMSDataKeyClient, keyID string) FieldEncryptor {
+	return kmsFieldEncryptor{client: client, keyID: keyID}
+}
+
+type kmsFieldEncryptor struct {
+	client KMSDataKeyClient
+	keyID  string
+}
+
+func (e kmsFieldEncryptor) GenerateDataKey(ctx context.Context) ([]byte, []byte, error) {
+	o, err := e.client.GenerateDataKey(
+		ctx, &kms.GenerateDataKeyInput{KeyId: aws.String(e.keyID), KeySpec: kmsTypes.DataKeySpecAes256},
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	return o.Plaintext, o.CiphertextBlob, nil
+}
+
+func (e kmsFieldEncryptor) DecryptDataKey(ctx context.Context, wrappedKey []byte) ([]byte, error) {
+	o, err := e.client.Decrypt(ctx, &kms.DecryptInput{KeyId: aws.String(e.keyID), CiphertextBlob: wrappedKey})
+	if err != nil {
+		return nil, err
+	}
+	return o.Plaintext, nil
+}
+
+// fieldEncryption the value of the attribute FieldEncryptionAttribute.
+type fieldEncryption struct {
+	WrappedKey []byte   `json:"key"`
+	Fields     []string `json:"fields"`
+}
+
+// fieldEncryptingClient encrypts the secret's fields before they are stored, and decrypts them on read.
+// The secrets without the attribute FieldEncryptionAttribute are read as is, e.g. the versions stored
+// before the encryption was activated.
+type fieldEncryptingClient struct {
+	SecretsmanagerClient
+	encryptor FieldEncryptor
+	fields    []string
+}
+
+func (c fieldEncryptingClient) GetSecretValue(
+	ctx context.Context, input *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.GetSecretValueOutput, error) {
+	o, err := c.SecretsmanagerClient.GetSecretValue(ctx, input, optFns...)
+	if err != nil || o == nil || o.SecretString == nil {
+		return o, err
+	}
+
+	v, err := c.decrypt(ctx, *o.SecretString)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt secret's fields: %w", err)
+	}
+
+	out := *o
+	out.SecretString = aws.String(v)
+	return &out, nil
+}
+
+func (c fieldEncryptingClient) PutSecretValue(
+	ctx context.Context, input *secretsmanager.PutSecretValueInput, optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.PutSecretValueOutput, error) {
+	if input.SecretString == nil {
+		return c.SecretsmanagerClient.PutSecretValue(ctx, input, optFns...)
+	}
+
+	v, err := c.encrypt(ctx, *input.SecretString)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt secret's fields: %w", err)
+	}
+
+	in := *input
+	in.SecretString = aws.String(v)
+	return c.SecretsmanagerClient.PutSecretValue(ctx, &in, optFns...)
+}
+
+func (c fieldEncryptingClient) TagResource(
+	ctx context.Context, input *secretsmanager.TagResourceInput, optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.TagResourceOutput, error) {
+	client, ok := c.SecretsmanagerClient.(SecretsmanagerTaggingClient)
+	if !ok {
+		return nil, errors.New("SecretsmanagerClient does not implement SecretsmanagerTaggingClient")
+	}
+	return client.TagResource(ctx, input, optFns...)
+}
+
+func (c fieldEncryptingClient) encrypt(ctx context.Context, secret string) (string, error) {
+	var v map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(secret), &v); err != nil {
+		return "", err
+	}
+
+	if _, ok := v[FieldEncryptionAttribute]; ok {
+		return "", errors.New("the secret's fields are encrypted already")
+	}
+
+	key, wrappedKey, err := c.encryptor.GenerateDataKey(ctx)
+	if err != nil {
+		return "", fmt.Errorf("generate data key: %w", err)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+
+	meta := fieldEncryption{WrappedKey: wrappedKey}
+	for _, field := range c.fields {
+		plaintext, ok := v[field]
+		if !ok {
+			continue
+		}
+
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+
+		ciphertext := aead.Seal(nonce, nonce, plaintext, []byte(field))
+		if v[field], err = json.Marshal(base64.StdEncoding.EncodeToString(ciphertext)); err != nil {
+			return "", err
+		}
+		meta.Fields = append(meta.Fields, field)
+	}
+
+	if v[FieldEncryptionAttribute], err = json.Marshal(meta); err != nil {
+		return "", err
+	}
+
+	o, err := json.Marshal(v)
+	return string(o), err
+}
+
+func (c fieldEncryptingClient) decrypt(ctx context.Context, secret string) (string, error) {
+	var v map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(secret), &v); err != nil {
+		// the secret is not a JSON object, hence it cannot have encrypted fields
+		return secret, nil
+	}
+
+	raw, ok := v[FieldEncryptionAttribute]
+	if !ok {
+		return secret, nil
+	}
+
+	var meta fieldEncryption
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return "", fmt.Errorf("faulty attribute %s: %w", FieldEncryptionAttribute, err)
+	}
+
+	key, err := c.encryptor.DecryptDataKey(ctx, meta.WrappedKey)
+	if err != nil {
+		return "", fmt.Errorf("decrypt data key: %w", err)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+
+	for _, field := range meta.Fields {
+		var encoded string
+		if err := json.Unmarshal(v[field], &encoded); err != nil {
+			return "", fmt.Errorf("faulty encrypted field %s: %w", field, err)
+		}
+
+		ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(ciphertext) < aead.NonceSize() {
+			return "", errors.New("faulty encrypted field " + field)
+		}
+
+		nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+		if v[field], err = aead.Open(nil, nonce, ciphertext, []byte(field)); err != nil {
+			return "", fmt.Errorf("decrypt field %s: %w", field, err)
+		}
+	}
+	delete(v, FieldEncryptionAttribute)
+
+	o, err := json.Marshal(v)
+	return string(o), err
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("faulty data key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
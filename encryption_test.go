@@ -0,0 +1,218 @@
+package lambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmsTypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// mockFieldEncryptor wraps the data key by reversing its bytes.
+type mockFieldEncryptor struct {
+	generated int
+}
+
+func (m *mockFieldEncryptor) GenerateDataKey(context.Context) ([]byte, []byte, error) {
+	m.generated++
+	key := []byte("0123456789abcdef0123456789abcdef")
+	return key, reverseBytes(key), nil
+}
+
+func (m *mockFieldEncryptor) DecryptDataKey(_ context.Context, wrappedKey []byte) ([]byte, error) {
+	return reverseBytes(wrappedKey), nil
+}
+
+func reverseBytes(v []byte) []byte {
+	o := make([]byte, len(v))
+	for i := range v {
+		o[len(v)-1-i] = v[i]
+	}
+	return o
+}
+
+func Test_fieldEncryptingClient_roundTrip(t *testing.T) {
+	mock := &mockSecretsmanagerClient{secretAWSCurrent: placeholderSecretUserStr}
+	c := fieldEncryptingClient{
+		SecretsmanagerClient: mock, encryptor: &mockFieldEncryptor{}, fields: []string{"password"},
+	}
+
+	if _, err := c.PutSecretValue(
+		context.TODO(), &secretsmanager.PutSecretValueInput{
+			SecretId:           aws.String("foo"),
+			ClientRequestToken: aws.String("bar"),
+			SecretString:       aws.String(placeholderSecretUserStr),
+			VersionStages:      []string{StagePending},
+		},
+	); err != nil {
+		t.Fatalf("PutSecretValue() unexpected error = %v", err)
+	}
+
+	stored := mock.secretByID["bar"][StagePending]
+	if strings.Contains(stored, placeholderPassword) {
+		t.Errorf("stored secret contains the password in plaintext: %s", stored)
+	}
+	if !strings.Contains(stored, FieldEncryptionAttribute) {
+		t.Errorf("stored secret has no attribute %s: %s", FieldEncryptionAttribute, stored)
+	}
+
+	o, err := c.GetSecretValue(
+		context.TODO(), &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String("foo"), VersionId: aws.String("bar"), VersionStage: aws.String(StagePending),
+		},
+	)
+	if err != nil {
+		t.Fatalf("GetSecretValue() unexpected error = %v", err)
+	}
+
+	var got mockObj
+	if err := json.Unmarshal([]byte(aws.ToString(o.SecretString)), &got); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+	if !reflect.DeepEqual(got, placeholderSecretUser) {
+		t.Errorf("GetSecretValue() got = %v, want %v", got, placeholderSecretUser)
+	}
+}
+
+func Test_fieldEncryptingClient_decrypt(t *testing.T) {
+	c := fieldEncryptingClient{encryptor: &mockFieldEncryptor{}, fields: []string{"password"}}
+
+	t.Run(
+		"plaintext secret is read as is", func(t *testing.T) {
+			got, err := c.decrypt(context.TODO(), placeholderSecretUserStr)
+			if err != nil {
+				t.Fatalf("decrypt() unexpected error = %v", err)
+			}
+			if got != placeholderSecretUserStr {
+				t.Errorf("decrypt() got = %v, want %v", got, placeholderSecretUserStr)
+			}
+		},
+	)
+
+	t.Run(
+		"tampered field fails", func(t *testing.T) {
+			encrypted, err := c.encrypt(context.TODO(), placeholderSecretUserStr)
+			if err != nil {
+				t.Fatalf("encrypt() unexpected error = %v", err)
+			}
+
+			var v map[string]any
+			_ = json.Unmarshal([]byte(encrypted), &v)
+			v["user"], v["password"] = v["password"], v["user"]
+			tampered, _ := json.Marshal(v)
+
+			if _, err := c.decrypt(context.TODO(), string(tampered)); err == nil {
+				t.Errorf("decrypt() expected error")
+			}
+		},
+	)
+}
+
+// mockCapturingClient records the passwords of the tested secrets.
+type mockCapturingClient struct {
+	mockDBClient
+	tested []string
+}
+
+func (m *mockCapturingClient) Test(_ context.Context, secret any) error {
+	m.tested = append(m.tested, secret.(*mockObj).Password)
+	return nil
+}
+
+func TestNewHandler_FieldEncryptor(t *testing.T) {
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		secretByID: map[string]map[string]string{
+			"foo": {"AWSCURRENT": placeholderSecretUserStr},
+			"bar": {"AWSPENDING": placeholderSecretUserStr},
+		},
+		rotationEnabled: aws.Bool(true),
+		// the stages are not returned with the secret value, hence createSecret does not skip
+		emptyVersionStages: true,
+	}
+	serviceClient := &mockCapturingClient{}
+	encryptor := &mockFieldEncryptor{}
+
+	h, err := NewHandler(
+		Config{
+			SecretsmanagerClient: client,
+			ServiceClient:        serviceClient,
+			SecretObj:            &mockObj{},
+			Metrics:              NoopMetrics{},
+			FieldEncryptor:       encryptor,
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewHandler() unexpected error = %v", err)
+	}
+
+	event := SecretsmanagerTriggerPayload{
+		SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+		Token:     "bar",
+	}
+	for _, step := range []string{"createSecret", "testSecret"} {
+		event.Step = step
+		if err := h(context.TODO(), event); err != nil {
+			t.Fatalf("handler() step %s unexpected error = %v", step, err)
+		}
+	}
+
+	if encryptor.generated != 1 {
+		t.Errorf("data keys generated %d times, want 1", encryptor.generated)
+	}
+	if stored := client.secretByID["bar"][StagePending]; strings.Contains(stored, placeholderSecretUserNewStr) {
+		t.Errorf("stored secret contains the password in plaintext: %s", stored)
+	}
+	if !reflect.DeepEqual(serviceClient.tested, []string{placeholderSecretUserNewStr}) {
+		t.Errorf("tested passwords = %v, want %v", serviceClient.tested, []string{placeholderSecretUserNewStr})
+	}
+}
+
+type mockKMSDataKeyClient struct {
+	generateInput *kms.GenerateDataKeyInput
+}
+
+func (m *mockKMSDataKeyClient) GenerateDataKey(
+	_ context.Context, input *kms.GenerateDataKeyInput, _ ...func(*kms.Options),
+) (*kms.GenerateDataKeyOutput, error) {
+	m.generateInput = input
+	return &kms.GenerateDataKeyOutput{Plaintext: []byte("key"), CiphertextBlob: []byte("wrapped")}, nil
+}
+
+func (m *mockKMSDataKeyClient) Decrypt(
+	_ context.Context, input *kms.DecryptInput, _ ...func(*kms.Options),
+) (*kms.DecryptOutput, error) {
+	if !bytes.Equal(input.CiphertextBlob, []byte("wrapped")) {
+		return nil, errors.New("faulty ciphertext")
+	}
+	return &kms.DecryptOutput{Plaintext: []byte("key")}, nil
+}
+
+func TestNewKMSFieldEncryptor(t *testing.T) {
+	client := &mockKMSDataKeyClient{}
+	e := NewKMSFieldEncryptor(client, "alias/foo")
+
+	key, wrappedKey, err := e.GenerateDataKey(context.TODO())
+	if err != nil {
+		t.Fatalf("GenerateDataKey() unexpected error = %v", err)
+	}
+	if aws.ToString(client.generateInput.KeyId) != "alias/foo" ||
+		client.generateInput.KeySpec != kmsTypes.DataKeySpecAes256 {
+		t.Errorf("GenerateDataKey() input = %+v", client.generateInput)
+	}
+
+	got, err := e.DecryptDataKey(context.TODO(), wrappedKey)
+	if err != nil {
+		t.Fatalf("DecryptDataKey() unexpected error = %v", err)
+	}
+	if !bytes.Equal(got, key) {
+		t.Errorf("DecryptDataKey() got = %s, want %s", got, key)
+	}
+}
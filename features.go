@@ -0,0 +1,100 @@
+package lambda
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metricFeatureUsed the counter of the optional features exercised by the step, e.g. to confirm that
+// the configuration takes effect.
+const metricFeatureUsed = "FeatureUsed"
+
+// Names of the optional features exercised by the steps.
+const (
+	featureStartupJitter       = "StartupJitter"
+	featureRotationOwnership   = "VerifyRotationOwnership"
+	featureMinRotationInterval = "MinRotationInterval"
+	featureMaxAttempts         = "MaxAttempts"
+	featureReclaimStalePending = "ReclaimStalePending"
+	featureRotationLock        = "RotationLockTTL"
+	featurePreflightKMSCheck   = "PreflightKMSCheck"
+	featurePasswordHistory     = "PasswordHistory"
+	featureTargetPolicy        = "TargetPasswordPolicy"
+	featureVerifyPromotion     = "VerifyPromotion"
+	featureDetectNoOpRotation  = "DetectNoOpRotation"
+	featureReconcilePromotion  = "ReconcilePromotion"
+	featureRollbackTest        = "RollbackOnPostFinishFailure"
+	featureDownstreamSync      = "DownstreamSync"
+	featureOldPasswordRevoked  = "VerifyOldPasswordRevoked"
+	featureCleanupStrayPending = "CleanupStrayPendingVersions"
+	featureServiceFinish       = "ServiceFinish"
+	featureCircuitBreaker      = "CircuitBreaker"
+)
+
+type featureRecorderCtxKey struct{}
+
+// featureRecorder collects the optional features exercised by the invocation, and the number of its retried calls.
+type featureRecorder struct {
+	mu       sync.Mutex
+	features map[string]struct{}
+	retries  int
+	promoted bool
+}
+
+// RecordFeature records the optional feature exercised by the invocation, e.g. by the ServiceClient.
+// The features are reported as the metric FeatureUsed and logged when the step ends.
+// The call is no-op if the context does not belong to the handler's invocation.
+func RecordFeature(ctx context.Context, feature string) {
+	r, ok := ctx.Value(featureRecorderCtxKey{}).(*featureRecorder)
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.features == nil {
+		r.features = map[string]struct{}{}
+	}
+	r.features[feature] = struct{}{}
+}
+
+// withFeatureRecorder sets the recorder of the features to the context.
+func withFeatureRecorder(ctx context.Context) (context.Context, *featureRecorder) {
+	r := &featureRecorder{}
+	return context.WithValue(ctx, featureRecorderCtxKey{}, r), r
+}
+
+// list returns the sorted names of the recorded features.
+func (r *featureRecorder) list() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	o := make([]string, 0, len(r.features))
+	for feature := range r.features {
+		o = append(o, feature)
+	}
+	sort.Strings(o)
+	return o
+}
+
+// retryCount returns the number of the recorded retries.
+func (r *featureRecorder) retryCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.retries
+}
+
+// report logs the features exercised by the step and increments the counter of each feature.
+func (r *featureRecorder) report(m Metrics, step string) {
+	features := r.list()
+	if len(features) == 0 {
+		return
+	}
+
+	log.Println("[INFO] " + step + " features used: " + strings.Join(features, ", "))
+	for _, feature := range features {
+		m.IncCounter(metricFeatureUsed, map[string]string{"Step": step, "Feature": feature})
+	}
+}
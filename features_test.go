@@ -0,0 +1,100 @@
+package lambda
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// mockFeatureClient records the feature on Create.
+type mockFeatureClient struct {
+	mockDBClient
+}
+
+func (m *mockFeatureClient) Create(ctx context.Context, secret any) error {
+	RecordFeature(ctx, "Custom")
+	return m.mockDBClient.Create(ctx, secret)
+}
+
+func TestNewHandler_recordsFeatures(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          Config
+		wantFeatures []string
+	}{
+		{
+			name:         "no optional features are configured",
+			wantFeatures: []string{"Custom"},
+		},
+		{
+			name: "optional features are configured",
+			cfg: Config{
+				StartupJitter:       time.Nanosecond,
+				MaxAttempts:         3,
+				MinRotationInterval: time.Hour,
+			},
+			wantFeatures: []string{"Custom", featureMaxAttempts, featureMinRotationInterval, featureStartupJitter},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				metrics := &mockMetrics{}
+
+				cfg := tt.cfg
+				cfg.SecretsmanagerClient = &mockSecretsmanagerClient{
+					secretAWSCurrent: placeholderSecretUserStr,
+					secretByID: map[string]map[string]string{
+						"foo": {"AWSCURRENT": placeholderSecretUserStr},
+						"bar": {"AWSPENDING": placeholderSecretUserStr},
+					},
+					rotationEnabled: aws.Bool(true),
+					// the stages are not returned with the secret value, hence createSecret does not skip
+					emptyVersionStages: true,
+				}
+				cfg.ServiceClient = &mockFeatureClient{}
+				cfg.SecretObj = &mockObj{}
+				cfg.Metrics = metrics
+
+				h, err := NewHandler(cfg)
+				if err != nil {
+					t.Fatalf("NewHandler() unexpected error = %v", err)
+				}
+
+				if err := h(
+					context.TODO(), SecretsmanagerTriggerPayload{
+						SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+						Token:     "bar",
+						Step:      "createSecret",
+					},
+				); err != nil {
+					t.Fatalf("handler() unexpected error = %v", err)
+				}
+
+				var got []string
+				for _, c := range metrics.counters {
+					if c.name != metricFeatureUsed {
+						continue
+					}
+					if c.dimensions["Step"] != "createSecret" {
+						t.Errorf("feature %s recorded for the step %s", c.dimensions["Feature"], c.dimensions["Step"])
+					}
+					got = append(got, c.dimensions["Feature"])
+				}
+				sort.Strings(got)
+				if !reflect.DeepEqual(got, tt.wantFeatures) {
+					t.Errorf("recorded features = %v, want %v", got, tt.wantFeatures)
+				}
+			},
+		)
+	}
+}
+
+func TestRecordFeature_noRecorder(t *testing.T) {
+	// the call outside the handler's invocation must not panic
+	RecordFeature(context.TODO(), "Custom")
+}
@@ -0,0 +1,53 @@
+package lambda
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// ServiceFinisher (optional) extends ServiceClient to finalise the rotation in the service after the secret
+// is promoted to the stage AWSCURRENT, e.g. to drop the previous role when the username is rotated.
+// Finish can be called more than once, e.g. when finishSecret is retried, hence it must be idempotent.
+type ServiceFinisher interface {
+	Finish(ctx context.Context, secretCurrent, secretPrevious any) error
+}
+
+// finishService calls the ServiceClient's Finish with the secret of the event's version and the secret
+// of the previous version if the ServiceClient implements ServiceFinisher.
+func finishService(
+	ctx context.Context, event SecretsmanagerTriggerPayload, cfg Config, previousVersion string,
+) error {
+	finisher, ok := cfg.ServiceClient.(ServiceFinisher)
+	if !ok || previousVersion == "" {
+		return nil
+	}
+	RecordFeature(ctx, featureServiceFinish)
+
+	if cfg.Debug {
+		log.Println("[DEBUG] finalise the rotation from the version " + previousVersion + " to " + event.Token)
+	}
+
+	vCurrent, err := getSecretValue(ctx, cfg.SecretsmanagerClient, event.SecretARN, StageCurrent, event.Token)
+	if err != nil {
+		return fmt.Errorf("get AWSCURRENT of the secret %s: %w", event.SecretARN, err)
+	}
+	secretCurrent := initNewSecretObj(cfg.SecretObj)
+	if err := cfg.decodeSecret(vCurrent, secretCurrent); err != nil {
+		return fmt.Errorf("deserialize AWSCURRENT: %w", err)
+	}
+
+	vPrevious, err := getSecretValue(ctx, cfg.SecretsmanagerClient, event.SecretARN, StagePrevious, previousVersion)
+	if err != nil {
+		return fmt.Errorf("get AWSPREVIOUS of the secret %s: %w", event.SecretARN, err)
+	}
+	secretPrevious := initNewSecretObj(cfg.SecretObj)
+	if err := cfg.decodeSecret(vPrevious, secretPrevious); err != nil {
+		return fmt.Errorf("deserialize AWSPREVIOUS: %w", err)
+	}
+
+	if err := finisher.Finish(withSecretKind(ctx, cfg, vCurrent), secretCurrent, secretPrevious); err != nil {
+		return fmt.Errorf("finish rotation in the service: %w", err)
+	}
+	return nil
+}
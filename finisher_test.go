@@ -0,0 +1,84 @@
+package lambda
+
+import (
+	"context"
+	"testing"
+)
+
+// mockFinisherClient records the secrets the rotation is finalised with.
+type mockFinisherClient struct {
+	mockDBClient
+	finished [][2]string
+}
+
+func (m *mockFinisherClient) Finish(_ context.Context, secretCurrent, secretPrevious any) error {
+	m.finished = append(m.finished, [2]string{secretCurrent.(*mockObj).Password, secretPrevious.(*mockObj).Password})
+	return nil
+}
+
+func Test_finishSecret_ServiceFinisher(t *testing.T) {
+	tests := []struct {
+		name         string
+		secretByID   map[string]map[string]string
+		wantFinished [][2]string
+	}{
+		{
+			name: "rotation is finalised after the promotion",
+			secretByID: map[string]map[string]string{
+				"foo": {StageCurrent: placeholderSecretUserStr},
+				"bar": {StagePending: placeholderSecretUserNewStr},
+			},
+			wantFinished: [][2]string{{placeholderPassword + "new", placeholderPassword}},
+		},
+		{
+			name: "rotation is finalised when finishSecret is retried after the promotion",
+			secretByID: map[string]map[string]string{
+				"foo": {StagePrevious: placeholderSecretUserStr},
+				"bar": {StageCurrent: placeholderSecretUserNewStr},
+			},
+			wantFinished: [][2]string{{placeholderPassword + "new", placeholderPassword}},
+		},
+		{
+			name: "no previous version to finalise the rotation from",
+			secretByID: map[string]map[string]string{
+				"bar": {StagePending: placeholderSecretUserNewStr},
+			},
+			wantFinished: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				serviceClient := &mockFinisherClient{}
+				cfg := Config{
+					SecretsmanagerClient: &mockSecretsmanagerClient{
+						secretAWSCurrent:  placeholderSecretUserStr,
+						secretAWSPrevious: placeholderSecretUserStr,
+						secretByID:        tt.secretByID,
+					},
+					ServiceClient: serviceClient,
+					SecretObj:     &mockObj{},
+				}
+
+				if err := finishSecret(
+					context.TODO(), SecretsmanagerTriggerPayload{
+						SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+						Token:     "bar",
+						Step:      "finishSecret",
+					}, cfg,
+				); err != nil {
+					t.Fatalf("finishSecret() unexpected error = %v", err)
+				}
+
+				if len(serviceClient.finished) != len(tt.wantFinished) {
+					t.Fatalf("Finish() called %d times, want %d", len(serviceClient.finished), len(tt.wantFinished))
+				}
+				for i := range tt.wantFinished {
+					if serviceClient.finished[i] != tt.wantFinished[i] {
+						t.Errorf("Finish() passwords = %v, want %v", serviceClient.finished[i], tt.wantFinished[i])
+					}
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,105 @@
+package lambda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// ErrInsufficientTime the time remaining before the invocation's deadline is insufficient to start the rotation step.
+var ErrInsufficientTime = errors.New("insufficient time remains to start the step")
+
+// ErrVersionNotStaged the secret's version to rotate is not labeled with the stage AWSPENDING.
+var ErrVersionNotStaged = errors.New("version is not staged for rotation")
+
+// fullRotationSteps the rotation steps run by RunFullRotation in order.
+var fullRotationSteps = []string{"createSecret", "setSecret", "testSecret", "finishSecret"}
+
+// DefaultStepTimeBudgets the minimum time which must remain before the invocation's deadline to start the step
+// by RunFullRotation.
+var DefaultStepTimeBudgets = map[string]time.Duration{
+	"createSecret": 5 * time.Second,
+	"setSecret":    10 * time.Second,
+	"testSecret":   10 * time.Second,
+	"finishSecret": 5 * time.Second,
+}
+
+// RunFullRotation runs the steps createSecret, setSecret, testSecret and finishSecret of the secret's version
+// in order, e.g. to rotate the secret manually. The time remaining before the context's deadline, i.e. the Lambda's
+// deadline, is budgeted across the steps: the step is not started and ErrInsufficientTime is returned if the remaining
+// time is shorter than the step's budget, see Config.StepTimeBudgets. It prevents the Lambda from being killed
+// in the middle of the step's write, the rotation can be resumed with the same token by the next invocation.
+//
+// The token must identify the version labeled with the stage AWSPENDING, e.g. the version of the rotation started
+// by Secrets Manager, because the steps refuse the version which has no stage for rotation. The secret's value
+// of the version is generated by createSecret unless staged already. ErrVersionNotStaged is returned otherwise,
+// before any step is run.
+func RunFullRotation(ctx context.Context, secretARN, token string, cfg Config) error {
+	if token == "" {
+		return errors.New("token must be set to run the full rotation")
+	}
+
+	handler, err := NewHandler(cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := checkVersionStaged(ctx, cfg.SecretsmanagerClient, secretARN, token); err != nil {
+		return err
+	}
+
+	for _, step := range fullRotationSteps {
+		if err := checkStepTimeBudget(ctx, step, cfg.StepTimeBudgets); err != nil {
+			log.Println("[WARN] the full rotation of the secret " + secretARN + " is stopped: " + err.Error())
+			return err
+		}
+
+		if err := handler(
+			ctx, SecretsmanagerTriggerPayload{SecretARN: secretARN, Token: token, Step: step},
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkVersionStaged checks that the secret's version is labeled with the stage AWSPENDING.
+func checkVersionStaged(ctx context.Context, client SecretsmanagerClient, secretARN, token string) error {
+	v, err := client.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(secretARN)})
+	if err != nil {
+		return fmt.Errorf("describe secret %s: %w", secretARN, err)
+	}
+
+	if !hasStage(v.VersionIdsToStages[token], StagePending) {
+		return fmt.Errorf("%w: version %s of the secret %s", ErrVersionNotStaged, token, secretARN)
+	}
+	return nil
+}
+
+// checkStepTimeBudget checks that the time remaining before the context's deadline is sufficient to start the step.
+// The step's budget is read from the budgets, or DefaultStepTimeBudgets. No deadline means unlimited time.
+func checkStepTimeBudget(ctx context.Context, step string, budgets map[string]time.Duration) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil
+	}
+
+	budget, ok := budgets[step]
+	if !ok {
+		budget = DefaultStepTimeBudgets[step]
+	}
+
+	if remaining := time.Until(deadline); remaining < budget {
+		return fmt.Errorf(
+			"%w %s: %s remains, %s required", ErrInsufficientTime, step,
+			remaining.Round(time.Millisecond), budget,
+		)
+	}
+	return nil
+}
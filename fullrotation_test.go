@@ -0,0 +1,122 @@
+package lambda
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestRunFullRotation(t *testing.T) {
+	tests := []struct {
+		name      string
+		deadline  time.Duration
+		budgets   map[string]time.Duration
+		wantCalls []string
+		wantErr   error
+	}{
+		{
+			name:      "no deadline, all steps run",
+			wantCalls: []string{"Create", "Set", "Test"},
+		},
+		{
+			name:      "sufficient time, all steps run",
+			deadline:  time.Minute,
+			wantCalls: []string{"Create", "Set", "Test"},
+		},
+		{
+			name:     "insufficient time, stopped before setSecret",
+			deadline: time.Second,
+			budgets: map[string]time.Duration{
+				"createSecret": time.Millisecond,
+			},
+			wantCalls: []string{"Create"},
+			wantErr:   ErrInsufficientTime,
+		},
+		{
+			name:     "insufficient time, no step started",
+			deadline: time.Second,
+			wantErr:  ErrInsufficientTime,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				client := &mockSecretsmanagerClient{
+					secretAWSCurrent: placeholderSecretUserStr,
+					secretByID: map[string]map[string]string{
+						"foo": {StageCurrent: placeholderSecretUserStr},
+					},
+					stagedVersions:  map[string][]string{"bar": {StagePending}},
+					rotationEnabled: aws.Bool(true),
+				}
+				serviceClient := &mockCallsRecordingClient{}
+
+				ctx := context.TODO()
+				if tt.deadline > 0 {
+					var cancel context.CancelFunc
+					ctx, cancel = context.WithTimeout(ctx, tt.deadline)
+					defer cancel()
+				}
+
+				err := RunFullRotation(
+					ctx, "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8", "bar", Config{
+						SecretsmanagerClient: client,
+						ServiceClient:        serviceClient,
+						SecretObj:            &mockObj{},
+						Metrics:              NoopMetrics{},
+						StepTimeBudgets:      tt.budgets,
+					},
+				)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("RunFullRotation() error = %v, want %v", err, tt.wantErr)
+				}
+				if !reflect.DeepEqual(serviceClient.calls, tt.wantCalls) {
+					t.Errorf("RunFullRotation() calls = %v, want %v", serviceClient.calls, tt.wantCalls)
+				}
+
+				_, promoted := client.secretByID["bar"][StageCurrent]
+				if promoted != (tt.wantErr == nil) {
+					t.Errorf("RunFullRotation() promoted = %v, want %v", promoted, tt.wantErr == nil)
+				}
+			},
+		)
+	}
+}
+
+func TestRunFullRotation_noToken(t *testing.T) {
+	if err := RunFullRotation(
+		context.TODO(), "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8", "", Config{},
+	); err == nil {
+		t.Errorf("RunFullRotation() expected error")
+	}
+}
+
+func TestRunFullRotation_tokenNotStaged(t *testing.T) {
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		secretByID: map[string]map[string]string{
+			"foo": {StageCurrent: placeholderSecretUserStr},
+		},
+		rotationEnabled: aws.Bool(true),
+	}
+	serviceClient := &mockCallsRecordingClient{}
+
+	err := RunFullRotation(
+		context.TODO(), "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8", "bar", Config{
+			SecretsmanagerClient: client,
+			ServiceClient:        serviceClient,
+			SecretObj:            &mockObj{},
+			Metrics:              NoopMetrics{},
+		},
+	)
+	if !errors.Is(err, ErrVersionNotStaged) {
+		t.Fatalf("RunFullRotation() error = %v, want %v", err, ErrVersionNotStaged)
+	}
+	if len(serviceClient.calls) > 0 {
+		t.Errorf("RunFullRotation() shall not call the service, got %v", serviceClient.calls)
+	}
+}
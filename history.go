@@ -0,0 +1,130 @@
+package lambda
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+// TagPasswordHistory the secret's tag with the salted hashes of the recent passwords, the entries are formatted
+// as "<salt>:<hash>" and separated by space, the latest entry is the last one.
+const TagPasswordHistory = "aws-lambda-secret-rotation:password-history"
+
+// MaxPasswordHistorySize the maximum number of the passwords' hashes which fit into the tag's value.
+const MaxPasswordHistorySize = 7
+
+const (
+	passwordHashSaltSize = 8
+	// passwordHashSize the size of the truncated SHA-256 digest.
+	passwordHashSize = 16
+)
+
+// passwordHistory the salted hashes of the recent passwords.
+type passwordHistory []string
+
+// parsePasswordHistory parses the value of the tag TagPasswordHistory.
+func parsePasswordHistory(v string) passwordHistory {
+	return strings.Fields(v)
+}
+
+// contains checks if the password's hash matches any recorded hash.
+func (h passwordHistory) contains(password string) bool {
+	for _, entry := range h {
+		salt, want, ok := decodePasswordHash(entry)
+		if !ok {
+			continue
+		}
+		if subtle.ConstantTimeCompare(hashPassword(salt, password), want) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// add records the password's hash and keeps the latest size entries.
+func (h passwordHistory) add(password string, size int) (passwordHistory, error) {
+	salt := make([]byte, passwordHashSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	entry := base64.RawStdEncoding.EncodeToString(salt) + ":" +
+		base64.RawStdEncoding.EncodeToString(hashPassword(salt, password))
+
+	o := append(append(passwordHistory{}, h...), entry)
+	if len(o) > size {
+		o = o[len(o)-size:]
+	}
+	return o, nil
+}
+
+func (h passwordHistory) String() string {
+	return strings.Join(h, " ")
+}
+
+func hashPassword(salt []byte, password string) []byte {
+	o := sha256.Sum256(append(append([]byte{}, salt...), password...))
+	return o[:passwordHashSize]
+}
+
+func decodePasswordHash(entry string) ([]byte, []byte, bool) {
+	saltEncoded, hashEncoded, ok := strings.Cut(entry, ":")
+	if !ok {
+		return nil, nil, false
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(saltEncoded)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	hash, err := base64.RawStdEncoding.DecodeString(hashEncoded)
+	if err != nil || len(hash) != passwordHashSize {
+		return nil, nil, false
+	}
+
+	return salt, hash, true
+}
+
+// loadPasswordHistory reads the password history from the secret's tags.
+func loadPasswordHistory(ctx context.Context) (passwordHistory, error) {
+	tags, err := SecretTagsFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return parsePasswordHistory(tags[TagPasswordHistory]), nil
+}
+
+// recordPasswordHistory adds the hash of the password of the promoted version to the secret's tag.
+func recordPasswordHistory(ctx context.Context, event SecretsmanagerTriggerPayload, cfg Config) error {
+	v, err := getSecretValue(ctx, cfg.SecretsmanagerClient, event.SecretARN, StageCurrent, event.Token)
+	if err != nil {
+		return fmt.Errorf("get AWSCURRENT of the secret %s: %w", event.SecretARN, err)
+	}
+
+	history, err := loadPasswordHistory(ctx)
+	if err != nil {
+		return err
+	}
+
+	password := secretAttribute(aws.ToString(v.SecretString), cfg.passwordField())
+	if history, err = history.add(password, cfg.PasswordHistorySize); err != nil {
+		return err
+	}
+
+	_, err = cfg.SecretsmanagerClient.(SecretsmanagerTaggingClient).TagResource(
+		ctx, &secretsmanager.TagResourceInput{
+			SecretId: aws.String(event.SecretARN),
+			Tags:     []types.Tag{{Key: aws.String(TagPasswordHistory), Value: aws.String(history.String())}},
+		},
+	)
+	return err
+}
@@ -0,0 +1,148 @@
+package lambda
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func Test_passwordHistory(t *testing.T) {
+	var h passwordHistory
+	for _, password := range []string{"foo", "bar", "baz"} {
+		var err error
+		if h, err = h.add(password, 2); err != nil {
+			t.Fatalf("add() unexpected error = %v", err)
+		}
+	}
+
+	if len(h) != 2 {
+		t.Fatalf("add() history size = %d, want 2", len(h))
+	}
+	if h.contains("foo") {
+		t.Errorf("contains() the password evicted from the history")
+	}
+	for _, password := range []string{"bar", "baz"} {
+		if !h.contains(password) {
+			t.Errorf("contains() the recorded password %s is not found", password)
+		}
+	}
+
+	v := h.String()
+	if strings.Contains(v, "bar") || strings.Contains(v, "baz") {
+		t.Errorf("history contains the password in plaintext: %s", v)
+	}
+	if len(v) > 256 {
+		t.Errorf("history exceeds the tag's value limit: %s", v)
+	}
+	if got := parsePasswordHistory(v); !got.contains("baz") {
+		t.Errorf("parsePasswordHistory() failed to parse %s", v)
+	}
+	if parsePasswordHistory("faulty").contains("faulty") {
+		t.Errorf("contains() matched the faulty entry")
+	}
+}
+
+func Test_createSecret_passwordHistory(t *testing.T) {
+	history, _ := passwordHistory{}.add(placeholderPassword+"old", MaxPasswordHistorySize)
+
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		secretByID: map[string]map[string]string{
+			"foo": {"AWSCURRENT": placeholderSecretUserStr},
+		},
+	}
+	serviceClient := &mockPasswordsClient{passwords: []string{placeholderPassword + "old", placeholderPassword + "new"}}
+
+	if err := createSecret(
+		WithSecretTags(context.TODO(), map[string]string{TagPasswordHistory: history.String()}),
+		SecretsmanagerTriggerPayload{
+			SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			Token:     "bar",
+			Step:      "createSecret",
+		}, Config{
+			SecretsmanagerClient: client,
+			ServiceClient:        serviceClient,
+			SecretObj:            &mockObj{},
+			PasswordHistorySize:  MaxPasswordHistorySize,
+			Metrics:              NoopMetrics{},
+		},
+	); err != nil {
+		t.Fatalf("createSecret() unexpected error = %v", err)
+	}
+
+	if serviceClient.calls != 2 {
+		t.Errorf("createSecret() called Create %d times, want 2", serviceClient.calls)
+	}
+	if got := getSecret(client, StagePending, "bar").Password; got != placeholderPassword+"new" {
+		t.Errorf("createSecret() staged the password %s, want %s", got, placeholderPassword+"new")
+	}
+}
+
+func Test_finishSecret_recordsPasswordHistory(t *testing.T) {
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		secretByID: map[string]map[string]string{
+			"foo": {"AWSCURRENT": placeholderSecretUserStr},
+			"bar": {"AWSPENDING": placeholderSecretUserNewStr},
+		},
+	}
+
+	if err := finishSecret(
+		context.TODO(), SecretsmanagerTriggerPayload{
+			SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			Token:     "bar",
+			Step:      "finishSecret",
+		}, Config{
+			SecretsmanagerClient: client,
+			ServiceClient:        &mockDBClient{},
+			SecretObj:            &mockObj{},
+			PasswordHistorySize:  2,
+			Metrics:              NoopMetrics{},
+		},
+	); err != nil {
+		t.Fatalf("finishSecret() unexpected error = %v", err)
+	}
+
+	promoted := getSecret(client, StageCurrent, "bar").Password
+	for _, tag := range client.tags {
+		if aws.ToString(tag.Key) == TagPasswordHistory {
+			if !parsePasswordHistory(aws.ToString(tag.Value)).contains(promoted) {
+				t.Errorf("tag %s does not contain the promoted password", TagPasswordHistory)
+			}
+			return
+		}
+	}
+	t.Errorf("finishSecret() did not set the tag %s", TagPasswordHistory)
+}
+
+func TestNewHandler_PasswordHistorySize(t *testing.T) {
+	tests := []struct {
+		name   string
+		client SecretsmanagerClient
+		size   int
+	}{
+		{
+			name:   "history exceeds the tag's capacity",
+			client: &mockSecretsmanagerClient{},
+			size:   MaxPasswordHistorySize + 1,
+		},
+		{
+			name:   "client cannot tag the secret",
+			client: mockNoTaggingClient{&mockSecretsmanagerClient{}},
+			size:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if _, err := NewHandler(
+					Config{SecretsmanagerClient: tt.client, SecretObj: &mockObj{}, PasswordHistorySize: tt.size},
+				); err == nil {
+					t.Errorf("NewHandler() expected error")
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,57 @@
+package lambda
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// ErrRotationInProgress the other version of the secret is pending with the rotation in progress marker set
+// within Config.RotationLockTTL, i.e. the concurrent rotation is not started.
+var ErrRotationInProgress = errors.New("another rotation of the secret is in progress")
+
+// checkRotationInProgress fails with ErrRotationInProgress if any of the pending versions is marked by the rotation
+// started within the TTL. The marker is the RotationMetadata stored in the pending secret's RotationMetadataField.
+func checkRotationInProgress(
+	ctx context.Context, event SecretsmanagerTriggerPayload, cfg Config, versions []string,
+) error {
+	for _, version := range versions {
+		v, err := getSecretValue(ctx, cfg.SecretsmanagerClient, event.SecretARN, StagePending, version)
+		if err != nil {
+			return fmt.Errorf("get AWSPENDING of the version %s: %w", version, err)
+		}
+
+		startedAt, ok := rotationStartedAt(aws.ToString(v.SecretString), cfg.RotationMetadataField)
+		if ok && time.Since(startedAt) < cfg.RotationLockTTL {
+			return fmt.Errorf(
+				"%w: the rotation of the version %s started at %s", ErrRotationInProgress, version,
+				startedAt.Format(time.RFC3339),
+			)
+		}
+	}
+	return nil
+}
+
+// rotationStartedAt returns the time the rotation started at according to the secret's RotationMetadata,
+// false is returned if the secret has no valid metadata, e.g. the version was not created by the rotation.
+func rotationStartedAt(secret, field string) (time.Time, bool) {
+	var v map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(secret), &v); err != nil {
+		return time.Time{}, false
+	}
+
+	var metadata RotationMetadata
+	if err := json.Unmarshal(v[field], &metadata); err != nil {
+		return time.Time{}, false
+	}
+
+	o, err := time.Parse(time.RFC3339, metadata.TriggeredAt)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return o, true
+}
@@ -0,0 +1,97 @@
+package lambda
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_createSecret_RotationLockTTL(t *testing.T) {
+	pendingSecret := func(startedAt time.Time) string {
+		return `{"user":"bar","password":"quxx","_rotation":{"created_by":"foo","token":"baz","triggered_at":"` +
+			startedAt.UTC().Format(time.RFC3339) + `"}}`
+	}
+
+	tests := []struct {
+		name      string
+		startedAt time.Time
+		wantErr   error
+	}{
+		{
+			name:      "concurrent rotation is rejected while the marker is fresh",
+			startedAt: time.Now().Add(-time.Minute),
+			wantErr:   ErrRotationInProgress,
+		},
+		{
+			name:      "stale pending version is reclaimed once the marker expires",
+			startedAt: time.Now().Add(-time.Hour),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				client := &mockSecretsmanagerClient{
+					secretAWSCurrent: placeholderSecretUserStr,
+					secretByID: map[string]map[string]string{
+						"foo": {StageCurrent: placeholderSecretUserStr},
+						"baz": {StagePending: pendingSecret(tt.startedAt)},
+					},
+				}
+
+				err := createSecret(
+					context.TODO(), SecretsmanagerTriggerPayload{
+						SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+						Token:     "bar",
+						Step:      "createSecret",
+					}, Config{
+						SecretsmanagerClient:  client,
+						ServiceClient:         &mockDBClient{},
+						SecretObj:             &mockObj{},
+						Metrics:               NoopMetrics{},
+						RotationMetadataField: "_rotation",
+						RotationLockTTL:       15 * time.Minute,
+						ReclaimStalePending:   true,
+					},
+				)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("createSecret() error = %v, want %v", err, tt.wantErr)
+				}
+
+				_, staged := client.secretByID["bar"][StagePending]
+				if staged != (tt.wantErr == nil) {
+					t.Errorf("createSecret() staged = %v, want %v", staged, tt.wantErr == nil)
+				}
+				if _, pending := client.secretByID["baz"][StagePending]; pending != (tt.wantErr != nil) {
+					t.Errorf("the concurrent rotation's pending version kept = %v, want %v", pending, tt.wantErr != nil)
+				}
+			},
+		)
+	}
+}
+
+func TestNewHandler_RotationLockTTLWithoutRotationMetadataField(t *testing.T) {
+	if _, err := NewHandler(
+		Config{
+			SecretsmanagerClient: &mockSecretsmanagerClient{},
+			SecretObj:            &mockObj{},
+			RotationLockTTL:      time.Minute,
+		},
+	); err == nil {
+		t.Errorf("NewHandler() expected error")
+	}
+}
+
+func Test_rotationStartedAt(t *testing.T) {
+	if _, ok := rotationStartedAt(placeholderSecretUserStr, "_rotation"); ok {
+		t.Errorf("rotationStartedAt() shall not find the marker in the secret without metadata")
+	}
+
+	want := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	got, ok := rotationStartedAt(
+		`{"_rotation":{"triggered_at":"`+want.Format(time.RFC3339)+`"}}`, "_rotation",
+	)
+	if !ok || !got.Equal(want) {
+		t.Errorf("rotationStartedAt() = %v, %v, want %v", got, ok, want)
+	}
+}
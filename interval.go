@@ -0,0 +1,95 @@
+package lambda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+// TagLastRotated the secret's tag with the time of the last rotation finished by the lambda, formatted as RFC3339.
+const TagLastRotated = "aws-lambda-secret-rotation:last-rotated"
+
+// ErrRotatedTooRecently the rotation is refused because the secret was rotated within Config.MinRotationInterval.
+var ErrRotatedTooRecently = errors.New("rotated too recently")
+
+// checkRotationInterval fails with ErrRotatedTooRecently if the secret was rotated within Config.MinRotationInterval.
+func checkRotationInterval(ctx context.Context, event SecretsmanagerTriggerPayload, cfg Config) error {
+	tags, err := SecretTagsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	v, ok := tags[TagLastRotated]
+	if !ok {
+		return nil
+	}
+
+	lastRotated, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		log.Println("[WARN] faulty tag " + TagLastRotated + " of the secret " + event.SecretARN + ": " + v)
+		return nil
+	}
+
+	if since := time.Since(lastRotated); since < cfg.MinRotationInterval {
+		return fmt.Errorf(
+			"%w: the secret %s was rotated %s ago, the minimum interval is %s", ErrRotatedTooRecently,
+			event.SecretARN, since.Round(time.Second), cfg.MinRotationInterval,
+		)
+	}
+
+	return nil
+}
+
+// tagLastRotated sets the time of the rotation to the secret's tag.
+func tagLastRotated(ctx context.Context, event SecretsmanagerTriggerPayload, cfg Config) error {
+	_, err := cfg.SecretsmanagerClient.(SecretsmanagerTaggingClient).TagResource(
+		ctx, &secretsmanager.TagResourceInput{
+			SecretId: aws.String(event.SecretARN),
+			Tags: []types.Tag{
+				{
+					Key:   aws.String(TagLastRotated),
+					Value: aws.String(time.Now().UTC().Format(time.RFC3339)),
+				},
+			},
+		},
+	)
+	return err
+}
+
+// NeedsRotation reports whether the secret is overdue for the rotation, i.e. it was not rotated within maxAge,
+// e.g. to detect the rotators which silently stopped. The time of the last rotation is read from the secret's tag
+// TagLastRotated, or from the secret's LastRotatedDate if the tag is not set. The secret which was never rotated
+// is overdue.
+func NeedsRotation(ctx context.Context, secretARN string, cfg Config, maxAge time.Duration) (bool, error) {
+	if cfg.SecretsmanagerClient == nil {
+		return false, errors.New("configuration for SecretsmanagerClient must be set")
+	}
+
+	v, err := cfg.SecretsmanagerClient.DescribeSecret(
+		ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(secretARN)},
+	)
+	if err != nil {
+		return false, fmt.Errorf("describe secret %s: %w", secretARN, err)
+	}
+
+	lastRotated := aws.ToTime(v.LastRotatedDate)
+	for _, tag := range v.Tags {
+		if aws.ToString(tag.Key) != TagLastRotated {
+			continue
+		}
+		if lastRotated, err = time.Parse(time.RFC3339, aws.ToString(tag.Value)); err != nil {
+			return false, fmt.Errorf("faulty tag %s of the secret %s: %w", TagLastRotated, secretARN, err)
+		}
+	}
+
+	if lastRotated.IsZero() {
+		return true, nil
+	}
+	return time.Since(lastRotated) > maxAge, nil
+}
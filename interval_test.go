@@ -0,0 +1,195 @@
+package lambda
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+func TestNewHandler_MinRotationInterval(t *testing.T) {
+	tests := []struct {
+		name        string
+		lastRotated string
+		wantErr     error
+	}{
+		{
+			name:        "unhappy path: rotated too recently",
+			lastRotated: time.Now().Add(-time.Minute).UTC().Format(time.RFC3339),
+			wantErr:     ErrRotatedTooRecently,
+		},
+		{
+			name:        "happy path: rotated before the interval",
+			lastRotated: time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339),
+		},
+		{
+			name: "happy path: never rotated",
+		},
+		{
+			name:        "happy path: faulty tag",
+			lastRotated: "foo",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				client := &mockSecretsmanagerClient{
+					secretAWSCurrent: placeholderSecretUserStr,
+					secretByID: map[string]map[string]string{
+						"foo": {"AWSCURRENT": placeholderSecretUserStr},
+						"bar": {"AWSPENDING": placeholderSecretUserStr},
+					},
+					rotationEnabled: aws.Bool(true),
+					// the stages are not returned with the secret value, hence createSecret does not skip
+					emptyVersionStages: true,
+				}
+				if tt.lastRotated != "" {
+					client.tags = []types.Tag{{Key: aws.String(TagLastRotated), Value: aws.String(tt.lastRotated)}}
+				}
+
+				h, err := NewHandler(
+					Config{
+						SecretsmanagerClient: client,
+						ServiceClient:        &mockDBClient{},
+						SecretObj:            &mockObj{},
+						MinRotationInterval:  time.Hour,
+						Metrics:              NoopMetrics{},
+					},
+				)
+				if err != nil {
+					t.Fatalf("NewHandler() unexpected error = %v", err)
+				}
+
+				err = h(
+					context.TODO(), SecretsmanagerTriggerPayload{
+						SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+						Token:     "bar",
+						Step:      "createSecret",
+					},
+				)
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("handler() error = %v, want %v", err, tt.wantErr)
+				}
+			},
+		)
+	}
+}
+
+func Test_finishSecret_tagsLastRotated(t *testing.T) {
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		secretByID: map[string]map[string]string{
+			"foo": {"AWSCURRENT": placeholderSecretUserStr},
+			"bar": {"AWSPENDING": placeholderSecretUserNewStr},
+		},
+	}
+
+	if err := finishSecret(
+		context.TODO(), SecretsmanagerTriggerPayload{
+			SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			Token:     "bar",
+			Step:      "finishSecret",
+		}, Config{
+			SecretsmanagerClient: client,
+			ServiceClient:        &mockDBClient{},
+			SecretObj:            &mockObj{},
+			MinRotationInterval:  time.Hour,
+			Metrics:              NoopMetrics{},
+		},
+	); err != nil {
+		t.Fatalf("finishSecret() unexpected error = %v", err)
+	}
+
+	for _, tag := range client.tags {
+		if aws.ToString(tag.Key) == TagLastRotated {
+			if v, err := time.Parse(time.RFC3339, aws.ToString(tag.Value)); err != nil || time.Since(v) > time.Minute {
+				t.Errorf("faulty tag %s value: %s", TagLastRotated, aws.ToString(tag.Value))
+			}
+			return
+		}
+	}
+	t.Errorf("finishSecret() did not set the tag %s", TagLastRotated)
+}
+
+func TestNewHandler_MinRotationIntervalWithoutTaggingClient(t *testing.T) {
+	if _, err := NewHandler(
+		Config{
+			SecretsmanagerClient: mockNoTaggingClient{&mockSecretsmanagerClient{}},
+			SecretObj:            &mockObj{},
+			MinRotationInterval:  time.Hour,
+		},
+	); err == nil {
+		t.Errorf("NewHandler() expected error")
+	}
+}
+
+func TestNeedsRotation(t *testing.T) {
+	tests := []struct {
+		name            string
+		lastRotated     string
+		lastRotatedDate *time.Time
+		want            bool
+		wantErr         bool
+	}{
+		{
+			name:        "fresh secret",
+			lastRotated: time.Now().Add(-time.Hour).UTC().Format(time.RFC3339),
+			want:        false,
+		},
+		{
+			name:        "stale secret",
+			lastRotated: time.Now().Add(-48 * time.Hour).UTC().Format(time.RFC3339),
+			want:        true,
+		},
+		{
+			name:            "stale secret without the tag",
+			lastRotatedDate: aws.Time(time.Now().Add(-48 * time.Hour)),
+			want:            true,
+		},
+		{
+			name:            "the tag takes precedence over the secret's last rotation date",
+			lastRotated:     time.Now().Add(-time.Hour).UTC().Format(time.RFC3339),
+			lastRotatedDate: aws.Time(time.Now().Add(-48 * time.Hour)),
+			want:            false,
+		},
+		{
+			name: "never rotated secret",
+			want: true,
+		},
+		{
+			name:        "faulty tag",
+			lastRotated: "foo",
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				client := &mockSecretsmanagerClient{
+					secretAWSCurrent: placeholderSecretUserStr,
+					secretByID: map[string]map[string]string{
+						"foo": {"AWSCURRENT": placeholderSecretUserStr},
+					},
+					lastRotatedDate: tt.lastRotatedDate,
+				}
+				if tt.lastRotated != "" {
+					client.tags = []types.Tag{{Key: aws.String(TagLastRotated), Value: aws.String(tt.lastRotated)}}
+				}
+
+				got, err := NeedsRotation(
+					context.TODO(), "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+					Config{SecretsmanagerClient: client}, 24*time.Hour,
+				)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("NeedsRotation() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if got != tt.want {
+					t.Errorf("NeedsRotation() got = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
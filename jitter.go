@@ -0,0 +1,25 @@
+package lambda
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// sleepJitter sleeps for the random duration up to max, it returns the context's error if the context
+// is done before the sleep ends.
+func sleepJitter(ctx context.Context, max time.Duration) error {
+	if max <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(max) + 1)))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
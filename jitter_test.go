@@ -0,0 +1,94 @@
+package lambda
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func Test_sleepJitter(t *testing.T) {
+	t.Run(
+		"sleep is bounded by the max jitter", func(t *testing.T) {
+			const max = 20 * time.Millisecond
+			for i := 0; i < 10; i++ {
+				start := time.Now()
+				if err := sleepJitter(context.TODO(), max); err != nil {
+					t.Fatalf("sleepJitter() unexpected error = %v", err)
+				}
+				// the margin for the timer's scheduling
+				if elapsed := time.Since(start); elapsed > max+50*time.Millisecond {
+					t.Errorf("sleepJitter() slept for %v, want at most %v", elapsed, max)
+				}
+			}
+		},
+	)
+
+	t.Run(
+		"no sleep if the jitter is not set", func(t *testing.T) {
+			if err := sleepJitter(context.TODO(), 0); err != nil {
+				t.Errorf("sleepJitter() unexpected error = %v", err)
+			}
+		},
+	)
+
+	t.Run(
+		"cancelled context interrupts the sleep", func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.TODO())
+			cancel()
+
+			start := time.Now()
+			if err := sleepJitter(ctx, time.Hour); !errors.Is(err, context.Canceled) {
+				t.Errorf("sleepJitter() error = %v, want %v", err, context.Canceled)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("sleepJitter() slept for %v despite the cancelled context", elapsed)
+			}
+		},
+	)
+}
+
+func TestNewHandler_StartupJitter(t *testing.T) {
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		secretByID: map[string]map[string]string{
+			"foo": {"AWSCURRENT": placeholderSecretUserStr},
+			"bar": {"AWSPENDING": placeholderSecretUserStr},
+		},
+		rotationEnabled: aws.Bool(true),
+		// the stages are not returned with the secret value, hence createSecret does not skip
+		emptyVersionStages: true,
+	}
+
+	h, err := NewHandler(
+		Config{
+			SecretsmanagerClient: client,
+			ServiceClient:        &mockDBClient{},
+			SecretObj:            &mockObj{},
+			Metrics:              NoopMetrics{},
+			StartupJitter:        time.Hour,
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewHandler() unexpected error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+
+	err = h(
+		ctx, SecretsmanagerTriggerPayload{
+			SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			Token:     "bar",
+			Step:      "createSecret",
+		},
+	)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("handler() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if client.secretByID["bar"][StagePending] != placeholderSecretUserStr {
+		t.Errorf("handler() staged the secret before the jitter elapsed")
+	}
+}
@@ -0,0 +1,112 @@
+package lambda
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// SecretKind defines the format of the secret's value.
+type SecretKind string
+
+const (
+	// SecretKindUnknown the secret's format could not be identified.
+	SecretKindUnknown SecretKind = ""
+
+	// SecretKindNeon the secret to access Neon database, e.g. it includes "project_id" and "branch_id".
+	SecretKindNeon SecretKind = "neon"
+
+	// SecretKindRDS the secret to access RDS database following the AWS structure, e.g. it includes "engine".
+	// See: https://docs.aws.amazon.com/secretsmanager/latest/userguide/reference_secret_json_structure.html
+	SecretKindRDS SecretKind = "rds"
+
+	// SecretKindDSN the secret which defines database access details as a single "dsn" string.
+	SecretKindDSN SecretKind = "dsn"
+)
+
+// DetectSecretKind identifies the format of the secret's value by its attributes.
+func DetectSecretKind(v *secretsmanager.GetSecretValueOutput) SecretKind {
+	if v == nil || v.SecretString == nil {
+		return SecretKindUnknown
+	}
+
+	var attributes map[string]any
+	if err := json.Unmarshal([]byte(*v.SecretString), &attributes); err != nil {
+		return SecretKindUnknown
+	}
+
+	has := func(key string) bool {
+		s, ok := attributes[key].(string)
+		return ok && s != ""
+	}
+
+	switch {
+	case has("project_id") && has("branch_id"):
+		return SecretKindNeon
+	case has("dsn"):
+		return SecretKindDSN
+	case has("engine") && has("username"):
+		return SecretKindRDS
+	default:
+		return SecretKindUnknown
+	}
+}
+
+// SecretDecoder decodes the secret's value to the Go object of the secret type, e.g. the value of the format
+// other than JSON.
+type SecretDecoder func(value string, secret any) error
+
+// SecretDecoders the registry of the SecretDecoder instances by the kind of the secret's value.
+// The value is decoded as JSON for the kinds without registered SecretDecoder.
+type SecretDecoders map[SecretKind]SecretDecoder
+
+// Register registers the SecretDecoder for the secret's kind, it replaces the previously registered SecretDecoder.
+func (d SecretDecoders) Register(kind SecretKind, decoder SecretDecoder) SecretDecoders {
+	if d == nil {
+		d = SecretDecoders{}
+	}
+	d[kind] = decoder
+	return d
+}
+
+// decodeSecret decodes the secret's value with the SecretDecoder registered for the secret's kind, or as JSON.
+// The kind configured explicitly takes precedence over the detected kind.
+func (cfg Config) decodeSecret(v *secretsmanager.GetSecretValueOutput, secret any) error {
+	if len(cfg.SecretDecoders) > 0 && v != nil && v.SecretString != nil {
+		kind := cfg.SecretKind
+		if kind == SecretKindUnknown {
+			kind = DetectSecretKind(v)
+		}
+		if decoder, ok := cfg.SecretDecoders[kind]; ok && decoder != nil {
+			return decoder(*v.SecretString, secret)
+		}
+	}
+	return extractSecretObject(v, secret, cfg.DisallowUnknownSecretFields)
+}
+
+type secretKindCtxKey struct{}
+
+// SecretKindFromContext returns the kind of the rotated secret, it allows the ServiceClient to select the logic
+// appropriate to the secret's format.
+func SecretKindFromContext(ctx context.Context) SecretKind {
+	if v, ok := ctx.Value(secretKindCtxKey{}).(SecretKind); ok {
+		return v
+	}
+	return SecretKindUnknown
+}
+
+// WithSecretKind sets the secret's kind to the context, e.g. to test the ServiceClient, or to invoke it directly.
+func WithSecretKind(ctx context.Context, kind SecretKind) context.Context {
+	return context.WithValue(ctx, secretKindCtxKey{}, kind)
+}
+
+// withSecretKind sets the secret's kind to the context. The kind configured explicitly takes precedence over
+// the detected kind.
+func withSecretKind(ctx context.Context, cfg Config, v *secretsmanager.GetSecretValueOutput) context.Context {
+	kind := cfg.SecretKind
+	if kind == SecretKindUnknown {
+		kind = DetectSecretKind(v)
+	}
+	return WithSecretKind(ctx, kind)
+}
@@ -2,19 +2,45 @@ package lambda
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
 	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 	"unsafe"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmsTypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
 	"github.com/aws/smithy-go"
 	smithyHttp "github.com/aws/smithy-go/transport/http"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// StageCurrent the stage of the secret's version in use.
+	StageCurrent = "AWSCURRENT"
+
+	// StagePending the stage of the secret's version being rotated.
+	StagePending = "AWSPENDING"
+
+	// StagePrevious the stage of the secret's version used before the last rotation.
+	StagePrevious = "AWSPREVIOUS"
 )
 
 // Config defines the rotation lambda's configuration.
@@ -22,63 +48,622 @@ type Config struct {
 	// SecretsmanagerClient the client's instance to communicate with the secretsmanager.
 	SecretsmanagerClient SecretsmanagerClient
 
+	// Retryer (optional) the retry policy of the AWS Secretsmanager API calls which overrides the client's retryer,
+	// e.g. DefaultRetryer, or the SDK's retryer with the custom max attempts and rate limit.
+	Retryer aws.Retryer
+
 	// ServiceClient the client's instance to communicate with the service delegated credentials storage.
+	// It's required by the steps which call it, finishSecret does not require it unless VerifyOldPasswordRevoked,
+	// or RollbackOnPostFinishFailure is set, see ErrNoServiceClient.
 	ServiceClient ServiceClient
 
+	// ServiceClients maps the secret ARN patterns to the clients' instances to communicate with the service.
+	// The pattern is a regular expression matched against the secret ARN of the triggering event.
+	// The patterns are evaluated in lexicographical order, ServiceClient is used if no pattern matches.
+	ServiceClients map[string]ServiceClient
+
+	// CircuitBreaker (optional) the circuit breaker around the ServiceClient's calls which connect to the service:
+	// the calls fail with ErrCircuitOpen after the consecutive failures, e.g. if the database was deleted.
+	// Every secret has its own circuit, so the failures of one target do not block the rotation of the secrets
+	// of other targets served by the same client.
+	CircuitBreaker *CircuitBreaker
+
 	// SecretObj defines the interface of the secret to rotate.
 	SecretObj any
 
+	// SecretKind the format of the secret's value, it is detected from the secret's value if not set.
+	// The kind is propagated to the ServiceClient's methods, see SecretKindFromContext.
+	SecretKind SecretKind
+
+	// SecretDecoders (optional) the SecretDecoder instances to decode the secret's value by its kind, see SecretKind.
+	// The value is decoded as JSON for the kinds not registered. DisallowUnknownSecretFields applies to JSON only.
+	SecretDecoders SecretDecoders
+
+	// RotationMetadataField (optional) the secret's attribute to store the RotationMetadata of the pending version,
+	// e.g. the lambda function which created the version and the rotation time. The metadata is not stored if not set.
+	// Note that SecretObj must define the attribute if DisallowUnknownSecretFields is set.
+	RotationMetadataField string
+
+	// TokenFields (optional) the secret's attributes which track the rotation token, e.g. the version ID embedded
+	// by the pipeline. createSecret sets them to the event's token, so the pending secret never carries the token
+	// of the other version and the following steps reconcile the version correctly.
+	// Note that SecretObj must define the attributes if DisallowUnknownSecretFields is set.
+	TokenFields []string
+
+	// KMSKeyResolver (optional) resolves the KMS key expected to encrypt the secret, e.g. the tenant's key.
+	// The secret's versions are encrypted with the key assigned to the secret, hence the new version is not staged
+	// unless the secret's KmsKeyId matches the resolved key. The check is skipped if an empty string is resolved.
+	KMSKeyResolver func(secretARN string) string
+
+	// DisallowUnknownSecretFields set to `true` to reject the secret's attributes not defined by SecretObj.
+	DisallowUnknownSecretFields bool
+
+	// AllowedSecretFields (optional) the secret's attributes to stage, other attributes are dropped before the secret
+	// is stored, e.g. to prevent the propagation of unexpected sensitive data. The password's attribute and
+	// RotationMetadataField are always kept. All attributes are staged if not set.
+	AllowedSecretFields []string
+
+	// AllowUnchangedSecret set to `true` to permit the generated secret identical to the current secret.
+	// Otherwise, createSecret fails with ErrUnchangedSecret because the generation effectively did nothing.
+	AllowUnchangedSecret bool
+
+	// PlaceholderPasswords (optional) the placeholder passwords of the bootstrapped secrets, e.g. "changeme",
+	// DefaultPlaceholderPasswords are used if not set. createSecret fails with ErrPlaceholderPassword
+	// if the generated password matches any of them, i.e. the placeholder is carried forward to AWSPENDING.
+	PlaceholderPasswords []string
+
+	// TargetPasswordPolicy (optional) the password rules enforced by the target database. The generated password
+	// which violates the policy is regenerated, i.e. createSecret never stages the password the database rejects.
+	// createSecret fails with ErrPasswordPolicyViolation if no compliant password is generated
+	// within MaxCreateAttempts.
+	TargetPasswordPolicy *TargetPasswordPolicy
+
+	// RandSource (optional) the source of randomness of the passwords generated by PasswordGenerator
+	// within ServiceClient.Create, unless the generator sets its own, crypto/rand.Reader is used by default.
+	// WARNING: a deterministic source must never be used in production,
+	// it is meant for tests only and requires AllowInsecureRandSource set to `true`.
+	RandSource io.Reader
+
+	// AllowInsecureRandSource set to `true` to permit RandSource other than crypto/rand.Reader.
+	AllowInsecureRandSource bool
+
+	// MaxCreateAttempts the number of attempts to generate the new secret with the password which differs
+	// from the current password, 3 attempts are made by default.
+	MaxCreateAttempts int
+
+	// MaxPutAttempts the number of attempts to put the generated secret to the stage AWSPENDING if the put fails
+	// with the transient error, e.g. the throttling, 3 attempts are made by default. The same secret is put
+	// on every attempt, hence the generated secret is not lost.
+	MaxPutAttempts int
+
+	// MaxAttempts (optional) the number of createSecret attempts for the same token after which the rotation is
+	// abandoned with ErrMaxAttemptsExceeded to break the infinite retry loops. The attempts are tracked in the secret's
+	// tag TagAttempts, hence SecretsmanagerClient must implement SecretsmanagerTaggingClient. Not limited if not set.
+	MaxAttempts int
+
+	// DLQClient (optional) publishes the notification about the terminal rotation failure, i.e. the rotation abandoned
+	// after MaxAttempts. It's not called on the failures which are retried.
+	DLQClient DLQClient
+
+	// AuditStore (optional) stores the tamper-evident audit records of the rotations, e.g. as the compliance
+	// evidence. The record is appended once per rotation when finishSecret promotes the new version.
+	// The records are hash-chained and never include the secret's values, see AuditRecord.
+	// The store's failure is logged and does not fail the step.
+	AuditStore AuditStore
+
+	// MinRotationInterval (optional) the minimum interval between the rotations, e.g. to prevent the rapid rotations
+	// by the misfiring schedule. createSecret fails with ErrRotatedTooRecently if the secret was rotated within
+	// the interval. The time of the rotation is stored in the secret's tag TagLastRotated by finishSecret,
+	// hence SecretsmanagerClient must implement SecretsmanagerTaggingClient.
+	MinRotationInterval time.Duration
+
+	// PasswordHistorySize (optional) the number of the recent passwords which must not be reused, up to
+	// MaxPasswordHistorySize. The salted hashes of the passwords are stored in the secret's tag TagPasswordHistory
+	// by finishSecret, hence SecretsmanagerClient must implement SecretsmanagerTaggingClient.
+	// The generated password which matches any recorded hash is regenerated.
+	PasswordHistorySize int
+
+	// StartupJitter (optional) the upper bound of the random delay before createSecret starts,
+	// e.g. to spread the load on the service when many secrets are rotated on the same schedule.
+	StartupJitter time.Duration
+
+	// PasswordField the secret's attribute with the password, "password" by default.
+	// The new secret is not staged unless the attribute is set.
+	PasswordField string
+
+	// HealCurrentStageConflict set to `true` to remove the stage AWSCURRENT from all versions but the one
+	// returned by GetSecretValue if more than one version is labeled with AWSCURRENT.
+	// Otherwise, createSecret fails on the inconsistent stage state.
+	HealCurrentStageConflict bool
+
+	// PreflightKMSCheck set to `true` to check that the KMS key which encrypts the secret is enabled
+	// before the new secret is generated. KMSClient must be set.
+	PreflightKMSCheck bool
+
+	// KMSClient the client's instance to communicate with the AWS KMS, it is required for PreflightKMSCheck.
+	KMSClient KMSClient
+
+	// FieldEncryptor (optional) encrypts EncryptedFields before the secret is stored, and decrypts them on read,
+	// see NewKMSFieldEncryptor for the AWS KMS envelope encryption.
+	FieldEncryptor FieldEncryptor
+
+	// EncryptedFields the secret's attributes to encrypt with FieldEncryptor, the password's attribute by default.
+	EncryptedFields []string
+
+	// SecretBase64Encoded set to `true` to read and store the secret's JSON value base64-encoded in SecretString,
+	// e.g. when the secrets are provisioned so by the pipeline. The values which are JSON objects are read as is.
+	SecretBase64Encoded bool
+
+	// SkipFinishDescribe set to `true` to skip DescribeSecret in finishSecret if the event supplies
+	// the current version's ID, e.g. to reduce the API calls of the high-volume rotation.
+	// The secret is described if the current version is not supplied.
+	SkipFinishDescribe bool
+
+	// VerifyPromotion set to `true` to confirm that the version was moved to the stage AWSCURRENT by finishSecret.
+	VerifyPromotion bool
+
+	// DetectNoOpRotation set to `true` to compare the passwords at the stages AWSCURRENT and AWSPREVIOUS
+	// after finishSecret moves the stage AWSCURRENT. finishSecret fails with ErrNoOpRotation, and the metric
+	// NoOpRotation is emitted if they are identical, i.e. no real rotation happened.
+	DetectNoOpRotation bool
+
+	// ReconcilePromotion set to `true` to re-describe the secret after the stage AWSCURRENT is moved by finishSecret,
+	// and to reconcile the stages, so the version is the only version at the stage AWSCURRENT,
+	// e.g. when the move was half-applied by the retried call of UpdateSecretVersionStage.
+	ReconcilePromotion bool
+
+	// STSClient (optional) the client's instance to communicate with the AWS STS, it's used to check
+	// that the secret belongs to the lambda's account. The check is skipped if not set.
+	STSClient STSClient
+
+	// AllowCrossAccount set to `true` to rotate the secrets which belong to the accounts other than the lambda's one.
+	AllowCrossAccount bool
+
+	// VerifyRotationOwnership set to `true` to refuse the rotation with ErrNotRotationOwner if the secret's
+	// rotation lambda is not the invoked lambda function, e.g. the secret is wired to another rotator.
+	VerifyRotationOwnership bool
+
+	// VerifyOldPasswordRevoked set to `true` to verify that the secret of the previous version fails the test
+	// after finishSecret, i.e. that the rotation changed the credentials. The step fails if the old secret passes,
+	// or if its test fails with the error other than ErrAuthFailed, e.g. because the service is unreachable.
+	VerifyOldPasswordRevoked bool
+
+	// RollbackOnPostFinishFailure set to `true` to test the secret right after it's promoted to the stage AWSCURRENT
+	// by finishSecret, and to roll the stage back to the previous version if the test fails.
+	RollbackOnPostFinishFailure bool
+
+	// StepTimeBudgets (optional) the minimum time by the step which must remain before the invocation's deadline
+	// to start the step by RunFullRotation, DefaultStepTimeBudgets are used for the steps not set.
+	StepTimeBudgets map[string]time.Duration
+
+	// ReclaimStalePending set to `true` to remove the stage AWSPENDING from the versions other than the rotated one
+	// by createSecret, e.g. left by the stuck rotation. Otherwise, createSecret fails with ErrStalePending.
+	ReclaimStalePending bool
+
+	// RotationLockTTL (optional) the time the rotation in progress is marked for: createSecret fails
+	// with ErrRotationInProgress if the other version of the secret is pending with the marker set within the TTL,
+	// e.g. by the concurrent rotation, even if ReclaimStalePending is set. The marker is the RotationMetadata,
+	// hence RotationMetadataField must be set.
+	RotationLockTTL time.Duration
+
+	// CleanupStrayPendingVersions set to `true` to remove the stage AWSPENDING from the versions other than
+	// the promoted one by finishSecret, e.g. the versions left by the failed rotations.
+	CleanupStrayPendingVersions bool
+
+	// DownstreamSyncers (optional) propagate the secret to the downstream stores after it's promoted to AWSCURRENT
+	// by finishSecret. The propagation is repeated when finishSecret is retried for the promoted version.
+	DownstreamSyncers []DownstreamSyncer
+
+	// DownstreamSyncNonFatal set to `true` to log the failures of DownstreamSyncers instead of failing finishSecret.
+	DownstreamSyncNonFatal bool
+
+	// CachePendingSecrets set to `true` to cache the secret generated by createSecret until it's staged as AWSPENDING,
+	// so the retried createSecret for the same token stages the same secret instead of generating a new one,
+	// e.g. when PutSecretValue failed after the password was reset in the service. The cache lives as long as
+	// the lambda's execution environment.
+	CachePendingSecrets bool
+
+	// pendingSecrets the cache of the generated secrets which are yet to be staged, set if CachePendingSecrets is set.
+	pendingSecrets *pendingSecrets
+
+	// DurationBuckets (optional) the upper bounds of the buckets to label the steps' durations emitted by the default
+	// Metrics, e.g. to track the SLO. Ignored if Metrics is set, see NewEMFMetrics.
+	DurationBuckets []time.Duration
+
+	// Metrics (optional) records the lambda's metrics, the metrics are written to stdout
+	// in the CloudWatch Embedded Metric Format by default. Use NoopMetrics to deactivate metrics.
+	Metrics Metrics
+
+	// Engine (optional) the database engine, e.g. "neon-postgres", set as the dimension Engine of all metrics,
+	// e.g. to slice the dashboards by engine.
+	Engine string
+
+	// TracerProvider (optional) the OpenTelemetry tracer provider to record the span of every step.
+	// The span's attributes identify the secret and the step, the secret's value is never recorded.
+	TracerProvider trace.TracerProvider
+
+	// MeterProvider (optional) the OpenTelemetry meter provider to record the metrics if Metrics is not set.
+	MeterProvider metric.MeterProvider
+
+	// PushgatewayURL (optional) the URL of the Prometheus Pushgateway, e.g. http://pushgateway:9091, to push
+	// the metrics of every invocation to at the end of the invocation in addition to Metrics.
+	// The failed push is logged, it does not fail the step.
+	PushgatewayURL string
+
+	// PushgatewayJob (optional) the job label of the metrics pushed to the Pushgateway, "secret_rotation" by default.
+	// The metrics are grouped by the job and the label "secret" set to the secret's name, the metrics of the group
+	// are replaced by every push, i.e. the group holds the last invocation's metrics of the secret.
+	PushgatewayJob string
+
+	// LogSummary set to `true` to log the invocation's summary as a single JSON line when the invocation completes:
+	// the step, the outcome, the duration, the secret's name, the features used, and the number of retries.
+	LogSummary bool
+
+	// LogRequestIDs set to `true` to log the request IDs of the AWS Secretsmanager API calls at debug level,
+	// e.g. for support escalation. The request IDs are logged if Debug is set as well.
+	LogRequestIDs bool
+
 	// Debug set to `true` to activate debug level logs.
 	Debug bool
 }
 
-// secretsmanagerTriggerPayload defines the AWS Lambda function's event payload type.
-type secretsmanagerTriggerPayload struct {
+// SecretsmanagerTriggerPayload defines the AWS Lambda function's event payload type.
+type SecretsmanagerTriggerPayload struct {
 	// The secret ARN or identifier
 	SecretARN string `json:"SecretId"`
 
 	// The ClientRequestToken of the secret version
 	Token string `json:"ClientRequestToken"`
 
-	// The rotation step (one of createSecret, setSecret, testSecret, or finishSecret)
+	// The rotation step (one of createSecret, setSecret, testSecret, or finishSecret),
+	// or validateCurrent to test the AWSCURRENT secret without rotation, e.g. on schedule
 	Step string `json:"Step"`
+
+	// CurrentVersionID (optional) the version ID at the stage AWSCURRENT, e.g. the output of the prior step
+	// in the Step Functions' state machine, finishSecret skips DescribeSecret if set and SkipFinishDescribe is `true`
+	CurrentVersionID string `json:"CurrentVersionId,omitempty"`
+}
+
+// UnmarshalJSON decodes the payload of Secretsmanager, or the payload wrapped in the attribute detail,
+// e.g. when the lambda is invoked by the Step Functions' state machine, or EventBridge rule.
+func (p *SecretsmanagerTriggerPayload) UnmarshalJSON(data []byte) error {
+	type payload SecretsmanagerTriggerPayload
+	var v struct {
+		payload
+		Detail json.RawMessage `json:"detail"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	if v.payload == (payload{}) && len(v.Detail) > 0 && string(v.Detail) != "null" {
+		var o payload
+		if err := json.Unmarshal(v.Detail, &o); err != nil {
+			return fmt.Errorf("decode detail: %w", err)
+		}
+		v.payload = o
+	}
+
+	*p = SecretsmanagerTriggerPayload(v.payload)
+	return nil
+}
+
+// NewTriggerPayload creates the AWS Lambda function's event payload, e.g. to invoke the handler in tests.
+func NewTriggerPayload(secretARN, token, step string) (SecretsmanagerTriggerPayload, error) {
+	o := SecretsmanagerTriggerPayload{
+		SecretARN: secretARN,
+		Token:     token,
+		Step:      step,
+	}
+	if err := o.Validate(); err != nil {
+		return SecretsmanagerTriggerPayload{}, err
+	}
+	return o, nil
+}
+
+// Validate checks that the payload defines the secret, the version token and the known rotation step.
+// The token is not required for the step validateCurrent.
+func (p SecretsmanagerTriggerPayload) Validate() error {
+	if p.SecretARN == "" {
+		return errors.New("secret ARN must be set")
+	}
+	if p.Step == "validateCurrent" {
+		return nil
+	}
+	if p.Token == "" {
+		return errors.New("token must be set")
+	}
+	switch p.Step {
+	case "createSecret", "setSecret", "testSecret", "finishSecret":
+		return nil
+	default:
+		return errors.New("unknown step " + p.Step)
+	}
 }
 
 // NewHandler initialises lambda handler.
-func NewHandler(cfg Config) (func(ctx context.Context, event secretsmanagerTriggerPayload) error, error) {
+func NewHandler(cfg Config) (func(ctx context.Context, event SecretsmanagerTriggerPayload) error, error) {
 	if cfg.SecretObj == nil {
 		return nil, errors.New("configuration for SecretObj type must be set")
 	}
 
-	return func(ctx context.Context, event secretsmanagerTriggerPayload) error {
-		if cfg.Debug {
-			log.Println(
-				"[DEBUG] arn: " + event.SecretARN + "; step: " + event.Step + "; token: " + event.Token + "\n",
-			)
+	if cfg.PreflightKMSCheck && cfg.KMSClient == nil {
+		return nil, errors.New("configuration for KMSClient must be set to run the KMS preflight check")
+	}
+
+	if _, ok := cfg.SecretsmanagerClient.(SecretsmanagerTaggingClient); cfg.MaxAttempts > 0 && !ok {
+		return nil, errors.New("SecretsmanagerClient must implement SecretsmanagerTaggingClient to track MaxAttempts")
+	}
+
+	if _, ok := cfg.SecretsmanagerClient.(SecretsmanagerTaggingClient); cfg.MinRotationInterval > 0 && !ok {
+		return nil, errors.New(
+			"SecretsmanagerClient must implement SecretsmanagerTaggingClient to check MinRotationInterval",
+		)
+	}
+
+	if cfg.PasswordHistorySize > MaxPasswordHistorySize {
+		return nil, errors.New(
+			"PasswordHistorySize must not exceed " + strconv.Itoa(MaxPasswordHistorySize),
+		)
+	}
+
+	if _, ok := cfg.SecretsmanagerClient.(SecretsmanagerTaggingClient); cfg.PasswordHistorySize > 0 && !ok {
+		return nil, errors.New(
+			"SecretsmanagerClient must implement SecretsmanagerTaggingClient to record the password history",
+		)
+	}
+
+	if cfg.RandSource != nil && cfg.RandSource != rand.Reader {
+		if !cfg.AllowInsecureRandSource {
+			return nil, errors.New("RandSource other than crypto/rand.Reader requires AllowInsecureRandSource")
 		}
-		if err := validateInput(ctx, event, cfg.SecretsmanagerClient); err != nil {
-			if cfg.Debug {
-				log.Println("[DEBUG] validation error:+" + err.Error() + "\n")
-			}
-			return err
+		log.Println("[WARN] the passwords are generated using RandSource other than crypto/rand.Reader")
+	}
+
+	if cfg.RotationLockTTL > 0 && cfg.RotationMetadataField == "" {
+		return nil, errors.New(
+			"configuration for RotationMetadataField must be set to mark the rotation in progress with RotationLockTTL",
+		)
+	}
+
+	routes, err := newServiceClientRoutes(cfg.ServiceClients)
+	if err != nil {
+		return nil, err
+	}
+
+	var breakers *circuitBreakers
+	if cfg.CircuitBreaker != nil {
+		if err := cfg.CircuitBreaker.validate(); err != nil {
+			return nil, err
 		}
+		breakers = newCircuitBreakers(*cfg.CircuitBreaker)
+	}
 
-		// routes to appropriate step.
-		switch s := event.Step; s {
-		case "createSecret":
-			return createSecret(ctx, event, cfg)
-		case "setSecret":
-			return setSecret(ctx, event, cfg)
-		case "testSecret":
-			return testSecret(ctx, event, cfg)
-		case "finishSecret":
-			return finishSecret(ctx, event, cfg)
-		default:
-			return errors.New("unknown step " + s)
+	if cfg.Retryer != nil {
+		cfg.SecretsmanagerClient = retryerClient{client: cfg.SecretsmanagerClient, retryer: cfg.Retryer}
+	}
+
+	if cfg.SecretBase64Encoded {
+		cfg.SecretsmanagerClient = base64SecretClient{SecretsmanagerClient: cfg.SecretsmanagerClient}
+	}
+
+	if cfg.FieldEncryptor != nil {
+		fields := cfg.EncryptedFields
+		if len(fields) == 0 {
+			fields = []string{cfg.passwordField()}
+		}
+		cfg.SecretsmanagerClient = fieldEncryptingClient{
+			SecretsmanagerClient: cfg.SecretsmanagerClient, encryptor: cfg.FieldEncryptor, fields: fields,
 		}
+	}
+
+	if cfg.LogRequestIDs || cfg.Debug {
+		cfg.SecretsmanagerClient = requestIDLoggingClient{client: cfg.SecretsmanagerClient}
+	}
+
+	account := &callerAccount{client: cfg.STSClient}
+
+	if cfg.Metrics == nil && cfg.MeterProvider != nil {
+		cfg.Metrics = NewOTelMetrics(cfg.MeterProvider)
+	}
+
+	if cfg.Metrics == nil && len(cfg.DurationBuckets) > 0 {
+		cfg.Metrics = NewEMFMetrics(os.Stdout, cfg.DurationBuckets...)
+	}
+
+	if cfg.CachePendingSecrets {
+		cfg.pendingSecrets = &pendingSecrets{}
+	}
+
+	logConfigSummary(cfg)
+
+	return func(ctx context.Context, event SecretsmanagerTriggerPayload) error {
+		cfg := cfg
+		cfg.ServiceClient = routes.route(event.SecretARN, cfg.ServiceClient)
+		if breakers != nil && cfg.ServiceClient != nil {
+			cfg.ServiceClient = withCircuitBreaker(cfg.ServiceClient, breakers.get(event.SecretARN))
+		}
+		// the secret is decoded into the copy of the configured SecretObj, so the attributes of the secret
+		// rotated earlier by the warm container do not leak into the secret which omits them
+		cfg.SecretObj = initNewSecretObj(cfg.SecretObj)
+
+		var pushgateway *pushgatewayMetrics
+		if cfg.PushgatewayURL != "" {
+			pushgateway = newPushgatewayMetrics(cfg.metrics())
+			cfg.Metrics = pushgateway
+		}
+
+		if cfg.Engine != "" {
+			cfg.Metrics = engineMetrics{Metrics: cfg.metrics(), engine: cfg.Engine}
+		}
+
+		ctx, endSpan := startStepSpan(ctx, cfg.TracerProvider, event)
+		ctx, features := withFeatureRecorder(ctx)
+
+		start := time.Now()
+		err := handle(ctx, event, cfg, account)
+		duration := time.Since(start)
+		recordStepMetrics(cfg.metrics(), event.Step, duration, err)
+		features.report(cfg.metrics(), event.Step)
+		if cfg.LogSummary {
+			logSummary(event, duration, features, err)
+		}
+
+		if cfg.AuditStore != nil {
+			recordAudit(ctx, cfg.AuditStore, event, features, start, duration, err)
+		}
+
+		if pushgateway != nil {
+			if errPush := pushgateway.push(
+				ctx, cfg.PushgatewayURL, cfg.PushgatewayJob, secretShortName(event.SecretARN),
+			); errPush != nil {
+				log.Println("[WARN] failed to push the metrics to the Pushgateway: " + errPush.Error())
+			}
+		}
+
+		endSpan(err)
+		return err
 	}, nil
 }
 
+// handle validates the event and routes it to the appropriate step.
+func handle(ctx context.Context, event SecretsmanagerTriggerPayload, cfg Config, account *callerAccount) error {
+	if cfg.Debug {
+		log.Println(
+			"[DEBUG] arn: " + event.SecretARN + "; step: " + event.Step + "; token: " + event.Token + "\n",
+		)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", event.Step, err)
+	}
+
+	if err := requireServiceClient(event.Step, cfg); err != nil {
+		return fmt.Errorf("%s: %w", event.Step, err)
+	}
+
+	ctx = withSecretTagsLoader(ctx, cfg.SecretsmanagerClient, event.SecretARN)
+	ctx = WithRotationToken(ctx, event.Token)
+
+	if cfg.STSClient != nil && !cfg.AllowCrossAccount {
+		if err := account.checkSecretAccount(ctx, event.SecretARN); err != nil {
+			return fmt.Errorf("%s: %w", event.Step, err)
+		}
+	}
+
+	if cfg.VerifyRotationOwnership {
+		RecordFeature(ctx, featureRotationOwnership)
+		if cfg.Debug {
+			log.Println("[DEBUG] Check the rotation lambda of the secret: " + event.SecretARN)
+		}
+		if err := checkRotationOwnership(ctx, cfg.SecretsmanagerClient, event.SecretARN); err != nil {
+			return fmt.Errorf("%s: %w", event.Step, err)
+		}
+	}
+
+	// the AWSCURRENT secret is validated outside the rotation, hence the token is not checked.
+	if event.Step == "validateCurrent" {
+		if err := validateCurrent(ctx, event, cfg); err != nil {
+			return fmt.Errorf("%s: %w", event.Step, err)
+		}
+		return nil
+	}
+
+	if err := validateInput(ctx, event, cfg.SecretsmanagerClient); err != nil {
+		if cfg.Debug {
+			log.Println("[DEBUG] validation error:+" + err.Error() + "\n")
+		}
+		return fmt.Errorf("%s: validate input: %w", event.Step, err)
+	}
+
+	// routes to appropriate step.
+	var err error
+	switch s := event.Step; s {
+	case "createSecret":
+		err = createSecret(ctx, event, cfg)
+	case "setSecret":
+		err = setSecret(ctx, event, cfg)
+	case "testSecret":
+		err = testSecret(ctx, event, cfg)
+	case "finishSecret":
+		err = finishSecret(ctx, event, cfg)
+	default:
+		return errors.New("unknown step " + s)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", event.Step, err)
+	}
+	return nil
+}
+
+// ErrNoServiceClient the ServiceClient is not set, while the step requires it.
+var ErrNoServiceClient = errors.New("ServiceClient must be set")
+
+// ErrAuthFailed the service rejected the secret's credentials. The ServiceClient wraps it in the error of Test
+// to confirm that the old password is revoked, see Config.VerifyOldPasswordRevoked.
+var ErrAuthFailed = errors.New("authentication failed")
+
+// requireServiceClient checks that the ServiceClient is set if the step calls it: createSecret, setSecret,
+// testSecret and validateCurrent always call it; finishSecret calls it only to verify that the old password
+// is revoked, or to test the promoted secret.
+func requireServiceClient(step string, cfg Config) error {
+	if cfg.ServiceClient != nil {
+		return nil
+	}
+
+	switch step {
+	case "createSecret":
+		return fmt.Errorf("%w to generate the secret", ErrNoServiceClient)
+	case "setSecret":
+		return fmt.Errorf("%w to set the secret in the service", ErrNoServiceClient)
+	case "testSecret", "validateCurrent":
+		return fmt.Errorf("%w to test the secret", ErrNoServiceClient)
+	case "finishSecret":
+		if cfg.VerifyOldPasswordRevoked {
+			return fmt.Errorf("%w to verify that the old password is revoked", ErrNoServiceClient)
+		}
+		if cfg.RollbackOnPostFinishFailure {
+			return fmt.Errorf("%w to test the promoted secret", ErrNoServiceClient)
+		}
+	}
+	return nil
+}
+
+type serviceClientRoute struct {
+	pattern *regexp.Regexp
+	client  ServiceClient
+}
+
+type serviceClientRoutes []serviceClientRoute
+
+func newServiceClientRoutes(clients map[string]ServiceClient) (serviceClientRoutes, error) {
+	patterns := make([]string, 0, len(clients))
+	for p := range clients {
+		patterns = append(patterns, p)
+	}
+	sort.Strings(patterns)
+
+	o := make(serviceClientRoutes, len(patterns))
+	for i, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, errors.New("faulty ServiceClients pattern " + p + ": " + err.Error())
+		}
+		o[i] = serviceClientRoute{pattern: re, client: clients[p]}
+	}
+	return o, nil
+}
+
+// route selects the ServiceClient for the secret ARN, defaultClient is returned if no pattern matches.
+func (r serviceClientRoutes) route(secretARN string, defaultClient ServiceClient) ServiceClient {
+	for _, v := range r {
+		if v.pattern.MatchString(secretARN) {
+			return v.client
+		}
+	}
+	return defaultClient
+}
+
 // SecretsmanagerClient client to communicate with the secretsmanager.
 type SecretsmanagerClient interface {
 	GetSecretValue(
@@ -101,9 +686,18 @@ type SecretsmanagerClient interface {
 	) (*secretsmanager.UpdateSecretVersionStageOutput, error)
 }
 
+// KMSClient client to communicate with the AWS KMS.
+type KMSClient interface {
+	DescribeKey(
+		ctx context.Context, input *kms.DescribeKeyInput, optFns ...func(*kms.Options),
+	) (*kms.DescribeKeyOutput, error)
+}
+
 // ServiceClient defines the interface to communicate with the service (e.g. database) to rotate the access credentials.
 type ServiceClient interface {
 	// Create generates the secret and mutates the `secret` value.
+	// It must not connect to the system delegated credentials storage, e.g. the database,
+	// the connections are opened by Set and Test only to minimise the window of the open connection.
 	Create(ctx context.Context, secret any) error
 
 	// Set sets newly generated credentials in the system delegated credentials storage.
@@ -114,22 +708,22 @@ type ServiceClient interface {
 }
 
 // validateInput checks if the secret version is staged correctly.
-func validateInput(ctx context.Context, event secretsmanagerTriggerPayload, client SecretsmanagerClient) error {
+func validateInput(ctx context.Context, event SecretsmanagerTriggerPayload, client SecretsmanagerClient) error {
 	v, err := client.DescribeSecret(
 		ctx, &secretsmanager.DescribeSecretInput{
 			SecretId: aws.String(event.SecretARN),
 		},
 	)
 	if err != nil {
-		return err
+		return fmt.Errorf("describe secret %s: %w", event.SecretARN, err)
 	}
 
 	if v.RotationEnabled == nil || !aws.ToBool(v.RotationEnabled) {
 		return errors.New("secret " + event.SecretARN + " is not enabled for rotation")
 	}
 
-	versions, ok := v.VersionIdsToStages[event.Token]
-	if !ok || len(versions) == 0 {
+	stages := v.VersionIdsToStages[event.Token]
+	if !hasStage(stages, StageCurrent) && !hasStage(stages, StagePending) {
 		return errors.New("secret version " + event.Token + " has no stage for rotation of secret " + event.SecretARN)
 	}
 
@@ -138,18 +732,68 @@ func validateInput(ctx context.Context, event secretsmanagerTriggerPayload, clie
 
 // createSecret the method first checks for the existence of a secret for the passed in secretARN.
 // If one does not exist, it will generate a new secret and put it with the passed in secretARN.
-func createSecret(ctx context.Context, event secretsmanagerTriggerPayload, cfg Config) error {
+func createSecret(ctx context.Context, event SecretsmanagerTriggerPayload, cfg Config) error {
+	if cfg.StartupJitter > 0 {
+		RecordFeature(ctx, featureStartupJitter)
+		if cfg.Debug {
+			log.Println("[DEBUG] Delay the rotation by the random jitter up to " + cfg.StartupJitter.String())
+		}
+		if err := sleepJitter(ctx, cfg.StartupJitter); err != nil {
+			return fmt.Errorf("startup jitter: %w", err)
+		}
+	}
+
 	if cfg.Debug {
 		log.Println("[DEBUG] Fetch AWSCURRENT of the secret: " + event.SecretARN)
 	}
-	v, err := getSecretValue(ctx, cfg.SecretsmanagerClient, event.SecretARN, "AWSCURRENT", "")
+	v, err := getSecretValue(ctx, cfg.SecretsmanagerClient, event.SecretARN, StageCurrent, "")
 	if err != nil {
 		if cfg.Debug {
 			if cfg.Debug {
 				log.Println("[DEBUG] error: " + err.Error())
 			}
 		}
-		return err
+		return fmt.Errorf("get AWSCURRENT of the secret %s: %w", event.SecretARN, err)
+	}
+
+	if cfg.Debug {
+		log.Println("[DEBUG] Check that a single version is labeled with the stage AWSCURRENT")
+	}
+	if err := checkCurrentStageConflict(
+		ctx, cfg.SecretsmanagerClient, event.SecretARN, aws.ToString(v.VersionId), cfg.HealCurrentStageConflict,
+	); err != nil {
+		if cfg.Debug {
+			log.Println("[DEBUG] error: " + err.Error())
+		}
+		return fmt.Errorf("check AWSCURRENT stage conflict: %w", err)
+	}
+
+	if cfg.MinRotationInterval > 0 {
+		RecordFeature(ctx, featureMinRotationInterval)
+		if cfg.Debug {
+			log.Println("[DEBUG] Check the time of the last rotation of the secret: " + event.SecretARN)
+		}
+		if err := checkRotationInterval(ctx, event, cfg); err != nil {
+			if cfg.Debug {
+				log.Println("[DEBUG] error: " + err.Error())
+			}
+			return err
+		}
+	}
+
+	// every invocation is counted, including the one which finds the pending version, because the rotation
+	// is retried from createSecret when any of the following steps fails
+	if cfg.MaxAttempts > 0 {
+		RecordFeature(ctx, featureMaxAttempts)
+		if cfg.Debug {
+			log.Println("[DEBUG] Count the rotation attempt of the version: " + event.Token)
+		}
+		if err := countAttempt(ctx, event, cfg); err != nil {
+			if cfg.Debug {
+				log.Println("[DEBUG] error: " + err.Error())
+			}
+			return err
+		}
 	}
 
 	if cfg.Debug {
@@ -158,71 +802,524 @@ func createSecret(ctx context.Context, event secretsmanagerTriggerPayload, cfg C
 				event.SecretARN,
 		)
 	}
-	if _, err := getSecretValue(
-		ctx, cfg.SecretsmanagerClient, event.SecretARN, "AWSPENDING", event.Token,
-	); nil == err {
+	if pending, err := getSecretValue(
+		ctx, cfg.SecretsmanagerClient, event.SecretARN, StagePending, event.Token,
+	); nil == err && hasStage(pending.VersionStages, StagePending) {
+		// the staged secret is reused as is, so the retried createSecret does not change the password again
+		logIdempotentSkip(cfg.metrics(), "createSecret", "AWSPENDING exists for the version "+event.Token)
+		return nil
+	}
+
+	if cfg.Debug {
+		log.Println("[DEBUG] Check that the stage AWSPENDING is not assigned to other versions of the secret")
+	}
+	if err := checkStalePending(ctx, event, cfg); err != nil {
 		if cfg.Debug {
-			log.Println("[DEBUG] AWSPENDING exists, return.")
+			log.Println("[DEBUG] error: " + err.Error())
+		}
+		return err
+	}
+
+	if cfg.PreflightKMSCheck {
+		RecordFeature(ctx, featurePreflightKMSCheck)
+		if cfg.Debug {
+			log.Println("[DEBUG] Check that the KMS key of the secret is enabled: " + event.SecretARN)
+		}
+		if err := checkKMSKeyEnabled(ctx, cfg.SecretsmanagerClient, cfg.KMSClient, event.SecretARN); err != nil {
+			if cfg.Debug {
+				log.Println("[DEBUG] error: " + err.Error())
+			}
+			return fmt.Errorf("preflight KMS check: %w", err)
+		}
+	}
+
+	// the key is checked before the secret is generated, because the ServiceClient may change the password
+	// in the service when it generates the secret
+	if cfg.KMSKeyResolver != nil {
+		if cfg.Debug {
+			log.Println("[DEBUG] Check the KMS key of the secret: " + event.SecretARN)
+		}
+		if err := checkKMSKey(ctx, cfg.SecretsmanagerClient, event.SecretARN, cfg.KMSKeyResolver); err != nil {
+			if cfg.Debug {
+				log.Println("[DEBUG] error: " + err.Error())
+			}
+			return fmt.Errorf("check KMS key: %w", err)
 		}
-		return nil
 	}
 
 	if cfg.Debug {
 		log.Println("[DEBUG] Deserialize secret from the stage AWSCURRENT")
 	}
-	if err := ExtractSecretObject(v, cfg.SecretObj); err != nil {
+	if err := cfg.decodeSecret(v, cfg.SecretObj); err != nil {
+		if cfg.Debug {
+			log.Println("[DEBUG] error: " + err.Error())
+		}
+		return fmt.Errorf("deserialize AWSCURRENT: %w", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	o, ok := cfg.pendingSecrets.load(event.SecretARN, event.Token)
+	if ok {
+		log.Println("[INFO] reuse the secret generated earlier for the version " + event.Token)
+	} else {
+		if o, err = generateSecret(withSecretKind(ctx, cfg, v), cfg, v); err != nil {
+			if cfg.Debug {
+				log.Println("[DEBUG] error: " + err.Error())
+			}
+			return fmt.Errorf("generate secret: %w", err)
+		}
+
+		if cfg.RotationMetadataField != "" {
+			if o, err = addRotationMetadata(o, cfg.RotationMetadataField, event.Token); err != nil {
+				if cfg.Debug {
+					log.Println("[DEBUG] error: " + err.Error())
+				}
+				return fmt.Errorf("add rotation metadata: %w", err)
+			}
+		}
+
+		if len(cfg.AllowedSecretFields) > 0 {
+			if o, err = filterSecretFields(o, cfg); err != nil {
+				if cfg.Debug {
+					log.Println("[DEBUG] error: " + err.Error())
+				}
+				return fmt.Errorf("filter secret's fields: %w", err)
+			}
+		}
+
+		cfg.pendingSecrets.store(event.SecretARN, event.Token, o)
+	}
+
+	if len(cfg.TokenFields) > 0 {
+		if o, err = stampToken(o, cfg.TokenFields, event.Token); err != nil {
+			if cfg.Debug {
+				log.Println("[DEBUG] error: " + err.Error())
+			}
+			return fmt.Errorf("stamp token: %w", err)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if cfg.Debug {
+		log.Println("[DEBUG] Put newly generated secret to AWSPENDING stage")
+	}
+	if err := putPendingSecret(ctx, event, cfg, o); err != nil {
 		if cfg.Debug {
 			log.Println("[DEBUG] error: " + err.Error())
 		}
-		return err
+		return fmt.Errorf("put AWSPENDING of the secret %s: %w", event.SecretARN, err)
+	}
+	cfg.pendingSecrets.delete(event.SecretARN, event.Token)
+	return nil
+}
+
+// defaultMaxPutAttempts the default number of attempts to put the generated secret to the stage AWSPENDING.
+const defaultMaxPutAttempts = 3
+
+// putRetryInterval the interval between the attempts to put the generated secret, it grows with every attempt.
+var putRetryInterval = 200 * time.Millisecond
+
+// putPendingSecret puts the generated secret to the stage AWSPENDING, the put is retried with the same secret
+// if it fails with the transient error. The retry is idempotent because the version's token is the same.
+func putPendingSecret(ctx context.Context, event SecretsmanagerTriggerPayload, cfg Config, secret *string) error {
+	maxAttempts := cfg.MaxPutAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxPutAttempts
+	}
+
+	for attempt := 1; ; attempt++ {
+		_, err := cfg.SecretsmanagerClient.PutSecretValue(
+			ctx, &secretsmanager.PutSecretValueInput{
+				SecretId:           aws.String(event.SecretARN),
+				ClientRequestToken: aws.String(event.Token),
+				SecretString:       secret,
+				VersionStages:      []string{StagePending},
+			},
+		)
+		if err == nil || attempt >= maxAttempts || !isTransientError(err) {
+			return err
+		}
+
+		log.Println("[WARN] put of the generated secret failed, retry with the same secret: " + err.Error())
+		RecordRetry(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * putRetryInterval):
+		}
+	}
+}
+
+// isTransientError checks if the AWS API call's error is transient, e.g. the throttling, or the server's error,
+// according to the SDK's standard classification of the retryable errors.
+func isTransientError(err error) bool {
+	return retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary
+}
+
+// pendingSecrets the concurrency-safe cache of the generated secrets by the secret ARN and the version token.
+// The nil cache does not store secrets.
+type pendingSecrets struct {
+	mu sync.Mutex
+	v  map[string]*string
+}
+
+func (c *pendingSecrets) load(secretARN, token string) (*string, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	o, ok := c.v[secretARN+"/"+token]
+	return o, ok
+}
+
+func (c *pendingSecrets) store(secretARN, token string, secret *string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.v == nil {
+		c.v = map[string]*string{}
+	}
+	c.v[secretARN+"/"+token] = secret
+}
+
+func (c *pendingSecrets) delete(secretARN, token string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.v, secretARN+"/"+token)
+}
+
+// ErrUnchangedSecret the generated secret is identical to the current secret, i.e. the generation did nothing.
+var ErrUnchangedSecret = errors.New("generated secret is identical to the current secret")
+
+// ErrPlaceholderPassword the generated password is the placeholder, e.g. carried forward from the bootstrapped secret.
+var ErrPlaceholderPassword = errors.New("generated password is the placeholder")
+
+// DefaultPlaceholderPasswords the placeholder passwords commonly set to bootstrap the secrets.
+var DefaultPlaceholderPasswords = []string{"placeholder", "changeme", "change_me", "password"}
+
+// isPlaceholderPassword checks if the password matches any of the placeholders case-insensitively,
+// DefaultPlaceholderPasswords are checked if no placeholders are set.
+func isPlaceholderPassword(password string, placeholders []string) bool {
+	if placeholders == nil {
+		placeholders = DefaultPlaceholderPasswords
+	}
+	for _, placeholder := range placeholders {
+		if strings.EqualFold(password, placeholder) {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultMaxCreateAttempts the default number of attempts to generate the password which differs from the current.
+const defaultMaxCreateAttempts = 3
+
+// generateSecret generates and serialises the new secret. The secret is regenerated if its password matches
+// the password of the current secret, or the recent password recorded in the password history,
+// the error is returned if the attempts are exhausted.
+func generateSecret(ctx context.Context, cfg Config, current *secretsmanager.GetSecretValueOutput) (*string, error) {
+	maxAttempts := cfg.MaxCreateAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxCreateAttempts
+	}
+
+	currentPassword := secretAttribute(aws.ToString(current.SecretString), cfg.passwordField())
+
+	var history passwordHistory
+	if cfg.PasswordHistorySize > 0 {
+		RecordFeature(ctx, featurePasswordHistory)
+		var err error
+		if history, err = loadPasswordHistory(ctx); err != nil {
+			return nil, fmt.Errorf("load password history: %w", err)
+		}
+	}
+
+	if cfg.RandSource != nil {
+		ctx = withRandSource(ctx, cfg.RandSource, cfg.AllowInsecureRandSource)
+	}
+
+	for attempt := 1; ; attempt++ {
+		if cfg.Debug {
+			log.Println("[DEBUG] Generate new secret")
+		}
+		if err := cfg.ServiceClient.Create(ctx, cfg.SecretObj); err != nil {
+			if errors.Is(err, ErrEntropySource) {
+				log.Println("[ERROR] the source of randomness failed, the password is not generated: " + err.Error())
+				cfg.metrics().IncCounter(metricEntropySourceFailure, map[string]string{"Step": "createSecret"})
+			}
+			return nil, fmt.Errorf("create: %w", err)
+		}
+
+		if cfg.Debug {
+			log.Println("[DEBUG] Serialize newly generated secret")
+		}
+		o, err := serialiseSecret(cfg.SecretObj, cfg.passwordField())
+		if err != nil {
+			return nil, fmt.Errorf("serialise: %w", err)
+		}
+
+		if *o == aws.ToString(current.SecretString) && !cfg.AllowUnchangedSecret {
+			return nil, ErrUnchangedSecret
+		}
+
+		password := secretAttribute(*o, cfg.passwordField())
+		if isPlaceholderPassword(password, cfg.PlaceholderPasswords) {
+			return nil, ErrPlaceholderPassword
+		}
+
+		var rejected error
+		switch {
+		case currentPassword != "" && password == currentPassword:
+			rejected = errors.New("generated password matches the current password")
+		case history.contains(password):
+			rejected = errors.New("generated password matches the recent password")
+		case cfg.TargetPasswordPolicy != nil:
+			RecordFeature(ctx, featureTargetPolicy)
+			rejected = cfg.TargetPasswordPolicy.Check(password)
+		}
+		if rejected == nil {
+			return o, nil
+		}
+
+		if attempt >= maxAttempts {
+			return nil, fmt.Errorf("%w after %d attempts", rejected, attempt)
+		}
+		log.Println("[WARN] " + rejected.Error() + ", regenerate the secret")
+	}
+}
+
+// secretAttribute returns the string attribute of the serialised secret.
+func secretAttribute(secret, attribute string) string {
+	var v map[string]any
+	if err := json.Unmarshal([]byte(secret), &v); err != nil {
+		return ""
+	}
+	o, _ := v[attribute].(string)
+	return o
+}
+
+// RotationMetadata defines the traceability details of the secret's version created by the rotation lambda.
+type RotationMetadata struct {
+	// CreatedBy the name of the lambda function which created the version.
+	CreatedBy string `json:"created_by"`
+	// Token the rotation token, i.e. the version ID.
+	Token string `json:"token"`
+	// TriggeredAt the time of the rotation in RFC3339 format.
+	TriggeredAt string `json:"triggered_at"`
+}
+
+// defaultRotationMetadataCreatedBy the creator's name used if the lambda function's name is unknown.
+const defaultRotationMetadataCreatedBy = "aws-lambda-secret-rotation"
+
+// addRotationMetadata sets the RotationMetadata as the attribute field of the serialised secret.
+func addRotationMetadata(secret *string, field, token string) (*string, error) {
+	var v map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(*secret), &v); err != nil {
+		return nil, err
+	}
+
+	createdBy := os.Getenv("AWS_LAMBDA_FUNCTION_NAME")
+	if createdBy == "" {
+		createdBy = defaultRotationMetadataCreatedBy
+	}
+
+	metadata, err := json.Marshal(
+		RotationMetadata{
+			CreatedBy:   createdBy,
+			Token:       token,
+			TriggeredAt: time.Now().UTC().Format(time.RFC3339),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	v[field] = metadata
+
+	o, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return (*string)(unsafe.Pointer(&o)), nil
+}
+
+// stampToken sets the attributes of the serialised secret to the token.
+func stampToken(secret *string, fields []string, token string) (*string, error) {
+	var v map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(*secret), &v); err != nil {
+		return nil, err
+	}
+
+	stamp, err := json.Marshal(token)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range fields {
+		if raw, ok := v[field]; ok && string(raw) != string(stamp) {
+			log.Println("[WARN] the secret's attribute " + field + " drifted from the token " + token + ", stamp it")
+		}
+		v[field] = stamp
+	}
+
+	o, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return (*string)(unsafe.Pointer(&o)), nil
+}
+
+// filterSecretFields drops the attributes of the serialised secret which are not listed in AllowedSecretFields.
+// The password's attribute and RotationMetadataField are always kept.
+func filterSecretFields(secret *string, cfg Config) (*string, error) {
+	var v map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(*secret), &v); err != nil {
+		return nil, err
+	}
+
+	allowed := map[string]struct{}{cfg.passwordField(): {}}
+	if cfg.RotationMetadataField != "" {
+		allowed[cfg.RotationMetadataField] = struct{}{}
+	}
+	for _, field := range cfg.AllowedSecretFields {
+		allowed[field] = struct{}{}
+	}
+
+	for field := range v {
+		if _, ok := allowed[field]; !ok {
+			log.Println("[WARN] drop the secret's attribute " + field + " which is not allowed")
+			delete(v, field)
+		}
+	}
+
+	o, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return (*string)(unsafe.Pointer(&o)), nil
+}
+
+// defaultSecretsmanagerKMSKey the AWS managed KMS key used to encrypt the secret if no key is assigned to it.
+const defaultSecretsmanagerKMSKey = "alias/aws/secretsmanager"
+
+// checkKMSKeyEnabled checks that the KMS key which encrypts the secret is enabled.
+func checkKMSKeyEnabled(
+	ctx context.Context, client SecretsmanagerClient, kmsClient KMSClient, secretARN string,
+) error {
+	v, err := client.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(secretARN)})
+	if err != nil {
+		return fmt.Errorf("describe secret %s: %w", secretARN, err)
+	}
+
+	keyID := aws.ToString(v.KmsKeyId)
+	if keyID == "" {
+		keyID = defaultSecretsmanagerKMSKey
+	}
+
+	key, err := kmsClient.DescribeKey(ctx, &kms.DescribeKeyInput{KeyId: aws.String(keyID)})
+	if err != nil {
+		return fmt.Errorf("describe KMS key %s: %w", keyID, err)
+	}
+
+	if key.KeyMetadata == nil || !key.KeyMetadata.Enabled || key.KeyMetadata.KeyState != kmsTypes.KeyStateEnabled {
+		var state string
+		if key.KeyMetadata != nil {
+			state = string(key.KeyMetadata.KeyState)
+		}
+		return errors.New("KMS key " + keyID + " of the secret " + secretARN + " is not enabled, state: " + state)
 	}
 
-	if cfg.Debug {
-		log.Println("[DEBUG] Generate new secret")
+	return nil
+}
+
+// checkKMSKey checks that the secret is encrypted with the KMS key resolved for the secret ARN.
+func checkKMSKey(
+	ctx context.Context, client SecretsmanagerClient, secretARN string, resolver func(secretARN string) string,
+) error {
+	want := resolver(secretARN)
+	if want == "" {
+		return nil
 	}
-	if err := cfg.ServiceClient.Create(ctx, cfg.SecretObj); err != nil {
-		return err
+
+	v, err := client.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(secretARN)})
+	if err != nil {
+		return fmt.Errorf("describe secret %s: %w", secretARN, err)
 	}
 
-	if cfg.Debug {
-		log.Println("[DEBUG] Serialize newly generated secret")
+	got := aws.ToString(v.KmsKeyId)
+	if got != want && !strings.HasSuffix(got, "/"+want) && !strings.HasSuffix(got, ":"+want) {
+		return errors.New("secret " + secretARN + " is encrypted with the KMS key " + got + ", expected " + want)
 	}
-	o, err := serialiseSecret(cfg.SecretObj)
+
+	return nil
+}
+
+// checkCurrentStageConflict checks that the stage AWSCURRENT labels a single version of the secret.
+// If heal is set, the stage is removed from all versions but currentVersionID.
+func checkCurrentStageConflict(
+	ctx context.Context, client SecretsmanagerClient, secretARN, currentVersionID string, heal bool,
+) error {
+	v, err := client.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(secretARN)})
 	if err != nil {
-		if cfg.Debug {
-			log.Println("[DEBUG] error: " + err.Error())
+		return fmt.Errorf("describe secret %s: %w", secretARN, err)
+	}
+
+	var versions []string
+	for version, stages := range v.VersionIdsToStages {
+		if hasStage(stages, StageCurrent) {
+			versions = append(versions, version)
 		}
-		return err
 	}
+	if len(versions) < 2 {
+		return nil
+	}
+	sort.Strings(versions)
 
-	if cfg.Debug {
-		log.Println("[DEBUG] Put newly generated secret to AWSPENDING stage")
+	if !heal || currentVersionID == "" || !hasStage(versions, currentVersionID) {
+		return errors.New(
+			"inconsistent stage state: versions " + strings.Join(versions, ", ") +
+				" are labeled with the stage AWSCURRENT of the secret " + secretARN,
+		)
 	}
-	_, err = cfg.SecretsmanagerClient.PutSecretValue(
-		ctx, &secretsmanager.PutSecretValueInput{
-			SecretId:           aws.String(event.SecretARN),
-			ClientRequestToken: aws.String(event.Token),
-			SecretString:       o,
-			VersionStages:      []string{"AWSPENDING"},
-		},
-	)
-	if err != nil && cfg.Debug {
-		if cfg.Debug {
-			log.Println("[DEBUG] error: " + err.Error())
+
+	for _, version := range versions {
+		if version == currentVersionID {
+			continue
+		}
+		log.Println("[INFO] remove the stage AWSCURRENT from the version " + version + " of the secret " + secretARN)
+		if _, err := client.UpdateSecretVersionStage(
+			ctx, &secretsmanager.UpdateSecretVersionStageInput{
+				SecretId:            aws.String(secretARN),
+				VersionStage:        aws.String(StageCurrent),
+				RemoveFromVersionId: aws.String(version),
+			},
+		); err != nil {
+			return fmt.Errorf("remove AWSCURRENT from the version %s: %w", version, err)
 		}
 	}
-	return err
+
+	return nil
 }
 
 // setSecret sets the AWSPENDING secret in the service that the secret belongs to.
 // For example, if the secret is a database credential,
 // this method should take the value of the AWSPENDING secret
 // and set the user's password to this value in the database.
-func setSecret(ctx context.Context, event secretsmanagerTriggerPayload, cfg Config) error {
+func setSecret(ctx context.Context, event SecretsmanagerTriggerPayload, cfg Config) error {
 	if cfg.Debug {
 		log.Println("[DEBUG] Fetch AWSPREVIOUS of the secret: " + event.SecretARN)
 	}
-	secretPrevious, err := getSecretValue(ctx, cfg.SecretsmanagerClient, event.SecretARN, "AWSPREVIOUS", "")
+	secretPrevious, err := getSecretValue(ctx, cfg.SecretsmanagerClient, event.SecretARN, StagePrevious, "")
 	switch err.(type) {
 	case *types.ResourceNotFoundException, nil:
 		secretPrevious = nil
@@ -232,37 +1329,41 @@ func setSecret(ctx context.Context, event secretsmanagerTriggerPayload, cfg Conf
 			case http.StatusBadRequest, http.StatusNotFound:
 				secretPrevious = nil
 			default:
-				return err
+				return fmt.Errorf("get AWSPREVIOUS of the secret %s: %w", event.SecretARN, err)
 			}
 		}
 	default:
 		if cfg.Debug {
 			log.Println("[DEBUG] error: " + err.Error())
 		}
-		return err
+		return fmt.Errorf("get AWSPREVIOUS of the secret %s: %w", event.SecretARN, err)
 	}
 
 	if cfg.Debug {
 		log.Println("[DEBUG] Fetch AWSCURRENT of the secret: " + event.SecretARN)
 	}
-	secretCurrent, err := getSecretValue(ctx, cfg.SecretsmanagerClient, event.SecretARN, "AWSCURRENT", "")
+	secretCurrent, err := getSecretValue(ctx, cfg.SecretsmanagerClient, event.SecretARN, StageCurrent, "")
 	if err != nil {
 		if cfg.Debug {
 			log.Println("[DEBUG] error: " + err.Error())
 		}
-		return err
+		return fmt.Errorf("get AWSCURRENT of the secret %s: %w", event.SecretARN, err)
 	}
 
 	if cfg.Debug {
 		log.Println("[DEBUG] Fetch AWSPENDING of the secret: " + event.SecretARN)
 	}
 	secretPending, err := getSecretValue(
-		ctx, cfg.SecretsmanagerClient, event.SecretARN, "AWSPENDING", event.Token,
+		ctx, cfg.SecretsmanagerClient, event.SecretARN, StagePending, event.Token,
 	)
 	if err != nil {
 		if cfg.Debug {
 			log.Println("[DEBUG] error: " + err.Error())
 		}
+		return fmt.Errorf("get AWSPENDING of the secret %s: %w", event.SecretARN, err)
+	}
+
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
@@ -271,23 +1372,26 @@ func setSecret(ctx context.Context, event secretsmanagerTriggerPayload, cfg Conf
 	}
 
 	current := initNewSecretObj(cfg.SecretObj)
-	if err := ExtractSecretObject(secretCurrent, current); err != nil {
-		return err
+	if err := cfg.decodeSecret(secretCurrent, current); err != nil {
+		return fmt.Errorf("deserialize AWSCURRENT: %w", err)
 	}
 
 	pending := initNewSecretObj(cfg.SecretObj)
-	if err := ExtractSecretObject(secretPending, pending); err != nil {
-		return err
+	if err := cfg.decodeSecret(secretPending, pending); err != nil {
+		return fmt.Errorf("deserialize AWSPENDING: %w", err)
 	}
 
 	previous := initNewSecretObj(cfg.SecretObj)
 	if secretPrevious != nil {
-		if err := ExtractSecretObject(secretPending, previous); err != nil {
-			return err
+		if err := cfg.decodeSecret(secretPending, previous); err != nil {
+			return fmt.Errorf("deserialize AWSPREVIOUS: %w", err)
 		}
 	}
 
-	return cfg.ServiceClient.Set(ctx, current, pending, previous)
+	if err := cfg.ServiceClient.Set(withSecretKind(ctx, cfg, secretPending), current, pending, previous); err != nil {
+		return fmt.Errorf("set: %w", err)
+	}
+	return nil
 }
 
 func initNewSecretObj(obj any) any {
@@ -365,39 +1469,91 @@ func translateRecursive(copy, original reflect.Value) {
 }
 
 // testSecret the method tries to log into the database with the secrets staged with AWSPENDING.
-func testSecret(ctx context.Context, event secretsmanagerTriggerPayload, cfg Config) error {
+func testSecret(ctx context.Context, event SecretsmanagerTriggerPayload, cfg Config) error {
 	if cfg.Debug {
 		log.Println("[DEBUG] Fetch AWSPENDING of the secret: " + event.SecretARN + ", version: " + event.Token)
 	}
 	v, err := getSecretValue(
-		ctx, cfg.SecretsmanagerClient, event.SecretARN, "AWSPENDING", event.Token,
+		ctx, cfg.SecretsmanagerClient, event.SecretARN, StagePending, event.Token,
 	)
 	if err != nil {
 		if cfg.Debug {
 			log.Println("[DEBUG] error: " + err.Error())
 		}
-		return err
+		return fmt.Errorf("get AWSPENDING of the secret %s: %w", event.SecretARN, err)
 	}
 
 	if cfg.Debug {
 		log.Println("[DEBUG] deserialize secret value")
 	}
-	if err := ExtractSecretObject(v, cfg.SecretObj); err != nil {
+	if err := cfg.decodeSecret(v, cfg.SecretObj); err != nil {
 		if cfg.Debug {
 			log.Println("[DEBUG] error: " + err.Error())
 		}
+		return fmt.Errorf("deserialize AWSPENDING: %w", err)
+	}
+
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
 	if cfg.Debug {
 		log.Println("[DEBUG] try to connect to database")
 	}
-	return cfg.ServiceClient.Test(ctx, cfg.SecretObj)
+	if err := cfg.ServiceClient.Test(withSecretKind(ctx, cfg, v), cfg.SecretObj); err != nil {
+		return fmt.Errorf("test: %w", err)
+	}
+	return nil
 }
 
-// finishSecret the method finishes the secret rotation
-// by setting the secret staged AWSPENDING with the AWSCURRENT stage.
-func finishSecret(ctx context.Context, event secretsmanagerTriggerPayload, cfg Config) error {
+// validateCurrent the method tries to log into the database with the secret staged with AWSCURRENT.
+// It never modifies the secret, e.g. to validate the credentials periodically without rotation.
+func validateCurrent(ctx context.Context, event SecretsmanagerTriggerPayload, cfg Config) error {
+	if cfg.Debug {
+		log.Println("[DEBUG] Fetch AWSCURRENT of the secret: " + event.SecretARN)
+	}
+	v, err := getSecretValue(ctx, cfg.SecretsmanagerClient, event.SecretARN, StageCurrent, "")
+	if err != nil {
+		if cfg.Debug {
+			log.Println("[DEBUG] error: " + err.Error())
+		}
+		return fmt.Errorf("get AWSCURRENT of the secret %s: %w", event.SecretARN, err)
+	}
+
+	secret := initNewSecretObj(cfg.SecretObj)
+	if err := cfg.decodeSecret(v, secret); err != nil {
+		if cfg.Debug {
+			log.Println("[DEBUG] error: " + err.Error())
+		}
+		return fmt.Errorf("deserialize AWSCURRENT: %w", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if cfg.Debug {
+		log.Println("[DEBUG] try to connect to database")
+	}
+	if err := cfg.ServiceClient.Test(withSecretKind(ctx, cfg, v), secret); err != nil {
+		return fmt.Errorf("test: %w", err)
+	}
+	return nil
+}
+
+// finishVersionStages returns the secret's versions mapped to their stages. DescribeSecret is skipped
+// if SkipFinishDescribe is set and the event supplies the current version other than the token,
+// i.e. the version to promote. Otherwise, e.g. on the retry after the promotion, the secret is described.
+func finishVersionStages(
+	ctx context.Context, event SecretsmanagerTriggerPayload, cfg Config,
+) (map[string][]string, error) {
+	if cfg.SkipFinishDescribe && event.CurrentVersionID != "" && event.CurrentVersionID != event.Token {
+		if cfg.Debug {
+			log.Println("[DEBUG] skip describe secret, the current version " + event.CurrentVersionID + " is supplied")
+		}
+		return map[string][]string{event.CurrentVersionID: {StageCurrent}}, nil
+	}
+
 	if cfg.Debug {
 		log.Println("[DEBUG] Describe secret: " + event.SecretARN)
 	}
@@ -410,38 +1566,395 @@ func finishSecret(ctx context.Context, event secretsmanagerTriggerPayload, cfg C
 		if cfg.Debug {
 			log.Println("[DEBUG] error: " + err.Error())
 		}
+		return nil, fmt.Errorf("describe secret %s: %w", event.SecretARN, err)
+	}
+
+	versions := versionIdsToStages(v)
+	if versions == nil {
+		if cfg.Debug {
+			log.Println("[DEBUG] no version stages described, fetch the version at the stage AWSCURRENT")
+		}
+		versions = currentVersionStages(ctx, cfg.SecretsmanagerClient, event.SecretARN, cfg.Debug)
+	}
+	return versions, nil
+}
+
+// finishSecret the method finishes the secret rotation
+// by setting the secret staged AWSPENDING with the AWSCURRENT stage.
+func finishSecret(ctx context.Context, event SecretsmanagerTriggerPayload, cfg Config) error {
+	versions, err := finishVersionStages(ctx, event, cfg)
+	if err != nil {
 		return err
 	}
 
+	// the versions are identified strictly by the stage AWSCURRENT, other stages, e.g. custom labels, are ignored
 	currentVersion := ""
-	if vv := v.VersionIdsToStages; vv != nil {
-		for version, stages := range vv {
-			for _, stage := range stages {
-				if "AWSCURRENT" == stage {
-					if event.Token == version {
-						if cfg.Debug {
-							log.Println("[DEBUG] version " + version + " is already at the stage AWSCURRENT")
-						}
-						return nil
-					}
-					currentVersion = version
+	for version, stages := range versions {
+		if !hasStage(stages, StageCurrent) {
+			continue
+		}
+		if event.Token == version {
+			logIdempotentSkip(cfg.metrics(), "finishSecret", "version "+version+" is already at the stage AWSCURRENT")
+			if cfg.ReconcilePromotion {
+				RecordFeature(ctx, featureReconcilePromotion)
+				if err := reconcilePromotion(ctx, cfg.SecretsmanagerClient, event); err != nil {
+					return fmt.Errorf("reconcile promotion: %w", err)
 				}
 			}
+			// the propagation and the service's finalisation are retried in case they failed after the promotion
+			if err := syncDownstream(ctx, event, cfg); err != nil {
+				return err
+			}
+			return finishService(ctx, event, cfg, versionAtStage(versions, StagePrevious))
+		}
+		currentVersion = version
+	}
+
+	// the old secret is fetched before the promotion, while it's still labeled with the stage AWSCURRENT
+	var (
+		oldSecret any
+		oldValue  *secretsmanager.GetSecretValueOutput
+	)
+	if cfg.VerifyOldPasswordRevoked && currentVersion != "" {
+		if cfg.Debug {
+			log.Println("[DEBUG] Fetch the version " + currentVersion + " to verify that it's revoked")
+		}
+		if oldValue, err = getSecretValue(
+			ctx, cfg.SecretsmanagerClient, event.SecretARN, StageCurrent, currentVersion,
+		); err != nil {
+			return fmt.Errorf("get AWSCURRENT of the secret %s: %w", event.SecretARN, err)
 		}
+		oldSecret = initNewSecretObj(cfg.SecretObj)
+		if err := cfg.decodeSecret(oldValue, oldSecret); err != nil {
+			return fmt.Errorf("deserialize AWSCURRENT: %w", err)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
 	if cfg.Debug {
 		log.Println("[DEBUG] update version from " + currentVersion + " to AWSCURRENT")
 	}
-	_, err = cfg.SecretsmanagerClient.UpdateSecretVersionStage(
+	if _, err = cfg.SecretsmanagerClient.UpdateSecretVersionStage(
 		ctx, &secretsmanager.UpdateSecretVersionStageInput{
 			SecretId:            aws.String(event.SecretARN),
-			VersionStage:        aws.String("AWSCURRENT"),
+			VersionStage:        aws.String(StageCurrent),
 			MoveToVersionId:     aws.String(event.Token),
 			RemoveFromVersionId: aws.String(currentVersion),
 		},
+	); err != nil {
+		return fmt.Errorf("move AWSCURRENT to the version %s: %w", event.Token, err)
+	}
+	recordPromotion(ctx)
+
+	if cfg.ReconcilePromotion {
+		RecordFeature(ctx, featureReconcilePromotion)
+		if cfg.Debug {
+			log.Println("[DEBUG] reconcile the stage AWSCURRENT of the secret: " + event.SecretARN)
+		}
+		if err := reconcilePromotion(ctx, cfg.SecretsmanagerClient, event); err != nil {
+			return fmt.Errorf("reconcile promotion: %w", err)
+		}
+	}
+
+	if cfg.VerifyPromotion {
+		RecordFeature(ctx, featureVerifyPromotion)
+		if cfg.Debug {
+			log.Println("[DEBUG] verify that version " + event.Token + " is at the stage AWSCURRENT")
+		}
+		if err := verifyPromotion(ctx, cfg.SecretsmanagerClient, event); err != nil {
+			return fmt.Errorf("verify promotion: %w", err)
+		}
+	}
+
+	if cfg.DetectNoOpRotation {
+		RecordFeature(ctx, featureDetectNoOpRotation)
+		if cfg.Debug {
+			log.Println("[DEBUG] compare AWSCURRENT and AWSPREVIOUS of the secret: " + event.SecretARN)
+		}
+		if err := detectNoOpRotation(ctx, event, cfg); err != nil {
+			return err
+		}
+	}
+
+	if cfg.RollbackOnPostFinishFailure {
+		RecordFeature(ctx, featureRollbackTest)
+		if err := testPromotedSecret(ctx, event, cfg, currentVersion); err != nil {
+			return err
+		}
+	}
+
+	if cfg.MinRotationInterval > 0 {
+		RecordFeature(ctx, featureMinRotationInterval)
+		if cfg.Debug {
+			log.Println("[DEBUG] Tag the time of the rotation of the secret: " + event.SecretARN)
+		}
+		if err := tagLastRotated(ctx, event, cfg); err != nil {
+			log.Println("[WARN] failed to tag the time of the rotation: " + err.Error())
+		}
+	}
+
+	if cfg.PasswordHistorySize > 0 {
+		RecordFeature(ctx, featurePasswordHistory)
+		if cfg.Debug {
+			log.Println("[DEBUG] Record the password's hash to the history of the secret: " + event.SecretARN)
+		}
+		if err := recordPasswordHistory(ctx, event, cfg); err != nil {
+			log.Println("[WARN] failed to record the password's hash: " + err.Error())
+		}
+	}
+
+	if err := syncDownstream(ctx, event, cfg); err != nil {
+		return err
+	}
+
+	if err := finishService(ctx, event, cfg, currentVersion); err != nil {
+		return err
+	}
+
+	if oldSecret != nil {
+		RecordFeature(ctx, featureOldPasswordRevoked)
+		if cfg.Debug {
+			log.Println("[DEBUG] verify that the secret of the version " + currentVersion + " is revoked")
+		}
+		// the test is expected to fail, hence the circuit breaker is bypassed not to count the failure
+		err := withoutCircuitBreaker(cfg.ServiceClient).Test(withSecretKind(ctx, cfg, oldValue), oldSecret)
+		switch {
+		case err == nil:
+			return errors.New(
+				"the secret of the previous version " + currentVersion + " still passes the test, " +
+					"the credentials were not changed by the rotation of the secret " + event.SecretARN,
+			)
+		case !errors.Is(err, ErrAuthFailed):
+			return fmt.Errorf("verify that the secret of the version %s is revoked: %w", currentVersion, err)
+		}
+	}
+
+	if cfg.CleanupStrayPendingVersions {
+		RecordFeature(ctx, featureCleanupStrayPending)
+		if cfg.Debug {
+			log.Println("[DEBUG] remove the stage AWSPENDING from the versions other than " + event.Token)
+		}
+		if _, err := CleanupStrayPendingVersions(
+			ctx, cfg.SecretsmanagerClient, event.SecretARN, event.Token,
+		); err != nil {
+			return fmt.Errorf("cleanup stray AWSPENDING versions: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// CleanupStrayPendingVersions removes the stage AWSPENDING from the secret's versions other than the token,
+// e.g. left by the failed rotations. It returns the IDs of the cleaned versions.
+func CleanupStrayPendingVersions(
+	ctx context.Context, client SecretsmanagerClient, secretARN, token string,
+) ([]string, error) {
+	v, err := client.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(secretARN)})
+	if err != nil {
+		return nil, fmt.Errorf("describe secret %s: %w", secretARN, err)
+	}
+
+	versions := strayPendingVersions(v, token)
+	for i, version := range versions {
+		log.Println("[INFO] remove the stage AWSPENDING from the version " + version + " of the secret " + secretARN)
+		if _, err := client.UpdateSecretVersionStage(
+			ctx, &secretsmanager.UpdateSecretVersionStageInput{
+				SecretId:            aws.String(secretARN),
+				VersionStage:        aws.String(StagePending),
+				RemoveFromVersionId: aws.String(version),
+			},
+		); err != nil {
+			return versions[:i], fmt.Errorf("remove AWSPENDING from the version %s: %w", version, err)
+		}
+	}
+
+	return versions, nil
+}
+
+// strayPendingVersions returns the sorted IDs of the secret's versions other than the token
+// labeled with the stage AWSPENDING.
+func strayPendingVersions(v *secretsmanager.DescribeSecretOutput, token string) []string {
+	var versions []string
+	for version, stages := range v.VersionIdsToStages {
+		if version != token && hasStage(stages, StagePending) {
+			versions = append(versions, version)
+		}
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// ErrStalePending the stage AWSPENDING is assigned to the version other than the rotated one,
+// e.g. by the stuck rotation.
+var ErrStalePending = errors.New("stale AWSPENDING version found")
+
+// checkStalePending fails with ErrStalePending if the stage AWSPENDING is assigned to the version other than
+// the event's token, the stage is removed from such versions instead if ReclaimStalePending is set.
+func checkStalePending(ctx context.Context, event SecretsmanagerTriggerPayload, cfg Config) error {
+	v, err := cfg.SecretsmanagerClient.DescribeSecret(
+		ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(event.SecretARN)},
+	)
+	if err != nil {
+		return fmt.Errorf("describe secret %s: %w", event.SecretARN, err)
+	}
+
+	versions := strayPendingVersions(v, event.Token)
+	if len(versions) == 0 {
+		return nil
+	}
+
+	if cfg.RotationLockTTL > 0 {
+		RecordFeature(ctx, featureRotationLock)
+		if err := checkRotationInProgress(ctx, event, cfg, versions); err != nil {
+			return err
+		}
+	}
+
+	if !cfg.ReclaimStalePending {
+		return fmt.Errorf(
+			"%w: the version %s of the secret %s is labeled with the stage AWSPENDING, the prior rotation "+
+				"may be stuck; set ReclaimStalePending to remove the stage and proceed",
+			ErrStalePending, strings.Join(versions, ", "), event.SecretARN,
+		)
+	}
+
+	log.Println("[WARN] reclaim the stage AWSPENDING from the stale versions: " + strings.Join(versions, ", "))
+	RecordFeature(ctx, featureReclaimStalePending)
+	if _, err := CleanupStrayPendingVersions(ctx, cfg.SecretsmanagerClient, event.SecretARN, event.Token); err != nil {
+		return fmt.Errorf("reclaim stale AWSPENDING: %w", err)
+	}
+	return nil
+}
+
+// testPromotedSecret tests the secret promoted to the stage AWSCURRENT,
+// the promotion is rolled back to the previousVersion if the test fails.
+func testPromotedSecret(
+	ctx context.Context, event SecretsmanagerTriggerPayload, cfg Config, previousVersion string,
+) error {
+	if cfg.Debug {
+		log.Println("[DEBUG] test the version " + event.Token + " promoted to the stage AWSCURRENT")
+	}
+	v, err := getSecretValue(ctx, cfg.SecretsmanagerClient, event.SecretARN, StageCurrent, event.Token)
+	if err != nil {
+		return fmt.Errorf("get AWSCURRENT of the secret %s: %w", event.SecretARN, err)
+	}
+
+	secret := initNewSecretObj(cfg.SecretObj)
+	if err := cfg.decodeSecret(v, secret); err != nil {
+		return fmt.Errorf("deserialize AWSCURRENT: %w", err)
+	}
+
+	errTest := cfg.ServiceClient.Test(withSecretKind(ctx, cfg, v), secret)
+	if errTest == nil {
+		return nil
+	}
+
+	if previousVersion == "" {
+		return fmt.Errorf("promoted secret test failed, no version to roll back to: %w", errTest)
+	}
+
+	log.Println(
+		"[ERROR] promoted secret test failed, roll back the stage AWSCURRENT to the version " + previousVersion +
+			": " + errTest.Error(),
+	)
+	if _, err := cfg.SecretsmanagerClient.UpdateSecretVersionStage(
+		ctx, &secretsmanager.UpdateSecretVersionStageInput{
+			SecretId:            aws.String(event.SecretARN),
+			VersionStage:        aws.String(StageCurrent),
+			MoveToVersionId:     aws.String(previousVersion),
+			RemoveFromVersionId: aws.String(event.Token),
+		},
+	); err != nil {
+		return fmt.Errorf("promoted secret test failed: %w; rollback failed: %s", errTest, err.Error())
+	}
+
+	return fmt.Errorf("promoted secret test failed, rolled back to the version %s: %w", previousVersion, errTest)
+}
+
+// verifyPromotion checks that the version of the event is at the stage AWSCURRENT.
+func verifyPromotion(ctx context.Context, client SecretsmanagerClient, event SecretsmanagerTriggerPayload) error {
+	v, err := client.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(event.SecretARN)})
+	if err != nil {
+		return fmt.Errorf("describe secret %s: %w", event.SecretARN, err)
+	}
+
+	for _, stage := range v.VersionIdsToStages[event.Token] {
+		if stage == StageCurrent {
+			return nil
+		}
+	}
+
+	return errors.New(
+		"version " + event.Token + " was not moved to the stage AWSCURRENT of the secret " + event.SecretARN,
+	)
+}
+
+// reconcilePromotion ensures that the version of the event is the only version at the stage AWSCURRENT:
+// the stage is moved to the version if it was not added, and removed from other versions if it was not removed.
+func reconcilePromotion(ctx context.Context, client SecretsmanagerClient, event SecretsmanagerTriggerPayload) error {
+	v, err := client.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(event.SecretARN)})
+	if err != nil {
+		return fmt.Errorf("describe secret %s: %w", event.SecretARN, err)
+	}
+
+	var (
+		promoted bool
+		stale    []string
 	)
-	return err
+	for version, stages := range v.VersionIdsToStages {
+		switch {
+		case !hasStage(stages, StageCurrent):
+		case version == event.Token:
+			promoted = true
+		default:
+			stale = append(stale, version)
+		}
+	}
+	sort.Strings(stale)
+
+	if !promoted {
+		input := &secretsmanager.UpdateSecretVersionStageInput{
+			SecretId:        aws.String(event.SecretARN),
+			VersionStage:    aws.String(StageCurrent),
+			MoveToVersionId: aws.String(event.Token),
+		}
+		if len(stale) > 0 {
+			input.RemoveFromVersionId = aws.String(stale[0])
+			stale = stale[1:]
+		}
+		log.Println("[WARN] version " + event.Token + " is not at the stage AWSCURRENT, move the stage again")
+		if _, err := client.UpdateSecretVersionStage(ctx, input); err != nil {
+			return fmt.Errorf("move AWSCURRENT to the version %s: %w", event.Token, err)
+		}
+	}
+
+	for _, version := range stale {
+		log.Println("[WARN] remove the stage AWSCURRENT from the stale version " + version)
+		if _, err := client.UpdateSecretVersionStage(
+			ctx, &secretsmanager.UpdateSecretVersionStageInput{
+				SecretId:            aws.String(event.SecretARN),
+				VersionStage:        aws.String(StageCurrent),
+				RemoveFromVersionId: aws.String(version),
+			},
+		); err != nil {
+			return fmt.Errorf("remove AWSCURRENT from the version %s: %w", version, err)
+		}
+	}
+
+	return nil
+}
+
+// hasStage checks if the stage is in the list of stages.
+func hasStage(stages []string, stage string) bool {
+	for _, s := range stages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
 }
 
 // StrToBool converts string to bool.
@@ -456,14 +1969,64 @@ func StrToBool(s string) bool {
 
 // ExtractSecretObject deserializes secret value to a Go object of the secret type.
 func ExtractSecretObject(v *secretsmanager.GetSecretValueOutput, secret any) error {
-	return json.Unmarshal([]byte(*v.SecretString), secret)
+	return extractSecretObject(v, secret, false)
+}
+
+// extractSecretObject deserializes secret value to a Go object of the secret type.
+// The secret's attributes not defined by the secret type are rejected if disallowUnknownFields is set.
+func extractSecretObject(v *secretsmanager.GetSecretValueOutput, secret any, disallowUnknownFields bool) error {
+	if v == nil || v.SecretString == nil {
+		return errors.New("secret value not found")
+	}
+
+	dec := json.NewDecoder(strings.NewReader(*v.SecretString))
+	if disallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+
+	if err := dec.Decode(secret); err != nil {
+		return err
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("unexpected data after the secret value")
+	}
+
+	return nil
+}
+
+// defaultPasswordField the secret's attribute with the password.
+const defaultPasswordField = "password"
+
+// passwordField returns the configured secret's password attribute, or the default attribute.
+func (cfg Config) passwordField() string {
+	if cfg.PasswordField == "" {
+		return defaultPasswordField
+	}
+	return cfg.PasswordField
 }
 
-func serialiseSecret(secret any) (*string, error) {
+// serialiseSecret serialises the secret and verifies that the output is a valid UTF-8 JSON object
+// with non-empty passwordField to prevent staging of corrupt secret.
+func serialiseSecret(secret any, passwordField string) (*string, error) {
 	o, err := json.Marshal(secret)
 	if err != nil {
 		return nil, err
 	}
+
+	if !utf8.Valid(o) {
+		return nil, errors.New("serialised secret is not valid UTF-8")
+	}
+
+	var v map[string]any
+	if err := json.Unmarshal(o, &v); err != nil {
+		return nil, errors.New("serialised secret is not valid JSON object: " + err.Error())
+	}
+
+	if s, ok := v[passwordField].(string); !ok || s == "" {
+		return nil, errors.New("serialised secret must have non-empty attribute " + passwordField)
+	}
+
 	return (*string)(unsafe.Pointer(&o)), nil
 }
 
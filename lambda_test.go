@@ -4,13 +4,19 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmsTypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
 	"github.com/aws/smithy-go"
 	smithyHttp "github.com/aws/smithy-go/transport/http"
 )
@@ -48,6 +54,32 @@ func Test_extractSecretObject(t *testing.T) {
 			wantErr:    true,
 			wantSecret: nil,
 		},
+		{
+			name: "unhappy path: no secret value",
+			args: args{
+				v:      &secretsmanager.GetSecretValueOutput{},
+				secret: &map[string]string{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unhappy path: nil output",
+			args: args{
+				v:      nil,
+				secret: &map[string]string{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unhappy path: trailing data",
+			args: args{
+				v: &secretsmanager.GetSecretValueOutput{
+					SecretString: aws.String(`{"foo": "bar"}{"foo": "baz"}`),
+				},
+				secret: &map[string]string{},
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(
@@ -65,6 +97,41 @@ func Test_extractSecretObject(t *testing.T) {
 	}
 }
 
+func Test_extractSecretObject_disallowUnknownFields(t *testing.T) {
+	v := &secretsmanager.GetSecretValueOutput{
+		SecretString: aws.String(`{"user":"bar","password":"quxx","foo":"bar"}`),
+	}
+
+	if err := extractSecretObject(v, &mockObj{}, false); err != nil {
+		t.Errorf("extractSecretObject() unexpected error = %v", err)
+	}
+
+	if err := extractSecretObject(v, &mockObj{}, true); err == nil {
+		t.Errorf("extractSecretObject() expected error for unknown attribute")
+	}
+}
+
+func FuzzExtractSecretObject(f *testing.F) {
+	f.Add(placeholderSecretUserStr)
+	f.Add(placeholderSecretUserNewStr)
+	f.Add(`{`)
+	f.Add(`null`)
+	f.Add(`{"user":1}`)
+
+	f.Fuzz(
+		func(t *testing.T, s string) {
+			for _, disallowUnknownFields := range []bool{false, true} {
+				err := extractSecretObject(
+					&secretsmanager.GetSecretValueOutput{SecretString: &s}, &mockObj{}, disallowUnknownFields,
+				)
+				if !json.Valid([]byte(s)) && err == nil {
+					t.Errorf("extractSecretObject() expected error for malformed JSON %q", s)
+				}
+			}
+		},
+	)
+}
+
 type mockObj struct {
 	User         string `json:"user"`
 	Password     string `json:"password"`
@@ -80,7 +147,27 @@ type mockSecretsmanagerClient struct {
 
 	secretByID map[string]map[string]string
 
+	// stagedVersions the versions labeled with the stages without the value, e.g. the version of the rotation
+	// started by Secrets Manager before createSecret put the value.
+	stagedVersions map[string][]string
+
 	rotationEnabled *bool
+
+	kmsKeyID *string
+
+	updateSecretVersionStageNoop bool
+
+	emptyVersionStages bool
+
+	secretAWSCurrentVersionID string
+
+	updateSecretVersionStageInputs []*secretsmanager.UpdateSecretVersionStageInput
+
+	tags []types.Tag
+
+	rotationLambdaARN *string
+
+	lastRotatedDate *time.Time
 }
 
 func getSecret(m *mockSecretsmanagerClient, stage, version string) mockObj {
@@ -163,6 +250,9 @@ func (m *mockSecretsmanagerClient) GetSecretValue(
 			}
 		}
 
+		if m.secretAWSCurrentVersionID != "" {
+			o.VersionId = aws.String(m.secretAWSCurrentVersionID)
+		}
 		return o, nil
 	}
 
@@ -211,6 +301,9 @@ func (m *mockSecretsmanagerClient) GetSecretValue(
 	}
 
 	o.VersionStages = stagesK
+	if m.emptyVersionStages {
+		o.VersionStages = nil
+	}
 	o.SecretString = &s
 
 	return o, nil
@@ -244,11 +337,15 @@ func (m *mockSecretsmanagerClient) DescribeSecret(
 
 	if m.secretByID == nil {
 		return &secretsmanager.DescribeSecretOutput{
-			ARN: input.SecretId,
+			ARN:  input.SecretId,
+			Tags: m.tags,
 		}, nil
 	}
 
-	versionIdsToStages := make(map[string][]string, len(m.secretByID))
+	versionIdsToStages := make(map[string][]string, len(m.secretByID)+len(m.stagedVersions))
+	for k, v := range m.stagedVersions {
+		versionIdsToStages[k] = v
+	}
 	for k, v := range m.secretByID {
 		versionIdsToStages[k] = make([]string, len(v))
 		var i uint8
@@ -262,17 +359,60 @@ func (m *mockSecretsmanagerClient) DescribeSecret(
 		ARN:                input.SecretId,
 		VersionIdsToStages: versionIdsToStages,
 		RotationEnabled:    m.rotationEnabled,
+		KmsKeyId:           m.kmsKeyID,
+		Tags:               m.tags,
+		RotationLambdaARN:  m.rotationLambdaARN,
+		LastRotatedDate:    m.lastRotatedDate,
 	}, nil
 }
 
+func (m *mockSecretsmanagerClient) TagResource(
+	ctx context.Context, input *secretsmanager.TagResourceInput, optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.TagResourceOutput, error) {
+	for _, tag := range input.Tags {
+		var found bool
+		for i := range m.tags {
+			if aws.ToString(m.tags[i].Key) == aws.ToString(tag.Key) {
+				m.tags[i].Value = tag.Value
+				found = true
+			}
+		}
+		if !found {
+			m.tags = append(m.tags, tag)
+		}
+	}
+	return &secretsmanager.TagResourceOutput{}, nil
+}
+
 func (m *mockSecretsmanagerClient) UpdateSecretVersionStage(
 	ctx context.Context, input *secretsmanager.UpdateSecretVersionStageInput,
 	optFns ...func(*secretsmanager.Options),
 ) (*secretsmanager.UpdateSecretVersionStageOutput, error) {
-	m.secretAWSCurrent = m.secretByID[*input.MoveToVersionId]["AWSPENDING"]
-	m.secretByID[*input.MoveToVersionId]["AWSCURRENT"] = m.secretAWSCurrent
-	delete(m.secretByID[*input.MoveToVersionId], "AWSPENDING")
-	delete(m.secretByID[*input.RemoveFromVersionId], "AWSCURRENT")
+	if m.updateSecretVersionStageNoop {
+		return nil, nil
+	}
+	m.updateSecretVersionStageInputs = append(m.updateSecretVersionStageInputs, input)
+
+	stage := *input.VersionStage
+	if input.RemoveFromVersionId != nil {
+		delete(m.secretByID[*input.RemoveFromVersionId], stage)
+	}
+	if input.MoveToVersionId == nil {
+		return nil, nil
+	}
+
+	stages := m.secretByID[*input.MoveToVersionId]
+	s, ok := stages["AWSPENDING"]
+	if !ok {
+		for _, v := range stages {
+			s = v
+		}
+	}
+	stages[stage] = s
+	if stage == "AWSCURRENT" {
+		m.secretAWSCurrent = s
+		delete(stages, "AWSPENDING")
+	}
 	return nil, nil
 }
 
@@ -304,6 +444,8 @@ var (
 
 type mockDBClient struct {
 	current, pending, previous any
+
+	testErr error
 }
 
 func (m *mockDBClient) Set(ctx context.Context, secretCurrent, secretPending, secretPrevious any) error {
@@ -314,7 +456,7 @@ func (m *mockDBClient) Set(ctx context.Context, secretCurrent, secretPending, se
 }
 
 func (m *mockDBClient) Test(ctx context.Context, secret any) error {
-	return nil
+	return m.testErr
 }
 
 func (m *mockDBClient) Create(ctx context.Context, secret any) error {
@@ -325,7 +467,7 @@ func (m *mockDBClient) Create(ctx context.Context, secret any) error {
 func Test_createSecret(t *testing.T) {
 	type args struct {
 		ctx   context.Context
-		event secretsmanagerTriggerPayload
+		event SecretsmanagerTriggerPayload
 		cfg   Config
 	}
 	tests := []struct {
@@ -337,7 +479,7 @@ func Test_createSecret(t *testing.T) {
 			name: "happy path",
 			args: args{
 				ctx: context.TODO(),
-				event: secretsmanagerTriggerPayload{
+				event: SecretsmanagerTriggerPayload{
 					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
 					Token:     "bar",
 					Step:      "createSecret",
@@ -362,7 +504,7 @@ func Test_createSecret(t *testing.T) {
 			name: "happy path: new secret already in the pending stage",
 			args: args{
 				ctx: context.TODO(),
-				event: secretsmanagerTriggerPayload{
+				event: SecretsmanagerTriggerPayload{
 					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
 					Token:     "foo",
 					Step:      "createSecret",
@@ -414,6 +556,13 @@ func Test_createSecret(t *testing.T) {
 	}
 }
 
+// mockMarshaler serialises to the defined, potentially corrupt, JSON.
+type mockMarshaler string
+
+func (m mockMarshaler) MarshalJSON() ([]byte, error) {
+	return []byte(m), nil
+}
+
 func Test_serialiseSecret(t *testing.T) {
 	type args struct {
 		secret any
@@ -432,11 +581,46 @@ func Test_serialiseSecret(t *testing.T) {
 			want:    &placeholderSecretUserStr,
 			wantErr: false,
 		},
+		{
+			name: "unhappy path: invalid UTF-8",
+			args: args{
+				secret: mockMarshaler(`{"user":"bar","password":"` + "\xff" + `"}`),
+			},
+			wantErr: true,
+		},
+		{
+			name: "unhappy path: not JSON object",
+			args: args{
+				secret: mockMarshaler(`["bar","quxx"]`),
+			},
+			wantErr: true,
+		},
+		{
+			name: "unhappy path: empty password",
+			args: args{
+				secret: mockMarshaler(`{"user":"bar","password":""}`),
+			},
+			wantErr: true,
+		},
+		{
+			name: "unhappy path: no password",
+			args: args{
+				secret: mockMarshaler(`{"user":"bar"}`),
+			},
+			wantErr: true,
+		},
+		{
+			name: "unhappy path: password is not string",
+			args: args{
+				secret: mockMarshaler(`{"user":"bar","password":1}`),
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(
 			tt.name, func(t *testing.T) {
-				got, err := serialiseSecret(tt.args.secret)
+				got, err := serialiseSecret(tt.args.secret, defaultPasswordField)
 				if (err != nil) != tt.wantErr {
 					t.Errorf("serialiseSecret() error = %v, wantErr %v", err, tt.wantErr)
 					return
@@ -452,7 +636,7 @@ func Test_serialiseSecret(t *testing.T) {
 func Test_finishSecret(t *testing.T) {
 	type args struct {
 		ctx   context.Context
-		event secretsmanagerTriggerPayload
+		event SecretsmanagerTriggerPayload
 		cfg   Config
 	}
 	tests := []struct {
@@ -464,7 +648,7 @@ func Test_finishSecret(t *testing.T) {
 			name: "happy path",
 			args: args{
 				ctx: context.TODO(),
-				event: secretsmanagerTriggerPayload{
+				event: SecretsmanagerTriggerPayload{
 					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
 					Token:     "bar",
 					Step:      "finishSecret",
@@ -492,7 +676,7 @@ func Test_finishSecret(t *testing.T) {
 			name: "happy path: already set",
 			args: args{
 				ctx: context.TODO(),
-				event: secretsmanagerTriggerPayload{
+				event: SecretsmanagerTriggerPayload{
 					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
 					Token:     "bar",
 					Step:      "finishSecret",
@@ -549,7 +733,7 @@ func Test_setSecret(t *testing.T) {
 	var mType mapType
 	type args struct {
 		ctx   context.Context
-		event secretsmanagerTriggerPayload
+		event SecretsmanagerTriggerPayload
 		cfg   Config
 	}
 	tests := []struct {
@@ -563,7 +747,7 @@ func Test_setSecret(t *testing.T) {
 			name: "happy path",
 			args: args{
 				ctx: context.TODO(),
-				event: secretsmanagerTriggerPayload{
+				event: SecretsmanagerTriggerPayload{
 					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
 					Token:     "bar",
 					Step:      "setSecret",
@@ -594,7 +778,7 @@ func Test_setSecret(t *testing.T) {
 			name: "happy path: SecretObj-map",
 			args: args{
 				ctx: context.TODO(),
-				event: secretsmanagerTriggerPayload{
+				event: SecretsmanagerTriggerPayload{
 					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
 					Token:     "bar",
 					Step:      "setSecret",
@@ -624,7 +808,7 @@ func Test_setSecret(t *testing.T) {
 			name: "happy path: AWSPREVIOUS is present",
 			args: args{
 				ctx: context.TODO(),
-				event: secretsmanagerTriggerPayload{
+				event: SecretsmanagerTriggerPayload{
 					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
 					Token:     "bar",
 					Step:      "setSecret",
@@ -653,7 +837,7 @@ func Test_setSecret(t *testing.T) {
 			name: "happy path: no AWSCURRENT version",
 			args: args{
 				ctx: context.TODO(),
-				event: secretsmanagerTriggerPayload{
+				event: SecretsmanagerTriggerPayload{
 					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
 					Token:     "foo",
 					Step:      "setSecret",
@@ -671,7 +855,7 @@ func Test_setSecret(t *testing.T) {
 			name: "unhappy path: no AWSPENDING version",
 			args: args{
 				ctx: context.TODO(),
-				event: secretsmanagerTriggerPayload{
+				event: SecretsmanagerTriggerPayload{
 					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
 					Token:     "foo",
 					Step:      "setSecret",
@@ -720,7 +904,7 @@ func Test_setSecret(t *testing.T) {
 func Test_testSecret(t *testing.T) {
 	type args struct {
 		ctx   context.Context
-		event secretsmanagerTriggerPayload
+		event SecretsmanagerTriggerPayload
 		cfg   Config
 	}
 	tests := []struct {
@@ -732,7 +916,7 @@ func Test_testSecret(t *testing.T) {
 			name: "happy path",
 			args: args{
 				ctx: context.TODO(),
-				event: secretsmanagerTriggerPayload{
+				event: SecretsmanagerTriggerPayload{
 					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
 					Token:     "foo",
 					Step:      "testSecret",
@@ -757,7 +941,7 @@ func Test_testSecret(t *testing.T) {
 			name: "unhappy path: no AWSPENDING found",
 			args: args{
 				ctx: context.TODO(),
-				event: secretsmanagerTriggerPayload{
+				event: SecretsmanagerTriggerPayload{
 					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
 					Token:     "foo",
 					Step:      "testSecret",
@@ -777,7 +961,7 @@ func Test_testSecret(t *testing.T) {
 			name: "unhappy path: faulty new secret value",
 			args: args{
 				ctx: context.TODO(),
-				event: secretsmanagerTriggerPayload{
+				event: SecretsmanagerTriggerPayload{
 					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
 					Token:     "foo",
 					Step:      "testSecret",
@@ -813,7 +997,7 @@ func Test_testSecret(t *testing.T) {
 func Test_validateEvent(t *testing.T) {
 	type args struct {
 		ctx    context.Context
-		event  secretsmanagerTriggerPayload
+		event  SecretsmanagerTriggerPayload
 		client SecretsmanagerClient
 	}
 	tests := []struct {
@@ -826,7 +1010,7 @@ func Test_validateEvent(t *testing.T) {
 			name: "happy path",
 			args: args{
 				ctx: context.TODO(),
-				event: secretsmanagerTriggerPayload{
+				event: SecretsmanagerTriggerPayload{
 					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
 					Token:     "foo",
 					Step:      "createSecret",
@@ -847,7 +1031,7 @@ func Test_validateEvent(t *testing.T) {
 			name: "unhappy path: no secret exists",
 			args: args{
 				ctx: context.TODO(),
-				event: secretsmanagerTriggerPayload{
+				event: SecretsmanagerTriggerPayload{
 					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
 					Token:     "foo",
 					Step:      "createSecret",
@@ -860,7 +1044,7 @@ func Test_validateEvent(t *testing.T) {
 			name: "unhappy path: rotation is not enabled",
 			args: args{
 				ctx: context.TODO(),
-				event: secretsmanagerTriggerPayload{
+				event: SecretsmanagerTriggerPayload{
 					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
 					Token:     "bar",
 					Step:      "createSecret",
@@ -881,7 +1065,7 @@ func Test_validateEvent(t *testing.T) {
 			name: "unhappy path: no stages for the version",
 			args: args{
 				ctx: context.TODO(),
-				event: secretsmanagerTriggerPayload{
+				event: SecretsmanagerTriggerPayload{
 					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
 					Token:     "bar",
 					Step:      "createSecret",
@@ -1005,7 +1189,7 @@ func TestNewHandler(t *testing.T) {
 	}
 	type argsHandler struct {
 		ctx   context.Context
-		event secretsmanagerTriggerPayload
+		event SecretsmanagerTriggerPayload
 	}
 	tests := []struct {
 		name        string
@@ -1042,7 +1226,7 @@ func TestNewHandler(t *testing.T) {
 			},
 			argsHandler: argsHandler{
 				ctx: context.TODO(),
-				event: secretsmanagerTriggerPayload{
+				event: SecretsmanagerTriggerPayload{
 					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
 					Token:     "foo",
 					Step:      "foobar",
@@ -1069,7 +1253,7 @@ func TestNewHandler(t *testing.T) {
 			},
 			argsHandler: argsHandler{
 				ctx: context.TODO(),
-				event: secretsmanagerTriggerPayload{
+				event: SecretsmanagerTriggerPayload{
 					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
 					Token:     "foo",
 					Step:      "foobar",
@@ -1099,7 +1283,7 @@ func TestNewHandler(t *testing.T) {
 			},
 			argsHandler: argsHandler{
 				ctx: context.TODO(),
-				event: secretsmanagerTriggerPayload{
+				event: SecretsmanagerTriggerPayload{
 					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
 					Token:     "foo",
 					Step:      "createSecret",
@@ -1129,7 +1313,7 @@ func TestNewHandler(t *testing.T) {
 			},
 			argsHandler: argsHandler{
 				ctx: context.TODO(),
-				event: secretsmanagerTriggerPayload{
+				event: SecretsmanagerTriggerPayload{
 					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
 					Token:     "foo",
 					Step:      "setSecret",
@@ -1159,7 +1343,7 @@ func TestNewHandler(t *testing.T) {
 			},
 			argsHandler: argsHandler{
 				ctx: context.TODO(),
-				event: secretsmanagerTriggerPayload{
+				event: SecretsmanagerTriggerPayload{
 					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
 					Token:     "foo",
 					Step:      "testSecret",
@@ -1189,7 +1373,7 @@ func TestNewHandler(t *testing.T) {
 			},
 			argsHandler: argsHandler{
 				ctx: context.TODO(),
-				event: secretsmanagerTriggerPayload{
+				event: SecretsmanagerTriggerPayload{
 					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
 					Token:     "foo",
 					Step:      "finishSecret",
@@ -1217,3 +1401,2324 @@ func TestNewHandler(t *testing.T) {
 		)
 	}
 }
+
+func TestNewHandler_routeServiceClients(t *testing.T) {
+	newSecretsmanagerClient := func() *mockSecretsmanagerClient {
+		return &mockSecretsmanagerClient{
+			secretAWSCurrent: placeholderSecretUserStr,
+			secretByID: map[string]map[string]string{
+				"foo": {
+					"AWSCURRENT": placeholderSecretUserStr,
+				},
+				"bar": {
+					"AWSPENDING": placeholderSecretUserNewStr,
+				},
+			},
+			rotationEnabled: aws.Bool(true),
+		}
+	}
+
+	clientProjectFoo := &mockDBClient{}
+	clientProjectBar := &mockDBClient{}
+	clientDefault := &mockDBClient{}
+
+	tests := []struct {
+		name       string
+		secretARN  string
+		wantClient *mockDBClient
+	}{
+		{
+			name:       "project foo",
+			secretARN:  "arn:aws:secretsmanager:us-east-1:000000000000:secret:neon/foo/user-5BKPC8",
+			wantClient: clientProjectFoo,
+		},
+		{
+			name:       "project bar",
+			secretARN:  "arn:aws:secretsmanager:us-east-1:000000000000:secret:neon/bar/user-5BKPC8",
+			wantClient: clientProjectBar,
+		},
+		{
+			name:       "default client",
+			secretARN:  "arn:aws:secretsmanager:us-east-1:000000000000:secret:neon/baz/user-5BKPC8",
+			wantClient: clientDefault,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				for _, c := range []*mockDBClient{clientProjectFoo, clientProjectBar, clientDefault} {
+					*c = mockDBClient{}
+				}
+
+				handler, err := NewHandler(
+					Config{
+						SecretsmanagerClient: newSecretsmanagerClient(),
+						ServiceClient:        clientDefault,
+						ServiceClients: map[string]ServiceClient{
+							`:secret:neon/foo/`: clientProjectFoo,
+							`:secret:neon/bar/`: clientProjectBar,
+						},
+						SecretObj: &mockObj{},
+					},
+				)
+				if err != nil {
+					t.Fatalf("NewHandler() unexpected error = %v", err)
+				}
+
+				if err := handler(
+					context.TODO(), SecretsmanagerTriggerPayload{
+						SecretARN: tt.secretARN,
+						Token:     "bar",
+						Step:      "setSecret",
+					},
+				); err != nil {
+					t.Fatalf("handler(ctx, event) unexpected error = %v", err)
+				}
+
+				for _, c := range []*mockDBClient{clientProjectFoo, clientProjectBar, clientDefault} {
+					if called := c.pending != nil; called != (c == tt.wantClient) {
+						t.Errorf("handler(ctx, event) routed the event to a wrong ServiceClient")
+					}
+				}
+			},
+		)
+	}
+}
+
+func TestNewHandler_faultyServiceClientsPattern(t *testing.T) {
+	_, err := NewHandler(
+		Config{
+			ServiceClients: map[string]ServiceClient{`(`: &mockDBClient{}},
+			SecretObj:      &mockObj{},
+		},
+	)
+	if err == nil {
+		t.Errorf("NewHandler() expected error for faulty ServiceClients pattern")
+	}
+}
+
+func Test_createSecret_idempotentSkipMetric(t *testing.T) {
+	m := &mockMetrics{}
+	cfg := Config{
+		SecretsmanagerClient: &mockSecretsmanagerClient{
+			secretAWSCurrent: placeholderSecretUserStr,
+			secretByID: map[string]map[string]string{
+				"foo": {
+					"AWSCURRENT": placeholderSecretUserStr,
+					"AWSPENDING": placeholderSecretUserNewStr,
+				},
+			},
+		},
+		ServiceClient: &mockDBClient{},
+		SecretObj:     &mockObj{},
+		Metrics:       m,
+	}
+	event := SecretsmanagerTriggerPayload{
+		SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+		Token:     "foo",
+		Step:      "createSecret",
+	}
+
+	for i := 1; i <= 2; i++ {
+		if err := createSecret(context.TODO(), event, cfg); err != nil {
+			t.Fatalf("createSecret() unexpected error = %v", err)
+		}
+
+		if skips := m.countCounter(metricIdempotentSkip); skips != i {
+			t.Errorf("createSecret() emitted %d %s metrics, want %d", skips, metricIdempotentSkip, i)
+		}
+	}
+}
+
+func Test_createSecret_KMSKeyResolver(t *testing.T) {
+	const tenantKeyARN = "arn:aws:kms:us-east-1:000000000000:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+
+	tests := []struct {
+		name     string
+		resolver func(string) string
+		wantErr  bool
+	}{
+		{
+			name:     "happy path: key ID matches",
+			resolver: func(string) string { return "1234abcd-12ab-34cd-56ef-1234567890ab" },
+			wantErr:  false,
+		},
+		{
+			name:     "happy path: key ARN matches",
+			resolver: func(string) string { return tenantKeyARN },
+			wantErr:  false,
+		},
+		{
+			name:     "happy path: no key resolved",
+			resolver: func(string) string { return "" },
+			wantErr:  false,
+		},
+		{
+			name:     "unhappy path: key of another tenant",
+			resolver: func(string) string { return "alias/tenant-bar" },
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				client := &mockSecretsmanagerClient{
+					secretAWSCurrent: placeholderSecretUserStr,
+					secretByID: map[string]map[string]string{
+						"foo": {
+							"AWSCURRENT": placeholderSecretUserStr,
+						},
+					},
+					kmsKeyID: aws.String(tenantKeyARN),
+				}
+				serviceClient := &mockPasswordsClient{passwords: []string{"new-password"}}
+				cfg := Config{
+					SecretsmanagerClient: client,
+					ServiceClient:        serviceClient,
+					SecretObj:            &mockObj{},
+					KMSKeyResolver:       tt.resolver,
+				}
+				event := SecretsmanagerTriggerPayload{
+					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:tenant-foo/bar-5BKPC8",
+					Token:     "bar",
+					Step:      "createSecret",
+				}
+
+				if err := createSecret(context.TODO(), event, cfg); (err != nil) != tt.wantErr {
+					t.Errorf("createSecret() error = %v, wantErr %v", err, tt.wantErr)
+				}
+
+				if _, staged := client.secretByID["bar"]; staged == tt.wantErr {
+					t.Errorf("createSecret() staged = %v, want %v", staged, !tt.wantErr)
+				}
+				// the secret is not generated with the unusable key, e.g. the password is not changed in the service
+				if generated := serviceClient.calls > 0; generated == tt.wantErr {
+					t.Errorf("createSecret() called Create = %v, want %v", generated, !tt.wantErr)
+				}
+			},
+		)
+	}
+}
+
+func Test_finishSecret_VerifyPromotion(t *testing.T) {
+	tests := []struct {
+		name    string
+		noop    bool
+		wantErr bool
+	}{
+		{
+			name:    "happy path: stage moved",
+			noop:    false,
+			wantErr: false,
+		},
+		{
+			name:    "unhappy path: stage did not move",
+			noop:    true,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				cfg := Config{
+					SecretsmanagerClient: &mockSecretsmanagerClient{
+						secretAWSCurrent: placeholderSecretUserStr,
+						secretByID: map[string]map[string]string{
+							"foo": {
+								"AWSCURRENT": placeholderSecretUserStr,
+							},
+							"bar": {
+								"AWSPENDING": placeholderSecretUserNewStr,
+							},
+						},
+						updateSecretVersionStageNoop: tt.noop,
+					},
+					ServiceClient:   &mockDBClient{},
+					SecretObj:       &mockObj{},
+					VerifyPromotion: true,
+				}
+				event := SecretsmanagerTriggerPayload{
+					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+					Token:     "bar",
+					Step:      "finishSecret",
+				}
+				if err := finishSecret(context.TODO(), event, cfg); (err != nil) != tt.wantErr {
+					t.Errorf("finishSecret() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			},
+		)
+	}
+}
+
+func Test_createSecret_pendingProbeWithoutStages(t *testing.T) {
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		secretByID: map[string]map[string]string{
+			"foo": {
+				"AWSCURRENT": placeholderSecretUserStr,
+			},
+			"bar": {
+				"AWSPENDING": placeholderSecretUserStr,
+			},
+		},
+		emptyVersionStages: true,
+	}
+	cfg := Config{
+		SecretsmanagerClient: client,
+		ServiceClient:        &mockDBClient{},
+		SecretObj:            &mockObj{},
+	}
+	event := SecretsmanagerTriggerPayload{
+		SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+		Token:     "bar",
+		Step:      "createSecret",
+	}
+
+	if err := createSecret(context.TODO(), event, cfg); err != nil {
+		t.Fatalf("createSecret() unexpected error = %v", err)
+	}
+
+	if getSecret(client, "AWSPENDING", "bar").Password == placeholderPassword {
+		t.Errorf("createSecret() shall generate new secret when the pending version has no stages")
+	}
+}
+
+func Test_createSecret_currentStageConflict(t *testing.T) {
+	newClient := func() *mockSecretsmanagerClient {
+		return &mockSecretsmanagerClient{
+			secretAWSCurrent:          placeholderSecretUserStr,
+			secretAWSCurrentVersionID: "foo",
+			secretByID: map[string]map[string]string{
+				"foo": {
+					"AWSCURRENT": placeholderSecretUserStr,
+				},
+				"baz": {
+					"AWSCURRENT": placeholderSecretUserStr,
+				},
+			},
+		}
+	}
+	event := SecretsmanagerTriggerPayload{
+		SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+		Token:     "bar",
+		Step:      "createSecret",
+	}
+
+	t.Run(
+		"unhappy path: inconsistent stage state", func(t *testing.T) {
+			client := newClient()
+			err := createSecret(
+				context.TODO(), event, Config{
+					SecretsmanagerClient: client,
+					ServiceClient:        &mockDBClient{},
+					SecretObj:            &mockObj{},
+					Metrics:              NoopMetrics{},
+				},
+			)
+			if err == nil || !strings.Contains(err.Error(), "inconsistent stage state") {
+				t.Fatalf("createSecret() error = %v, want inconsistent stage state error", err)
+			}
+			if _, ok := client.secretByID["bar"]; ok {
+				t.Errorf("createSecret() shall not stage the new version")
+			}
+		},
+	)
+
+	t.Run(
+		"happy path: heal the inconsistent stage state", func(t *testing.T) {
+			client := newClient()
+			if err := createSecret(
+				context.TODO(), event, Config{
+					SecretsmanagerClient:     client,
+					ServiceClient:            &mockDBClient{},
+					SecretObj:                &mockObj{},
+					HealCurrentStageConflict: true,
+					Metrics:                  NoopMetrics{},
+				},
+			); err != nil {
+				t.Fatalf("createSecret() unexpected error = %v", err)
+			}
+			if _, ok := client.secretByID["baz"]["AWSCURRENT"]; ok {
+				t.Errorf("createSecret() shall remove the stage AWSCURRENT from the version baz")
+			}
+			if _, ok := client.secretByID["foo"]["AWSCURRENT"]; !ok {
+				t.Errorf("createSecret() shall keep the stage AWSCURRENT of the version foo")
+			}
+			if _, ok := client.secretByID["bar"]["AWSPENDING"]; !ok {
+				t.Errorf("createSecret() shall stage the new version")
+			}
+		},
+	)
+}
+
+func Test_createSecret_corruptSecretNotStaged(t *testing.T) {
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		secretByID: map[string]map[string]string{
+			"foo": {
+				"AWSCURRENT": placeholderSecretUserStr,
+			},
+		},
+	}
+
+	err := createSecret(
+		context.TODO(), SecretsmanagerTriggerPayload{
+			SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			Token:     "bar",
+			Step:      "createSecret",
+		}, Config{
+			SecretsmanagerClient: client,
+			ServiceClient:        &mockDBClient{},
+			SecretObj:            &mockObj{},
+			PasswordField:        "token",
+			Metrics:              NoopMetrics{},
+		},
+	)
+	if err == nil {
+		t.Fatal("createSecret() expected error")
+	}
+	if _, ok := client.secretByID["bar"]; ok {
+		t.Errorf("createSecret() shall not stage the corrupt secret")
+	}
+}
+
+func Test_finishSecret_RollbackOnPostFinishFailure(t *testing.T) {
+	event := SecretsmanagerTriggerPayload{
+		SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+		Token:     "bar",
+		Step:      "finishSecret",
+	}
+
+	tests := []struct {
+		name          string
+		testErr       error
+		wantErr       bool
+		wantCurrent   string
+		wantUpdateLen int
+	}{
+		{
+			name:          "happy path: promoted secret passed the test",
+			wantCurrent:   "bar",
+			wantUpdateLen: 1,
+		},
+		{
+			name:          "unhappy path: promoted secret failed the test, rolled back",
+			testErr:       errors.New("failed to connect"),
+			wantErr:       true,
+			wantCurrent:   "foo",
+			wantUpdateLen: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				client := &mockSecretsmanagerClient{
+					secretAWSCurrent: placeholderSecretUserStr,
+					secretByID: map[string]map[string]string{
+						"foo": {
+							"AWSCURRENT": placeholderSecretUserStr,
+						},
+						"bar": {
+							"AWSPENDING": placeholderSecretUserNewStr,
+						},
+					},
+				}
+
+				err := finishSecret(
+					context.TODO(), event, Config{
+						SecretsmanagerClient:        client,
+						ServiceClient:               &mockDBClient{testErr: tt.testErr},
+						SecretObj:                   &mockObj{},
+						RollbackOnPostFinishFailure: true,
+						Metrics:                     NoopMetrics{},
+					},
+				)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("finishSecret() error = %v, wantErr %v", err, tt.wantErr)
+				}
+
+				if len(client.updateSecretVersionStageInputs) != tt.wantUpdateLen {
+					t.Fatalf(
+						"finishSecret() called UpdateSecretVersionStage %d times, want %d",
+						len(client.updateSecretVersionStageInputs), tt.wantUpdateLen,
+					)
+				}
+
+				if tt.wantUpdateLen == 2 {
+					rollback := client.updateSecretVersionStageInputs[1]
+					if aws.ToString(rollback.MoveToVersionId) != "foo" ||
+						aws.ToString(rollback.RemoveFromVersionId) != "bar" ||
+						aws.ToString(rollback.VersionStage) != "AWSCURRENT" {
+						t.Errorf("finishSecret() unexpected rollback input: %+v", rollback)
+					}
+				}
+
+				if _, ok := client.secretByID[tt.wantCurrent]["AWSCURRENT"]; !ok {
+					t.Errorf("finishSecret() version %s shall be at the stage AWSCURRENT", tt.wantCurrent)
+				}
+			},
+		)
+	}
+}
+
+func Test_createSecret_RotationMetadataField(t *testing.T) {
+	t.Setenv("AWS_LAMBDA_FUNCTION_NAME", "rotation-lambda")
+
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		secretByID: map[string]map[string]string{
+			"foo": {
+				"AWSCURRENT": placeholderSecretUserStr,
+			},
+		},
+	}
+
+	if err := createSecret(
+		context.TODO(), SecretsmanagerTriggerPayload{
+			SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			Token:     "bar",
+			Step:      "createSecret",
+		}, Config{
+			SecretsmanagerClient:  client,
+			ServiceClient:         &mockDBClient{},
+			SecretObj:             &mockObj{},
+			RotationMetadataField: "rotation",
+			Metrics:               NoopMetrics{},
+		},
+	); err != nil {
+		t.Fatalf("createSecret() unexpected error = %v", err)
+	}
+
+	var got struct {
+		mockObj
+		Rotation json.RawMessage `json:"rotation"`
+	}
+	if err := json.Unmarshal([]byte(client.secretByID["bar"]["AWSPENDING"]), &got); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	if got.Password != placeholderSecretUserNewStr {
+		t.Errorf("createSecret() shall stage the new secret")
+	}
+
+	var metadata RotationMetadata
+	if err := json.Unmarshal(got.Rotation, &metadata); err != nil {
+		t.Fatalf("createSecret() faulty rotation metadata: %v", err)
+	}
+	if metadata.CreatedBy != "rotation-lambda" || metadata.Token != "bar" {
+		t.Errorf("createSecret() unexpected rotation metadata: %+v", metadata)
+	}
+	if _, err := time.Parse(time.RFC3339, metadata.TriggeredAt); err != nil {
+		t.Errorf("createSecret() unexpected rotation metadata triggered_at: %v", err)
+	}
+	if strings.Contains(string(got.Rotation), placeholderPassword) {
+		t.Errorf("createSecret() rotation metadata shall not include the password")
+	}
+}
+
+func Test_createSecret_TokenFields(t *testing.T) {
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		secretByID: map[string]map[string]string{
+			"foo": {
+				"AWSCURRENT": placeholderSecretUserStr,
+			},
+		},
+	}
+
+	if err := createSecret(
+		context.TODO(), SecretsmanagerTriggerPayload{
+			SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			Token:     "bar",
+			Step:      "createSecret",
+		}, Config{
+			SecretsmanagerClient:  client,
+			ServiceClient:         &mockDBClient{},
+			SecretObj:             &mockObj{},
+			RotationMetadataField: "rotation",
+			TokenFields:           []string{"version_token"},
+			Metrics:               NoopMetrics{},
+		},
+	); err != nil {
+		t.Fatalf("createSecret() unexpected error = %v", err)
+	}
+
+	pending := client.secretByID["bar"]["AWSPENDING"]
+	if got := secretAttribute(pending, "version_token"); got != "bar" {
+		t.Errorf("createSecret() staged the token %q, want %q", got, "bar")
+	}
+	if got := secretAttribute(pending, "password"); got != placeholderSecretUserNewStr {
+		t.Errorf("createSecret() shall stage the new secret")
+	}
+}
+
+func Test_stampToken(t *testing.T) {
+	secret := `{"password":"quxx","version_token":"foo","token":1}`
+
+	got, err := stampToken(&secret, []string{"version_token", "token"}, "bar")
+	if err != nil {
+		t.Fatalf("stampToken() unexpected error = %v", err)
+	}
+
+	want := `{"password":"quxx","token":"bar","version_token":"bar"}`
+	if *got != want {
+		t.Errorf("stampToken() got = %s, want %s", *got, want)
+	}
+}
+
+func TestNewTriggerPayload(t *testing.T) {
+	type args struct {
+		secretARN string
+		token     string
+		step      string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    SecretsmanagerTriggerPayload
+		wantErr bool
+	}{
+		{
+			name: "happy path",
+			args: args{
+				secretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+				token:     "bar",
+				step:      "testSecret",
+			},
+			want: SecretsmanagerTriggerPayload{
+				SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+				Token:     "bar",
+				Step:      "testSecret",
+			},
+		},
+		{
+			name: "happy path: validateCurrent without token",
+			args: args{
+				secretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+				step:      "validateCurrent",
+			},
+			want: SecretsmanagerTriggerPayload{
+				SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+				Step:      "validateCurrent",
+			},
+		},
+		{
+			name: "unhappy path: invalid step",
+			args: args{
+				secretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+				token:     "bar",
+				step:      "foobar",
+			},
+			wantErr: true,
+		},
+		{
+			name: "unhappy path: no token",
+			args: args{
+				secretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+				step:      "createSecret",
+			},
+			wantErr: true,
+		},
+		{
+			name: "unhappy path: no secret ARN",
+			args: args{
+				token: "bar",
+				step:  "createSecret",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := NewTriggerPayload(tt.args.secretARN, tt.args.token, tt.args.step)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("NewTriggerPayload() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("NewTriggerPayload() got = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+// mockPasswordsClient sets the passwords to the secret on subsequent Create calls.
+type mockPasswordsClient struct {
+	mockDBClient
+	passwords []string
+	calls     int
+}
+
+func (m *mockPasswordsClient) Create(ctx context.Context, secret any) error {
+	secret.(*mockObj).Password = m.passwords[m.calls%len(m.passwords)]
+	m.calls++
+	return nil
+}
+
+func Test_createSecret_pendingPasswordEqualsCurrent(t *testing.T) {
+	tests := []struct {
+		name              string
+		passwords         []string
+		maxCreateAttempts int
+		wantCalls         int
+		wantErr           bool
+	}{
+		{
+			name:      "happy path: regenerated",
+			passwords: []string{placeholderPassword, placeholderPassword + "new"},
+			wantCalls: 2,
+		},
+		{
+			name:      "unhappy path: attempts exhausted",
+			passwords: []string{placeholderPassword},
+			wantCalls: defaultMaxCreateAttempts,
+			wantErr:   true,
+		},
+		{
+			name:              "unhappy path: single attempt",
+			passwords:         []string{placeholderPassword, placeholderPassword + "new"},
+			maxCreateAttempts: 1,
+			wantCalls:         1,
+			wantErr:           true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				client := &mockSecretsmanagerClient{
+					secretAWSCurrent: placeholderSecretUserStr,
+					secretByID: map[string]map[string]string{
+						"foo": {
+							"AWSCURRENT": placeholderSecretUserStr,
+						},
+					},
+				}
+				serviceClient := &mockPasswordsClient{passwords: tt.passwords}
+
+				err := createSecret(
+					context.TODO(), SecretsmanagerTriggerPayload{
+						SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+						Token:     "bar",
+						Step:      "createSecret",
+					}, Config{
+						SecretsmanagerClient: client,
+						ServiceClient:        serviceClient,
+						SecretObj:            &mockObj{},
+						MaxCreateAttempts:    tt.maxCreateAttempts,
+						Metrics:              NoopMetrics{},
+						// the secret with the current password is identical to the current secret
+						AllowUnchangedSecret: true,
+					},
+				)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("createSecret() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if serviceClient.calls != tt.wantCalls {
+					t.Errorf("createSecret() called Create %d times, want %d", serviceClient.calls, tt.wantCalls)
+				}
+
+				_, staged := client.secretByID["bar"]
+				if staged == tt.wantErr {
+					t.Errorf("createSecret() staged = %v, want %v", staged, !tt.wantErr)
+				}
+			},
+		)
+	}
+}
+
+func Test_createSecret_targetPasswordPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		passwords []string
+		wantCalls int
+		wantErr   error
+	}{
+		{
+			name:      "happy path: too short password regenerated",
+			passwords: []string{"short", "compliant-password"},
+			wantCalls: 2,
+		},
+		{
+			name:      "unhappy path: attempts exhausted",
+			passwords: []string{"short"},
+			wantCalls: defaultMaxCreateAttempts,
+			wantErr:   ErrPasswordPolicyViolation,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				client := &mockSecretsmanagerClient{
+					secretAWSCurrent: placeholderSecretUserStr,
+					secretByID: map[string]map[string]string{
+						"foo": {"AWSCURRENT": placeholderSecretUserStr},
+					},
+				}
+				serviceClient := &mockPasswordsClient{passwords: tt.passwords}
+
+				err := createSecret(
+					context.TODO(), SecretsmanagerTriggerPayload{
+						SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+						Token:     "bar",
+						Step:      "createSecret",
+					}, Config{
+						SecretsmanagerClient: client,
+						ServiceClient:        serviceClient,
+						SecretObj:            &mockObj{},
+						Metrics:              NoopMetrics{},
+						TargetPasswordPolicy: &TargetPasswordPolicy{MinLength: 8},
+					},
+				)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("createSecret() error = %v, want %v", err, tt.wantErr)
+				}
+				if serviceClient.calls != tt.wantCalls {
+					t.Errorf("createSecret() called Create %d times, want %d", serviceClient.calls, tt.wantCalls)
+				}
+
+				staged := client.secretByID["bar"][StagePending]
+				if tt.wantErr == nil && secretAttribute(staged, "password") != "compliant-password" {
+					t.Errorf("createSecret() staged = %s, want the regenerated password", staged)
+				}
+				if tt.wantErr != nil && staged != "" {
+					t.Errorf("createSecret() staged the password which violates the policy")
+				}
+			},
+		)
+	}
+}
+
+// mockNoopClient does not mutate the secret on Create.
+type mockNoopClient struct {
+	mockDBClient
+	calls int
+}
+
+func (m *mockNoopClient) Create(context.Context, any) error {
+	m.calls++
+	return nil
+}
+
+func Test_createSecret_unchangedSecret(t *testing.T) {
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		secretByID: map[string]map[string]string{
+			"foo": {"AWSCURRENT": placeholderSecretUserStr},
+		},
+	}
+	serviceClient := &mockNoopClient{}
+
+	err := createSecret(
+		context.TODO(), SecretsmanagerTriggerPayload{
+			SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			Token:     "bar",
+			Step:      "createSecret",
+		}, Config{
+			SecretsmanagerClient: client,
+			ServiceClient:        serviceClient,
+			SecretObj:            &mockObj{},
+			Metrics:              NoopMetrics{},
+		},
+	)
+	if !errors.Is(err, ErrUnchangedSecret) {
+		t.Fatalf("createSecret() error = %v, want %v", err, ErrUnchangedSecret)
+	}
+	if serviceClient.calls != 1 {
+		t.Errorf("createSecret() called Create %d times, want 1", serviceClient.calls)
+	}
+	if _, staged := client.secretByID["bar"]; staged {
+		t.Errorf("createSecret() staged the secret identical to the current secret")
+	}
+}
+
+func Test_createSecret_AllowedSecretFields(t *testing.T) {
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		secretByID: map[string]map[string]string{
+			"foo": {"AWSCURRENT": placeholderSecretUserStr},
+		},
+	}
+
+	if err := createSecret(
+		context.TODO(), SecretsmanagerTriggerPayload{
+			SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			Token:     "bar",
+			Step:      "createSecret",
+		}, Config{
+			SecretsmanagerClient: client,
+			ServiceClient:        &mockDBClient{},
+			SecretObj:            &mockObj{},
+			AllowedSecretFields:  []string{"user", "host"},
+			Metrics:              NoopMetrics{},
+		},
+	); err != nil {
+		t.Fatalf("createSecret() unexpected error = %v", err)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal([]byte(client.secretByID["bar"][StagePending]), &got); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	want := map[string]any{"user": "bar", "password": placeholderSecretUserNewStr, "host": "dev"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("staged secret = %v, want %v", got, want)
+	}
+}
+
+type mockKMSClient struct {
+	keyState kmsTypes.KeyState
+	keyIDs   []string
+}
+
+func (m *mockKMSClient) DescribeKey(
+	ctx context.Context, input *kms.DescribeKeyInput, optFns ...func(*kms.Options),
+) (*kms.DescribeKeyOutput, error) {
+	m.keyIDs = append(m.keyIDs, aws.ToString(input.KeyId))
+	return &kms.DescribeKeyOutput{
+		KeyMetadata: &kmsTypes.KeyMetadata{
+			KeyId:    input.KeyId,
+			Enabled:  m.keyState == kmsTypes.KeyStateEnabled,
+			KeyState: m.keyState,
+		},
+	}, nil
+}
+
+func Test_createSecret_PreflightKMSCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		kmsKeyID   *string
+		keyState   kmsTypes.KeyState
+		wantKeyIDs []string
+		wantErr    bool
+	}{
+		{
+			name:       "happy path: enabled key",
+			kmsKeyID:   aws.String("arn:aws:kms:us-east-1:000000000000:key/foo"),
+			keyState:   kmsTypes.KeyStateEnabled,
+			wantKeyIDs: []string{"arn:aws:kms:us-east-1:000000000000:key/foo"},
+		},
+		{
+			name:       "happy path: AWS managed key",
+			keyState:   kmsTypes.KeyStateEnabled,
+			wantKeyIDs: []string{defaultSecretsmanagerKMSKey},
+		},
+		{
+			name:       "unhappy path: disabled key",
+			kmsKeyID:   aws.String("arn:aws:kms:us-east-1:000000000000:key/foo"),
+			keyState:   kmsTypes.KeyStateDisabled,
+			wantKeyIDs: []string{"arn:aws:kms:us-east-1:000000000000:key/foo"},
+			wantErr:    true,
+		},
+		{
+			name:       "unhappy path: key pending deletion",
+			kmsKeyID:   aws.String("arn:aws:kms:us-east-1:000000000000:key/foo"),
+			keyState:   kmsTypes.KeyStatePendingDeletion,
+			wantKeyIDs: []string{"arn:aws:kms:us-east-1:000000000000:key/foo"},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				client := &mockSecretsmanagerClient{
+					secretAWSCurrent: placeholderSecretUserStr,
+					secretByID: map[string]map[string]string{
+						"foo": {
+							"AWSCURRENT": placeholderSecretUserStr,
+						},
+					},
+					kmsKeyID: tt.kmsKeyID,
+				}
+				kmsClient := &mockKMSClient{keyState: tt.keyState}
+				serviceClient := &mockPasswordsClient{passwords: []string{placeholderPassword + "new"}}
+
+				err := createSecret(
+					context.TODO(), SecretsmanagerTriggerPayload{
+						SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+						Token:     "bar",
+						Step:      "createSecret",
+					}, Config{
+						SecretsmanagerClient: client,
+						ServiceClient:        serviceClient,
+						SecretObj:            &mockObj{},
+						PreflightKMSCheck:    true,
+						KMSClient:            kmsClient,
+						Metrics:              NoopMetrics{},
+					},
+				)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("createSecret() error = %v, wantErr %v", err, tt.wantErr)
+				}
+
+				if !reflect.DeepEqual(kmsClient.keyIDs, tt.wantKeyIDs) {
+					t.Errorf("createSecret() described keys %v, want %v", kmsClient.keyIDs, tt.wantKeyIDs)
+				}
+
+				if tt.wantErr && serviceClient.calls > 0 {
+					t.Errorf("createSecret() shall not generate the secret if the KMS key is not enabled")
+				}
+			},
+		)
+	}
+}
+
+func TestNewHandler_PreflightKMSCheckWithoutKMSClient(t *testing.T) {
+	if _, err := NewHandler(Config{SecretObj: &mockObj{}, PreflightKMSCheck: true}); err == nil {
+		t.Errorf("NewHandler() expected error")
+	}
+}
+
+// mockCallsRecordingClient records the calls of the ServiceClient's methods.
+type mockCallsRecordingClient struct {
+	mockDBClient
+	calls []string
+}
+
+func (m *mockCallsRecordingClient) Create(ctx context.Context, secret any) error {
+	m.calls = append(m.calls, "Create")
+	return m.mockDBClient.Create(ctx, secret)
+}
+
+func (m *mockCallsRecordingClient) Set(ctx context.Context, secretCurrent, secretPending, secretPrevious any) error {
+	m.calls = append(m.calls, "Set")
+	return m.mockDBClient.Set(ctx, secretCurrent, secretPending, secretPrevious)
+}
+
+func (m *mockCallsRecordingClient) Test(ctx context.Context, secret any) error {
+	m.calls = append(m.calls, "Test")
+	return m.mockDBClient.Test(ctx, secret)
+}
+
+func Test_createSecret_doesNotConnectToService(t *testing.T) {
+	serviceClient := &mockCallsRecordingClient{}
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		secretByID: map[string]map[string]string{
+			"foo": {
+				"AWSCURRENT": placeholderSecretUserStr,
+			},
+		},
+	}
+
+	if err := createSecret(
+		context.TODO(), SecretsmanagerTriggerPayload{
+			SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			Token:     "bar",
+			Step:      "createSecret",
+		}, Config{
+			SecretsmanagerClient: client,
+			ServiceClient:        serviceClient,
+			SecretObj:            &mockObj{},
+			Metrics:              NoopMetrics{},
+		},
+	); err != nil {
+		t.Fatalf("createSecret() unexpected error = %v", err)
+	}
+
+	if want := []string{"Create"}; !reflect.DeepEqual(serviceClient.calls, want) {
+		t.Errorf("createSecret() called %v, want %v", serviceClient.calls, want)
+	}
+}
+
+func TestCleanupStrayPendingVersions(t *testing.T) {
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		secretByID: map[string]map[string]string{
+			"foo": {
+				"AWSCURRENT": placeholderSecretUserStr,
+			},
+			"bar": {
+				"AWSPENDING": placeholderSecretUserNewStr,
+			},
+			"baz": {
+				"AWSPENDING": placeholderSecretUserNewStr,
+			},
+			"qux": {
+				"AWSPENDING": placeholderSecretUserNewStr,
+				"custom":     placeholderSecretUserNewStr,
+			},
+		},
+	}
+
+	got, err := CleanupStrayPendingVersions(
+		context.TODO(), client, "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8", "bar",
+	)
+	if err != nil {
+		t.Fatalf("CleanupStrayPendingVersions() unexpected error = %v", err)
+	}
+
+	if want := []string{"baz", "qux"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CleanupStrayPendingVersions() got = %v, want %v", got, want)
+	}
+
+	for version, wantPending := range map[string]bool{"foo": false, "bar": true, "baz": false, "qux": false} {
+		if _, ok := client.secretByID[version]["AWSPENDING"]; ok != wantPending {
+			t.Errorf("version %s has stage AWSPENDING: %v, want %v", version, ok, wantPending)
+		}
+	}
+	if _, ok := client.secretByID["qux"]["custom"]; !ok {
+		t.Errorf("CleanupStrayPendingVersions() shall preserve other stages")
+	}
+}
+
+func Test_finishSecret_CleanupStrayPendingVersions(t *testing.T) {
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		secretByID: map[string]map[string]string{
+			"foo": {
+				"AWSCURRENT": placeholderSecretUserStr,
+			},
+			"bar": {
+				"AWSPENDING": placeholderSecretUserNewStr,
+			},
+			"baz": {
+				"AWSPENDING": placeholderSecretUserNewStr,
+			},
+		},
+	}
+
+	if err := finishSecret(
+		context.TODO(), SecretsmanagerTriggerPayload{
+			SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			Token:     "bar",
+			Step:      "finishSecret",
+		}, Config{
+			SecretsmanagerClient:        client,
+			ServiceClient:               &mockDBClient{},
+			SecretObj:                   &mockObj{},
+			CleanupStrayPendingVersions: true,
+			Metrics:                     NoopMetrics{},
+		},
+	); err != nil {
+		t.Fatalf("finishSecret() unexpected error = %v", err)
+	}
+
+	if _, ok := client.secretByID["baz"]["AWSPENDING"]; ok {
+		t.Errorf("finishSecret() shall remove the stage AWSPENDING from the stray version")
+	}
+	if _, ok := client.secretByID["bar"]["AWSCURRENT"]; !ok {
+		t.Errorf("finishSecret() shall promote the version")
+	}
+}
+
+// mockDescribeCountingClient counts the calls of DescribeSecret.
+type mockDescribeCountingClient struct {
+	*mockSecretsmanagerClient
+	describeCalls int
+}
+
+func (m *mockDescribeCountingClient) DescribeSecret(
+	ctx context.Context, input *secretsmanager.DescribeSecretInput, optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.DescribeSecretOutput, error) {
+	m.describeCalls++
+	return m.mockSecretsmanagerClient.DescribeSecret(ctx, input, optFns...)
+}
+
+func Test_finishSecret_SkipFinishDescribe(t *testing.T) {
+	tests := []struct {
+		name              string
+		currentVersionID  string
+		wantDescribeCalls int
+	}{
+		{
+			name:              "current version supplied",
+			currentVersionID:  "foo",
+			wantDescribeCalls: 0,
+		},
+		{
+			name:              "current version not supplied",
+			wantDescribeCalls: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				client := &mockDescribeCountingClient{
+					mockSecretsmanagerClient: &mockSecretsmanagerClient{
+						secretAWSCurrent: placeholderSecretUserStr,
+						secretByID: map[string]map[string]string{
+							"foo": {"AWSCURRENT": placeholderSecretUserStr},
+							"bar": {"AWSPENDING": placeholderSecretUserNewStr},
+						},
+					},
+				}
+
+				if err := finishSecret(
+					context.TODO(), SecretsmanagerTriggerPayload{
+						SecretARN:        "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+						Token:            "bar",
+						Step:             "finishSecret",
+						CurrentVersionID: tt.currentVersionID,
+					}, Config{
+						SecretsmanagerClient: client,
+						ServiceClient:        &mockDBClient{},
+						SecretObj:            &mockObj{},
+						SkipFinishDescribe:   true,
+						Metrics:              NoopMetrics{},
+					},
+				); err != nil {
+					t.Fatalf("finishSecret() unexpected error = %v", err)
+				}
+
+				if client.describeCalls != tt.wantDescribeCalls {
+					t.Errorf("DescribeSecret called %d times, want %d", client.describeCalls, tt.wantDescribeCalls)
+				}
+				if _, ok := client.secretByID["bar"]["AWSCURRENT"]; !ok {
+					t.Errorf("finishSecret() shall promote the version")
+				}
+				if _, ok := client.secretByID["foo"]["AWSCURRENT"]; ok {
+					t.Errorf("finishSecret() shall remove the stage AWSCURRENT from the previous version")
+				}
+			},
+		)
+	}
+}
+
+func Test_finishSecret_customStages(t *testing.T) {
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		secretByID: map[string]map[string]string{
+			"foo": {
+				StageCurrent: placeholderSecretUserStr,
+				"custom":     placeholderSecretUserStr,
+			},
+			"bar": {
+				StagePending: placeholderSecretUserNewStr,
+				"release-1":  placeholderSecretUserNewStr,
+			},
+			"baz": {
+				"AWSCURRENT-backup": placeholderSecretUserStr,
+			},
+		},
+	}
+
+	if err := finishSecret(
+		context.TODO(), SecretsmanagerTriggerPayload{
+			SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			Token:     "bar",
+			Step:      "finishSecret",
+		}, Config{
+			SecretsmanagerClient: client,
+			ServiceClient:        &mockDBClient{},
+			SecretObj:            &mockObj{},
+			Metrics:              NoopMetrics{},
+		},
+	); err != nil {
+		t.Fatalf("finishSecret() unexpected error = %v", err)
+	}
+
+	if len(client.updateSecretVersionStageInputs) != 1 {
+		t.Fatalf("finishSecret() shall update the stage once")
+	}
+	if got := aws.ToString(client.updateSecretVersionStageInputs[0].RemoveFromVersionId); got != "foo" {
+		t.Errorf("finishSecret() removed the stage AWSCURRENT from the version %s, want foo", got)
+	}
+	if _, ok := client.secretByID["foo"]["custom"]; !ok {
+		t.Errorf("finishSecret() shall preserve the custom stages")
+	}
+}
+
+func Test_validateInput_customStagesOnly(t *testing.T) {
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		secretByID: map[string]map[string]string{
+			"foo": {
+				StageCurrent: placeholderSecretUserStr,
+			},
+			"bar": {
+				"custom": placeholderSecretUserNewStr,
+			},
+		},
+		rotationEnabled: aws.Bool(true),
+	}
+
+	if err := validateInput(
+		context.TODO(), SecretsmanagerTriggerPayload{
+			SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			Token:     "bar",
+			Step:      "finishSecret",
+		}, client,
+	); err == nil {
+		t.Errorf("validateInput() expected error for the version with custom stages only")
+	}
+}
+
+// mockFailingClient fails the ServiceClient's methods with err.
+type mockFailingClient struct {
+	err error
+}
+
+func (m mockFailingClient) Create(context.Context, any) error {
+	return m.err
+}
+
+func (m mockFailingClient) Set(context.Context, any, any, any) error {
+	return m.err
+}
+
+func (m mockFailingClient) Test(context.Context, any) error {
+	return m.err
+}
+
+// mockFailingStageUpdateClient fails the update of the secret's version stage with err.
+type mockFailingStageUpdateClient struct {
+	*mockSecretsmanagerClient
+	err error
+}
+
+func (m mockFailingStageUpdateClient) UpdateSecretVersionStage(
+	context.Context, *secretsmanager.UpdateSecretVersionStageInput, ...func(*secretsmanager.Options),
+) (*secretsmanager.UpdateSecretVersionStageOutput, error) {
+	return nil, m.err
+}
+
+func TestNewHandler_wrappedErrors(t *testing.T) {
+	errSentinel := errors.New("sentinel")
+
+	newSecretsmanagerClient := func(tokenStage string) *mockSecretsmanagerClient {
+		return &mockSecretsmanagerClient{
+			secretAWSCurrent: placeholderSecretUserStr,
+			secretByID: map[string]map[string]string{
+				"foo": {"AWSCURRENT": placeholderSecretUserStr},
+				"bar": {tokenStage: placeholderSecretUserNewStr},
+			},
+			rotationEnabled: aws.Bool(true),
+		}
+	}
+
+	// the stages are not returned with the secret value, hence createSecret does not skip the pending version
+	newSecretsmanagerClientEmptyVersionStages := func() *mockSecretsmanagerClient {
+		o := newSecretsmanagerClient("AWSPENDING")
+		o.emptyVersionStages = true
+		return o
+	}
+
+	tests := []struct {
+		name                 string
+		step                 string
+		secretsmanagerClient SecretsmanagerClient
+		serviceClient        ServiceClient
+		wantErrMsg           string
+	}{
+		{
+			name:                 "createSecret: ServiceClient.Create fails",
+			step:                 "createSecret",
+			secretsmanagerClient: newSecretsmanagerClientEmptyVersionStages(),
+			serviceClient:        mockFailingClient{err: errSentinel},
+			wantErrMsg:           "createSecret: generate secret: create: sentinel",
+		},
+		{
+			name:                 "setSecret: ServiceClient.Set fails",
+			step:                 "setSecret",
+			secretsmanagerClient: newSecretsmanagerClient("AWSPENDING"),
+			serviceClient:        mockFailingClient{err: errSentinel},
+			wantErrMsg:           "setSecret: set: sentinel",
+		},
+		{
+			name:                 "testSecret: ServiceClient.Test fails",
+			step:                 "testSecret",
+			secretsmanagerClient: newSecretsmanagerClient("AWSPENDING"),
+			serviceClient:        mockFailingClient{err: errSentinel},
+			wantErrMsg:           "testSecret: test: sentinel",
+		},
+		{
+			name: "finishSecret: UpdateSecretVersionStage fails",
+			step: "finishSecret",
+			secretsmanagerClient: mockFailingStageUpdateClient{
+				mockSecretsmanagerClient: newSecretsmanagerClient("AWSPENDING"),
+				err:                      errSentinel,
+			},
+			serviceClient: &mockDBClient{},
+			wantErrMsg:    "finishSecret: move AWSCURRENT to the version bar: sentinel",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				h, err := NewHandler(
+					Config{
+						SecretsmanagerClient: tt.secretsmanagerClient,
+						ServiceClient:        tt.serviceClient,
+						SecretObj:            &mockObj{},
+						Metrics:              NoopMetrics{},
+					},
+				)
+				if err != nil {
+					t.Fatalf("NewHandler() unexpected error = %v", err)
+				}
+
+				err = h(
+					context.TODO(), SecretsmanagerTriggerPayload{
+						SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+						Token:     "bar",
+						Step:      tt.step,
+					},
+				)
+				if !errors.Is(err, errSentinel) {
+					t.Fatalf("handler() error = %v, want wrapped %v", err, errSentinel)
+				}
+				if err.Error() != tt.wantErrMsg {
+					t.Errorf("handler() error = %q, want %q", err.Error(), tt.wantErrMsg)
+				}
+			},
+		)
+	}
+}
+
+func Test_finishSecret_RollbackOnPostFinishFailure_wrappedError(t *testing.T) {
+	errSentinel := errors.New("sentinel")
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		secretByID: map[string]map[string]string{
+			"foo": {"AWSCURRENT": placeholderSecretUserStr},
+			"bar": {"AWSPENDING": placeholderSecretUserNewStr},
+		},
+	}
+
+	err := finishSecret(
+		context.TODO(), SecretsmanagerTriggerPayload{
+			SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			Token:     "bar",
+			Step:      "finishSecret",
+		}, Config{
+			SecretsmanagerClient:        client,
+			ServiceClient:               &mockDBClient{testErr: errSentinel},
+			SecretObj:                   &mockObj{},
+			RollbackOnPostFinishFailure: true,
+			Metrics:                     NoopMetrics{},
+		},
+	)
+	if !errors.Is(err, errSentinel) {
+		t.Errorf("finishSecret() error = %v, want wrapped %v", err, errSentinel)
+	}
+}
+
+func TestNewHandler_validateCurrent(t *testing.T) {
+	errSentinel := errors.New("sentinel")
+	tests := []struct {
+		name        string
+		testErr     error
+		wantErr     bool
+		wantCounter string
+	}{
+		{
+			name:        "happy path",
+			wantCounter: metricStepSuccess,
+		},
+		{
+			name:        "unhappy path: the test failed",
+			testErr:     errSentinel,
+			wantErr:     true,
+			wantCounter: metricStepFailure,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				client := &mockSecretsmanagerClient{
+					secretAWSCurrent: placeholderSecretUserStr,
+					secretByID: map[string]map[string]string{
+						"foo": {"AWSCURRENT": placeholderSecretUserStr},
+					},
+				}
+				serviceClient := &mockCallsRecordingClient{mockDBClient: mockDBClient{testErr: tt.testErr}}
+				metrics := &mockMetrics{}
+
+				h, err := NewHandler(
+					Config{
+						SecretsmanagerClient: client,
+						ServiceClient:        serviceClient,
+						SecretObj:            &mockObj{},
+						Metrics:              metrics,
+					},
+				)
+				if err != nil {
+					t.Fatalf("NewHandler() unexpected error = %v", err)
+				}
+
+				err = h(
+					context.TODO(), SecretsmanagerTriggerPayload{
+						SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+						Step:      "validateCurrent",
+					},
+				)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("handler() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if tt.wantErr && !errors.Is(err, errSentinel) {
+					t.Errorf("handler() error = %v, want wrapped %v", err, errSentinel)
+				}
+
+				if want := []string{"Test"}; !reflect.DeepEqual(serviceClient.calls, want) {
+					t.Errorf("handler() called %v, want %v", serviceClient.calls, want)
+				}
+				if len(client.updateSecretVersionStageInputs) != 0 {
+					t.Errorf("handler() must not update the secret's stages")
+				}
+				if got := metrics.countCounter(tt.wantCounter); got != 1 {
+					t.Errorf("handler() recorded %d %s metrics, want 1", got, tt.wantCounter)
+				}
+			},
+		)
+	}
+}
+
+// mockFailingPutClient fails PutSecretValue the given number of times.
+type mockFailingPutClient struct {
+	*mockSecretsmanagerClient
+	failures int
+}
+
+func (m *mockFailingPutClient) PutSecretValue(
+	ctx context.Context, input *secretsmanager.PutSecretValueInput, optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.PutSecretValueOutput, error) {
+	if m.failures > 0 {
+		m.failures--
+		return nil, errors.New("throttled")
+	}
+	return m.mockSecretsmanagerClient.PutSecretValue(ctx, input, optFns...)
+}
+
+func TestNewHandler_CachePendingSecrets(t *testing.T) {
+	tests := []struct {
+		name                string
+		cachePendingSecrets bool
+		wantPassword        string
+		wantCreateCalls     int
+	}{
+		{
+			name:                "the retried createSecret stages the secret generated first",
+			cachePendingSecrets: true,
+			wantPassword:        "first",
+			wantCreateCalls:     1,
+		},
+		{
+			name:            "the retried createSecret generates new secret without cache",
+			wantPassword:    "second",
+			wantCreateCalls: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				client := &mockSecretsmanagerClient{
+					secretAWSCurrent: placeholderSecretUserStr,
+					secretByID: map[string]map[string]string{
+						"foo": {"AWSCURRENT": placeholderSecretUserStr},
+						"bar": {"AWSPENDING": placeholderSecretUserStr},
+					},
+					rotationEnabled: aws.Bool(true),
+					// the stages are not returned with the secret value, hence createSecret does not skip
+					emptyVersionStages: true,
+				}
+				serviceClient := &mockPasswordsClient{passwords: []string{"first", "second"}}
+
+				h, err := NewHandler(
+					Config{
+						SecretsmanagerClient: &mockFailingPutClient{mockSecretsmanagerClient: client, failures: 1},
+						ServiceClient:        serviceClient,
+						SecretObj:            &mockObj{},
+						CachePendingSecrets:  tt.cachePendingSecrets,
+						Metrics:              NoopMetrics{},
+					},
+				)
+				if err != nil {
+					t.Fatalf("NewHandler() unexpected error = %v", err)
+				}
+
+				event := SecretsmanagerTriggerPayload{
+					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+					Token:     "bar",
+					Step:      "createSecret",
+				}
+				if err := h(context.TODO(), event); err == nil {
+					t.Fatalf("handler() expected error on the first attempt")
+				}
+				if err := h(context.TODO(), event); err != nil {
+					t.Fatalf("handler() unexpected error = %v", err)
+				}
+
+				if got := getSecret(client, "AWSPENDING", "bar").Password; got != tt.wantPassword {
+					t.Errorf("staged password = %v, want %v", got, tt.wantPassword)
+				}
+				if serviceClient.calls != tt.wantCreateCalls {
+					t.Errorf("Create called %d times, want %d", serviceClient.calls, tt.wantCreateCalls)
+				}
+			},
+		)
+	}
+}
+
+func TestNewHandler_createSecretReusesPending(t *testing.T) {
+	// the secret was staged partially by the previous attempt
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		secretByID: map[string]map[string]string{
+			"foo": {"AWSCURRENT": placeholderSecretUserStr},
+			"bar": {"AWSPENDING": `{"password":"first"}`},
+		},
+		rotationEnabled: aws.Bool(true),
+	}
+	serviceClient := &mockPasswordsClient{passwords: []string{"second"}}
+
+	h, err := NewHandler(
+		Config{
+			SecretsmanagerClient: client,
+			ServiceClient:        serviceClient,
+			SecretObj:            &mockObj{},
+			Metrics:              NoopMetrics{},
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewHandler() unexpected error = %v", err)
+	}
+
+	if err := h(
+		context.TODO(), SecretsmanagerTriggerPayload{
+			SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			Token:     "bar",
+			Step:      "createSecret",
+		},
+	); err != nil {
+		t.Fatalf("handler() unexpected error = %v", err)
+	}
+
+	if serviceClient.calls != 0 {
+		t.Errorf("Create called %d times, want 0", serviceClient.calls)
+	}
+	if got := getSecret(client, "AWSPENDING", "bar").Password; got != "first" {
+		t.Errorf("staged password = %v, want %v", got, "first")
+	}
+}
+
+// mockRevokingClient fails the test of the secrets with the revoked password.
+type mockRevokingClient struct {
+	mockDBClient
+	revoked string
+	err     error
+}
+
+func (m *mockRevokingClient) Test(ctx context.Context, secret any) error {
+	if secret.(*mockObj).Password == m.revoked {
+		return fmt.Errorf("%w: password authentication failed", ErrAuthFailed)
+	}
+	return m.err
+}
+
+func Test_finishSecret_VerifyOldPasswordRevoked(t *testing.T) {
+	tests := []struct {
+		name          string
+		serviceClient ServiceClient
+		wantErr       bool
+	}{
+		{
+			name:          "happy path: old password is revoked",
+			serviceClient: &mockRevokingClient{revoked: placeholderPassword},
+		},
+		{
+			name:          "unhappy path: old password still connects",
+			serviceClient: &mockDBClient{},
+			wantErr:       true,
+		},
+		{
+			name:          "unhappy path: old password test fails with the error other than authentication",
+			serviceClient: &mockRevokingClient{err: errors.New("connection refused")},
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				client := &mockSecretsmanagerClient{
+					secretAWSCurrent: placeholderSecretUserStr,
+					secretByID: map[string]map[string]string{
+						"foo": {"AWSCURRENT": placeholderSecretUserStr},
+						"bar": {"AWSPENDING": placeholderSecretUserNewStr},
+					},
+				}
+
+				err := finishSecret(
+					context.TODO(), SecretsmanagerTriggerPayload{
+						SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+						Token:     "bar",
+						Step:      "finishSecret",
+					}, Config{
+						SecretsmanagerClient:     client,
+						ServiceClient:            tt.serviceClient,
+						SecretObj:                &mockObj{},
+						VerifyOldPasswordRevoked: true,
+						Metrics:                  NoopMetrics{},
+					},
+				)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("finishSecret() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			},
+		)
+	}
+}
+
+// mockBlockingClient blocks the ServiceClient's methods until the context is done.
+type mockBlockingClient struct{}
+
+func (mockBlockingClient) Create(ctx context.Context, _ any) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (mockBlockingClient) Set(ctx context.Context, _, _, _ any) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (mockBlockingClient) Test(ctx context.Context, _ any) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// mockBlockingStageUpdateClient blocks the update of the secret's version stage until the context is done.
+type mockBlockingStageUpdateClient struct {
+	*mockSecretsmanagerClient
+}
+
+func (m mockBlockingStageUpdateClient) UpdateSecretVersionStage(
+	ctx context.Context, _ *secretsmanager.UpdateSecretVersionStageInput, _ ...func(*secretsmanager.Options),
+) (*secretsmanager.UpdateSecretVersionStageOutput, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// mockCancellingClient cancels the context when the secret is read.
+type mockCancellingClient struct {
+	*mockSecretsmanagerClient
+	cancel context.CancelFunc
+}
+
+func (m mockCancellingClient) GetSecretValue(
+	ctx context.Context, input *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.GetSecretValueOutput, error) {
+	m.cancel()
+	return m.mockSecretsmanagerClient.GetSecretValue(ctx, input, optFns...)
+}
+
+func (m mockCancellingClient) DescribeSecret(
+	ctx context.Context, input *secretsmanager.DescribeSecretInput, optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.DescribeSecretOutput, error) {
+	m.cancel()
+	return m.mockSecretsmanagerClient.DescribeSecret(ctx, input, optFns...)
+}
+
+func TestNewHandler_contextCancellation(t *testing.T) {
+	newSecretsmanagerClient := func() *mockSecretsmanagerClient {
+		return &mockSecretsmanagerClient{
+			secretAWSCurrent: placeholderSecretUserStr,
+			secretByID: map[string]map[string]string{
+				"foo": {"AWSCURRENT": placeholderSecretUserStr},
+				"bar": {"AWSPENDING": placeholderSecretUserNewStr},
+			},
+			rotationEnabled: aws.Bool(true),
+			// the stages are not returned with the secret value, hence createSecret does not skip
+			emptyVersionStages: true,
+		}
+	}
+
+	steps := []struct {
+		step                 string
+		secretsmanagerClient SecretsmanagerClient
+	}{
+		{step: "createSecret", secretsmanagerClient: newSecretsmanagerClient()},
+		{step: "setSecret", secretsmanagerClient: newSecretsmanagerClient()},
+		{step: "testSecret", secretsmanagerClient: newSecretsmanagerClient()},
+		{step: "finishSecret", secretsmanagerClient: mockBlockingStageUpdateClient{newSecretsmanagerClient()}},
+		{step: "validateCurrent", secretsmanagerClient: newSecretsmanagerClient()},
+	}
+	for _, tt := range steps {
+		t.Run(
+			tt.step+": cancelled mid-step", func(t *testing.T) {
+				h, err := NewHandler(
+					Config{
+						SecretsmanagerClient: tt.secretsmanagerClient,
+						ServiceClient:        mockBlockingClient{},
+						SecretObj:            &mockObj{},
+						Metrics:              NoopMetrics{},
+					},
+				)
+				if err != nil {
+					t.Fatalf("NewHandler() unexpected error = %v", err)
+				}
+
+				ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+				defer cancel()
+
+				start := time.Now()
+				err = h(
+					ctx, SecretsmanagerTriggerPayload{
+						SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+						Token:     "bar",
+						Step:      tt.step,
+					},
+				)
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("handler() error = %v, want %v", err, context.DeadlineExceeded)
+				}
+				if elapsed := time.Since(start); elapsed > time.Second {
+					t.Errorf("handler() returned after %v, want prompt return", elapsed)
+				}
+			},
+		)
+
+		t.Run(
+			tt.step+": cancelled before the step", func(t *testing.T) {
+				serviceClient := &mockCallsRecordingClient{}
+				h, err := NewHandler(
+					Config{
+						SecretsmanagerClient: newSecretsmanagerClient(),
+						ServiceClient:        serviceClient,
+						SecretObj:            &mockObj{},
+						Metrics:              NoopMetrics{},
+					},
+				)
+				if err != nil {
+					t.Fatalf("NewHandler() unexpected error = %v", err)
+				}
+
+				ctx, cancel := context.WithCancel(context.TODO())
+				cancel()
+
+				err = h(
+					ctx, SecretsmanagerTriggerPayload{
+						SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+						Token:     "bar",
+						Step:      tt.step,
+					},
+				)
+				if !errors.Is(err, context.Canceled) {
+					t.Errorf("handler() error = %v, want %v", err, context.Canceled)
+				}
+				if len(serviceClient.calls) > 0 {
+					t.Errorf("handler() called %v after the cancellation", serviceClient.calls)
+				}
+			},
+		)
+
+		t.Run(
+			tt.step+": cancelled while reading the secret", func(t *testing.T) {
+				ctx, cancel := context.WithCancel(context.TODO())
+				defer cancel()
+
+				client := newSecretsmanagerClient()
+				serviceClient := &mockCallsRecordingClient{}
+				h, err := NewHandler(
+					Config{
+						SecretsmanagerClient: mockCancellingClient{mockSecretsmanagerClient: client, cancel: cancel},
+						ServiceClient:        serviceClient,
+						SecretObj:            &mockObj{},
+						Metrics:              NoopMetrics{},
+					},
+				)
+				if err != nil {
+					t.Fatalf("NewHandler() unexpected error = %v", err)
+				}
+
+				err = h(
+					ctx, SecretsmanagerTriggerPayload{
+						SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+						Token:     "bar",
+						Step:      tt.step,
+					},
+				)
+				if !errors.Is(err, context.Canceled) {
+					t.Errorf("handler() error = %v, want %v", err, context.Canceled)
+				}
+				if len(serviceClient.calls) > 0 || len(client.updateSecretVersionStageInputs) > 0 {
+					t.Errorf("handler() proceeded after the cancellation")
+				}
+			},
+		)
+	}
+}
+
+func TestSecretsmanagerTriggerPayload_UnmarshalJSON(t *testing.T) {
+	want := SecretsmanagerTriggerPayload{
+		SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+		Token:     "bar",
+		Step:      "createSecret",
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		want    SecretsmanagerTriggerPayload
+		wantErr bool
+	}{
+		{
+			name: "flat payload",
+			data: `{"SecretId":"arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",` +
+				`"ClientRequestToken":"bar","Step":"createSecret"}`,
+			want: want,
+		},
+		{
+			name: "detail-wrapped payload",
+			data: `{"source":"custom","detail":{` +
+				`"SecretId":"arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",` +
+				`"ClientRequestToken":"bar","Step":"createSecret"}}`,
+			want: want,
+		},
+		{
+			name: "flat payload takes precedence",
+			data: `{"SecretId":"arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",` +
+				`"ClientRequestToken":"bar","Step":"createSecret","detail":{"Step":"finishSecret"}}`,
+			want: want,
+		},
+		{
+			name: "null detail",
+			data: `{"detail":null}`,
+		},
+		{
+			name:    "faulty detail",
+			data:    `{"detail":"foo"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				var got SecretsmanagerTriggerPayload
+				if err := json.Unmarshal([]byte(tt.data), &got); (err != nil) != tt.wantErr {
+					t.Fatalf("UnmarshalJSON() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if got != tt.want {
+					t.Errorf("UnmarshalJSON() got = %+v, want %+v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func Test_createSecret_stalePending(t *testing.T) {
+	tests := []struct {
+		name                string
+		reclaimStalePending bool
+		wantErr             error
+	}{
+		{
+			name:    "unhappy path: stale AWSPENDING version",
+			wantErr: ErrStalePending,
+		},
+		{
+			name:                "happy path: stale AWSPENDING version is reclaimed",
+			reclaimStalePending: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				client := &mockSecretsmanagerClient{
+					secretAWSCurrent: placeholderSecretUserStr,
+					secretByID: map[string]map[string]string{
+						"foo":   {"AWSCURRENT": placeholderSecretUserStr},
+						"stale": {"AWSPENDING": placeholderSecretUserNewStr},
+					},
+				}
+
+				err := createSecret(
+					context.TODO(), SecretsmanagerTriggerPayload{
+						SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+						Token:     "bar",
+						Step:      "createSecret",
+					}, Config{
+						SecretsmanagerClient: client,
+						ServiceClient:        &mockDBClient{},
+						SecretObj:            &mockObj{},
+						ReclaimStalePending:  tt.reclaimStalePending,
+						Metrics:              NoopMetrics{},
+					},
+				)
+				if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+					t.Fatalf("createSecret() error = %v, wantErr %v", err, tt.wantErr)
+				}
+
+				_, stalePending := client.secretByID["stale"][StagePending]
+				if stalePending != (tt.wantErr != nil) {
+					t.Errorf("stale version labeled AWSPENDING = %v, want %v", stalePending, tt.wantErr != nil)
+				}
+
+				_, staged := client.secretByID["bar"][StagePending]
+				if staged != (tt.wantErr == nil) {
+					t.Errorf("createSecret() staged = %v, want %v", staged, tt.wantErr == nil)
+				}
+
+				if tt.wantErr == nil {
+					input := client.updateSecretVersionStageInputs[0]
+					if aws.ToString(input.RemoveFromVersionId) != "stale" ||
+						aws.ToString(input.VersionStage) != StagePending {
+						t.Errorf("UpdateSecretVersionStage() input = %+v", input)
+					}
+				}
+			},
+		)
+	}
+}
+
+// mockHalfAppliedClient applies the first move of the stage partially: either the stage is added
+// to the new version only, or removed from the old version only.
+type mockHalfAppliedClient struct {
+	*mockSecretsmanagerClient
+	addOnly bool
+	applied bool
+}
+
+func (m *mockHalfAppliedClient) UpdateSecretVersionStage(
+	ctx context.Context, input *secretsmanager.UpdateSecretVersionStageInput,
+	optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.UpdateSecretVersionStageOutput, error) {
+	if m.applied {
+		return m.mockSecretsmanagerClient.UpdateSecretVersionStage(ctx, input, optFns...)
+	}
+	m.applied = true
+
+	in := *input
+	if m.addOnly {
+		in.RemoveFromVersionId = nil
+	} else {
+		in.MoveToVersionId = nil
+	}
+	return m.mockSecretsmanagerClient.UpdateSecretVersionStage(ctx, &in, optFns...)
+}
+
+func Test_finishSecret_ReconcilePromotion(t *testing.T) {
+	tests := []struct {
+		name        string
+		addOnly     bool
+		reconcile   bool
+		wantCurrent []string
+	}{
+		{
+			name:        "stage added to the new version, but not removed from the old version",
+			addOnly:     true,
+			reconcile:   true,
+			wantCurrent: []string{"bar"},
+		},
+		{
+			name:        "stage removed from the old version, but not added to the new version",
+			addOnly:     false,
+			reconcile:   true,
+			wantCurrent: []string{"bar"},
+		},
+		{
+			name:        "half-applied move is not reconciled unless set",
+			addOnly:     true,
+			reconcile:   false,
+			wantCurrent: []string{"bar", "foo"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				client := &mockSecretsmanagerClient{
+					secretAWSCurrent: placeholderSecretUserStr,
+					secretByID: map[string]map[string]string{
+						"foo": {StageCurrent: placeholderSecretUserStr},
+						"bar": {StagePending: placeholderSecretUserNewStr},
+					},
+				}
+				cfg := Config{
+					SecretsmanagerClient: &mockHalfAppliedClient{mockSecretsmanagerClient: client, addOnly: tt.addOnly},
+					ServiceClient:        &mockDBClient{},
+					SecretObj:            &mockObj{},
+					ReconcilePromotion:   tt.reconcile,
+				}
+				event := SecretsmanagerTriggerPayload{
+					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+					Token:     "bar",
+					Step:      "finishSecret",
+				}
+				if err := finishSecret(context.TODO(), event, cfg); err != nil {
+					t.Fatalf("finishSecret() unexpected error = %v", err)
+				}
+
+				var gotCurrent []string
+				for version, stages := range client.secretByID {
+					if _, ok := stages[StageCurrent]; ok {
+						gotCurrent = append(gotCurrent, version)
+					}
+				}
+				sort.Strings(gotCurrent)
+				if !reflect.DeepEqual(gotCurrent, tt.wantCurrent) {
+					t.Errorf("versions at the stage AWSCURRENT = %v, want %v", gotCurrent, tt.wantCurrent)
+				}
+			},
+		)
+	}
+}
+
+func TestNewHandler_nilServiceClient(t *testing.T) {
+	tests := []struct {
+		name    string
+		step    string
+		cfg     Config
+		wantErr error
+	}{
+		{
+			name: "finishSecret does not require ServiceClient",
+			step: "finishSecret",
+		},
+		{
+			name:    "setSecret requires ServiceClient",
+			step:    "setSecret",
+			wantErr: ErrNoServiceClient,
+		},
+		{
+			name:    "createSecret requires ServiceClient",
+			step:    "createSecret",
+			wantErr: ErrNoServiceClient,
+		},
+		{
+			name:    "finishSecret requires ServiceClient to verify that the old password is revoked",
+			step:    "finishSecret",
+			cfg:     Config{VerifyOldPasswordRevoked: true},
+			wantErr: ErrNoServiceClient,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				client := &mockSecretsmanagerClient{
+					secretAWSCurrent: placeholderSecretUserStr,
+					secretByID: map[string]map[string]string{
+						"foo": {StageCurrent: placeholderSecretUserStr},
+						"bar": {StagePending: placeholderSecretUserNewStr},
+					},
+					rotationEnabled: aws.Bool(true),
+				}
+
+				cfg := tt.cfg
+				cfg.SecretsmanagerClient = client
+				cfg.SecretObj = &mockObj{}
+				cfg.Metrics = NoopMetrics{}
+
+				handler, err := NewHandler(cfg)
+				if err != nil {
+					t.Fatalf("NewHandler() unexpected error = %v", err)
+				}
+
+				err = handler(
+					context.TODO(), SecretsmanagerTriggerPayload{
+						SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+						Token:     "bar",
+						Step:      tt.step,
+					},
+				)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("handler() error = %v, want %v", err, tt.wantErr)
+				}
+
+				if tt.wantErr == nil {
+					if _, ok := client.secretByID["bar"][StageCurrent]; !ok {
+						t.Errorf("handler() shall promote the version bar to the stage AWSCURRENT")
+					}
+				} else if len(client.updateSecretVersionStageInputs) > 0 {
+					t.Errorf("handler() shall not change the secret's stages")
+				}
+			},
+		)
+	}
+}
+
+// mockGeneratingClient generates the password using PasswordGenerator.
+type mockGeneratingClient struct {
+	mockDBClient
+}
+
+func (m *mockGeneratingClient) Create(ctx context.Context, secret any) error {
+	password, err := PasswordGenerator{}.GenerateContext(ctx)
+	if err != nil {
+		return err
+	}
+	secret.(*mockObj).Password = password
+	return nil
+}
+
+func Test_createSecret_placeholderCurrentPassword(t *testing.T) {
+	secretWithPassword := func(password string) string {
+		return `{"user":"bar","password":"` + password + `","host":"dev","project_id":"baz","branch_id":"br-foo",` +
+			`"dbname":"foo"}`
+	}
+
+	tests := []struct {
+		name            string
+		currentPassword string
+		serviceClient   ServiceClient
+		wantErr         error
+	}{
+		{
+			name:          "empty current password is replaced with the strong password",
+			serviceClient: &mockGeneratingClient{},
+		},
+		{
+			name:            "placeholder current password is replaced with the strong password",
+			currentPassword: "changeme",
+			serviceClient:   &mockGeneratingClient{},
+		},
+		{
+			name:            "placeholder current password is not carried forward",
+			currentPassword: "changeme",
+			serviceClient:   &mockNoopClient{},
+			wantErr:         ErrPlaceholderPassword,
+		},
+		{
+			name:            "placeholder is not staged in any case",
+			currentPassword: placeholderPassword,
+			serviceClient:   &mockPasswordsClient{passwords: []string{"PlaceHolder"}},
+			wantErr:         ErrPlaceholderPassword,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				current := secretWithPassword(tt.currentPassword)
+				client := &mockSecretsmanagerClient{
+					secretAWSCurrent: current,
+					secretByID: map[string]map[string]string{
+						"foo": {StageCurrent: current},
+					},
+				}
+
+				err := createSecret(
+					context.TODO(), SecretsmanagerTriggerPayload{
+						SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+						Token:     "bar",
+						Step:      "createSecret",
+					}, Config{
+						SecretsmanagerClient: client,
+						ServiceClient:        tt.serviceClient,
+						SecretObj:            &mockObj{},
+						Metrics:              NoopMetrics{},
+						// the secret with the carried forward password is identical to the current secret
+						AllowUnchangedSecret: true,
+					},
+				)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("createSecret() error = %v, want %v", err, tt.wantErr)
+				}
+
+				staged, ok := client.secretByID["bar"][StagePending]
+				if tt.wantErr != nil {
+					if ok {
+						t.Errorf("createSecret() staged the placeholder password")
+					}
+					return
+				}
+
+				password := secretAttribute(staged, defaultPasswordField)
+				if len(password) != defaultPasswordLength || password == tt.currentPassword ||
+					isPlaceholderPassword(password, nil) {
+					t.Errorf("createSecret() staged the password %q, want the strong password", password)
+				}
+			},
+		)
+	}
+}
+
+// mockTransientPutClient fails the first puts with the error, and records the put secrets.
+type mockTransientPutClient struct {
+	*mockSecretsmanagerClient
+	err      error
+	failures int
+	puts     []string
+}
+
+func (m *mockTransientPutClient) PutSecretValue(
+	ctx context.Context, input *secretsmanager.PutSecretValueInput, optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.PutSecretValueOutput, error) {
+	m.puts = append(m.puts, aws.ToString(input.SecretString))
+	if m.failures > 0 {
+		m.failures--
+		return nil, m.err
+	}
+	return m.mockSecretsmanagerClient.PutSecretValue(ctx, input, optFns...)
+}
+
+func Test_createSecret_putRetriedWithSameSecret(t *testing.T) {
+	defer func(v time.Duration) { putRetryInterval = v }(putRetryInterval)
+	putRetryInterval = time.Millisecond
+
+	tests := []struct {
+		name      string
+		err       error
+		failures  int
+		wantPuts  int
+		wantError bool
+	}{
+		{
+			name:     "transient failure, retried with the same secret",
+			err:      &mockThrottlingError{},
+			failures: 1,
+			wantPuts: 2,
+		},
+		{
+			name:      "transient failures exhaust the attempts",
+			err:       &mockThrottlingError{},
+			failures:  defaultMaxPutAttempts,
+			wantPuts:  defaultMaxPutAttempts,
+			wantError: true,
+		},
+		{
+			name:      "non-transient failure is not retried",
+			err:       errors.New("access denied"),
+			failures:  1,
+			wantPuts:  1,
+			wantError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				mock := &mockSecretsmanagerClient{
+					secretAWSCurrent: placeholderSecretUserStr,
+					secretByID: map[string]map[string]string{
+						"foo": {StageCurrent: placeholderSecretUserStr},
+					},
+				}
+				client := &mockTransientPutClient{mockSecretsmanagerClient: mock, err: tt.err, failures: tt.failures}
+				serviceClient := &mockGeneratingClient{}
+
+				err := createSecret(
+					context.TODO(), SecretsmanagerTriggerPayload{
+						SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+						Token:     "bar",
+						Step:      "createSecret",
+					}, Config{
+						SecretsmanagerClient: client,
+						ServiceClient:        serviceClient,
+						SecretObj:            &mockObj{},
+						Metrics:              NoopMetrics{},
+					},
+				)
+				if (err != nil) != tt.wantError {
+					t.Fatalf("createSecret() error = %v, wantErr %v", err, tt.wantError)
+				}
+
+				if len(client.puts) != tt.wantPuts {
+					t.Fatalf("createSecret() put %d times, want %d", len(client.puts), tt.wantPuts)
+				}
+				for _, put := range client.puts[1:] {
+					if put != client.puts[0] {
+						t.Errorf("createSecret() put the regenerated secret, want the same secret")
+					}
+				}
+
+				staged := mock.secretByID["bar"][StagePending]
+				if tt.wantError == (staged != "") {
+					t.Errorf("createSecret() staged = %v, want %v", staged != "", !tt.wantError)
+				}
+				if !tt.wantError && staged != client.puts[0] {
+					t.Errorf("createSecret() staged = %v, want %v", staged, client.puts[0])
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,223 @@
+package lambda
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// metricsNamespace the CloudWatch namespace of the lambda's metrics.
+	metricsNamespace = "SecretRotation"
+
+	// metricIdempotentSkip the counter of the steps which completed without doing any work
+	// because the rotation state had already been reached, e.g. AWSPENDING version already exists.
+	metricIdempotentSkip = "IdempotentSkip"
+
+	// metricStepSuccess the counter of the steps completed successfully.
+	metricStepSuccess = "StepSuccess"
+
+	// metricStepFailure the counter of the failed steps.
+	metricStepFailure = "StepFailure"
+
+	// metricEntropySourceFailure the counter of the failures of the password generator's source of randomness,
+	// it's meant to trigger the alarm.
+	metricEntropySourceFailure = "EntropySourceFailure"
+
+	// metricNoOpRotation the counter of the rotations which left the identical secrets at the stages AWSCURRENT
+	// and AWSPREVIOUS, it's meant to trigger the alarm.
+	metricNoOpRotation = "NoOpRotation"
+
+	// metricStepDuration the step's execution duration.
+	metricStepDuration = "StepDuration"
+
+	// propertyDurationBuckets the EMF property with the upper bounds of the duration buckets in milliseconds.
+	propertyDurationBuckets = "DurationBuckets"
+
+	// propertyDurationBucket the EMF property with the upper bound of the bucket the duration lands in.
+	propertyDurationBucket = "DurationBucket"
+
+	// bucketInf the bucket of the durations above the highest upper bound.
+	bucketInf = "+Inf"
+)
+
+// Metrics defines the interface to record the lambda's metrics, e.g. to CloudWatch, Prometheus, or Datadog.
+type Metrics interface {
+	// IncCounter increments the counter by one.
+	IncCounter(name string, dimensions map[string]string)
+
+	// ObserveDuration records the duration.
+	ObserveDuration(name string, d time.Duration, dimensions map[string]string)
+}
+
+// NoopMetrics discards the metrics.
+type NoopMetrics struct{}
+
+// IncCounter does nothing.
+func (NoopMetrics) IncCounter(string, map[string]string) {}
+
+// ObserveDuration does nothing.
+func (NoopMetrics) ObserveDuration(string, time.Duration, map[string]string) {}
+
+// NewEMFMetrics initialises Metrics which writes the metrics to w in the CloudWatch Embedded Metric Format.
+// The durations are labeled with the upper bound of the bucket they land in if the buckets' upper bounds are set,
+// e.g. to track the SLO.
+// See: https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch_Embedded_Metric_Format_Specification.html
+func NewEMFMetrics(w io.Writer, buckets ...time.Duration) Metrics {
+	o := &emfMetrics{w: w, buckets: append([]time.Duration(nil), buckets...)}
+	sort.Slice(o.buckets, func(i, j int) bool { return o.buckets[i] < o.buckets[j] })
+	return o
+}
+
+var defaultMetrics = NewEMFMetrics(os.Stdout)
+
+// metrics returns the configured Metrics, or the default EMF Metrics.
+func (cfg Config) metrics() Metrics {
+	if cfg.Metrics == nil {
+		return defaultMetrics
+	}
+	return cfg.Metrics
+}
+
+// dimensionEngine the metrics' dimension with the database engine.
+const dimensionEngine = "Engine"
+
+// engineMetrics sets the dimension Engine to all recorded metrics, e.g. to slice the dashboards by engine
+// for the fleets mixing the databases.
+type engineMetrics struct {
+	Metrics
+	engine string
+}
+
+func (m engineMetrics) IncCounter(name string, dimensions map[string]string) {
+	m.Metrics.IncCounter(name, m.withEngine(dimensions))
+}
+
+func (m engineMetrics) ObserveDuration(name string, d time.Duration, dimensions map[string]string) {
+	m.Metrics.ObserveDuration(name, d, m.withEngine(dimensions))
+}
+
+func (m engineMetrics) withEngine(dimensions map[string]string) map[string]string {
+	o := make(map[string]string, len(dimensions)+1)
+	for k, v := range dimensions {
+		o[k] = v
+	}
+	o[dimensionEngine] = m.engine
+	return o
+}
+
+type emfMetrics struct {
+	mu      sync.Mutex
+	w       io.Writer
+	buckets []time.Duration
+}
+
+type emfMetricDefinition struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+type emfDirective struct {
+	Namespace  string                `json:"Namespace"`
+	Dimensions [][]string            `json:"Dimensions"`
+	Metrics    []emfMetricDefinition `json:"Metrics"`
+}
+
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+// IncCounter writes the counter metric.
+func (m *emfMetrics) IncCounter(name string, dimensions map[string]string) {
+	m.emit(name, "Count", 1, dimensions, nil)
+}
+
+// ObserveDuration writes the duration metric in milliseconds.
+func (m *emfMetrics) ObserveDuration(name string, d time.Duration, dimensions map[string]string) {
+	var properties map[string]any
+	if len(m.buckets) > 0 {
+		bounds := make([]float64, len(m.buckets))
+		for i, b := range m.buckets {
+			bounds[i] = milliseconds(b)
+		}
+		properties = map[string]any{
+			propertyDurationBuckets: bounds,
+			propertyDurationBucket:  m.bucket(d),
+		}
+	}
+	m.emit(name, "Milliseconds", milliseconds(d), dimensions, properties)
+}
+
+// bucket returns the upper bound in milliseconds of the smallest bucket the duration lands in.
+func (m *emfMetrics) bucket(d time.Duration) string {
+	for _, b := range m.buckets {
+		if d <= b {
+			return strconv.FormatFloat(milliseconds(b), 'f', -1, 64)
+		}
+	}
+	return bucketInf
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1e3
+}
+
+func (m *emfMetrics) emit(
+	name, unit string, value float64, dimensions map[string]string, properties map[string]any,
+) {
+	dimensionKeys := make([]string, 0, len(dimensions))
+	o := make(map[string]any, len(dimensions)+len(properties)+2)
+	for k, v := range properties {
+		o[k] = v
+	}
+	for k, v := range dimensions {
+		dimensionKeys = append(dimensionKeys, k)
+		o[k] = v
+	}
+	o[name] = value
+	o["_aws"] = emfMetadata{
+		Timestamp: time.Now().UnixMilli(),
+		CloudWatchMetrics: []emfDirective{
+			{
+				Namespace:  metricsNamespace,
+				Dimensions: [][]string{dimensionKeys},
+				Metrics:    []emfMetricDefinition{{Name: name, Unit: unit}},
+			},
+		},
+	}
+
+	b, err := json.Marshal(o)
+	if err != nil {
+		log.Println("[ERROR] failed to serialise metric " + name + ": " + err.Error())
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.w.Write(append(b, '\n')); err != nil {
+		log.Println("[ERROR] failed to emit metric " + name + ": " + err.Error())
+	}
+}
+
+// recordStepMetrics records the step's outcome and duration.
+func recordStepMetrics(m Metrics, step string, d time.Duration, err error) {
+	dimensions := map[string]string{"Step": step}
+	if err != nil {
+		m.IncCounter(metricStepFailure, dimensions)
+	} else {
+		m.IncCounter(metricStepSuccess, dimensions)
+	}
+	m.ObserveDuration(metricStepDuration, d, dimensions)
+}
+
+// logIdempotentSkip reports the step which returned without doing any work.
+func logIdempotentSkip(m Metrics, step, reason string) {
+	log.Println("[INFO] " + step + " skipped: " + reason)
+	m.IncCounter(metricIdempotentSkip, map[string]string{"Step": step})
+}
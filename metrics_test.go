@@ -0,0 +1,264 @@
+package lambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+type recordedMetric struct {
+	name       string
+	dimensions map[string]string
+}
+
+// mockMetrics records the names and dimensions of the metrics.
+type mockMetrics struct {
+	mu        sync.Mutex
+	counters  []recordedMetric
+	durations []recordedMetric
+}
+
+func (m *mockMetrics) IncCounter(name string, dimensions map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counters = append(m.counters, recordedMetric{name: name, dimensions: dimensions})
+}
+
+func (m *mockMetrics) ObserveDuration(name string, _ time.Duration, dimensions map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.durations = append(m.durations, recordedMetric{name: name, dimensions: dimensions})
+}
+
+func (m *mockMetrics) countCounter(name string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var o int
+	for _, c := range m.counters {
+		if c.name == name {
+			o++
+		}
+	}
+	return o
+}
+
+func Test_emfMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewEMFMetrics(&buf)
+
+	m.IncCounter(metricIdempotentSkip, map[string]string{"Step": "finishSecret"})
+	m.ObserveDuration(metricStepDuration, 1500*time.Microsecond, map[string]string{"Step": "finishSecret"})
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("emfMetrics emitted %d lines, want 2", len(lines))
+	}
+
+	for i, want := range []struct {
+		name  string
+		unit  string
+		value float64
+	}{
+		{name: metricIdempotentSkip, unit: "Count", value: 1},
+		{name: metricStepDuration, unit: "Milliseconds", value: 1.5},
+	} {
+		var got map[string]any
+		if err := json.Unmarshal(lines[i], &got); err != nil {
+			t.Fatalf("emfMetrics emitted faulty JSON: %v", err)
+		}
+
+		if got["Step"] != "finishSecret" || got[want.name] != want.value {
+			t.Errorf("emfMetrics emitted unexpected metric values: %v", got)
+		}
+
+		directive := got["_aws"].(map[string]any)["CloudWatchMetrics"].([]any)[0].(map[string]any)
+		if directive["Namespace"] != metricsNamespace {
+			t.Errorf("emfMetrics emitted unexpected namespace: %v", directive["Namespace"])
+		}
+		if !reflect.DeepEqual(directive["Dimensions"], []any{[]any{"Step"}}) {
+			t.Errorf("emfMetrics emitted unexpected dimensions: %v", directive["Dimensions"])
+		}
+		metric := directive["Metrics"].([]any)[0].(map[string]any)
+		if metric["Name"] != want.name || metric["Unit"] != want.unit {
+			t.Errorf("emfMetrics emitted unexpected metric definition: %v", metric)
+		}
+	}
+}
+
+func Test_emfMetrics_durationBuckets(t *testing.T) {
+	tests := []struct {
+		name       string
+		d          time.Duration
+		wantBucket string
+	}{
+		{
+			name:       "duration lands in the bucket",
+			d:          300 * time.Millisecond,
+			wantBucket: "500",
+		},
+		{
+			name:       "duration equals the upper bound",
+			d:          100 * time.Millisecond,
+			wantBucket: "100",
+		},
+		{
+			name:       "duration above the highest upper bound",
+			d:          2 * time.Second,
+			wantBucket: bucketInf,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				var buf bytes.Buffer
+				m := NewEMFMetrics(&buf, time.Second, 100*time.Millisecond, 500*time.Millisecond)
+				m.ObserveDuration(metricStepDuration, tt.d, map[string]string{"Step": "testSecret"})
+
+				var got map[string]any
+				if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+					t.Fatalf("emfMetrics emitted faulty JSON: %v", err)
+				}
+
+				if want := []any{100., 500., 1000.}; !reflect.DeepEqual(got[propertyDurationBuckets], want) {
+					t.Errorf("emfMetrics emitted buckets %v, want %v", got[propertyDurationBuckets], want)
+				}
+				if got[propertyDurationBucket] != tt.wantBucket {
+					t.Errorf("emfMetrics emitted bucket %v, want %v", got[propertyDurationBucket], tt.wantBucket)
+				}
+			},
+		)
+	}
+}
+
+func Test_emfMetrics_noDurationBuckets(t *testing.T) {
+	var buf bytes.Buffer
+	NewEMFMetrics(&buf).ObserveDuration(metricStepDuration, time.Second, map[string]string{"Step": "testSecret"})
+
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("emfMetrics emitted faulty JSON: %v", err)
+	}
+	if _, ok := got[propertyDurationBucket]; ok {
+		t.Errorf("emfMetrics shall not emit the bucket if no buckets are configured")
+	}
+}
+
+func TestNewHandler_metrics(t *testing.T) {
+	steps := []string{"createSecret", "setSecret", "testSecret", "finishSecret", "foobar"}
+	for _, step := range steps {
+		t.Run(
+			step, func(t *testing.T) {
+				m := &mockMetrics{}
+				handler, err := NewHandler(
+					Config{
+						SecretsmanagerClient: &mockSecretsmanagerClient{
+							secretAWSCurrent: placeholderSecretUserStr,
+							secretByID: map[string]map[string]string{
+								"foo": {
+									"AWSCURRENT": placeholderSecretUserStr,
+								},
+								"bar": {
+									"AWSPENDING": placeholderSecretUserNewStr,
+								},
+							},
+							rotationEnabled: aws.Bool(true),
+						},
+						ServiceClient: &mockDBClient{},
+						SecretObj:     &mockObj{},
+						Metrics:       m,
+					},
+				)
+				if err != nil {
+					t.Fatalf("NewHandler() unexpected error = %v", err)
+				}
+
+				err = handler(
+					context.TODO(), SecretsmanagerTriggerPayload{
+						SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+						Token:     "bar",
+						Step:      step,
+					},
+				)
+
+				wantCounter := metricStepSuccess
+				if err != nil {
+					wantCounter = metricStepFailure
+				}
+				wantCounters := []recordedMetric{{name: wantCounter, dimensions: map[string]string{"Step": step}}}
+				if step == "createSecret" {
+					// AWSPENDING exists for the version
+					wantCounters = append(
+						[]recordedMetric{{name: metricIdempotentSkip, dimensions: map[string]string{"Step": step}}},
+						wantCounters...,
+					)
+				}
+
+				if !reflect.DeepEqual(m.counters, wantCounters) {
+					t.Errorf("handler(ctx, event) counters = %v, want %v", m.counters, wantCounters)
+				}
+
+				wantDurations := []recordedMetric{
+					{name: metricStepDuration, dimensions: map[string]string{"Step": step}},
+				}
+				if !reflect.DeepEqual(m.durations, wantDurations) {
+					t.Errorf("handler(ctx, event) durations = %v, want %v", m.durations, wantDurations)
+				}
+
+				if (err != nil) != (step == "foobar") {
+					t.Errorf("handler(ctx, event) unexpected error = %v", err)
+				}
+			},
+		)
+	}
+}
+
+func TestNewHandler_Engine(t *testing.T) {
+	m := &mockMetrics{}
+	handler, err := NewHandler(
+		Config{
+			SecretsmanagerClient: &mockSecretsmanagerClient{
+				secretAWSCurrent: placeholderSecretUserStr,
+				secretByID: map[string]map[string]string{
+					"foo": {"AWSCURRENT": placeholderSecretUserStr},
+					"bar": {"AWSPENDING": placeholderSecretUserNewStr},
+				},
+				rotationEnabled: aws.Bool(true),
+			},
+			ServiceClient: &mockDBClient{},
+			SecretObj:     &mockObj{},
+			Metrics:       m,
+			Engine:        "neon-postgres",
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewHandler() unexpected error = %v", err)
+	}
+
+	if err := handler(
+		context.TODO(), SecretsmanagerTriggerPayload{
+			SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			Token:     "bar",
+			Step:      "testSecret",
+		},
+	); err != nil {
+		t.Fatalf("handler(ctx, event) unexpected error = %v", err)
+	}
+
+	dimensions := map[string]string{"Step": "testSecret", dimensionEngine: "neon-postgres"}
+
+	wantCounters := []recordedMetric{{name: metricStepSuccess, dimensions: dimensions}}
+	if !reflect.DeepEqual(m.counters, wantCounters) {
+		t.Errorf("handler(ctx, event) counters = %v, want %v", m.counters, wantCounters)
+	}
+
+	wantDurations := []recordedMetric{{name: metricStepDuration, dimensions: dimensions}}
+	if !reflect.DeepEqual(m.durations, wantDurations) {
+		t.Errorf("handler(ctx, event) durations = %v, want %v", m.durations, wantDurations)
+	}
+}
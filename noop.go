@@ -0,0 +1,70 @@
+package lambda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	smithyHttp "github.com/aws/smithy-go/transport/http"
+)
+
+// ErrNoOpRotation the secret at the stage AWSPREVIOUS is identical to the secret at the stage AWSCURRENT
+// after finishSecret, i.e. no real rotation happened.
+var ErrNoOpRotation = errors.New("no-op rotation")
+
+// detectNoOpRotation compares the passwords of the versions at the stages AWSCURRENT and AWSPREVIOUS,
+// the secrets are compared as a whole if they have no password's attribute. The check is skipped if the secret
+// has no version at the stage AWSPREVIOUS, e.g. after the first rotation.
+func detectNoOpRotation(ctx context.Context, event SecretsmanagerTriggerPayload, cfg Config) error {
+	previous, err := getSecretValue(ctx, cfg.SecretsmanagerClient, event.SecretARN, StagePrevious, "")
+	if err != nil {
+		if isSecretNotFound(err) {
+			if cfg.Debug {
+				log.Println("[DEBUG] no AWSPREVIOUS of the secret " + event.SecretARN + ", skip the no-op check")
+			}
+			return nil
+		}
+		return fmt.Errorf("get AWSPREVIOUS of the secret %s: %w", event.SecretARN, err)
+	}
+
+	current, err := getSecretValue(ctx, cfg.SecretsmanagerClient, event.SecretARN, StageCurrent, "")
+	if err != nil {
+		return fmt.Errorf("get AWSCURRENT of the secret %s: %w", event.SecretARN, err)
+	}
+
+	currentSecret, previousSecret := aws.ToString(current.SecretString), aws.ToString(previous.SecretString)
+	currentPassword := secretAttribute(currentSecret, cfg.passwordField())
+	previousPassword := secretAttribute(previousSecret, cfg.passwordField())
+	if currentPassword == "" && previousPassword == "" {
+		currentPassword, previousPassword = currentSecret, previousSecret
+	}
+
+	if currentPassword != previousPassword {
+		return nil
+	}
+
+	log.Println("[ERROR] AWSPREVIOUS is identical to AWSCURRENT of the secret " + event.SecretARN)
+	cfg.metrics().IncCounter(metricNoOpRotation, map[string]string{"Step": "finishSecret"})
+	return fmt.Errorf(
+		"%w: the password at the stage AWSPREVIOUS is identical to AWSCURRENT of the secret %s",
+		ErrNoOpRotation, event.SecretARN,
+	)
+}
+
+// isSecretNotFound checks if the error reports that the secret's version is not found.
+func isSecretNotFound(err error) bool {
+	var notFound *types.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		return true
+	}
+
+	var e *smithyHttp.ResponseError
+	if errors.As(err, &e) {
+		return e.HTTPStatusCode() == http.StatusBadRequest || e.HTTPStatusCode() == http.StatusNotFound
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package lambda
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func Test_finishSecret_DetectNoOpRotation(t *testing.T) {
+	tests := []struct {
+		name           string
+		secretPending  string
+		secretPrevious string
+		wantErr        error
+	}{
+		{
+			name:           "happy path: rotated",
+			secretPending:  placeholderSecretUserNewStr,
+			secretPrevious: placeholderSecretUserStr,
+		},
+		{
+			name:          "happy path: no previous version",
+			secretPending: placeholderSecretUserNewStr,
+		},
+		{
+			name:           "unhappy path: previous is identical to current",
+			secretPending:  placeholderSecretUserStr,
+			secretPrevious: placeholderSecretUserStr,
+			wantErr:        ErrNoOpRotation,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				client := &mockSecretsmanagerClient{
+					secretAWSCurrent:  placeholderSecretUserStr,
+					secretAWSPrevious: tt.secretPrevious,
+					secretByID: map[string]map[string]string{
+						"foo": {"AWSCURRENT": placeholderSecretUserStr},
+						"bar": {"AWSPENDING": tt.secretPending},
+					},
+				}
+				metrics := &mockMetrics{}
+
+				err := finishSecret(
+					context.TODO(), SecretsmanagerTriggerPayload{
+						SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+						Token:     "bar",
+						Step:      "finishSecret",
+					}, Config{
+						SecretsmanagerClient: client,
+						ServiceClient:        &mockDBClient{},
+						SecretObj:            &mockObj{},
+						DetectNoOpRotation:   true,
+						Metrics:              metrics,
+					},
+				)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("finishSecret() error = %v, want %v", err, tt.wantErr)
+				}
+
+				wantAlerts := 0
+				if tt.wantErr != nil {
+					wantAlerts = 1
+				}
+				if got := metrics.countCounter(metricNoOpRotation); got != wantAlerts {
+					t.Errorf("metric %s recorded %d times, want %d", metricNoOpRotation, got, wantAlerts)
+				}
+			},
+		)
+	}
+}
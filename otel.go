@@ -0,0 +1,108 @@
+package lambda
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName the name of the OpenTelemetry tracer and meter.
+const instrumentationName = "github.com/kislerdm/aws-lambda-secret-rotation"
+
+// startStepSpan starts the OpenTelemetry span of the rotation step. The span's attributes identify the secret
+// and the step only, the secret's value is never recorded. No span is started if the tracer provider is not set.
+func startStepSpan(
+	ctx context.Context, tp trace.TracerProvider, event SecretsmanagerTriggerPayload,
+) (context.Context, func(err error)) {
+	if tp == nil {
+		return ctx, func(error) {}
+	}
+
+	ctx, span := tp.Tracer(instrumentationName).Start(
+		ctx, event.Step,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("secretsmanager.secret_id", event.SecretARN),
+			attribute.String("secretsmanager.client_request_token", event.Token),
+			attribute.String("rotation.step", event.Step),
+		),
+	)
+
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		} else {
+			span.SetStatus(codes.Ok, "")
+		}
+		span.End()
+	}
+}
+
+// NewOTelMetrics initialises Metrics which records the metrics with the OpenTelemetry meter provider:
+// the counters as Int64Counter, and the durations in milliseconds as Float64Histogram.
+func NewOTelMetrics(mp metric.MeterProvider) Metrics {
+	return &otelMetrics{
+		meter:      mp.Meter(instrumentationName),
+		counters:   map[string]metric.Int64Counter{},
+		histograms: map[string]metric.Float64Histogram{},
+	}
+}
+
+type otelMetrics struct {
+	meter metric.Meter
+
+	mu         sync.Mutex
+	counters   map[string]metric.Int64Counter
+	histograms map[string]metric.Float64Histogram
+}
+
+func (m *otelMetrics) IncCounter(name string, dimensions map[string]string) {
+	m.mu.Lock()
+	counter, ok := m.counters[name]
+	if !ok {
+		var err error
+		if counter, err = m.meter.Int64Counter(name); err != nil {
+			m.mu.Unlock()
+			log.Println("[WARN] failed to create the counter " + name + ": " + err.Error())
+			return
+		}
+		m.counters[name] = counter
+	}
+	m.mu.Unlock()
+
+	counter.Add(context.Background(), 1, metric.WithAttributes(otelAttributes(dimensions)...))
+}
+
+func (m *otelMetrics) ObserveDuration(name string, d time.Duration, dimensions map[string]string) {
+	m.mu.Lock()
+	histogram, ok := m.histograms[name]
+	if !ok {
+		var err error
+		if histogram, err = m.meter.Float64Histogram(name, metric.WithUnit("ms")); err != nil {
+			m.mu.Unlock()
+			log.Println("[WARN] failed to create the histogram " + name + ": " + err.Error())
+			return
+		}
+		m.histograms[name] = histogram
+	}
+	m.mu.Unlock()
+
+	histogram.Record(context.Background(), milliseconds(d), metric.WithAttributes(otelAttributes(dimensions)...))
+}
+
+func otelAttributes(dimensions map[string]string) []attribute.KeyValue {
+	o := make([]attribute.KeyValue, 0, len(dimensions))
+	for k, v := range dimensions {
+		o = append(o, attribute.String(k, v))
+	}
+	sort.Slice(o, func(i, j int) bool { return o[i].Key < o[j].Key })
+	return o
+}
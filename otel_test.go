@@ -0,0 +1,126 @@
+package lambda
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestNewHandler_TracerProvider(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		secretByID: map[string]map[string]string{
+			"foo": {"AWSCURRENT": placeholderSecretUserStr},
+			"bar": {"AWSPENDING": placeholderSecretUserStr},
+		},
+		rotationEnabled: aws.Bool(true),
+		// the stages are not returned with the secret value, hence createSecret does not skip
+		emptyVersionStages: true,
+	}
+
+	h, err := NewHandler(
+		Config{
+			SecretsmanagerClient: client,
+			ServiceClient:        &mockDBClient{},
+			SecretObj:            &mockObj{},
+			Metrics:              NoopMetrics{},
+			TracerProvider:       tp,
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewHandler() unexpected error = %v", err)
+	}
+
+	steps := []string{"createSecret", "setSecret", "testSecret", "finishSecret"}
+	for _, step := range steps {
+		if err := h(
+			context.TODO(), SecretsmanagerTriggerPayload{
+				SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+				Token:     "bar",
+				Step:      step,
+			},
+		); err != nil {
+			t.Fatalf("handler() step %s unexpected error = %v", step, err)
+		}
+	}
+
+	var got []string
+	for _, span := range recorder.Ended() {
+		got = append(got, span.Name())
+
+		if span.Status().Code != codes.Ok {
+			t.Errorf("span %s status = %v, want %v", span.Name(), span.Status().Code, codes.Ok)
+		}
+
+		for _, attr := range span.Attributes() {
+			if v := attr.Value.Emit(); strings.Contains(v, placeholderPassword) {
+				t.Errorf("span %s attribute %s contains the secret's value", span.Name(), attr.Key)
+			}
+		}
+	}
+	if !reflect.DeepEqual(got, steps) {
+		t.Errorf("spans = %v, want %v", got, steps)
+	}
+}
+
+func TestNewHandler_MeterProvider(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	h, err := NewHandler(
+		Config{
+			SecretsmanagerClient: &mockSecretsmanagerClient{
+				secretAWSCurrent: placeholderSecretUserStr,
+				secretByID: map[string]map[string]string{
+					"foo": {"AWSCURRENT": placeholderSecretUserStr},
+					"bar": {"AWSPENDING": placeholderSecretUserNewStr},
+				},
+				rotationEnabled: aws.Bool(true),
+			},
+			ServiceClient: &mockDBClient{},
+			SecretObj:     &mockObj{},
+			MeterProvider: mp,
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewHandler() unexpected error = %v", err)
+	}
+
+	if err := h(
+		context.TODO(), SecretsmanagerTriggerPayload{
+			SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			Token:     "bar",
+			Step:      "testSecret",
+		},
+	); err != nil {
+		t.Fatalf("handler() unexpected error = %v", err)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.TODO(), &rm); err != nil {
+		t.Fatalf("Collect() unexpected error = %v", err)
+	}
+
+	got := map[string]bool{}
+	for _, scope := range rm.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			got[m.Name] = true
+		}
+	}
+	for _, name := range []string{metricStepSuccess, metricStepDuration} {
+		if !got[name] {
+			t.Errorf("metric %s is not recorded", name)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package lambda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// ErrNotRotationOwner the secret is configured to be rotated by the lambda function other than the invoked one.
+var ErrNotRotationOwner = errors.New("secret is rotated by another lambda function")
+
+// checkRotationOwnership checks that the secret's rotation lambda is the invoked lambda function.
+// The function's alias, or version is ignored in comparison.
+func checkRotationOwnership(ctx context.Context, client SecretsmanagerClient, secretARN string) error {
+	lc, ok := lambdacontext.FromContext(ctx)
+	if !ok || lc.InvokedFunctionArn == "" {
+		return errors.New("no lambda context found to identify the invoked function")
+	}
+
+	v, err := client.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(secretARN)})
+	if err != nil {
+		return fmt.Errorf("describe secret %s: %w", secretARN, err)
+	}
+
+	if rotator := aws.ToString(v.RotationLambdaARN); unqualifiedFunctionARN(rotator) !=
+		unqualifiedFunctionARN(lc.InvokedFunctionArn) {
+		return fmt.Errorf(
+			"%w: secret %s is configured with the rotation lambda %q, the invoked function is %s",
+			ErrNotRotationOwner, secretARN, rotator, lc.InvokedFunctionArn,
+		)
+	}
+
+	return nil
+}
+
+// unqualifiedFunctionARN strips the alias, or version from the lambda function's ARN,
+// e.g. arn:aws:lambda:us-east-1:000000000000:function:foo:live.
+func unqualifiedFunctionARN(arn string) string {
+	parts := strings.SplitN(arn, ":", 8)
+	if len(parts) == 8 {
+		return strings.Join(parts[:7], ":")
+	}
+	return arn
+}
@@ -0,0 +1,96 @@
+package lambda
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestNewHandler_VerifyRotationOwnership(t *testing.T) {
+	const functionARN = "arn:aws:lambda:us-east-1:000000000000:function:foo"
+
+	tests := []struct {
+		name              string
+		invokedARN        string
+		rotationLambdaARN *string
+		wantErr           error
+		wantAnyErr        bool
+	}{
+		{
+			name:              "happy path: the secret is rotated by the invoked function",
+			invokedARN:        functionARN,
+			rotationLambdaARN: aws.String(functionARN),
+		},
+		{
+			name:              "happy path: the function invoked by the alias",
+			invokedARN:        functionARN + ":live",
+			rotationLambdaARN: aws.String(functionARN),
+		},
+		{
+			name:              "unhappy path: the secret is rotated by another function",
+			invokedARN:        functionARN,
+			rotationLambdaARN: aws.String("arn:aws:lambda:us-east-1:000000000000:function:bar"),
+			wantErr:           ErrNotRotationOwner,
+		},
+		{
+			name:       "unhappy path: the secret has no rotation lambda",
+			invokedARN: functionARN,
+			wantErr:    ErrNotRotationOwner,
+		},
+		{
+			name:              "unhappy path: no lambda context",
+			rotationLambdaARN: aws.String(functionARN),
+			wantAnyErr:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				h, err := NewHandler(
+					Config{
+						SecretsmanagerClient: &mockSecretsmanagerClient{
+							secretAWSCurrent: placeholderSecretUserStr,
+							secretByID: map[string]map[string]string{
+								"foo": {"AWSCURRENT": placeholderSecretUserStr},
+								"bar": {"AWSPENDING": placeholderSecretUserNewStr},
+							},
+							rotationEnabled:   aws.Bool(true),
+							rotationLambdaARN: tt.rotationLambdaARN,
+						},
+						ServiceClient:           &mockDBClient{},
+						SecretObj:               &mockObj{},
+						Metrics:                 NoopMetrics{},
+						VerifyRotationOwnership: true,
+					},
+				)
+				if err != nil {
+					t.Fatalf("NewHandler() unexpected error = %v", err)
+				}
+
+				ctx := context.TODO()
+				if tt.invokedARN != "" {
+					ctx = lambdacontext.NewContext(ctx, &lambdacontext.LambdaContext{InvokedFunctionArn: tt.invokedARN})
+				}
+
+				err = h(
+					ctx, SecretsmanagerTriggerPayload{
+						SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+						Token:     "bar",
+						Step:      "testSecret",
+					},
+				)
+				switch {
+				case tt.wantAnyErr:
+					if err == nil {
+						t.Errorf("handler() expected error")
+					}
+				case !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil):
+					t.Errorf("handler() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			},
+		)
+	}
+}
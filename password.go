@@ -0,0 +1,215 @@
+package lambda
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+const (
+	defaultPasswordLength  = 32
+	defaultPasswordCharset = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+// ErrEntropySource the source of randomness failed. The error is fatal: the password is never generated
+// from a weaker source.
+var ErrEntropySource = errors.New("entropy source failure")
+
+// PasswordGenerator generates random passwords to be used by the ServiceClient implementations.
+type PasswordGenerator struct {
+	// RandSource the source of randomness, crypto/rand.Reader is used by default.
+	// WARNING: a deterministic source must never be used in production,
+	// it is meant for tests only and requires AllowInsecureRandSource set to `true`.
+	RandSource io.Reader
+
+	// AllowInsecureRandSource set to `true` to permit RandSource other than crypto/rand.Reader.
+	AllowInsecureRandSource bool
+
+	// Length the password's length, 32 characters are generated by default.
+	Length int
+
+	// Charset the characters to compose the password, alphanumeric characters are used by default.
+	Charset string
+
+	// PolicyProvider (optional) provides the password policy at runtime which overrides Length and Charset.
+	PolicyProvider PolicyProvider
+}
+
+// PasswordPolicy defines the requirements to the generated passwords, the zero attributes are not enforced.
+type PasswordPolicy struct {
+	// Length the password's length.
+	Length int
+
+	// Charset the characters to compose the password.
+	Charset string
+}
+
+// PolicyProvider provides the password policy at runtime, e.g. from the centralised policy service.
+type PolicyProvider interface {
+	PasswordPolicy(ctx context.Context) (PasswordPolicy, error)
+}
+
+// ErrPasswordPolicyViolation the generated password violates the TargetPasswordPolicy.
+var ErrPasswordPolicyViolation = errors.New("password policy violation")
+
+// TargetPasswordPolicy defines the password rules enforced by the target database, e.g. by the Postgres extension
+// passwordcheck. The generated password is checked client-side to avoid staging the password which the database
+// rejects when the secret is set. The zero attributes are not enforced.
+type TargetPasswordPolicy struct {
+	// MinLength the minimum password's length.
+	MinLength int
+
+	// MaxLength the maximum password's length.
+	MaxLength int
+
+	// MinUppercase the minimum number of the uppercase letters.
+	MinUppercase int
+
+	// MinLowercase the minimum number of the lowercase letters.
+	MinLowercase int
+
+	// MinDigits the minimum number of the digits.
+	MinDigits int
+
+	// MinSpecial the minimum number of the characters other than letters and digits.
+	MinSpecial int
+
+	// DisallowedCharacters the characters which must not be present in the password.
+	DisallowedCharacters string
+}
+
+// Check returns ErrPasswordPolicyViolation if the password does not satisfy the policy.
+func (p TargetPasswordPolicy) Check(password string) error {
+	length := len([]rune(password))
+	if p.MinLength > 0 && length < p.MinLength {
+		return fmt.Errorf(
+			"%w: generated password is shorter than %d characters", ErrPasswordPolicyViolation, p.MinLength,
+		)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return fmt.Errorf(
+			"%w: generated password is longer than %d characters", ErrPasswordPolicyViolation, p.MaxLength,
+		)
+	}
+
+	if p.DisallowedCharacters != "" && strings.ContainsAny(password, p.DisallowedCharacters) {
+		return fmt.Errorf("%w: generated password contains disallowed characters", ErrPasswordPolicyViolation)
+	}
+
+	var upper, lower, digits, special int
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper++
+		case unicode.IsLower(r):
+			lower++
+		case unicode.IsDigit(r):
+			digits++
+		case !unicode.IsLetter(r):
+			special++
+		}
+	}
+
+	for _, c := range []struct {
+		name     string
+		got, min int
+	}{
+		{"uppercase letters", upper, p.MinUppercase},
+		{"lowercase letters", lower, p.MinLowercase},
+		{"digits", digits, p.MinDigits},
+		{"special characters", special, p.MinSpecial},
+	} {
+		if c.got < c.min {
+			return fmt.Errorf(
+				"%w: generated password contains less than %d %s", ErrPasswordPolicyViolation, c.min, c.name,
+			)
+		}
+	}
+
+	return nil
+}
+
+// Generate generates a new password.
+func (g PasswordGenerator) Generate() (string, error) {
+	return g.GenerateContext(context.Background())
+}
+
+type randSourceCtxKey struct{}
+
+// randSource the source of randomness set by Config.RandSource.
+type randSource struct {
+	src           io.Reader
+	allowInsecure bool
+}
+
+// withRandSource sets the source of randomness to the context, it's used by GenerateContext
+// unless PasswordGenerator.RandSource is set.
+func withRandSource(ctx context.Context, src io.Reader, allowInsecure bool) context.Context {
+	return context.WithValue(ctx, randSourceCtxKey{}, randSource{src: src, allowInsecure: allowInsecure})
+}
+
+// GenerateContext generates a new password which satisfies the policy of the PolicyProvider if set.
+// The source of randomness set by Config.RandSource is used unless RandSource is set.
+func (g PasswordGenerator) GenerateContext(ctx context.Context) (string, error) {
+	if g.PolicyProvider != nil {
+		policy, err := g.PolicyProvider.PasswordPolicy(ctx)
+		if err != nil {
+			return "", fmt.Errorf("fetch password policy: %w", err)
+		}
+		if policy.Length > 0 {
+			g.Length = policy.Length
+		}
+		if policy.Charset != "" {
+			g.Charset = policy.Charset
+		}
+	}
+
+	src := g.RandSource
+	if src == nil {
+		if v, ok := ctx.Value(randSourceCtxKey{}).(randSource); ok {
+			src, g.AllowInsecureRandSource = v.src, v.allowInsecure
+		}
+	}
+	switch {
+	case src == nil:
+		src = rand.Reader
+	case src != rand.Reader && !g.AllowInsecureRandSource:
+		return "", errors.New("RandSource other than crypto/rand.Reader requires AllowInsecureRandSource")
+	}
+
+	length := g.Length
+	if length <= 0 {
+		length = defaultPasswordLength
+	}
+
+	charset := g.Charset
+	if charset == "" {
+		charset = defaultPasswordCharset
+	}
+	if len(charset) > 256 {
+		return "", errors.New("charset must not exceed 256 characters")
+	}
+
+	// the bytes above the largest multiple of the charset's length are discarded to avoid modulo bias
+	maxByte := 256 - 256%len(charset)
+
+	o := make([]byte, 0, length)
+	buf := make([]byte, length)
+	for len(o) < length {
+		n := length - len(o)
+		if _, err := io.ReadFull(src, buf[:n]); err != nil {
+			return "", fmt.Errorf("%w: failed to read random bytes: %s", ErrEntropySource, err.Error())
+		}
+		for _, b := range buf[:n] {
+			if int(b) < maxByte {
+				o = append(o, charset[int(b)%len(charset)])
+			}
+		}
+	}
+
+	return string(o), nil
+}
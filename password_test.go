@@ -0,0 +1,346 @@
+package lambda
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPasswordGenerator_Generate(t *testing.T) {
+	tests := []struct {
+		name       string
+		generator  PasswordGenerator
+		want       string
+		wantLength int
+		wantErr    bool
+	}{
+		{
+			name: "happy path: deterministic source",
+			generator: PasswordGenerator{
+				RandSource:              bytes.NewReader([]byte{0, 1, 2, 255, 61, 62, 100}),
+				AllowInsecureRandSource: true,
+				Length:                  6,
+			},
+			want:       "ABC9Am",
+			wantLength: 6,
+		},
+		{
+			name: "happy path: custom charset",
+			generator: PasswordGenerator{
+				RandSource:              bytes.NewReader([]byte{0, 1, 2, 3}),
+				AllowInsecureRandSource: true,
+				Length:                  4,
+				Charset:                 "ab",
+			},
+			want:       "abab",
+			wantLength: 4,
+		},
+		{
+			name:       "happy path: defaults",
+			generator:  PasswordGenerator{},
+			wantLength: defaultPasswordLength,
+		},
+		{
+			name: "happy path: crypto/rand.Reader",
+			generator: PasswordGenerator{
+				RandSource: rand.Reader,
+				Length:     10,
+			},
+			wantLength: 10,
+		},
+		{
+			name: "unhappy path: deterministic source is not allowed",
+			generator: PasswordGenerator{
+				RandSource: bytes.NewReader([]byte{0, 1, 2}),
+				Length:     3,
+			},
+			wantErr: true,
+		},
+		{
+			name: "unhappy path: source exhausted",
+			generator: PasswordGenerator{
+				RandSource:              bytes.NewReader([]byte{0}),
+				AllowInsecureRandSource: true,
+				Length:                  3,
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := tt.generator.Generate()
+				if (err != nil) != tt.wantErr {
+					t.Errorf("Generate() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+				if tt.want != "" && got != tt.want {
+					t.Errorf("Generate() got = %v, want %v", got, tt.want)
+				}
+				if len(got) != tt.wantLength {
+					t.Errorf("Generate() got length = %v, want %v", len(got), tt.wantLength)
+				}
+			},
+		)
+	}
+}
+
+type mockPolicyProvider struct {
+	policy PasswordPolicy
+	err    error
+}
+
+func (m mockPolicyProvider) PasswordPolicy(context.Context) (PasswordPolicy, error) {
+	return m.policy, m.err
+}
+
+func TestPasswordGenerator_GenerateContext_PolicyProvider(t *testing.T) {
+	tests := []struct {
+		name        string
+		generator   PasswordGenerator
+		wantLength  int
+		wantCharset string
+		wantErr     bool
+	}{
+		{
+			name: "happy path: stricter policy overrides the static config",
+			generator: PasswordGenerator{
+				Length:         16,
+				PolicyProvider: mockPolicyProvider{policy: PasswordPolicy{Length: 64, Charset: "abc123"}},
+			},
+			wantLength:  64,
+			wantCharset: "abc123",
+		},
+		{
+			name: "happy path: empty policy keeps the static config",
+			generator: PasswordGenerator{
+				Length:         16,
+				Charset:        "xyz",
+				PolicyProvider: mockPolicyProvider{},
+			},
+			wantLength:  16,
+			wantCharset: "xyz",
+		},
+		{
+			name:        "happy path: no provider",
+			generator:   PasswordGenerator{Length: 16},
+			wantLength:  16,
+			wantCharset: defaultPasswordCharset,
+		},
+		{
+			name: "unhappy path: provider failed",
+			generator: PasswordGenerator{
+				PolicyProvider: mockPolicyProvider{err: errors.New("foo")},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := tt.generator.GenerateContext(context.TODO())
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("GenerateContext() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if len(got) != tt.wantLength {
+					t.Errorf("GenerateContext() length = %v, want %v", len(got), tt.wantLength)
+				}
+				if strings.Trim(got, tt.wantCharset) != "" {
+					t.Errorf("GenerateContext() got = %v, want the characters of %v", got, tt.wantCharset)
+				}
+			},
+		)
+	}
+}
+
+// failingReader fails every read, e.g. to simulate the failure of crypto/rand.
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("getrandom: resource temporarily unavailable")
+}
+
+func TestPasswordGenerator_Generate_EntropySourceFailure(t *testing.T) {
+	g := PasswordGenerator{RandSource: failingReader{}, AllowInsecureRandSource: true}
+
+	got, err := g.Generate()
+	if !errors.Is(err, ErrEntropySource) {
+		t.Errorf("Generate() error = %v, want %v", err, ErrEntropySource)
+	}
+	if got != "" {
+		t.Errorf("Generate() got = %v, want no password generated from a weaker source", got)
+	}
+}
+
+// mockGeneratorClient generates the password with the PasswordGenerator.
+type mockGeneratorClient struct {
+	mockDBClient
+	generator PasswordGenerator
+}
+
+func (m *mockGeneratorClient) Create(ctx context.Context, secret any) error {
+	password, err := m.generator.GenerateContext(ctx)
+	if err != nil {
+		return err
+	}
+	secret.(*mockObj).Password = password
+	return nil
+}
+
+func Test_createSecret_EntropySourceFailure(t *testing.T) {
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		secretByID: map[string]map[string]string{
+			"foo": {"AWSCURRENT": placeholderSecretUserStr},
+		},
+	}
+	metrics := &mockMetrics{}
+
+	err := createSecret(
+		context.TODO(), SecretsmanagerTriggerPayload{
+			SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			Token:     "bar",
+			Step:      "createSecret",
+		}, Config{
+			SecretsmanagerClient: client,
+			ServiceClient: &mockGeneratorClient{
+				generator: PasswordGenerator{RandSource: failingReader{}, AllowInsecureRandSource: true},
+			},
+			SecretObj: &mockObj{},
+			Metrics:   metrics,
+		},
+	)
+	if !errors.Is(err, ErrEntropySource) {
+		t.Fatalf("createSecret() error = %v, want %v", err, ErrEntropySource)
+	}
+	if _, staged := client.secretByID["bar"]; staged {
+		t.Errorf("createSecret() staged the secret despite the entropy source failure")
+	}
+	if got := metrics.countCounter(metricEntropySourceFailure); got != 1 {
+		t.Errorf("metric %s recorded %d times, want 1", metricEntropySourceFailure, got)
+	}
+}
+
+func TestTargetPasswordPolicy_Check(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   TargetPasswordPolicy
+		password string
+		wantErr  bool
+	}{
+		{
+			name:     "zero policy",
+			password: "a",
+		},
+		{
+			name:     "compliant",
+			policy:   TargetPasswordPolicy{MinLength: 8, MaxLength: 10, MinUppercase: 1, MinDigits: 2, MinSpecial: 1},
+			password: "Abcd12_ef",
+		},
+		{
+			name:     "too short",
+			policy:   TargetPasswordPolicy{MinLength: 8},
+			password: "Abcd12_",
+			wantErr:  true,
+		},
+		{
+			name:     "too long",
+			policy:   TargetPasswordPolicy{MaxLength: 4},
+			password: "Abcd12_",
+			wantErr:  true,
+		},
+		{
+			name:     "no digits",
+			policy:   TargetPasswordPolicy{MinDigits: 1},
+			password: "Abcdef",
+			wantErr:  true,
+		},
+		{
+			name:     "disallowed characters",
+			policy:   TargetPasswordPolicy{DisallowedCharacters: "'\"\\"},
+			password: "Abc'def",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				err := tt.policy.Check(tt.password)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("Check() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if err != nil && !errors.Is(err, ErrPasswordPolicyViolation) {
+					t.Errorf("Check() error = %v, want %v", err, ErrPasswordPolicyViolation)
+				}
+			},
+		)
+	}
+}
+
+func Test_createSecret_RandSource(t *testing.T) {
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		secretByID: map[string]map[string]string{
+			"foo": {"AWSCURRENT": placeholderSecretUserStr},
+		},
+	}
+
+	if err := createSecret(
+		context.TODO(), SecretsmanagerTriggerPayload{
+			SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			Token:     "bar",
+			Step:      "createSecret",
+		}, Config{
+			SecretsmanagerClient:    client,
+			ServiceClient:           &mockGeneratingClient{},
+			SecretObj:               &mockObj{},
+			RandSource:              bytes.NewReader(bytes.Repeat([]byte{0, 1, 255}, defaultPasswordLength)),
+			AllowInsecureRandSource: true,
+			Metrics:                 NoopMetrics{},
+		},
+	); err != nil {
+		t.Fatalf("createSecret() unexpected error = %v", err)
+	}
+
+	// the byte 255 is discarded to avoid modulo bias
+	want := strings.Repeat("AB", defaultPasswordLength/2)
+	if got := secretAttribute(client.secretByID["bar"]["AWSPENDING"], "password"); got != want {
+		t.Errorf("createSecret() staged the password %s, want %s", got, want)
+	}
+}
+
+func TestNewHandler_RandSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{
+			name: "crypto/rand.Reader",
+			cfg:  Config{SecretObj: &mockObj{}, RandSource: rand.Reader},
+		},
+		{
+			name: "deterministic source permitted explicitly",
+			cfg: Config{
+				SecretObj: &mockObj{}, RandSource: bytes.NewReader([]byte{0}), AllowInsecureRandSource: true,
+			},
+		},
+		{
+			name:    "unhappy path: deterministic source is not permitted",
+			cfg:     Config{SecretObj: &mockObj{}, RandSource: bytes.NewReader([]byte{0})},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if _, err := NewHandler(tt.cfg); (err != nil) != tt.wantErr {
+					t.Errorf("NewHandler() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			},
+		)
+	}
+}
@@ -69,6 +69,7 @@ func main() {
 			SecretsmanagerClient: clientSecretsManager,
 			ServiceClient:        client,
 			SecretObj:            &s,
+			PasswordField:        os.Getenv("ATTRIBUTE_SECRET"),
 			Debug:                secretRotation.StrToBool(os.Getenv("DEBUG")),
 		},
 	)
@@ -0,0 +1,85 @@
+package neon
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"os"
+	"sync"
+)
+
+// defaultCACertificates the CA certificates which sign Neon's TLS certificates, i.e. Let's Encrypt ISRG Root X1 and X2.
+// See: https://neon.tech/docs/connect/connect-securely
+//
+//go:generate sh -c "curl -sSfL 'https://letsencrypt.org/certs/{isrgrootx1,isrg-root-x2}.pem' > ca.pem"
+//go:embed ca.pem
+var defaultCACertificates []byte
+
+// DefaultCACertPool returns the pool of the embedded CA certificates which sign Neon's TLS certificates.
+func DefaultCACertPool() (*x509.CertPool, error) {
+	o := x509.NewCertPool()
+	if !o.AppendCertsFromPEM(defaultCACertificates) {
+		return nil, errors.New("failed to parse embedded CA certificates")
+	}
+	return o, nil
+}
+
+var (
+	defaultCAFileOnce sync.Once
+	defaultCAFilePath string
+	defaultCAFileErr  error
+)
+
+// defaultCAFile writes the embedded CA certificates to the temporary file once and returns its path,
+// because the pq driver reads the root certificates from the file.
+func defaultCAFile() (string, error) {
+	defaultCAFileOnce.Do(
+		func() {
+			f, err := os.CreateTemp("", "neon-ca-*.pem")
+			if err != nil {
+				defaultCAFileErr = errors.New("failed to store embedded CA certificates: " + err.Error())
+				return
+			}
+			defer func() { _ = f.Close() }()
+
+			if _, err := f.Write(defaultCACertificates); err != nil {
+				defaultCAFileErr = errors.New("failed to store embedded CA certificates: " + err.Error())
+				return
+			}
+			defaultCAFilePath = f.Name()
+		},
+	)
+	return defaultCAFilePath, defaultCAFileErr
+}
+
+// inlineCAFiles the paths of the files with the inline CA certificates by the certificates' SHA256 hash.
+var inlineCAFiles sync.Map
+
+// inlineCAFile writes the PEM encoded CA certificates to the temporary file once and returns its path,
+// because the pq driver reads the root certificates from the file.
+func inlineCAFile(certificates string) (string, error) {
+	if !x509.NewCertPool().AppendCertsFromPEM([]byte(certificates)) {
+		return "", errors.New("faulty sslrootcert: no PEM encoded certificates found")
+	}
+
+	hash := sha256.Sum256([]byte(certificates))
+	key := hex.EncodeToString(hash[:])
+	if path, ok := inlineCAFiles.Load(key); ok {
+		return path.(string), nil
+	}
+
+	f, err := os.CreateTemp("", "neon-ca-inline-*.pem")
+	if err != nil {
+		return "", errors.New("failed to store sslrootcert: " + err.Error())
+	}
+	defer func() { _ = f.Close() }()
+
+	if _, err := f.WriteString(certificates); err != nil {
+		return "", errors.New("failed to store sslrootcert: " + err.Error())
+	}
+
+	path, _ := inlineCAFiles.LoadOrStore(key, f.Name())
+	return path.(string), nil
+}
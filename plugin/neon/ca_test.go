@@ -0,0 +1,84 @@
+package neon
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDefaultCACertPool(t *testing.T) {
+	if _, err := DefaultCACertPool(); err != nil {
+		t.Fatalf("DefaultCACertPool() unexpected error = %v", err)
+	}
+
+	// the embedded certificates must be refreshed with `go generate` before they expire
+	rest := defaultCACertificates
+	var n int
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		n++
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatalf("faulty embedded CA certificate: %v", err)
+		}
+		if !cert.IsCA {
+			t.Errorf("embedded certificate %s is not CA", cert.Subject)
+		}
+		if cert.NotAfter.Before(time.Now().AddDate(1, 0, 0)) {
+			t.Errorf("embedded CA certificate %s expires on %s, refresh it", cert.Subject, cert.NotAfter)
+		}
+	}
+
+	if n == 0 {
+		t.Errorf("no embedded CA certificates found")
+	}
+}
+
+func Test_defaultCAFile(t *testing.T) {
+	path, err := defaultCAFile()
+	if err != nil {
+		t.Fatalf("defaultCAFile() unexpected error = %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+	if string(got) != string(defaultCACertificates) {
+		t.Errorf("defaultCAFile() content does not match embedded CA certificates")
+	}
+
+	if again, _ := defaultCAFile(); again != path {
+		t.Errorf("defaultCAFile() shall store the certificates once")
+	}
+}
+
+func Test_inlineCAFile(t *testing.T) {
+	path, err := inlineCAFile(string(defaultCACertificates))
+	if err != nil {
+		t.Fatalf("inlineCAFile() unexpected error = %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+	if string(got) != string(defaultCACertificates) {
+		t.Errorf("inlineCAFile() content does not match the certificates")
+	}
+
+	if again, _ := inlineCAFile(string(defaultCACertificates)); again != path {
+		t.Errorf("inlineCAFile() shall store the same certificates once")
+	}
+
+	if _, err := inlineCAFile("foo"); err == nil {
+		t.Errorf("inlineCAFile() expected error for faulty certificates")
+	}
+}
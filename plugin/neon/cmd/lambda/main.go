@@ -4,11 +4,14 @@ import (
 	"context"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
 	dbclient "github.com/kislerdm/aws-lambda-secret-rotation/plugin/neon"
 
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 	secretRotation "github.com/kislerdm/aws-lambda-secret-rotation"
 
@@ -44,14 +47,77 @@ func main() {
 	if err != nil {
 		log.Fatalf("unable to init Neon SDK, %v", err)
 	}
+	if secretRotation.StrToBool(os.Getenv("UPDATE_FROM_CONNECTION_URI")) {
+		clientNeon = dbclient.NewConnectionURIResetterClient(clientNeon, adminSecret.Token)
+	}
+
+	var sessionDrainGrace time.Duration
+	if v := os.Getenv("SESSION_DRAIN_GRACE"); v != "" {
+		if sessionDrainGrace, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("unable to parse SESSION_DRAIN_GRACE, %v", err)
+		}
+	}
+
+	var operationsTimeout time.Duration
+	if v := os.Getenv("OPERATIONS_TIMEOUT"); v != "" {
+		if operationsTimeout, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("unable to parse OPERATIONS_TIMEOUT, %v", err)
+		}
+	}
+
+	var allowedHostSuffixes []string
+	if v := os.Getenv("ALLOWED_HOST_SUFFIXES"); v != "" {
+		allowedHostSuffixes = strings.Split(v, ",")
+	}
+
+	var requiredRoleMemberships []string
+	if v := os.Getenv("REQUIRED_ROLE_MEMBERSHIPS"); v != "" {
+		requiredRoleMemberships = strings.Split(v, ",")
+	}
+
+	var fieldEncryptor secretRotation.FieldEncryptor
+	if keyID := os.Getenv("FIELD_ENCRYPTION_KMS_KEY_ID"); keyID != "" {
+		fieldEncryptor = secretRotation.NewKMSFieldEncryptor(kms.NewFromConfig(cfgSecretsManager), keyID)
+	}
 
 	var s dbclient.SecretUser
 	handler, err := secretRotation.NewHandler(
 		secretRotation.Config{
 			SecretsmanagerClient: clientSecretsManager,
-			ServiceClient:        dbclient.NewServiceClient(clientNeon),
-			SecretObj:            &s,
-			Debug:                secretRotation.StrToBool(os.Getenv("DEBUG")),
+			Retryer:              secretRotation.DefaultRetryer(),
+			ServiceClient: dbclient.NewServiceClientWithConfig(
+				clientNeon, dbclient.Config{
+					TerminateExistingSessions: secretRotation.StrToBool(os.Getenv("TERMINATE_EXISTING_SESSIONS")),
+					SessionDrainGrace:         sessionDrainGrace,
+					DeferTestOnSuspendedEndpoint: secretRotation.StrToBool(
+						os.Getenv("DEFER_TEST_ON_SUSPENDED_ENDPOINT"),
+					),
+					ExpectedEndpointType: sdk.EndpointType(os.Getenv("EXPECTED_ENDPOINT_TYPE")),
+					SearchPath:           os.Getenv("SEARCH_PATH"),
+					VerifyReplication:    secretRotation.StrToBool(os.Getenv("VERIFY_REPLICATION")),
+					AllowedHostSuffixes:  allowedHostSuffixes,
+					PoolerMode:           os.Getenv("POOLER_MODE"),
+					EmitConnectionURI:    secretRotation.StrToBool(os.Getenv("EMIT_CONNECTION_URI")),
+					VerifyReadWrite:      secretRotation.StrToBool(os.Getenv("VERIFY_READ_WRITE")),
+					RequireAllHosts:      secretRotation.StrToBool(os.Getenv("REQUIRE_ALL_HOSTS")),
+					RotateUsername:       secretRotation.StrToBool(os.Getenv("ROTATE_USERNAME")),
+					NeonAPIFallbackToSQL: secretRotation.StrToBool(os.Getenv("NEON_API_FALLBACK_TO_SQL")),
+					TestScript:           os.Getenv("TEST_SCRIPT"),
+					RefreshHostFromNeon:  secretRotation.StrToBool(os.Getenv("REFRESH_HOST_FROM_NEON")),
+					ConnectLogLevel:      os.Getenv("CONNECT_LOG_LEVEL"),
+					OperationsTimeout:    operationsTimeout,
+					ValidateOnlySet:      secretRotation.StrToBool(os.Getenv("VALIDATE_ONLY_SET")),
+					Metrics:              secretRotation.NewEMFMetrics(os.Stdout),
+					ResolveReadWriteEndpoint: secretRotation.StrToBool(
+						os.Getenv("RESOLVE_READ_WRITE_ENDPOINT"),
+					),
+					RequiredRoleMemberships: requiredRoleMemberships,
+				},
+			),
+			SecretObj:      &s,
+			FieldEncryptor: fieldEncryptor,
+			Engine:         "neon-postgres",
+			Debug:          secretRotation.StrToBool(os.Getenv("DEBUG")),
 		},
 	)
 	if err != nil {
@@ -0,0 +1,69 @@
+package neon
+
+import (
+	"log"
+	"strconv"
+	"sync/atomic"
+
+	lambda "github.com/kislerdm/aws-lambda-secret-rotation"
+)
+
+// metricConnectionLeak the counter of the ServiceClient's calls which left the database connections open.
+const metricConnectionLeak = "ConnectionLeak"
+
+// connectionBalance tracks the number of the open database connections.
+type connectionBalance struct {
+	open   int64
+	opened int64
+}
+
+// track counts the connection as open until it's closed.
+func (b *connectionBalance) track(conn db) db {
+	if b == nil {
+		return conn
+	}
+	atomic.AddInt64(&b.open, 1)
+	atomic.AddInt64(&b.opened, 1)
+	return &trackedDB{db: conn, balance: b}
+}
+
+// value returns the number of the open connections.
+func (b *connectionBalance) value() int64 {
+	if b == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&b.open)
+}
+
+// watch returns the function which reports the connections opened since the call and left open.
+func (b *connectionBalance) watch(m lambda.Metrics, method string) func() {
+	start := b.value()
+	return func() {
+		if leaked := b.value() - start; leaked != 0 {
+			log.Println("[ERROR] " + method + " left " + strconv.FormatInt(leaked, 10) + " database connections open")
+			m.IncCounter(metricConnectionLeak, map[string]string{"Method": method})
+		}
+	}
+}
+
+// trackedDB decrements the balance of the open connections on the first Close.
+type trackedDB struct {
+	db
+	balance *connectionBalance
+	closed  int32
+}
+
+func (t *trackedDB) Close() error {
+	if atomic.CompareAndSwapInt32(&t.closed, 0, 1) {
+		atomic.AddInt64(&t.balance.open, -1)
+	}
+	return t.db.Close()
+}
+
+// metrics returns the configured Metrics, or NoopMetrics.
+func (c dbClient) metrics() lambda.Metrics {
+	if c.cfg.Metrics == nil {
+		return lambda.NoopMetrics{}
+	}
+	return c.cfg.Metrics
+}
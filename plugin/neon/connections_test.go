@@ -0,0 +1,97 @@
+package neon
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordedCounter struct {
+	name       string
+	dimensions map[string]string
+}
+
+// mockMetrics records the counters.
+type mockMetrics struct {
+	mu       sync.Mutex
+	counters []recordedCounter
+}
+
+func (m *mockMetrics) IncCounter(name string, dimensions map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counters = append(m.counters, recordedCounter{name: name, dimensions: dimensions})
+}
+
+func (m *mockMetrics) ObserveDuration(string, time.Duration, map[string]string) {}
+
+func Test_dbClient_connectionsBalanced(t *testing.T) {
+	m := &mockRecordingDB{}
+	metrics := &mockMetrics{}
+	c := dbClient{
+		c: newMockSDKClient(),
+		cfg: Config{
+			TerminateExistingSessions: true,
+			VerifyReadWrite:           true,
+			VerifyReplication:         true,
+			Metrics:                   metrics,
+		},
+		connect:     m.connect,
+		connections: &connectionBalance{},
+	}
+
+	secret := &SecretUser{
+		User:         "qux",
+		Password:     placeholderPassword,
+		Host:         "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+		Hosts:        []string{"ep-baz-qux-654321.us-east-2.aws.neon.tech"},
+		ProjectID:    "foo",
+		BranchID:     "br-bar",
+		DatabaseName: "baz",
+	}
+
+	if err := c.Set(context.TODO(), &SecretUser{}, secret, &SecretUser{}); err != nil {
+		t.Fatalf("Set() unexpected error = %v", err)
+	}
+	if err := c.Test(context.TODO(), secret); err != nil {
+		t.Fatalf("Test() unexpected error = %v", err)
+	}
+
+	if c.connections.opened == 0 {
+		t.Fatalf("no database connections tracked")
+	}
+	if got := c.connections.value(); got != 0 {
+		t.Errorf("open connections = %d, want 0", got)
+	}
+	if len(metrics.counters) != 0 {
+		t.Errorf("unexpected metrics %v", metrics.counters)
+	}
+}
+
+func Test_connectionBalance_watch(t *testing.T) {
+	m := &mockRecordingDB{}
+	metrics := &mockMetrics{}
+	c := dbClient{connect: m.connect, connections: &connectionBalance{}}
+
+	end := c.connections.watch(metrics, "Test")
+	conn, err := c.openDBConnection(
+		&SecretUser{User: "qux", Host: "ep-foo-bar-123456.us-east-2.aws.neon.tech", DatabaseName: "baz"},
+	)
+	if err != nil {
+		t.Fatalf("openDBConnection() unexpected error = %v", err)
+	}
+	end()
+
+	if len(metrics.counters) != 1 || metrics.counters[0].name != metricConnectionLeak ||
+		metrics.counters[0].dimensions["Method"] != "Test" {
+		t.Errorf("metrics = %v, want %s of the method Test", metrics.counters, metricConnectionLeak)
+	}
+
+	// the repeated Close does not skew the balance
+	_ = conn.Close()
+	_ = conn.Close()
+	if got := c.connections.value(); got != 0 {
+		t.Errorf("open connections = %d, want 0", got)
+	}
+}
@@ -0,0 +1,115 @@
+package neon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+
+	lambda "github.com/kislerdm/aws-lambda-secret-rotation"
+	neon "github.com/kislerdm/neon-sdk-go"
+)
+
+// defaultNeonAPIBaseURL the base URL of Neon API.
+const defaultNeonAPIBaseURL = "https://console.neon.tech/api/v2"
+
+// RolePasswordReset defines the response of the role's password reset including the connection URIs,
+// which the SDK's neon.RoleOperations does not expose.
+type RolePasswordReset struct {
+	neon.RoleOperations
+	neon.ConnectionURIsOptionalResponse
+}
+
+// ConnectionURIResetter the Neon API client which resets the role's password and returns the connection URIs.
+// The secret's host, user and password are updated from the role's connection URI if the client implements it.
+type ConnectionURIResetter interface {
+	ResetProjectBranchRolePasswordWithConnectionURIs(projectID, branchID, roleName string) (RolePasswordReset, error)
+}
+
+// NewConnectionURIResetterClient wraps the Neon SDK client to return the connection URIs of the password reset,
+// e.g. to follow the changes of the endpoint's host. The password is reset by the direct call to Neon API
+// authenticated with the API key, other calls are served by the SDK client.
+func NewConnectionURIResetterClient(client neon.Client, apiKey string) neon.Client {
+	return connectionURIResetterClient{
+		Client:     client,
+		apiKey:     apiKey,
+		baseURL:    defaultNeonAPIBaseURL,
+		httpClient: http.DefaultClient,
+	}
+}
+
+type connectionURIResetterClient struct {
+	neon.Client
+
+	apiKey     string
+	baseURL    string
+	httpClient *http.Client
+}
+
+func (c connectionURIResetterClient) ResetProjectBranchRolePasswordWithConnectionURIs(
+	projectID, branchID, roleName string,
+) (RolePasswordReset, error) {
+	req, err := http.NewRequest(
+		http.MethodPost, c.baseURL+"/projects/"+url.PathEscape(projectID)+"/branches/"+url.PathEscape(branchID)+
+			"/roles/"+url.PathEscape(roleName)+"/reset_password", nil,
+	)
+	if err != nil {
+		return RolePasswordReset{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return RolePasswordReset{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode > 299 {
+		return RolePasswordReset{}, neon.Error{HTTPCode: resp.StatusCode}
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return RolePasswordReset{}, err
+	}
+
+	var o RolePasswordReset
+	if err := json.Unmarshal(b, &o); err != nil {
+		return RolePasswordReset{}, errors.New("faulty password reset response: " + err.Error())
+	}
+	return o, nil
+}
+
+// resetPassword resets the role's password using Neon API, the secret's host, user and password are updated
+// from the role's connection URI if the client returns it.
+func (c dbClient) resetPassword(ctx context.Context, s *SecretUser) (neon.RoleOperations, error) {
+	r, ok := c.c.(ConnectionURIResetter)
+	if !ok {
+		o, err := c.c.ResetProjectBranchRolePassword(s.ProjectID, s.BranchID, s.User)
+		if err == nil {
+			s.Password = o.RoleResponse.Role.Password
+		}
+		return o, err
+	}
+
+	o, err := r.ResetProjectBranchRolePasswordWithConnectionURIs(s.ProjectID, s.BranchID, s.User)
+	if err != nil {
+		return neon.RoleOperations{}, err
+	}
+	s.Password = o.RoleResponse.Role.Password
+
+	for _, v := range o.ConnectionUris {
+		if v.ConnectionParameters.Role != "" && v.ConnectionParameters.Role != s.User {
+			continue
+		}
+		if err := s.UpdateFromConnectionURI(v.ConnectionURI); err != nil {
+			return neon.RoleOperations{}, err
+		}
+		lambda.RecordFeature(ctx, featureConnectionURI)
+		break
+	}
+	return o.RoleOperations, nil
+}
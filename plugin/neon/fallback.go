@@ -0,0 +1,93 @@
+package neon
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+
+	lambda "github.com/kislerdm/aws-lambda-secret-rotation"
+	neon "github.com/kislerdm/neon-sdk-go"
+	"github.com/lib/pq"
+)
+
+// metricNeonAPIFallback the counter of the calls which fell back to SQL because Neon API was unreachable.
+const metricNeonAPIFallback = "NeonAPIFallback"
+
+// isNeonAPIUnreachable checks if the Neon API call failed because the API is unreachable, or unavailable,
+// i.e. the request failed to be sent over the network, or the API responded with the server error, or throttled
+// the request. The cancelled invocation and the faulty response are not considered as unreachable API.
+func isNeonAPIUnreachable(err error) bool {
+	var e neon.Error
+	if errors.As(err, &e) {
+		return e.HTTPCode >= http.StatusInternalServerError || e.HTTPCode == http.StatusTooManyRequests
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// generatePasswordFallback generates the password locally because Neon API is unreachable,
+// the password is set over SQL by Set, see setPasswordFallback.
+func (c dbClient) generatePasswordFallback(ctx context.Context, s *SecretUser, errAPI error) error {
+	log.Println("[WARN] Neon API is unreachable, fall back to SQL to rotate the password: " + errAPI.Error())
+	c.metrics().IncCounter(metricNeonAPIFallback, map[string]string{"Method": "Create"})
+	lambda.RecordFeature(ctx, featureNeonAPIFallbackToSQL)
+
+	password, err := c.cfg.PasswordGenerator.GenerateContext(ctx)
+	if err != nil {
+		return err
+	}
+	s.Password = password
+	return nil
+}
+
+// queryAlterRolePassword returns the query to set the role's password.
+func queryAlterRolePassword(user, password string) string {
+	return "ALTER ROLE " + pq.QuoteIdentifier(user) + " WITH PASSWORD " + pq.QuoteLiteral(password)
+}
+
+// setPasswordFallback sets the pending secret's password over the connection of the current secret's role
+// if the pending password fails the authentication, i.e. it was generated while Neon API was unreachable.
+func (c dbClient) setPasswordFallback(ctx context.Context, current, pending *SecretUser) error {
+	err := c.authenticate(ctx, pending)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(authError(err), ErrInvalidPassword) {
+		return err
+	}
+
+	log.Println("[WARN] the password of the role " + pending.User + " is not set by Neon API, set it over SQL")
+	c.metrics().IncCounter(metricNeonAPIFallback, map[string]string{"Method": "Set"})
+	lambda.RecordFeature(ctx, featureNeonAPIFallbackToSQL)
+
+	v := *current
+	v.Host = pending.Host
+	v.Port = pending.Port
+	db, err := c.openDBConnection(&v)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	if _, err := db.ExecContext(ctx, queryAlterRolePassword(pending.User, pending.Password)); err != nil {
+		return errors.New("failed to set the password of the role " + pending.User + " over SQL: " + err.Error())
+	}
+	return nil
+}
+
+// authenticate opens the connection using the secret to check that its password passes the authentication.
+func (c dbClient) authenticate(ctx context.Context, s *SecretUser) error {
+	db, err := c.openDBConnection(s)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+	return db.PingContext(ctx)
+}
@@ -0,0 +1,222 @@
+package neon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	lambda "github.com/kislerdm/aws-lambda-secret-rotation"
+	sdk "github.com/kislerdm/neon-sdk-go"
+	"github.com/lib/pq"
+)
+
+// mockUnreachableSDKClient fails the password reset with the error.
+type mockUnreachableSDKClient struct {
+	sdk.Client
+	err error
+}
+
+func (m mockUnreachableSDKClient) ResetProjectBranchRolePassword(string, string, string) (sdk.RoleOperations, error) {
+	return sdk.RoleOperations{}, m.err
+}
+
+func Test_dbClient_Create_NeonAPIFallbackToSQL(t *testing.T) {
+	tests := []struct {
+		name       string
+		fallback   bool
+		apiErr     error
+		wantMetric bool
+		wantErr    bool
+	}{
+		{
+			name:       "password is generated locally if Neon API is unavailable",
+			fallback:   true,
+			apiErr:     sdk.Error{HTTPCode: http.StatusServiceUnavailable},
+			wantMetric: true,
+		},
+		{
+			name:     "password is generated locally if Neon API is unreachable",
+			fallback: true,
+			apiErr: &url.Error{
+				Op:  "Post",
+				URL: "https://console.neon.tech/api/v2",
+				Err: &net.OpError{
+					Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "console.neon.tech"},
+				},
+			},
+			wantMetric: true,
+		},
+		{
+			name:       "password is generated locally if Neon API is throttled",
+			fallback:   true,
+			apiErr:     sdk.Error{HTTPCode: http.StatusTooManyRequests},
+			wantMetric: true,
+		},
+		{
+			name:     "cancelled invocation is not fallen back",
+			fallback: true,
+			apiErr:   &url.Error{Op: "Post", URL: "https://console.neon.tech/api/v2", Err: context.Canceled},
+			wantErr:  true,
+		},
+		{
+			name:     "deadline exceeded is not fallen back",
+			fallback: true,
+			apiErr:   fmt.Errorf("reset password: %w", context.DeadlineExceeded),
+			wantErr:  true,
+		},
+		{
+			name:     "faulty response is not fallen back",
+			fallback: true,
+			apiErr:   &json.SyntaxError{Offset: 1},
+			wantErr:  true,
+		},
+		{
+			name:     "unknown error is not fallen back",
+			fallback: true,
+			apiErr:   errors.New("foo"),
+			wantErr:  true,
+		},
+		{
+			name:     "client error is not fallen back",
+			fallback: true,
+			apiErr:   sdk.Error{HTTPCode: http.StatusNotFound},
+			wantErr:  true,
+		},
+		{
+			name:    "no fallback unless set",
+			apiErr:  sdk.Error{HTTPCode: http.StatusServiceUnavailable},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				metrics := &mockMetrics{}
+				c := dbClient{
+					c: mockUnreachableSDKClient{Client: newMockSDKClient(), err: tt.apiErr},
+					cfg: Config{
+						NeonAPIFallbackToSQL: tt.fallback,
+						Metrics:              metrics,
+						PasswordGenerator:    lambda.PasswordGenerator{Length: 12},
+					},
+					connect: func(string) (db, error) {
+						t.Fatal("Create() shall not connect to the database")
+						return nil, nil
+					},
+				}
+
+				s := &SecretUser{User: "qux", Password: placeholderPassword, ProjectID: "foo", BranchID: "br-bar"}
+				if err := c.Create(context.TODO(), s); (err != nil) != tt.wantErr {
+					t.Fatalf("Create() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if !tt.wantErr && len(s.Password) != 12 {
+					t.Errorf("Create() shall generate new password using the configured generator")
+				}
+
+				var gotMetric bool
+				for _, m := range metrics.counters {
+					gotMetric = gotMetric || m.name == metricNeonAPIFallback
+				}
+				if gotMetric != tt.wantMetric {
+					t.Errorf("metric %s recorded = %v, want %v", metricNeonAPIFallback, gotMetric, tt.wantMetric)
+				}
+			},
+		)
+	}
+}
+
+// mockAuthDB fails the authentication of the connections with the password.
+type mockAuthDB struct {
+	mockRecordingDB
+	invalidPassword string
+}
+
+func (m *mockAuthDB) connect(connStr string) (db, error) {
+	conn, _ := m.mockRecordingDB.connect(connStr)
+	if m.invalidPassword != "" && strings.HasSuffix(connStr, " password="+m.invalidPassword) {
+		return mockAuthConn{conn.(*mockRecordingConn)}, nil
+	}
+	return conn, nil
+}
+
+type mockAuthConn struct {
+	*mockRecordingConn
+}
+
+func (c mockAuthConn) PingContext(context.Context) error {
+	return &pq.Error{Code: sqlStateInvalidPassword, Message: "password authentication failed"}
+}
+
+func Test_dbClient_Set_NeonAPIFallbackToSQL(t *testing.T) {
+	current := &SecretUser{
+		User:         "qux",
+		Password:     placeholderPassword,
+		Host:         "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+		DatabaseName: "baz",
+	}
+	pending := &SecretUser{
+		User:         "qux",
+		Password:     placeholderPassword + "new",
+		Host:         "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+		DatabaseName: "baz",
+	}
+
+	tests := []struct {
+		name            string
+		invalidPassword string
+		wantQueries     []recordedQuery
+		wantMetric      bool
+	}{
+		{
+			name:            "password generated while Neon API was unreachable is set over SQL",
+			invalidPassword: pending.Password,
+			wantQueries: []recordedQuery{
+				{
+					connStr: "user=qux dbname=baz host=ep-foo-bar-123456.us-east-2.aws.neon.tech sslmode=verify-full" +
+						" password=" + placeholderPassword,
+					query: `ALTER ROLE "qux" WITH PASSWORD '` + placeholderPassword + `new'`,
+				},
+			},
+			wantMetric: true,
+		},
+		{
+			name:        "password set by Neon API is not set over SQL",
+			wantQueries: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				m := &mockAuthDB{invalidPassword: tt.invalidPassword}
+				metrics := &mockMetrics{}
+				c := dbClient{
+					c:       newMockSDKClient(),
+					cfg:     Config{NeonAPIFallbackToSQL: true, Metrics: metrics},
+					connect: m.connect,
+				}
+
+				if err := c.Set(context.TODO(), current, pending, &SecretUser{}); err != nil {
+					t.Fatalf("Set() unexpected error = %v", err)
+				}
+				if !reflect.DeepEqual(m.queries, tt.wantQueries) {
+					t.Errorf("Set() queries = %v, want %v", m.queries, tt.wantQueries)
+				}
+
+				var gotMetric bool
+				for _, m := range metrics.counters {
+					gotMetric = gotMetric || m.name == metricNeonAPIFallback
+				}
+				if gotMetric != tt.wantMetric {
+					t.Errorf("metric %s recorded = %v, want %v", metricNeonAPIFallback, gotMetric, tt.wantMetric)
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,53 @@
+package neon
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	lambda "github.com/kislerdm/aws-lambda-secret-rotation"
+)
+
+// poolerHostSuffix the suffix of the endpoint ID in the host of the endpoint's connection pooler.
+const poolerHostSuffix = "-pooler"
+
+// refreshHost sets the secret's host to the host of the branch's current read_write endpoint according to Neon API
+// if the secret's host does not belong to any of the branch's endpoints, e.g. after the branch is restored.
+// The host of the connection pooler is preserved as the pooler's host of the current endpoint.
+func (c dbClient) refreshHost(ctx context.Context, s *SecretUser) error {
+	lambda.RecordFeature(ctx, featureRefreshHost)
+
+	o, err := c.c.ListProjectBranchEndpoints(s.ProjectID, s.BranchID)
+	if err != nil {
+		return errors.New("failed to fetch the endpoints: " + err.Error())
+	}
+
+	endpointID, _, _ := strings.Cut(s.Host, ".")
+	pooler := strings.HasSuffix(endpointID, poolerHostSuffix)
+	endpointID = strings.TrimSuffix(endpointID, poolerHostSuffix)
+
+	for _, endpoint := range o.Endpoints {
+		if endpoint.Host == s.Host || endpoint.ID == endpointID {
+			return nil
+		}
+	}
+
+	for _, endpoint := range o.Endpoints {
+		if endpoint.Type != endpointTypeReadWrite {
+			continue
+		}
+
+		host := endpoint.Host
+		if pooler {
+			id, domain, _ := strings.Cut(host, ".")
+			host = id + poolerHostSuffix + "." + domain
+		}
+
+		log.Println("[INFO] the host " + s.Host + " is stale, it's refreshed to " + host)
+		s.Host = host
+		return nil
+	}
+
+	return errors.New("no read_write endpoint found for the branch " + s.BranchID + " to refresh the host " + s.Host)
+}
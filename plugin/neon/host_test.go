@@ -0,0 +1,72 @@
+package neon
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func Test_dbClient_Create_RefreshHostFromNeon(t *testing.T) {
+	// the host of the read_write endpoint returned by the mock Neon API
+	const currentHost = "ep-little-smoke-851426.us-east-2.aws.neon.tech"
+
+	tests := []struct {
+		name     string
+		refresh  bool
+		host     string
+		wantHost string
+	}{
+		{
+			name:     "stale host is refreshed",
+			refresh:  true,
+			host:     "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+			wantHost: currentHost,
+		},
+		{
+			name:     "stale pooler's host is refreshed to the pooler's host",
+			refresh:  true,
+			host:     "ep-foo-bar-123456-pooler.us-east-2.aws.neon.tech",
+			wantHost: "ep-little-smoke-851426-pooler.us-east-2.aws.neon.tech",
+		},
+		{
+			name:     "current pooler's host is kept",
+			refresh:  true,
+			host:     "ep-little-smoke-851426-pooler.us-east-2.aws.neon.tech",
+			wantHost: "ep-little-smoke-851426-pooler.us-east-2.aws.neon.tech",
+		},
+		{
+			name:     "host is not refreshed unless set",
+			host:     "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+			wantHost: "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				c := dbClient{
+					c:   newMockSDKClient(),
+					cfg: Config{RefreshHostFromNeon: tt.refresh, EmitConnectionURI: true},
+				}
+
+				s := &SecretUser{
+					User:         "qux",
+					Password:     placeholderPassword,
+					Host:         tt.host,
+					ProjectID:    "shiny-wind-028834",
+					BranchID:     "br-aged-salad-637688",
+					DatabaseName: "baz",
+				}
+				if err := c.Create(context.TODO(), s); err != nil {
+					t.Fatalf("Create() unexpected error = %v", err)
+				}
+
+				if s.Host != tt.wantHost {
+					t.Errorf("Create() host = %v, want %v", s.Host, tt.wantHost)
+				}
+				if !strings.Contains(s.ConnectionURI, "@"+tt.wantHost+"/") {
+					t.Errorf("Create() connection_uri = %v, want the host %v", s.ConnectionURI, tt.wantHost)
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,72 @@
+package neon
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+// ErrSecretNotFound no secret is tagged with the Neon project and role.
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretsListingClient defines the AWS Secretsmanager client to list the secrets.
+type SecretsListingClient interface {
+	ListSecrets(
+		ctx context.Context, input *secretsmanager.ListSecretsInput, optFns ...func(*secretsmanager.Options),
+	) (*secretsmanager.ListSecretsOutput, error)
+}
+
+// FindSecretARN returns the ARN of the secret tagged with the Neon project ID and role name,
+// see TagProjectID and TagRoleName. It allows to find the secret to rotate by the role, e.g. in maintenance tools.
+func FindSecretARN(ctx context.Context, client SecretsListingClient, projectID, roleName string) (string, error) {
+	if projectID == "" || roleName == "" {
+		return "", errors.New("project ID and role name must be set")
+	}
+
+	// the filters match the tags' keys and values independently, hence the pairs are checked for every secret
+	input := &secretsmanager.ListSecretsInput{
+		Filters: []types.Filter{
+			{Key: types.FilterNameStringTypeTagKey, Values: []string{TagProjectID, TagRoleName}},
+			{Key: types.FilterNameStringTypeTagValue, Values: []string{projectID, roleName}},
+		},
+	}
+
+	var found []string
+	for {
+		v, err := client.ListSecrets(ctx, input)
+		if err != nil {
+			return "", errors.New("failed to list secrets: " + err.Error())
+		}
+
+		for _, secret := range v.SecretList {
+			tags := map[string]string{}
+			for _, tag := range secret.Tags {
+				tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+			}
+			if tags[TagProjectID] == projectID && tags[TagRoleName] == roleName {
+				found = append(found, aws.ToString(secret.ARN))
+			}
+		}
+
+		if aws.ToString(v.NextToken) == "" {
+			break
+		}
+		input.NextToken = v.NextToken
+	}
+
+	switch len(found) {
+	case 0:
+		return "", ErrSecretNotFound
+	case 1:
+		return found[0], nil
+	default:
+		return "", errors.New(
+			"ambiguous lookup: " + strconv.Itoa(len(found)) + " secrets are tagged with the project " + projectID +
+				" and the role " + roleName,
+		)
+	}
+}
@@ -0,0 +1,124 @@
+package neon
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+// mockListingClient returns a page per secret.
+type mockListingClient struct {
+	secrets []types.SecretListEntry
+	err     error
+}
+
+func (m mockListingClient) ListSecrets(
+	_ context.Context, input *secretsmanager.ListSecretsInput, _ ...func(*secretsmanager.Options),
+) (*secretsmanager.ListSecretsOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	var page int
+	if input.NextToken != nil {
+		page = int(aws.ToString(input.NextToken)[0] - '0')
+	}
+
+	o := &secretsmanager.ListSecretsOutput{}
+	if page < len(m.secrets) {
+		o.SecretList = []types.SecretListEntry{m.secrets[page]}
+	}
+	if page+1 < len(m.secrets) {
+		o.NextToken = aws.String(string(rune('0' + page + 1)))
+	}
+	return o, nil
+}
+
+func newSecretListEntry(arn, projectID, roleName string) types.SecretListEntry {
+	return types.SecretListEntry{
+		ARN: aws.String(arn),
+		Tags: []types.Tag{
+			{Key: aws.String(TagProjectID), Value: aws.String(projectID)},
+			{Key: aws.String(TagRoleName), Value: aws.String(roleName)},
+		},
+	}
+}
+
+func TestFindSecretARN(t *testing.T) {
+	tests := []struct {
+		name      string
+		client    SecretsListingClient
+		projectID string
+		roleName  string
+		want      string
+		wantErr   error
+	}{
+		{
+			name: "happy path: matched by tags on the second page",
+			client: mockListingClient{
+				secrets: []types.SecretListEntry{
+					// the tags' values are swapped, hence they match the filters, but not the pairs
+					newSecretListEntry("arn:foo", "bar", "shiny-wind-028834"),
+					newSecretListEntry("arn:bar", "shiny-wind-028834", "bar"),
+				},
+			},
+			projectID: "shiny-wind-028834",
+			roleName:  "bar",
+			want:      "arn:bar",
+		},
+		{
+			name: "unhappy path: not found",
+			client: mockListingClient{
+				secrets: []types.SecretListEntry{newSecretListEntry("arn:foo", "shiny-wind-028834", "foo")},
+			},
+			projectID: "shiny-wind-028834",
+			roleName:  "bar",
+			wantErr:   ErrSecretNotFound,
+		},
+		{
+			name: "unhappy path: ambiguous",
+			client: mockListingClient{
+				secrets: []types.SecretListEntry{
+					newSecretListEntry("arn:foo", "shiny-wind-028834", "bar"),
+					newSecretListEntry("arn:bar", "shiny-wind-028834", "bar"),
+				},
+			},
+			projectID: "shiny-wind-028834",
+			roleName:  "bar",
+			wantErr:   errors.New("ambiguous lookup"),
+		},
+		{
+			name:      "unhappy path: list error",
+			client:    mockListingClient{err: errors.New("foo")},
+			projectID: "shiny-wind-028834",
+			roleName:  "bar",
+			wantErr:   errors.New("failed to list secrets"),
+		},
+		{
+			name:     "unhappy path: no project",
+			client:   mockListingClient{},
+			roleName: "bar",
+			wantErr:  errors.New("project ID and role name must be set"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := FindSecretARN(context.TODO(), tt.client, tt.projectID, tt.roleName)
+				if (err != nil) != (tt.wantErr != nil) {
+					t.Fatalf("FindSecretARN() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if tt.wantErr == ErrSecretNotFound && !errors.Is(err, ErrSecretNotFound) {
+					t.Errorf("FindSecretARN() error = %v, want %v", err, ErrSecretNotFound)
+				}
+				if got != tt.want {
+					t.Errorf("FindSecretARN() got = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,35 @@
+package neon
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// queryCheckRoleMembership fails with the SQLSTATE P0001 if the session's role is not a member of the role.
+func queryCheckRoleMembership(role string) (string, error) {
+	if strings.Contains(role, "$") {
+		return "", errors.New("role name must not contain $")
+	}
+	literal := pq.QuoteLiteral(role)
+	return `DO $$ BEGIN IF NOT pg_has_role(current_user, ` + literal + `, 'member') THEN ` +
+		`RAISE EXCEPTION 'role % is not a member of %', current_user, ` + literal + `; ` +
+		`END IF; END $$`, nil
+}
+
+// checkRoleMemberships checks that the session's role is a member of the roles, e.g. the group role
+// which grants the privileges to the application's roles.
+func checkRoleMemberships(ctx context.Context, db db, roles []string) error {
+	for _, role := range roles {
+		query, err := queryCheckRoleMembership(role)
+		if err != nil {
+			return err
+		}
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return errors.New("missing role membership: " + err.Error())
+		}
+	}
+	return nil
+}
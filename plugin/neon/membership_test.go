@@ -0,0 +1,88 @@
+package neon
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func Test_queryCheckRoleMembership(t *testing.T) {
+	got, err := queryCheckRoleMembership("app_users")
+	if err != nil {
+		t.Fatalf("queryCheckRoleMembership() unexpected error = %v", err)
+	}
+	want := `DO $$ BEGIN IF NOT pg_has_role(current_user, 'app_users', 'member') THEN ` +
+		`RAISE EXCEPTION 'role % is not a member of %', current_user, 'app_users'; END IF; END $$`
+	if got != want {
+		t.Errorf("queryCheckRoleMembership() got = %v, want %v", got, want)
+	}
+
+	if _, err := queryCheckRoleMembership("app$$users"); err == nil {
+		t.Errorf("queryCheckRoleMembership() expected error")
+	}
+}
+
+func Test_dbClient_Test_RequiredRoleMemberships(t *testing.T) {
+	queryAppUsers, _ := queryCheckRoleMembership("app_users")
+	queryReporting, _ := queryCheckRoleMembership("reporting")
+
+	tests := []struct {
+		name        string
+		roles       []string
+		execErrs    map[string]error
+		wantQueries []string
+		wantErr     bool
+	}{
+		{
+			name: "memberships are not verified by default",
+		},
+		{
+			name:        "role is a member of all required roles",
+			roles:       []string{"app_users", "reporting"},
+			wantQueries: []string{queryAppUsers, queryReporting},
+		},
+		{
+			name:  "role lost the membership",
+			roles: []string{"app_users", "reporting"},
+			execErrs: map[string]error{
+				queryAppUsers: &pq.Error{Code: "P0001", Message: "role qux is not a member of app_users"},
+			},
+			wantQueries: []string{queryAppUsers},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				m := &mockRecordingDB{execErrs: tt.execErrs}
+				c := dbClient{
+					c:       newMockSDKClient(),
+					cfg:     Config{RequiredRoleMemberships: tt.roles},
+					connect: m.connect,
+				}
+
+				err := c.Test(
+					context.TODO(), &SecretUser{
+						User:         "qux",
+						Password:     placeholderPassword,
+						Host:         "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+						DatabaseName: "baz",
+					},
+				)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("Test() error = %v, wantErr %v", err, tt.wantErr)
+				}
+
+				var got []string
+				for _, q := range m.queries {
+					got = append(got, q.query)
+				}
+				if !reflect.DeepEqual(got, tt.wantQueries) {
+					t.Errorf("Test() queries = %v, want %v", got, tt.wantQueries)
+				}
+			},
+		)
+	}
+}
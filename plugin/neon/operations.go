@@ -0,0 +1,84 @@
+package neon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	lambda "github.com/kislerdm/aws-lambda-secret-rotation"
+	neon "github.com/kislerdm/neon-sdk-go"
+)
+
+// defaultOperationsPollInterval the interval between the polls of the Neon API operations' statuses.
+const defaultOperationsPollInterval = time.Second
+
+// Statuses of the Neon API operations.
+const (
+	operationStatusFinished  neon.OperationStatus = "finished"
+	operationStatusSkipped   neon.OperationStatus = "skipped"
+	operationStatusFailed    neon.OperationStatus = "failed"
+	operationStatusError     neon.OperationStatus = "error"
+	operationStatusCancelled neon.OperationStatus = "cancelled"
+)
+
+// ErrOperationsTimeout the Neon API operations did not finish within Config.OperationsTimeout.
+var ErrOperationsTimeout = errors.New("neon operations timeout")
+
+// waitForOperations polls the statuses of the operations until all of them finish, or OperationsTimeout elapses.
+func (c dbClient) waitForOperations(ctx context.Context, projectID string, operations []neon.Operation) error {
+	lambda.RecordFeature(ctx, featureWaitForOperations)
+
+	interval := c.cfg.OperationsPollInterval
+	if interval <= 0 {
+		interval = defaultOperationsPollInterval
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.OperationsTimeout)
+	defer cancel()
+
+	for _, operation := range operations {
+		for {
+			done, err := operationDone(operation)
+			if err != nil {
+				return err
+			}
+			if done {
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf(
+					"%w: operation %s is %s after %s", ErrOperationsTimeout, operation.ID, operation.Status,
+					c.cfg.OperationsTimeout,
+				)
+			case <-time.After(interval):
+			}
+
+			o, err := c.c.GetProjectOperation(projectID, operation.ID)
+			if err != nil {
+				return err
+			}
+			operation = o.Operation
+		}
+	}
+
+	return nil
+}
+
+// operationDone checks if the operation finished, the error is returned if the operation failed.
+func operationDone(operation neon.Operation) (bool, error) {
+	switch operation.Status {
+	case operationStatusFinished, operationStatusSkipped:
+		return true, nil
+	case operationStatusFailed, operationStatusError, operationStatusCancelled:
+		msg := "neon operation " + operation.ID + " " + string(operation.Action) + " " + string(operation.Status)
+		if operation.Error != "" {
+			msg += ": " + operation.Error
+		}
+		return false, errors.New(msg)
+	default:
+		return false, nil
+	}
+}
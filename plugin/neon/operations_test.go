@@ -0,0 +1,101 @@
+package neon
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	sdk "github.com/kislerdm/neon-sdk-go"
+)
+
+// mockOperationsSDKClient returns the password reset's operation with the status "running",
+// the following statuses are returned by the operation's polls.
+type mockOperationsSDKClient struct {
+	sdk.Client
+	statuses []sdk.OperationStatus
+	polls    int
+}
+
+func (m *mockOperationsSDKClient) ResetProjectBranchRolePassword(string, string, string) (sdk.RoleOperations, error) {
+	return sdk.RoleOperations{
+		OperationsResponse: sdk.OperationsResponse{
+			Operations: []sdk.Operation{{ID: "op-foo", Action: "apply_config", Status: "running"}},
+		},
+		RoleResponse: sdk.RoleResponse{Role: sdk.Role{Password: placeholderPassword + "new"}},
+	}, nil
+}
+
+func (m *mockOperationsSDKClient) GetProjectOperation(_ string, operationID string) (sdk.OperationResponse, error) {
+	status := m.statuses[len(m.statuses)-1]
+	if m.polls < len(m.statuses) {
+		status = m.statuses[m.polls]
+	}
+	m.polls++
+	return sdk.OperationResponse{Operation: sdk.Operation{ID: operationID, Action: "apply_config", Status: status}}, nil
+}
+
+func Test_dbClient_Create_OperationsTimeout(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []sdk.OperationStatus
+		wantPolls int
+		wantErr   bool
+		wantErrIs error
+	}{
+		{
+			name:      "happy path: running then finished",
+			statuses:  []sdk.OperationStatus{"running", "finished"},
+			wantPolls: 2,
+		},
+		{
+			name:      "unhappy path: operation failed",
+			statuses:  []sdk.OperationStatus{"failed"},
+			wantPolls: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "unhappy path: timeout",
+			statuses:  []sdk.OperationStatus{"running"},
+			wantErr:   true,
+			wantErrIs: ErrOperationsTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				m := &mockOperationsSDKClient{statuses: tt.statuses}
+				c := dbClient{
+					c: m,
+					cfg: Config{
+						OperationsTimeout: 50 * time.Millisecond, OperationsPollInterval: time.Millisecond,
+					},
+				}
+
+				s := &SecretUser{
+					User:         "qux",
+					Password:     placeholderPassword,
+					Host:         "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+					ProjectID:    "foo",
+					BranchID:     "br-bar",
+					DatabaseName: "baz",
+				}
+				err := c.Create(context.TODO(), s)
+
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("Create() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+					t.Errorf("Create() error = %v, want %v", err, tt.wantErrIs)
+				}
+
+				if tt.wantPolls > 0 && m.polls != tt.wantPolls {
+					t.Errorf("Create() polled the operation %d times, want %d", m.polls, tt.wantPolls)
+				}
+				if !tt.wantErr && s.Password != placeholderPassword+"new" {
+					t.Errorf("Create() shall set the new password")
+				}
+			},
+		)
+	}
+}
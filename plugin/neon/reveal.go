@@ -0,0 +1,30 @@
+package neon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	lambda "github.com/kislerdm/aws-lambda-secret-rotation"
+)
+
+// ErrPasswordMismatch the pending secret's password does not match the role's password revealed by Neon API.
+var ErrPasswordMismatch = errors.New("password mismatch")
+
+// validateRolePassword checks that the secret's password matches the role's password revealed by Neon API,
+// i.e. the password reset by Create was applied.
+func (c dbClient) validateRolePassword(ctx context.Context, s *SecretUser) error {
+	lambda.RecordFeature(ctx, featureValidateOnlySet)
+
+	o, err := c.c.GetProjectBranchRolePassword(s.ProjectID, s.BranchID, s.User)
+	if err != nil {
+		return fmt.Errorf("reveal the password of the role %s: %w", s.User, err)
+	}
+
+	if o.Password != s.Password {
+		return fmt.Errorf(
+			"%w: the pending password does not match the password of the role %s in Neon", ErrPasswordMismatch, s.User,
+		)
+	}
+	return nil
+}
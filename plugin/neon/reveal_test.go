@@ -0,0 +1,89 @@
+package neon
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	sdk "github.com/kislerdm/neon-sdk-go"
+)
+
+// mockRevealSDKClient counts the password resets, and reveals the password set by the last reset,
+// or revealedPassword if set.
+type mockRevealSDKClient struct {
+	sdk.Client
+	revealedPassword string
+	password         string
+	resets           int
+	reveals          int
+}
+
+func (m *mockRevealSDKClient) ResetProjectBranchRolePassword(string, string, string) (sdk.RoleOperations, error) {
+	m.resets++
+	m.password = placeholderPassword + "new"
+	return sdk.RoleOperations{RoleResponse: sdk.RoleResponse{Role: sdk.Role{Password: m.password}}}, nil
+}
+
+func (m *mockRevealSDKClient) GetProjectBranchRolePassword(string, string, string) (sdk.RolePasswordResponse, error) {
+	m.reveals++
+	if m.revealedPassword != "" {
+		return sdk.RolePasswordResponse{Password: m.revealedPassword}, nil
+	}
+	return sdk.RolePasswordResponse{Password: m.password}, nil
+}
+
+func Test_dbClient_Set_ValidateOnlySet(t *testing.T) {
+	tests := []struct {
+		name             string
+		revealedPassword string
+		wantErr          error
+	}{
+		{
+			name: "happy path: password matches",
+		},
+		{
+			name:             "unhappy path: password mismatch",
+			revealedPassword: "foo",
+			wantErr:          ErrPasswordMismatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				m := &mockRevealSDKClient{revealedPassword: tt.revealedPassword}
+				c := dbClient{
+					c:   m,
+					cfg: Config{ValidateOnlySet: true, NeonAPIFallbackToSQL: true},
+					connect: func(string) (db, error) {
+						t.Fatal("Set() shall not connect to the database")
+						return nil, nil
+					},
+				}
+
+				current := &SecretUser{
+					User:         "qux",
+					Password:     placeholderPassword,
+					Host:         "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+					ProjectID:    "foo",
+					BranchID:     "br-bar",
+					DatabaseName: "baz",
+				}
+				pending := *current
+				if err := c.Create(context.TODO(), &pending); err != nil {
+					t.Fatalf("Create() unexpected error = %v", err)
+				}
+
+				if err := c.Set(context.TODO(), current, &pending, nil); !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Set() error = %v, want %v", err, tt.wantErr)
+				}
+
+				if m.resets != 1 {
+					t.Errorf("the password is reset %d times, want 1", m.resets)
+				}
+				if m.reveals != 1 {
+					t.Errorf("the password is revealed %d times, want 1", m.reveals)
+				}
+			},
+		)
+	}
+}
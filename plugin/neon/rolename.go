@@ -0,0 +1,45 @@
+package neon
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxRoleNameLength the maximum length of the Postgres identifier in bytes, i.e. NAMEDATALEN-1.
+const maxRoleNameLength = 63
+
+// RoleName derives the role name from the base role name and the suffix, e.g. the role's clone per rotation.
+// The base name is truncated to fit the Postgres limit of 63 bytes, so the suffix which makes the name unique
+// is preserved. The multibyte characters are not split, and the warning is logged when the name is truncated.
+func RoleName(base, suffix string) (string, error) {
+	base = strings.TrimSpace(base)
+	suffix = strings.TrimSpace(suffix)
+	if base == "" {
+		return "", errors.New("base role name must be set")
+	}
+	if !utf8.ValidString(base) || !utf8.ValidString(suffix) {
+		return "", errors.New("role name must be valid UTF-8 string")
+	}
+
+	if suffix != "" {
+		suffix = "_" + suffix
+	}
+	if len(suffix) >= maxRoleNameLength {
+		return "", errors.New("role name suffix " + suffix + " exceeds the limit of 63 bytes")
+	}
+
+	if len(base)+len(suffix) <= maxRoleNameLength {
+		return base + suffix, nil
+	}
+
+	truncated := base[:maxRoleNameLength-len(suffix)]
+	for !utf8.ValidString(truncated) {
+		truncated = truncated[:len(truncated)-1]
+	}
+
+	o := truncated + suffix
+	log.Println("[WARN] role name " + base + suffix + " exceeds the limit of 63 bytes, truncated to " + o)
+	return o, nil
+}
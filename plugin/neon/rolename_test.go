@@ -0,0 +1,68 @@
+package neon
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRoleName(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    string
+		suffix  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "short name",
+			base:   "qux",
+			suffix: "1a2b",
+			want:   "qux_1a2b",
+		},
+		{
+			name: "no suffix",
+			base: "qux",
+			want: "qux",
+		},
+		{
+			name:   "long base name is truncated",
+			base:   strings.Repeat("a", 70),
+			suffix: "1a2b",
+			want:   strings.Repeat("a", 58) + "_1a2b",
+		},
+		{
+			name:   "multibyte characters are not split",
+			base:   strings.Repeat("a", 57) + "ü" + "bar",
+			suffix: "1a2b",
+			want:   strings.Repeat("a", 57) + "_1a2b",
+		},
+		{
+			name:    "empty base name",
+			base:    " ",
+			suffix:  "1a2b",
+			wantErr: true,
+		},
+		{
+			name:    "long suffix",
+			base:    "qux",
+			suffix:  strings.Repeat("b", 63),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := RoleName(tt.base, tt.suffix)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("RoleName() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if got != tt.want {
+					t.Errorf("RoleName() got = %v, want %v", got, tt.want)
+				}
+				if len(got) > maxRoleNameLength {
+					t.Errorf("RoleName() length = %d exceeds %d", len(got), maxRoleNameLength)
+				}
+			},
+		)
+	}
+}
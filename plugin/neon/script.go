@@ -0,0 +1,180 @@
+package neon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrTestScriptStatementNotAllowed the test script's statement controls the transaction, or the session's settings,
+// e.g. COMMIT, which could end the read-only transaction the statement runs in.
+var ErrTestScriptStatementNotAllowed = errors.New("test script statement not allowed")
+
+// notAllowedScriptKeywords the leading keywords of the statements which control the transaction,
+// or the session's settings, hence could escape the read-only transaction.
+var notAllowedScriptKeywords = map[string]struct{}{
+	"ABORT": {}, "BEGIN": {}, "COMMIT": {}, "DISCARD": {}, "END": {}, "PREPARE": {}, "RELEASE": {}, "RESET": {},
+	"ROLLBACK": {}, "SAVEPOINT": {}, "SET": {}, "START": {},
+}
+
+// queryReadOnly wraps the statement into the read-only transaction which is rolled back, hence the statement
+// cannot change the data even if the role is permitted to. The session's transactions are set read-only as well,
+// so the statement which ends the transaction, e.g. "COMMIT; DELETE FROM foo", cannot change the data either.
+func queryReadOnly(statement string) string {
+	return "SET SESSION CHARACTERISTICS AS TRANSACTION READ ONLY; BEGIN READ ONLY; " + statement + "; ROLLBACK"
+}
+
+// checkScriptStatement returns ErrTestScriptStatementNotAllowed if the statement controls the transaction,
+// or the session's settings.
+func checkScriptStatement(statement string) error {
+	keyword := strings.ToUpper(firstKeyword(statement))
+	if _, ok := notAllowedScriptKeywords[keyword]; ok {
+		return fmt.Errorf("%w: %s", ErrTestScriptStatementNotAllowed, keyword)
+	}
+	return nil
+}
+
+// firstKeyword returns the statement's first word skipping the leading comments.
+func firstKeyword(statement string) string {
+	for {
+		statement = strings.TrimSpace(statement)
+		switch {
+		case strings.HasPrefix(statement, "--"):
+			_, statement, _ = strings.Cut(statement, "\n")
+		case strings.HasPrefix(statement, "/*"):
+			statement = statement[blockCommentEnd(statement):]
+		default:
+			end := strings.IndexFunc(
+				statement, func(r rune) bool {
+					return !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+				},
+			)
+			if end < 0 {
+				return statement
+			}
+			return statement[:end]
+		}
+	}
+}
+
+// runTestScript runs the script's statements one by one in the read-only transactions,
+// it fails on the first statement which errors. The statements which control the transaction,
+// or the session's settings are rejected before any statement runs.
+func runTestScript(ctx context.Context, db db, script string) error {
+	statements := splitStatements(script)
+	for i, statement := range statements {
+		if err := checkScriptStatement(statement); err != nil {
+			return fmt.Errorf("test script statement #%d: %w", i+1, err)
+		}
+	}
+
+	for i, statement := range statements {
+		if _, err := db.ExecContext(ctx, queryReadOnly(statement)); err != nil {
+			return fmt.Errorf("test script statement #%d failed: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// splitStatements splits the SQL script into the statements separated by semicolons. The semicolons
+// within the quoted strings, the escape strings, the quoted identifiers, the dollar-quoted strings
+// and the comments are preserved.
+func splitStatements(script string) []string {
+	var (
+		o     []string
+		start int
+	)
+
+	appendStatement := func(end int) {
+		if s := strings.TrimSpace(script[start:end]); s != "" {
+			o = append(o, s)
+		}
+	}
+
+	for i := 0; i < len(script); i++ {
+		switch ch := script[i]; {
+		case (ch == 'E' || ch == 'e') && strings.HasPrefix(script[i+1:], "'") && !isIdentifierByte(script, i-1):
+			// the escape string's quote can be escaped by the backslash
+			i++
+			for i++; i < len(script) && script[i] != '\''; i++ {
+				if script[i] == '\\' {
+					i++
+				}
+			}
+		case ch == '\'' || ch == '"':
+			// the escaped quote is doubled, hence it's skipped as two consecutive quoted strings
+			if j := strings.IndexByte(script[i+1:], ch); j >= 0 {
+				i += j + 1
+			} else {
+				i = len(script)
+			}
+		case ch == '/' && strings.HasPrefix(script[i:], "/*"):
+			i += blockCommentEnd(script[i:]) - 1
+		case ch == '-' && strings.HasPrefix(script[i:], "--"):
+			if j := strings.IndexByte(script[i:], '\n'); j >= 0 {
+				i += j
+			} else {
+				i = len(script)
+			}
+		case ch == '$':
+			if tag := dollarQuoteTag(script[i:]); tag != "" {
+				if j := strings.Index(script[i+len(tag):], tag); j >= 0 {
+					i += len(tag) + j + len(tag) - 1
+				} else {
+					i = len(script)
+				}
+			}
+		case ch == ';':
+			appendStatement(i)
+			start = i + 1
+		}
+	}
+	appendStatement(len(script))
+
+	return o
+}
+
+// blockCommentEnd returns the index following the end of the block comment the string starts with,
+// the nested block comments are skipped.
+func blockCommentEnd(s string) int {
+	var depth int
+	for i := 0; i < len(s)-1; i++ {
+		switch s[i : i+2] {
+		case "/*":
+			depth++
+			i++
+		case "*/":
+			depth--
+			i++
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(s)
+}
+
+// isIdentifierByte reports whether the script's byte at the index belongs to the identifier, or the keyword.
+func isIdentifierByte(script string, i int) bool {
+	if i < 0 {
+		return false
+	}
+	ch := script[i]
+	return ch == '_' || ch == '$' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9'
+}
+
+// dollarQuoteTag returns the dollar quote's tag the string starts with, e.g. "$$", or "$body$".
+// Empty string is returned if the string does not start with the tag, e.g. the positional parameter "$1".
+func dollarQuoteTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		switch ch := s[i]; {
+		case ch == '$':
+			return s[:i+1]
+		case ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || i > 1 && ch >= '0' && ch <= '9':
+		default:
+			return ""
+		}
+	}
+	return ""
+}
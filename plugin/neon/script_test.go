@@ -0,0 +1,178 @@
+package neon
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func Test_splitStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{
+			name:   "statements separated by semicolons",
+			script: "SELECT 1;\n SELECT 2 ;\n\n",
+			want:   []string{"SELECT 1", "SELECT 2"},
+		},
+		{
+			name:   "single statement without semicolon",
+			script: "SELECT 1",
+			want:   []string{"SELECT 1"},
+		},
+		{
+			name:   "semicolons within quoted strings and identifiers",
+			script: `SELECT 'foo;''bar' FROM "baz;qux"; SELECT 2`,
+			want:   []string{`SELECT 'foo;''bar' FROM "baz;qux"`, "SELECT 2"},
+		},
+		{
+			name:   "semicolons within dollar-quoted strings",
+			script: "DO $body$ BEGIN PERFORM 1; END $body$; SELECT $1::int; SELECT $$;$$",
+			want:   []string{"DO $body$ BEGIN PERFORM 1; END $body$", "SELECT $1::int", "SELECT $$;$$"},
+		},
+		{
+			name:   "semicolons within comments",
+			script: "-- foo; bar\nSELECT 1; SELECT 2 -- baz;",
+			want:   []string{"-- foo; bar\nSELECT 1", "SELECT 2 -- baz;"},
+		},
+		{
+			name:   "semicolons within escape strings",
+			script: `SELECT E'foo\';bar'; SELECT e'\\'; SELECT 2`,
+			want:   []string{`SELECT E'foo\';bar'`, `SELECT e'\\'`, "SELECT 2"},
+		},
+		{
+			name:   "semicolons within nested block comments",
+			script: "/* foo; /* bar; */ baz; */ SELECT 1; SELECT 2",
+			want:   []string{"/* foo; /* bar; */ baz; */ SELECT 1", "SELECT 2"},
+		},
+		{
+			name:   "empty script",
+			script: " ; ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if got := splitStatements(tt.script); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("splitStatements() = %q, want %q", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func Test_dbClient_Test_TestScript(t *testing.T) {
+	const (
+		statementOK     = "SELECT count(*) FROM app.users"
+		statementFailed = "SELECT count(*) FROM app.orders"
+	)
+
+	tests := []struct {
+		name        string
+		script      string
+		execErrs    map[string]error
+		wantQueries []string
+		wantErr     bool
+	}{
+		{
+			name: "no script by default",
+		},
+		{
+			name:        "all statements succeed",
+			script:      statementOK + "; " + statementFailed + ";",
+			wantQueries: []string{queryReadOnly(statementOK), queryReadOnly(statementFailed)},
+		},
+		{
+			name:   "one of two statements fails",
+			script: statementFailed + "; " + statementOK + ";",
+			execErrs: map[string]error{
+				queryReadOnly(statementFailed): &pq.Error{
+					Code: "42501", Message: "permission denied for table orders",
+				},
+			},
+			wantQueries: []string{queryReadOnly(statementFailed)},
+			wantErr:     true,
+		},
+		{
+			name:    "unhappy path: the statement ending the read-only transaction is rejected",
+			script:  statementOK + "; COMMIT; DELETE FROM app.users",
+			wantErr: true,
+		},
+		{
+			name:    "unhappy path: the statement changing the session's settings is rejected",
+			script:  "/* the comment */ set session characteristics as transaction read write; " + statementOK,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				m := &mockRecordingDB{execErrs: tt.execErrs}
+				c := dbClient{
+					c:       newMockSDKClient(),
+					cfg:     Config{TestScript: tt.script, TestRetries: 2},
+					connect: m.connect,
+				}
+
+				err := c.Test(
+					context.TODO(), &SecretUser{
+						User:         "qux",
+						Password:     placeholderPassword,
+						Host:         "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+						DatabaseName: "baz",
+					},
+				)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("Test() error = %v, wantErr %v", err, tt.wantErr)
+				}
+
+				var got []string
+				for _, q := range m.queries {
+					got = append(got, q.query)
+				}
+				if !reflect.DeepEqual(got, tt.wantQueries) {
+					t.Errorf("Test() queries = %v, want %v", got, tt.wantQueries)
+				}
+			},
+		)
+	}
+}
+
+func Test_checkScriptStatement(t *testing.T) {
+	tests := []struct {
+		statement string
+		wantErr   bool
+	}{
+		{statement: "SELECT count(*) FROM app.users"},
+		{statement: "-- the comment\nWITH u AS (SELECT 1) SELECT * FROM u"},
+		{statement: "DO $$ BEGIN PERFORM 1; END $$"},
+		{statement: "BEGIN", wantErr: true},
+		{statement: "start transaction read write", wantErr: true},
+		{statement: "COMMIT", wantErr: true},
+		{statement: "End", wantErr: true},
+		{statement: "ROLLBACK", wantErr: true},
+		{statement: "ABORT", wantErr: true},
+		{statement: "PREPARE TRANSACTION 'foo'", wantErr: true},
+		{statement: "SET default_transaction_read_only = off", wantErr: true},
+		{statement: "RESET ALL", wantErr: true},
+		{statement: "/* foo /* bar */ */ COMMIT", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.statement, func(t *testing.T) {
+				err := checkScriptStatement(tt.statement)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("checkScriptStatement() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if tt.wantErr && !errors.Is(err, ErrTestScriptStatementNotAllowed) {
+					t.Errorf("checkScriptStatement() error = %v, want %v", err, ErrTestScriptStatementNotAllowed)
+				}
+			},
+		)
+	}
+}
@@ -4,33 +4,724 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	lambda "github.com/kislerdm/aws-lambda-secret-rotation"
 	neon "github.com/kislerdm/neon-sdk-go"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
+// Config defines the `ServiceClient`'s configuration.
+type Config struct {
+	// Dialer (optional) dials the database connections, e.g. through a proxy, or the endpoint's IP address.
+	// Note that Neon routes connections by SNI, hence TLS ServerName is always set to the secret's host
+	// regardless of the dialed address.
+	Dialer pq.Dialer
+
+	// SSLRootCert (optional) the path to the file with the CA certificates to verify the server's certificate,
+	// the embedded CA certificates which sign Neon's certificates are used by default, see DefaultCACertPool.
+	SSLRootCert string
+
+	// TerminateExistingSessions set to `true` to terminate the role's sessions opened before the password change.
+	// The session used to terminate other sessions is preserved.
+	TerminateExistingSessions bool
+
+	// SessionDrainGrace (optional) the grace period to drain the role's sessions when TerminateExistingSessions is set.
+	// Only the sessions idle for longer than the grace period are terminated if set, the sessions running transactions,
+	// or idle for shorter period are preserved.
+	SessionDrainGrace time.Duration
+
+	// DeferTestOnSuspendedEndpoint set to `true` to defer the secret's test if the connection fails
+	// while the endpoint is suspended according to Neon API. ErrEndpointSuspended is returned in that case,
+	// so the rotation step is retried by Secretsmanager instead of failing the rotation because of the cold start.
+	DeferTestOnSuspendedEndpoint bool
+
+	// TestRetries the number of times to retry the secret's test after the connection failed with the retryable error.
+	TestRetries int
+
+	// TestRetryInterval the interval between the secret's test attempts.
+	TestRetryInterval time.Duration
+
+	// ValidateOnlySet set to `true` to make Set validate-only when the password is reset by Neon API in Create:
+	// Set confirms that the pending secret's password matches the role's password revealed by Neon API,
+	// and fails with ErrPasswordMismatch otherwise. The role's password is never modified by Set, hence
+	// NeonAPIFallbackToSQL is not applied by Set. It is ignored if RotateUsername is set.
+	ValidateOnlySet bool
+
+	// OperationsTimeout (optional) the maximum time to wait for the Neon API operations of the password reset
+	// to finish, e.g. to apply the new password to the compute, before the password is considered applied.
+	// The operations are not awaited if not set.
+	OperationsTimeout time.Duration
+
+	// OperationsPollInterval (optional) the interval between the polls of the operations' statuses, 1s by default.
+	OperationsPollInterval time.Duration
+
+	// ConnectLogLevel (optional) the granularity of the logs of the secret's test attempts:
+	// ConnectLogLevelDebug to log every failed attempt, e.g. to debug the retries while the compute starts,
+	// or ConnectLogLevelInfo to log only the outcome of the retried test. ConnectLogLevelInfo is used by default.
+	ConnectLogLevel string
+
+	// RetryableSQLStates the SQLSTATE codes of the transient errors to retry the secret's test,
+	// DefaultRetryableSQLStates are used if not set. The authentication error 28P01 is never retried.
+	// See: https://www.postgresql.org/docs/current/errcodes-appendix.html
+	RetryableSQLStates []string
+
+	// CanaryDatabase (optional) the database to test the secret against before other databases,
+	// the other databases are not tested if the canary test fails.
+	CanaryDatabase string
+
+	// TestDatabases (optional) the databases to test the secret against in addition to the secret's database.
+	TestDatabases []string
+
+	// ExpectedEndpointType (optional) the type of the endpoint, i.e. read_write, or read_only, the secret's host
+	// must resolve to according to Neon API. The secret's test fails without connecting to the database otherwise.
+	ExpectedEndpointType neon.EndpointType
+
+	// SearchPath (optional) the comma separated list of schemas to set as the search_path of the test session,
+	// e.g. "app, public", for the roles which rely on the search_path other than default.
+	SearchPath string
+
+	// VerifyReplication set to `true` to verify that the logical replication of the secret's database is healthy
+	// after the password change, i.e. the logical replication slots are active and the role's replication
+	// connections are streaming. It's meant for the roles used by the logical replication subscriptions.
+	VerifyReplication bool
+
+	// AllowedHostSuffixes (optional) the domains the secret's host must belong to, e.g. "neon.tech".
+	// The connection to the host outside the domains is refused to prevent the crafted secret from directing
+	// the lambda to unintended hosts, e.g. the instance metadata endpoint. Any host is allowed if not set.
+	AllowedHostSuffixes []string
+
+	// PoolerMode (optional) the mode of the connection pooler of the secret's host, i.e. PoolerModeTransaction,
+	// or PoolerModeSession. The secret's test runs the query appropriate for the mode if set: the query
+	// in the transaction mode avoids the prepared statements which are not supported by the pooler.
+	PoolerMode string
+
+	// EmitConnectionURI set to `true` to store the connection URI composed of the secret's attributes
+	// as the secret's attribute connection_uri.
+	EmitConnectionURI bool
+
+	// Metrics (optional) records the metric ConnectionLeak when the database connections opened by Set, or Test
+	// are left open, e.g. to raise the alarm because the leaked connections keep the compute active.
+	// The leaks are logged only if not set.
+	Metrics lambda.Metrics
+
+	// VerifyReadWrite set to `true` to verify that the secret's host is not in recovery, i.e. not a read replica,
+	// before the secret is set. ErrReadOnly is returned otherwise.
+	VerifyReadWrite bool
+
+	// RequireAllHosts set to `true` to require that the secret's test succeeds against all hosts of the secret
+	// with SecretUser.Hosts set. Otherwise, the test succeeds if at least one host passes.
+	RequireAllHosts bool
+
+	// ResolveReadWriteEndpoint set to `true` to set the secret using the branch's read_write endpoint resolved
+	// by Neon API if the secret's host is read-only according to VerifyReadWrite.
+	ResolveReadWriteEndpoint bool
+
+	// RotateUsername set to `true` to rotate the role together with the password: Create creates the role with
+	// the name derived from the secret's base role name, see RoleName; Set grants the privileges of the current role
+	// to the new role; Finish transfers the objects owned by the previous role to the new role and drops it.
+	// Note that the current role must be able to grant its privileges and the membership in itself.
+	RotateUsername bool
+
+	// Verifiers (optional) the Verifier instances to test the secret by its kind, e.g. the kind configured
+	// as lambda.Config.SecretKind for the MySQL proxy. The Postgres verifier is used for the kinds not registered.
+	// The secret's test retries, the hosts and the databases to test apply to the registered verifiers as well.
+	Verifiers Verifiers
+
+	// NeonAPIFallbackToSQL set to `true` to rotate the password over SQL if Neon API is unreachable:
+	// Create generates the password locally, and Set changes it using the current secret's role,
+	// the fallback is logged and counted by the metric NeonAPIFallback. Note that the role must be permitted
+	// to change its password over SQL. The fallback does not apply when the username is rotated.
+	NeonAPIFallbackToSQL bool
+
+	// PasswordGenerator (optional) generates the password when NeonAPIFallbackToSQL applies,
+	// the default lambda.PasswordGenerator is used unless set.
+	PasswordGenerator lambda.PasswordGenerator
+
+	// TestScript (optional) the SQL script to run when the secret is tested, e.g. the application's queries.
+	// The script's statements separated by semicolons run one by one in the read-only transactions which are
+	// rolled back, the secret's test fails if any statement errors. The statements which control the transaction,
+	// or the session's settings are rejected, e.g. COMMIT, or SET, see ErrTestScriptStatementNotAllowed.
+	TestScript string
+
+	// RefreshHostFromNeon set to `true` to refresh the secret's host when the secret is created: the host is set
+	// to the host of the branch's read_write endpoint found with Neon API if the host does not belong to any
+	// of the branch's endpoints, e.g. after the branch is restored. The pooler's host is preserved as such.
+	RefreshHostFromNeon bool
+
+	// RequiredRoleMemberships (optional) the roles the secret's role must be a member of, e.g. "app_users".
+	// The secret's test fails if the role is not a member of any of them, e.g. the recreated role lost its memberships.
+	RequiredRoleMemberships []string
+}
+
+const (
+	// PoolerModeTransaction the pooler assigns the server connection to the client for the transaction.
+	PoolerModeTransaction = "transaction"
+
+	// PoolerModeSession the pooler assigns the server connection to the client for the session.
+	PoolerModeSession = "session"
+)
+
+// Granularity levels of the secret's test attempts logs, see Config.ConnectLogLevel.
+const (
+	ConnectLogLevelDebug = "debug"
+	ConnectLogLevelInfo  = "info"
+)
+
+// DefaultRetryableSQLStates the SQLSTATE codes of the errors which are transient on Neon, e.g. while the compute starts.
+var DefaultRetryableSQLStates = []string{
+	"08000", // connection_exception
+	"08001", // sqlclient_unable_to_establish_sqlconnection
+	"08006", // connection_failure
+	"53300", // too_many_connections
+	"57P01", // admin_shutdown
+	"57P03", // cannot_connect_now
+}
+
+// sqlStateInvalidPassword the SQLSTATE code of the authentication error, it's never retried.
+const sqlStateInvalidPassword = "28P01"
+
+// sqlStateInvalidAuthorization the SQLSTATE code of the authorization error, e.g. the role cannot log in.
+const sqlStateInvalidAuthorization = "28000"
+
+// ErrInvalidPassword the password authentication of the secret's role failed, it wraps lambda.ErrAuthFailed.
+var ErrInvalidPassword = fmt.Errorf("%w: invalid password", lambda.ErrAuthFailed)
+
+// ErrNoLoginPrivilege the secret's role is not permitted to log in, e.g. the role lacks the attribute LOGIN.
+var ErrNoLoginPrivilege = errors.New("role is not permitted to log in")
+
+// Names of the optional features exercised by the ServiceClient, see lambda.RecordFeature.
+const (
+	featureNeonAPI                      = "NeonAPI"
+	featureTerminateSessions            = "TerminateExistingSessions"
+	featureVerifyReadWrite              = "VerifyReadWrite"
+	featureResolveReadWriteEndpoint     = "ResolveReadWriteEndpoint"
+	featureDeferTestOnSuspendedEndpoint = "DeferTestOnSuspendedEndpoint"
+	featureSearchPath                   = "SearchPath"
+	featurePoolerMode                   = "PoolerMode"
+	featureVerifyReplication            = "VerifyReplication"
+	featureRotateUsername               = "RotateUsername"
+	featureNeonAPIFallbackToSQL         = "NeonAPIFallbackToSQL"
+	featureTestScript                   = "TestScript"
+	featureRefreshHost                  = "RefreshHostFromNeon"
+	featureRequiredRoleMemberships      = "RequiredRoleMemberships"
+	featureWaitForOperations            = "WaitForOperations"
+	featureValidateOnlySet              = "ValidateOnlySet"
+	featureConnectionURI                = "UpdateFromConnectionURI"
+)
+
+// ErrReadOnly the secret's host is in recovery, i.e. it's a read replica.
+var ErrReadOnly = errors.New("target is read-only")
+
+// sqlStateReadOnly the SQLSTATE code read_only_sql_transaction.
+const sqlStateReadOnly = "25006"
+
+// ErrEndpointSuspended the secret's test is deferred because the endpoint is suspended.
+var ErrEndpointSuspended = errors.New("endpoint is suspended, the secret's test is deferred")
+
 // NewServiceClient initiates the `ServiceClient` to rotate credentials for Neon user.
 func NewServiceClient(client neon.Client) lambda.ServiceClient {
-	return &dbClient{c: client}
+	return NewServiceClientWithConfig(client, Config{})
+}
+
+// NewServiceClientWithConfig initiates the `ServiceClient` to rotate credentials for Neon user
+// using custom configuration.
+// The client implements lambda.ServiceFinisher to drop the previous role when the username is rotated.
+func NewServiceClientWithConfig(client neon.Client, cfg Config) lambda.ServiceClient {
+	return &dbClient{c: client, cfg: cfg, connections: &connectionBalance{}}
 }
 
 type dbClient struct {
-	c neon.Client
+	c   neon.Client
+	cfg Config
+
+	// connect opens the database connection, pq driver is used if not set.
+	connect func(connStr string) (db, error)
+
+	// connections (optional) tracks the balance of the opened and closed database connections.
+	connections *connectionBalance
 }
 
 func (c dbClient) Set(ctx context.Context, secretCurrent, secretPending, secretPrevious any) error {
+	defer c.connections.watch(c.metrics(), "Set")()
+
+	// the writer is selected among the secret's hosts, hence the hosts are verified regardless of VerifyReadWrite
+	if s, ok := secretPending.(*SecretUser); c.cfg.VerifyReadWrite || (ok && len(s.Hosts) > 0) {
+		if !ok {
+			return errors.New("wrong secret type")
+		}
+		if c.cfg.VerifyReadWrite {
+			lambda.RecordFeature(ctx, featureVerifyReadWrite)
+		}
+
+		target, err := c.readWriteTarget(ctx, s)
+		if err != nil {
+			return err
+		}
+		secretPending = target
+	}
+
+	if c.cfg.ValidateOnlySet && !c.cfg.RotateUsername {
+		pending, ok := secretPending.(*SecretUser)
+		if !ok {
+			return errors.New("wrong secret type")
+		}
+		if err := c.validateRolePassword(ctx, pending); err != nil {
+			return err
+		}
+	}
+
+	if c.cfg.NeonAPIFallbackToSQL && !c.cfg.RotateUsername && !c.cfg.ValidateOnlySet {
+		current, ok := secretCurrent.(*SecretUser)
+		pending, okPending := secretPending.(*SecretUser)
+		if !ok || !okPending {
+			return errors.New("wrong secret type")
+		}
+		if err := c.setPasswordFallback(ctx, current, pending); err != nil {
+			return err
+		}
+	}
+
+	if c.cfg.RotateUsername {
+		current, ok := secretCurrent.(*SecretUser)
+		pending, okPending := secretPending.(*SecretUser)
+		if !ok || !okPending {
+			return errors.New("wrong secret type")
+		}
+		if err := c.cloneRole(ctx, current, pending); err != nil {
+			return err
+		}
+	}
+
+	if c.cfg.TerminateExistingSessions {
+		lambda.RecordFeature(ctx, featureTerminateSessions)
+		return c.terminateExistingSessions(ctx, secretPending)
+	}
 	return nil
 }
 
+// queryTerminateSessions terminates the role's sessions except the session which runs the query.
+const queryTerminateSessions = `SELECT pg_terminate_backend(pid) FROM pg_stat_activity ` +
+	`WHERE usename = $1 AND pid <> pg_backend_pid()`
+
+// queryTerminateIdleSessions terminates the role's sessions which have been idle for longer than the grace period
+// defined in seconds, the sessions running transactions are preserved.
+const queryTerminateIdleSessions = queryTerminateSessions +
+	` AND state = 'idle' AND state_change < now() - make_interval(secs => $2)`
+
+// queryCheckReadWrite fails with the SQLSTATE 25006 if the server is in recovery.
+const queryCheckReadWrite = `DO $$ BEGIN IF pg_is_in_recovery() THEN ` +
+	`RAISE EXCEPTION 'server is in recovery' USING ERRCODE = '` + sqlStateReadOnly + `'; END IF; END $$`
+
+// readWriteTarget returns the secret with the first of its hosts which is not in recovery, or the secret
+// with the host of the branch's read_write endpoint if ResolveReadWriteEndpoint is set.
+func (c dbClient) readWriteTarget(ctx context.Context, s *SecretUser) (*SecretUser, error) {
+	var err error
+	for _, host := range s.hosts() {
+		v := *s
+		v.Host = host
+		if err = c.checkReadWrite(ctx, &v); err == nil {
+			return &v, nil
+		}
+		if len(s.Hosts) > 0 {
+			log.Println("[WARN] host " + host + " is not the writer: " + err.Error())
+		}
+	}
+
+	if err == nil {
+		return nil, errors.New("failed to connect")
+	}
+	if !errors.Is(err, ErrReadOnly) || !c.cfg.ResolveReadWriteEndpoint {
+		return nil, err
+	}
+
+	endpoint, errResolve := c.findReadWriteEndpoint(s)
+	if errResolve != nil {
+		return nil, fmt.Errorf("%w; %s", err, errResolve.Error())
+	}
+
+	log.Println("[WARN] " + err.Error() + ", use the read_write endpoint " + endpoint.Host)
+	lambda.RecordFeature(ctx, featureResolveReadWriteEndpoint)
+	v := *s
+	v.Host = endpoint.Host
+	if err := c.checkReadWrite(ctx, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// checkReadWrite checks that the secret's host is not in recovery.
+func (c dbClient) checkReadWrite(ctx context.Context, s *SecretUser) error {
+	db, err := c.openDBConnection(s)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	_, err = db.ExecContext(ctx, queryCheckReadWrite)
+	var e *pq.Error
+	if errors.As(err, &e) && e.Code == sqlStateReadOnly {
+		return fmt.Errorf("%w: host %s is in recovery", ErrReadOnly, s.Host)
+	}
+	return err
+}
+
+// findReadWriteEndpoint finds the read_write endpoint of the secret's branch using Neon API.
+func (c dbClient) findReadWriteEndpoint(s *SecretUser) (neon.Endpoint, error) {
+	o, err := c.c.ListProjectBranchEndpoints(s.ProjectID, s.BranchID)
+	if err != nil {
+		return neon.Endpoint{}, errors.New("failed to fetch the endpoints: " + err.Error())
+	}
+
+	for _, endpoint := range o.Endpoints {
+		if endpoint.Type == endpointTypeReadWrite && endpoint.Host != s.Host {
+			return endpoint, nil
+		}
+	}
+
+	return neon.Endpoint{}, errors.New("no read_write endpoint found for the branch " + s.BranchID)
+}
+
+func (c dbClient) terminateExistingSessions(ctx context.Context, secret any) error {
+	db, err := c.openDBConnection(secret)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	if c.cfg.SessionDrainGrace > 0 {
+		_, err = db.ExecContext(
+			ctx, queryTerminateIdleSessions, secret.(*SecretUser).User, c.cfg.SessionDrainGrace.Seconds(),
+		)
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, queryTerminateSessions, secret.(*SecretUser).User)
+	return err
+}
+
 func (c dbClient) Test(ctx context.Context, secret any) error {
+	defer c.connections.watch(c.metrics(), "Test")()
+
+	s, ok := secret.(*SecretUser)
+	if !ok {
+		return errors.New("wrong secret type")
+	}
+
+	if err := setIDsFromTags(ctx, s); err != nil {
+		log.Println("[WARN] " + err.Error())
+	}
+
+	if len(s.Hosts) > 0 {
+		return c.testHosts(ctx, s)
+	}
+	return c.testHost(ctx, s)
+}
+
+// testHosts tests the secret against its hosts, at least one host must pass unless RequireAllHosts is set.
+func (c dbClient) testHosts(ctx context.Context, s *SecretUser) error {
+	var (
+		err    error
+		passed int
+	)
+	hosts := s.hosts()
+	for _, host := range hosts {
+		v := *s
+		v.Host = host
+		if errHost := c.testHost(ctx, &v); errHost != nil {
+			log.Println("[WARN] host " + host + " test failed: " + errHost.Error())
+			if c.cfg.RequireAllHosts {
+				return fmt.Errorf("host %s: %w", host, errHost)
+			}
+			err = fmt.Errorf("host %s: %w", host, errHost)
+			continue
+		}
+		passed++
+	}
+
+	if passed == 0 {
+		return fmt.Errorf("no host of %d passed the test, last error: %w", len(hosts), err)
+	}
+	return nil
+}
+
+// testHost tests the secret against its host.
+func (c dbClient) testHost(ctx context.Context, s *SecretUser) error {
+	if c.cfg.ExpectedEndpointType != "" {
+		if err := c.checkEndpointType(s); err != nil {
+			return err
+		}
+	}
+
+	if c.cfg.CanaryDatabase == "" && len(c.cfg.TestDatabases) == 0 {
+		return c.testDatabase(ctx, s)
+	}
+
+	for i, dbname := range c.testDatabases(s) {
+		v := *s
+		v.DatabaseName = dbname
+		if err := c.testDatabase(ctx, &v); err != nil {
+			if i == 0 && c.cfg.CanaryDatabase != "" {
+				log.Println("[ERROR] canary database " + dbname + " test failed, other databases are skipped")
+			} else {
+				log.Println("[ERROR] database " + dbname + " test failed")
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// testDatabases returns the names of the databases to test the secret against, the canary database goes first.
+func (c dbClient) testDatabases(s *SecretUser) []string {
+	var o []string
+	seen := map[string]struct{}{}
+	for _, dbname := range append([]string{c.cfg.CanaryDatabase, s.DatabaseName}, c.cfg.TestDatabases...) {
+		if _, ok := seen[dbname]; ok || dbname == "" {
+			continue
+		}
+		seen[dbname] = struct{}{}
+		o = append(o, dbname)
+	}
+	return o
+}
+
+func (c dbClient) testDatabase(ctx context.Context, secret *SecretUser) error {
+	verify := c.verifier(ctx)
+
+	var (
+		err      error
+		attempts int
+	)
+	for attempts = 1; ; attempts++ {
+		if err = verify(ctx, secret); err == nil || attempts > c.cfg.TestRetries || !c.isRetryable(err) {
+			break
+		}
+
+		if c.cfg.ConnectLogLevel == ConnectLogLevelDebug {
+			log.Println(
+				"[DEBUG] the secret's test attempt " + strconv.Itoa(attempts) + " failed, retry after the error: " +
+					err.Error(),
+			)
+		}
+		lambda.RecordRetry(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.cfg.TestRetryInterval):
+		}
+	}
+	logTestOutcome(attempts, err)
+
+	if err := authError(err); err != nil {
+		return err
+	}
+
+	if err != nil && c.cfg.DeferTestOnSuspendedEndpoint && c.isEndpointSuspended(secret) {
+		log.Println("[WARN] failed to connect to the suspended endpoint: " + err.Error())
+		lambda.RecordFeature(ctx, featureDeferTestOnSuspendedEndpoint)
+		return ErrEndpointSuspended
+	}
+
+	return err
+}
+
+func (c dbClient) ping(ctx context.Context, secret any) error {
 	db, err := c.openDBConnection(secret)
 	if err != nil {
 		return err
 	}
 	defer func() { _ = db.Close() }()
 
-	return db.PingContext(ctx)
+	if c.cfg.SearchPath != "" {
+		lambda.RecordFeature(ctx, featureSearchPath)
+		if _, err := db.ExecContext(ctx, querySetSearchPath(c.cfg.SearchPath)); err != nil {
+			return err
+		}
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		return err
+	}
+
+	if c.cfg.PoolerMode != "" {
+		lambda.RecordFeature(ctx, featurePoolerMode)
+		if err := probePooler(ctx, db, c.cfg.PoolerMode); err != nil {
+			return err
+		}
+	}
+
+	if c.cfg.VerifyReplication {
+		lambda.RecordFeature(ctx, featureVerifyReplication)
+		if _, err := db.ExecContext(ctx, queryCheckReplication); err != nil {
+			return errors.New("unhealthy replication: " + err.Error())
+		}
+	}
+
+	if len(c.cfg.RequiredRoleMemberships) > 0 {
+		lambda.RecordFeature(ctx, featureRequiredRoleMemberships)
+		if err := checkRoleMemberships(ctx, db, c.cfg.RequiredRoleMemberships); err != nil {
+			return err
+		}
+	}
+
+	if c.cfg.TestScript != "" {
+		lambda.RecordFeature(ctx, featureTestScript)
+		if err := runTestScript(ctx, db, c.cfg.TestScript); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// probePooler runs the query appropriate for the pooler's mode: the query without parameters is sent
+// using the simple query protocol in the transaction mode, hence no statement is prepared; the parametrised query
+// is sent using the extended query protocol in the session mode.
+func probePooler(ctx context.Context, db db, mode string) error {
+	var err error
+	switch mode {
+	case PoolerModeTransaction:
+		_, err = db.ExecContext(ctx, "SELECT 1")
+	case PoolerModeSession:
+		_, err = db.ExecContext(ctx, "SELECT $1::int", 1)
+	default:
+		return errors.New("unsupported pooler mode " + mode)
+	}
+	if err != nil {
+		return errors.New("pooler probe failed: " + err.Error())
+	}
+	return nil
+}
+
+// queryCheckReplication fails if the database's logical replication slots are inactive,
+// or the replication connections of the session's role are not streaming.
+const queryCheckReplication = `DO $$ BEGIN ` +
+	`IF EXISTS (SELECT 1 FROM pg_replication_slots ` +
+	`WHERE slot_type = 'logical' AND database = current_database() AND NOT active) THEN ` +
+	`RAISE EXCEPTION 'inactive logical replication slot'; END IF; ` +
+	`IF EXISTS (SELECT 1 FROM pg_stat_replication ` +
+	`WHERE usename = current_user AND state NOT IN ('streaming', 'catchup')) THEN ` +
+	`RAISE EXCEPTION 'replication connection is not streaming'; END IF; ` +
+	`END $$`
+
+// querySetSearchPath returns the query to set the search_path, the schemas are quoted as identifiers.
+func querySetSearchPath(searchPath string) string {
+	var schemas []string
+	for _, schema := range strings.Split(searchPath, ",") {
+		if schema = strings.TrimSpace(schema); schema != "" {
+			schemas = append(schemas, pq.QuoteIdentifier(schema))
+		}
+	}
+	return "SET search_path TO " + strings.Join(schemas, ", ")
+}
+
+// authError distinguishes the role without the LOGIN privilege from the wrong password,
+// nil is returned if the error is not the authentication error.
+func authError(err error) error {
+	var e *pq.Error
+	if !errors.As(err, &e) {
+		return nil
+	}
+
+	switch e.Code {
+	case sqlStateInvalidPassword:
+		return fmt.Errorf("%w: %s", ErrInvalidPassword, e.Message)
+	case sqlStateInvalidAuthorization:
+		return fmt.Errorf("%w: %s", ErrNoLoginPrivilege, e.Message)
+	default:
+		return nil
+	}
+}
+
+// logTestOutcome logs the outcome of the retried secret's test.
+func logTestOutcome(attempts int, err error) {
+	if attempts <= 1 {
+		return
+	}
+	if err != nil {
+		log.Println("[INFO] the secret's test failed after " + strconv.Itoa(attempts) + " attempts: " + err.Error())
+		return
+	}
+	log.Println("[INFO] the secret's test succeeded after " + strconv.Itoa(attempts) + " attempts")
+}
+
+// isRetryable checks if the error's SQLSTATE code is retryable.
+func (c dbClient) isRetryable(err error) bool {
+	var e *pq.Error
+	if !errors.As(err, &e) || e.Code == sqlStateInvalidPassword {
+		return false
+	}
+
+	codes := c.cfg.RetryableSQLStates
+	if codes == nil {
+		codes = DefaultRetryableSQLStates
+	}
+
+	for _, code := range codes {
+		if string(e.Code) == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// endpointStateIdle the state of the suspended endpoint.
+const endpointStateIdle neon.EndpointState = "idle"
+
+// endpointTypeReadWrite the type of the branch's primary endpoint.
+const endpointTypeReadWrite neon.EndpointType = "read_write"
+
+// isEndpointSuspended checks if the endpoint of the secret's host is suspended.
+func (c dbClient) isEndpointSuspended(s *SecretUser) bool {
+	endpoint, err := c.findEndpoint(s)
+	if err != nil {
+		log.Println("[WARN] " + err.Error())
+		return false
+	}
+	return endpoint.CurrentState == endpointStateIdle
+}
+
+// checkEndpointType checks that the secret's host resolves to the endpoint of the expected type.
+func (c dbClient) checkEndpointType(s *SecretUser) error {
+	endpoint, err := c.findEndpoint(s)
+	if err != nil {
+		return err
+	}
+
+	if endpoint.Type != c.cfg.ExpectedEndpointType {
+		return errors.New(
+			"endpoint " + endpoint.ID + " of the host " + s.Host + " has the type " + string(endpoint.Type) +
+				", expected " + string(c.cfg.ExpectedEndpointType),
+		)
+	}
+
+	return nil
+}
+
+// findEndpoint finds the endpoint of the secret's host using Neon API.
+func (c dbClient) findEndpoint(s *SecretUser) (neon.Endpoint, error) {
+	o, err := c.c.ListProjectBranchEndpoints(s.ProjectID, s.BranchID)
+	if err != nil {
+		return neon.Endpoint{}, errors.New("failed to fetch the endpoints: " + err.Error())
+	}
+
+	// the host is prefixed with the endpoint ID, e.g. ep-foo-123456.us-east-2.aws.neon.tech,
+	// or ep-foo-123456-pooler.us-east-2.aws.neon.tech when connection pooling is used
+	endpointID := strings.TrimSuffix(strings.SplitN(s.Host, ".", 2)[0], "-pooler")
+	for _, endpoint := range o.Endpoints {
+		if endpoint.Host == s.Host || endpoint.ID == endpointID {
+			return endpoint, nil
+		}
+	}
+
+	return neon.Endpoint{}, errors.New("no endpoint found for the host " + s.Host)
 }
 
 func (c dbClient) Create(ctx context.Context, secret any) error {
@@ -39,12 +730,46 @@ func (c dbClient) Create(ctx context.Context, secret any) error {
 		return errors.New("wrong secret type")
 	}
 
-	o, err := c.c.ResetProjectBranchRolePassword(s.ProjectID, s.BranchID, s.User)
-	if err != nil {
+	if err := setIDsFromTags(ctx, s); err != nil {
+		return err
+	}
+
+	if c.cfg.RefreshHostFromNeon {
+		if err := c.refreshHost(ctx, s); err != nil {
+			return err
+		}
+	}
+
+	if c.cfg.RotateUsername {
+		if err := c.createRole(ctx, s); err != nil {
+			return err
+		}
+	} else {
+		o, err := c.resetPassword(ctx, s)
+		switch {
+		case err == nil:
+			lambda.RecordFeature(ctx, featureNeonAPI)
+			if c.cfg.OperationsTimeout > 0 {
+				if err := c.waitForOperations(ctx, s.ProjectID, o.Operations); err != nil {
+					return fmt.Errorf("wait for the password reset: %w", err)
+				}
+			}
+		case c.cfg.NeonAPIFallbackToSQL && isNeonAPIUnreachable(err):
+			if err := c.generatePasswordFallback(ctx, s, err); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+	}
+
+	if err := checkDSNRoundTrip(s); err != nil {
 		return err
 	}
 
-	s.Password = o.RoleResponse.Role.Password
+	if c.cfg.EmitConnectionURI {
+		s.ConnectionURI = s.connectionURI()
+	}
 
 	return nil
 }
@@ -52,6 +777,7 @@ func (c dbClient) Create(ctx context.Context, secret any) error {
 type db interface {
 	Close() error
 	PingContext(ctx context.Context) error
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
 }
 
 type mockDB struct {
@@ -69,7 +795,32 @@ func (m mockDB) PingContext(ctx context.Context) error {
 	return nil
 }
 
+func (m mockDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return nil, m.PingContext(ctx)
+}
+
+// sslRootCert returns the path to the file with the CA certificates to verify the server's certificate:
+// the secret's inline certificates, the configured file, or the embedded CA certificates, in that order.
+func (c dbClient) sslRootCert(s *SecretUser) (string, error) {
+	if s.SSLRootCert != "" {
+		return inlineCAFile(s.SSLRootCert)
+	}
+	if c.cfg.SSLRootCert != "" {
+		return c.cfg.SSLRootCert, nil
+	}
+	return defaultCAFile()
+}
+
+// openDBConnection opens the database connection, the connection is tracked until it's closed.
 func (c dbClient) openDBConnection(secret any) (db, error) {
+	conn, err := c.dial(secret)
+	if err != nil {
+		return nil, err
+	}
+	return c.connections.track(conn), nil
+}
+
+func (c dbClient) dial(secret any) (db, error) {
 	s, ok := secret.(*SecretUser)
 	if !ok {
 		return nil, errors.New("wrong secret type")
@@ -79,15 +830,12 @@ func (c dbClient) openDBConnection(secret any) (db, error) {
 		return nil, errors.New("failed to connect")
 	}
 
-	connStr := "user=" + s.User +
-		" dbname=" + s.DatabaseName +
-		" host=" + s.Host +
-		" sslmode=verify-full"
-
-	if s.Password != "" {
-		connStr += " password=" + s.Password
+	if !isHostAllowed(s.Host, c.cfg.AllowedHostSuffixes) {
+		return nil, errors.New("host " + s.Host + " is not allowed")
 	}
 
+	connStr := s.connString()
+
 	if s.Host == "dev" {
 		if s.DatabaseName == "fail" {
 			return mockDB{FailedPing: true}, nil
@@ -95,5 +843,39 @@ func (c dbClient) openDBConnection(secret any) (db, error) {
 		return mockDB{}, nil
 	}
 
-	return sql.Open("postgres", connStr)
+	if c.connect != nil {
+		return c.connect(connStr)
+	}
+
+	rootCert, err := c.sslRootCert(s)
+	if err != nil {
+		return nil, err
+	}
+
+	connector, err := pq.NewConnector(connStr + " sslrootcert=" + quoteDSNValue(rootCert))
+	if err != nil {
+		return nil, err
+	}
+	if c.cfg.Dialer != nil {
+		connector.Dialer(c.cfg.Dialer)
+	}
+
+	return sql.OpenDB(connector), nil
+}
+
+// isHostAllowed checks that the host belongs to one of the domains, any host is allowed if no domains set.
+func isHostAllowed(host string, suffixes []string) bool {
+	if len(suffixes) == 0 {
+		return true
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, suffix := range suffixes {
+		suffix = strings.ToLower(strings.Trim(strings.TrimSpace(suffix), "."))
+		if suffix != "" && (host == suffix || strings.HasSuffix(host, "."+suffix)) {
+			return true
+		}
+	}
+
+	return false
 }
@@ -1,10 +1,28 @@
 package neon
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	lambda "github.com/kislerdm/aws-lambda-secret-rotation"
 	sdk "github.com/kislerdm/neon-sdk-go"
+	"github.com/lib/pq"
 )
 
 func newMockSDKClient() sdk.Client {
@@ -195,3 +213,1307 @@ func Test_clientDB_TryConnection(t *testing.T) {
 		)
 	}
 }
+
+// mockDialer routes the connections to the mock Postgres server which reads the SNI from TLS ClientHello.
+type mockDialer struct {
+	mu              sync.Mutex
+	addresses       []string
+	serverNames     chan string
+	serverTLSConfig *tls.Config
+}
+
+func newMockDialer() *mockDialer {
+	return &mockDialer{serverNames: make(chan string, 10)}
+}
+
+func (d *mockDialer) Dial(network, address string) (net.Conn, error) {
+	d.mu.Lock()
+	d.addresses = append(d.addresses, address)
+	d.mu.Unlock()
+
+	client, server := net.Pipe()
+	go d.serve(server)
+	return client, nil
+}
+
+func (d *mockDialer) DialTimeout(network, address string, _ time.Duration) (net.Conn, error) {
+	return d.Dial(network, address)
+}
+
+func (d *mockDialer) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	// SSLRequest message: int32 length, int32 code
+	sslRequest := make([]byte, 8)
+	if _, err := io.ReadFull(conn, sslRequest); err != nil {
+		return
+	}
+	if _, err := conn.Write([]byte("S")); err != nil {
+		return
+	}
+
+	cfg := d.serverTLSConfig
+	if cfg == nil {
+		cfg = &tls.Config{
+			GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+				d.serverNames <- hello.ServerName
+				return nil, errors.New("handshake aborted by the mock server")
+			},
+		}
+	}
+	_ = tls.Server(conn, cfg).Handshake()
+}
+
+func Test_dbClient_Dialer(t *testing.T) {
+	const endpoint = "ep-foo-bar-123456.us-east-2.aws.neon.tech"
+
+	dialer := newMockDialer()
+	c := dbClient{
+		c:   newMockSDKClient(),
+		cfg: Config{Dialer: dialer},
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
+	defer cancel()
+
+	err := c.Test(
+		ctx, &SecretUser{
+			User:         "qux",
+			Password:     placeholderPassword,
+			Host:         endpoint,
+			ProjectID:    "foo",
+			BranchID:     "br-bar",
+			DatabaseName: "baz",
+		},
+	)
+	if err == nil {
+		t.Fatalf("Test() expected error because the mock server aborts TLS handshake")
+	}
+
+	select {
+	case got := <-dialer.serverNames:
+		if got != endpoint {
+			t.Errorf("TLS ServerName = %v, want %v", got, endpoint)
+		}
+	default:
+		t.Fatalf("TLS ClientHello was not received")
+	}
+
+	if len(dialer.addresses) == 0 || dialer.addresses[0] != endpoint+":5432" {
+		t.Errorf("dialed addresses = %v, want %v", dialer.addresses, endpoint+":5432")
+	}
+}
+
+type recordedQuery struct {
+	connStr string
+	query   string
+	args    []any
+}
+
+// mockRecordingDB records the queries executed over the connections it opens.
+type mockRecordingDB struct {
+	mu      sync.Mutex
+	queries []recordedQuery
+	opened  []string
+	// execErrs the errors returned by the queries
+	execErrs map[string]error
+}
+
+func (m *mockRecordingDB) connect(connStr string) (db, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.opened = append(m.opened, connStr)
+	return &mockRecordingConn{db: m, connStr: connStr}, nil
+}
+
+type mockRecordingConn struct {
+	db      *mockRecordingDB
+	connStr string
+}
+
+func (c *mockRecordingConn) Close() error {
+	return nil
+}
+
+func (c *mockRecordingConn) PingContext(context.Context) error {
+	return nil
+}
+
+func (c *mockRecordingConn) ExecContext(_ context.Context, query string, args ...any) (sql.Result, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.queries = append(c.db.queries, recordedQuery{connStr: c.connStr, query: query, args: args})
+	return nil, c.db.execErrs[query]
+}
+
+func Test_dbClient_Set_TerminateExistingSessions(t *testing.T) {
+	pending := &SecretUser{
+		User:         "qux",
+		Password:     placeholderPassword + "new",
+		Host:         "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+		ProjectID:    "foo",
+		BranchID:     "br-bar",
+		DatabaseName: "baz",
+	}
+
+	tests := []struct {
+		name        string
+		terminate   bool
+		drainGrace  time.Duration
+		wantQueries []recordedQuery
+	}{
+		{
+			name:        "sessions are preserved by default",
+			terminate:   false,
+			wantQueries: nil,
+		},
+		{
+			name:      "role's other sessions are terminated",
+			terminate: true,
+			wantQueries: []recordedQuery{
+				{
+					connStr: "user=qux dbname=baz host=ep-foo-bar-123456.us-east-2.aws.neon.tech sslmode=verify-full" +
+						" password=" + placeholderPassword + "new",
+					query: "SELECT pg_terminate_backend(pid) FROM pg_stat_activity " +
+						"WHERE usename = $1 AND pid <> pg_backend_pid()",
+					args: []any{"qux"},
+				},
+			},
+		},
+		{
+			name:       "role's sessions idle for longer than the grace period are terminated",
+			terminate:  true,
+			drainGrace: 30 * time.Second,
+			wantQueries: []recordedQuery{
+				{
+					connStr: "user=qux dbname=baz host=ep-foo-bar-123456.us-east-2.aws.neon.tech sslmode=verify-full" +
+						" password=" + placeholderPassword + "new",
+					query: "SELECT pg_terminate_backend(pid) FROM pg_stat_activity " +
+						"WHERE usename = $1 AND pid <> pg_backend_pid() " +
+						"AND state = 'idle' AND state_change < now() - make_interval(secs => $2)",
+					args: []any{"qux", float64(30)},
+				},
+			},
+		},
+		{
+			name:        "grace period is ignored unless sessions termination is set",
+			drainGrace:  30 * time.Second,
+			wantQueries: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				m := &mockRecordingDB{}
+				c := dbClient{
+					c:       newMockSDKClient(),
+					cfg:     Config{TerminateExistingSessions: tt.terminate, SessionDrainGrace: tt.drainGrace},
+					connect: m.connect,
+				}
+
+				if err := c.Set(context.TODO(), &SecretUser{}, pending, &SecretUser{}); err != nil {
+					t.Fatalf("Set() unexpected error = %v", err)
+				}
+
+				if !reflect.DeepEqual(m.queries, tt.wantQueries) {
+					t.Errorf("Set() queries = %v, want %v", m.queries, tt.wantQueries)
+				}
+			},
+		)
+	}
+}
+
+func Test_dbClient_Test_DeferTestOnSuspendedEndpoint(t *testing.T) {
+	tests := []struct {
+		name      string
+		deferTest bool
+		host      string
+		failed    bool
+		wantErr   error
+	}{
+		{
+			name:      "happy path: connection to the suspended endpoint failed, test deferred",
+			deferTest: true,
+			host:      "ep-little-smoke-851426.us-east-2.aws.neon.tech",
+			failed:    true,
+			wantErr:   ErrEndpointSuspended,
+		},
+		{
+			name:      "happy path: connection to the suspended endpoint's pooler failed, test deferred",
+			deferTest: true,
+			host:      "ep-little-smoke-851426-pooler.us-east-2.aws.neon.tech",
+			failed:    true,
+			wantErr:   ErrEndpointSuspended,
+		},
+		{
+			name:      "happy path: connection succeeded",
+			deferTest: true,
+			host:      "ep-little-smoke-851426.us-east-2.aws.neon.tech",
+		},
+		{
+			name:    "unhappy path: connection failed, the test is not deferred",
+			host:    "ep-little-smoke-851426.us-east-2.aws.neon.tech",
+			failed:  true,
+			wantErr: errors.New("failed to query"),
+		},
+		{
+			name:      "unhappy path: connection failed, unknown endpoint",
+			deferTest: true,
+			host:      "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+			failed:    true,
+			wantErr:   errors.New("failed to query"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				c := dbClient{
+					c:   newMockSDKClient(),
+					cfg: Config{DeferTestOnSuspendedEndpoint: tt.deferTest},
+					connect: func(string) (db, error) {
+						return mockDB{FailedPing: tt.failed}, nil
+					},
+				}
+
+				err := c.Test(
+					context.TODO(), &SecretUser{
+						User:         "qux",
+						Password:     placeholderPassword,
+						Host:         tt.host,
+						ProjectID:    "shiny-wind-028834",
+						BranchID:     "br-aged-salad-637688",
+						DatabaseName: "baz",
+					},
+				)
+				if !reflect.DeepEqual(err, tt.wantErr) {
+					t.Errorf("Test() error = %v, want %v", err, tt.wantErr)
+				}
+			},
+		)
+	}
+}
+
+func Test_dbClient_isRetryable(t *testing.T) {
+	tests := []struct {
+		name  string
+		codes []string
+		err   error
+		want  bool
+	}{
+		{
+			name: "cannot_connect_now is retryable by default",
+			err:  &pq.Error{Code: "57P03"},
+			want: true,
+		},
+		{
+			name: "invalid_password is fatal",
+			err:  &pq.Error{Code: "28P01"},
+			want: false,
+		},
+		{
+			name:  "invalid_password is fatal even if configured as retryable",
+			codes: []string{"28P01"},
+			err:   &pq.Error{Code: "28P01"},
+			want:  false,
+		},
+		{
+			name:  "custom retryable code",
+			codes: []string{"40001"},
+			err:   &pq.Error{Code: "40001"},
+			want:  true,
+		},
+		{
+			name:  "cannot_connect_now is not retryable if not configured",
+			codes: []string{"40001"},
+			err:   &pq.Error{Code: "57P03"},
+			want:  false,
+		},
+		{
+			name: "non-SQL error is not retryable",
+			err:  errors.New("foo"),
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				c := dbClient{cfg: Config{RetryableSQLStates: tt.codes}}
+				if got := c.isRetryable(tt.err); got != tt.want {
+					t.Errorf("isRetryable() = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+// mockPingErrorsDB returns the errors on subsequent pings.
+type mockPingErrorsDB struct {
+	errs  []error
+	pings int
+}
+
+func (m *mockPingErrorsDB) connect(string) (db, error) {
+	return m, nil
+}
+
+func (m *mockPingErrorsDB) Close() error {
+	return nil
+}
+
+func (m *mockPingErrorsDB) PingContext(context.Context) error {
+	m.pings++
+	if len(m.errs) == 0 {
+		return nil
+	}
+	err := m.errs[0]
+	m.errs = m.errs[1:]
+	return err
+}
+
+func (m *mockPingErrorsDB) ExecContext(context.Context, string, ...any) (sql.Result, error) {
+	return nil, nil
+}
+
+func Test_dbClient_Test_retries(t *testing.T) {
+	tests := []struct {
+		name      string
+		errs      []error
+		wantPings int
+		wantErr   bool
+	}{
+		{
+			name:      "happy path: retryable error",
+			errs:      []error{&pq.Error{Code: "57P03"}, &pq.Error{Code: "57P03"}},
+			wantPings: 3,
+		},
+		{
+			name:      "unhappy path: retries exhausted",
+			errs:      []error{&pq.Error{Code: "57P03"}, &pq.Error{Code: "57P03"}, &pq.Error{Code: "57P03"}},
+			wantPings: 3,
+			wantErr:   true,
+		},
+		{
+			name:      "unhappy path: authentication error is not retried",
+			errs:      []error{&pq.Error{Code: "28P01"}},
+			wantPings: 1,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				m := &mockPingErrorsDB{errs: tt.errs}
+				c := dbClient{
+					c:       newMockSDKClient(),
+					cfg:     Config{TestRetries: 2, TestRetryInterval: time.Millisecond},
+					connect: m.connect,
+				}
+
+				err := c.Test(
+					context.TODO(), &SecretUser{
+						User:         "qux",
+						Password:     placeholderPassword,
+						Host:         "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+						DatabaseName: "baz",
+					},
+				)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("Test() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if m.pings != tt.wantPings {
+					t.Errorf("Test() pings = %d, want %d", m.pings, tt.wantPings)
+				}
+			},
+		)
+	}
+}
+
+func Test_dbClient_Test_ConnectLogLevel(t *testing.T) {
+	tests := []struct {
+		name            string
+		connectLogLevel string
+		wantAttemptLogs bool
+	}{
+		{
+			name:            "debug",
+			connectLogLevel: ConnectLogLevelDebug,
+			wantAttemptLogs: true,
+		},
+		{
+			name:            "info",
+			connectLogLevel: ConnectLogLevelInfo,
+		},
+		{
+			name: "default",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				var buf bytes.Buffer
+				log.SetOutput(&buf)
+				defer log.SetOutput(os.Stderr)
+
+				m := &mockPingErrorsDB{errs: []error{&pq.Error{Code: "57P03"}, &pq.Error{Code: "57P03"}}}
+				c := dbClient{
+					c: newMockSDKClient(),
+					cfg: Config{
+						TestRetries: 2, TestRetryInterval: time.Millisecond, ConnectLogLevel: tt.connectLogLevel,
+					},
+					connect: m.connect,
+				}
+
+				if err := c.Test(
+					context.TODO(), &SecretUser{
+						User:         "qux",
+						Password:     placeholderPassword,
+						Host:         "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+						DatabaseName: "baz",
+					},
+				); err != nil {
+					t.Fatalf("Test() unexpected error = %v", err)
+				}
+
+				logs := buf.String()
+				if got := strings.Count(logs, "[DEBUG] the secret's test attempt"); (got == 2) != tt.wantAttemptLogs {
+					t.Errorf("Test() logged %d attempts, want attempts logged: %v\n%s", got, tt.wantAttemptLogs, logs)
+				}
+				if !strings.Contains(logs, "[INFO] the secret's test succeeded after 3 attempts") {
+					t.Errorf("Test() shall log the outcome at info:\n%s", logs)
+				}
+			},
+		)
+	}
+}
+
+func Test_dbClient_Create_doesNotConnectToDB(t *testing.T) {
+	c := dbClient{
+		c: newMockSDKClient(),
+		connect: func(string) (db, error) {
+			t.Fatal("Create() shall not connect to the database")
+			return nil, nil
+		},
+	}
+
+	s := &SecretUser{
+		User:         "qux",
+		Password:     placeholderPassword,
+		Host:         "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+		ProjectID:    "foo",
+		BranchID:     "br-bar",
+		DatabaseName: "baz",
+	}
+	if err := c.Create(context.TODO(), s); err != nil {
+		t.Fatalf("Create() unexpected error = %v", err)
+	}
+	if s.Password == placeholderPassword {
+		t.Errorf("Create() shall generate new password")
+	}
+}
+
+// mockDatabasesDB fails pings to the defined databases and records the tested databases.
+type mockDatabasesDB struct {
+	failed map[string]bool
+	tested []string
+}
+
+func (m *mockDatabasesDB) connect(connStr string) (db, error) {
+	var dbname string
+	for _, kv := range strings.Fields(connStr) {
+		if strings.HasPrefix(kv, "dbname=") {
+			dbname = strings.TrimPrefix(kv, "dbname=")
+		}
+	}
+	m.tested = append(m.tested, dbname)
+	return mockDB{FailedPing: m.failed[dbname]}, nil
+}
+
+func Test_dbClient_Test_CanaryDatabase(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        Config
+		failed     map[string]bool
+		wantTested []string
+		wantErr    bool
+	}{
+		{
+			name:       "happy path: single database by default",
+			wantTested: []string{"baz"},
+		},
+		{
+			name: "happy path: canary goes first",
+			cfg: Config{
+				CanaryDatabase: "canary",
+				TestDatabases:  []string{"foo", "baz"},
+			},
+			wantTested: []string{"canary", "baz", "foo"},
+		},
+		{
+			name: "unhappy path: canary failed, the rest is skipped",
+			cfg: Config{
+				CanaryDatabase: "canary",
+				TestDatabases:  []string{"foo"},
+			},
+			failed:     map[string]bool{"canary": true},
+			wantTested: []string{"canary"},
+			wantErr:    true,
+		},
+		{
+			name: "unhappy path: canary passed, the rest failed",
+			cfg: Config{
+				CanaryDatabase: "canary",
+				TestDatabases:  []string{"foo", "bar"},
+			},
+			failed:     map[string]bool{"foo": true},
+			wantTested: []string{"canary", "baz", "foo"},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				m := &mockDatabasesDB{failed: tt.failed}
+				c := dbClient{
+					c:       newMockSDKClient(),
+					cfg:     tt.cfg,
+					connect: m.connect,
+				}
+
+				err := c.Test(
+					context.TODO(), &SecretUser{
+						User:         "qux",
+						Password:     placeholderPassword,
+						Host:         "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+						DatabaseName: "baz",
+					},
+				)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("Test() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if !reflect.DeepEqual(m.tested, tt.wantTested) {
+					t.Errorf("Test() tested databases = %v, want %v", m.tested, tt.wantTested)
+				}
+			},
+		)
+	}
+}
+
+func Test_dbClient_sslRootCert(t *testing.T) {
+	defaultPath, err := defaultCAFile()
+	if err != nil {
+		t.Fatalf("defaultCAFile() unexpected error = %v", err)
+	}
+
+	// the inline certificate is a single embedded CA certificate to distinguish it from the defaults
+	block, _ := pem.Decode(defaultCACertificates)
+	inline := string(pem.EncodeToMemory(block))
+	inlineCert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		cfg      Config
+		secret   SecretUser
+		wantPath string
+		wantCA   *x509.Certificate
+		wantErr  bool
+	}{
+		{
+			name:     "embedded CA certificates by default",
+			wantPath: defaultPath,
+		},
+		{
+			name:     "configured file",
+			cfg:      Config{SSLRootCert: "/foo/ca.pem"},
+			wantPath: "/foo/ca.pem",
+		},
+		{
+			name:   "inline certificates override configured file",
+			cfg:    Config{SSLRootCert: "/foo/ca.pem"},
+			secret: SecretUser{SSLRootCert: inline},
+			wantCA: inlineCert,
+		},
+		{
+			name:    "unhappy path: faulty inline certificates",
+			secret:  SecretUser{SSLRootCert: "foo"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := dbClient{cfg: tt.cfg}.sslRootCert(&tt.secret)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("sslRootCert() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if tt.wantPath != "" && got != tt.wantPath {
+					t.Errorf("sslRootCert() got = %v, want %v", got, tt.wantPath)
+				}
+
+				if tt.wantCA != nil {
+					// the pq driver loads the file's certificates as the TLS config's root CAs
+					b, err := os.ReadFile(got)
+					if err != nil {
+						t.Fatalf("unexpected error = %v", err)
+					}
+					rootCAs := x509.NewCertPool()
+					if !rootCAs.AppendCertsFromPEM(b) {
+						t.Fatalf("sslRootCert() file has no certificates")
+					}
+					want := x509.NewCertPool()
+					want.AddCert(tt.wantCA)
+					if !rootCAs.Equal(want) {
+						t.Errorf("sslRootCert() root CAs do not match the inline certificates")
+					}
+				}
+			},
+		)
+	}
+}
+
+func Test_dbClient_Test_ExpectedEndpointType(t *testing.T) {
+	tests := []struct {
+		name         string
+		endpointType sdk.EndpointType
+		host         string
+		wantErr      bool
+	}{
+		{
+			name:         "happy path: read_write endpoint",
+			endpointType: "read_write",
+			host:         "ep-little-smoke-851426.us-east-2.aws.neon.tech",
+		},
+		{
+			name:         "happy path: pooler of read_write endpoint",
+			endpointType: "read_write",
+			host:         "ep-little-smoke-851426-pooler.us-east-2.aws.neon.tech",
+		},
+		{
+			name:         "unhappy path: read_only endpoint expected",
+			endpointType: "read_only",
+			host:         "ep-little-smoke-851426.us-east-2.aws.neon.tech",
+			wantErr:      true,
+		},
+		{
+			name:         "unhappy path: unknown endpoint",
+			endpointType: "read_write",
+			host:         "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				var connected bool
+				c := dbClient{
+					c:   newMockSDKClient(),
+					cfg: Config{ExpectedEndpointType: tt.endpointType},
+					connect: func(string) (db, error) {
+						connected = true
+						return mockDB{}, nil
+					},
+				}
+
+				err := c.Test(
+					context.TODO(), &SecretUser{
+						User:         "qux",
+						Password:     placeholderPassword,
+						Host:         tt.host,
+						ProjectID:    "shiny-wind-028834",
+						BranchID:     "br-aged-salad-637688",
+						DatabaseName: "baz",
+					},
+				)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("Test() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if connected == tt.wantErr {
+					t.Errorf("Test() connected = %v, shall connect only to the expected endpoint", connected)
+				}
+			},
+		)
+	}
+}
+
+func Test_dbClient_Test_SearchPath(t *testing.T) {
+	tests := []struct {
+		name        string
+		searchPath  string
+		wantQueries []string
+	}{
+		{
+			name: "search_path is not set by default",
+		},
+		{
+			name:        "search_path is set before the test",
+			searchPath:  "app, $user,public",
+			wantQueries: []string{`SET search_path TO "app", "$user", "public"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				m := &mockRecordingDB{}
+				c := dbClient{
+					c:       newMockSDKClient(),
+					cfg:     Config{SearchPath: tt.searchPath},
+					connect: m.connect,
+				}
+
+				if err := c.Test(
+					context.TODO(), &SecretUser{
+						User:         "qux",
+						Password:     placeholderPassword,
+						Host:         "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+						DatabaseName: "baz",
+					},
+				); err != nil {
+					t.Fatalf("Test() unexpected error = %v", err)
+				}
+
+				var got []string
+				for _, q := range m.queries {
+					got = append(got, q.query)
+				}
+				if !reflect.DeepEqual(got, tt.wantQueries) {
+					t.Errorf("Test() queries = %v, want %v", got, tt.wantQueries)
+				}
+			},
+		)
+	}
+}
+
+func Test_dbClient_Create_IDsFromTags(t *testing.T) {
+	var s SecretUser
+	if err := json.Unmarshal(
+		[]byte(`{"user":"qux","password":"quxx","host":"ep-foo-bar-123456.us-east-2.aws.neon.tech","dbname":"baz"}`), &s,
+	); err != nil {
+		t.Fatalf("unexpected error = %v", err)
+	}
+
+	ctx := lambda.WithSecretTags(
+		context.TODO(), map[string]string{TagProjectID: "shiny-wind-028834", TagBranchID: "br-aged-salad-637688"},
+	)
+	if err := (dbClient{c: newMockSDKClient()}).Create(ctx, &s); err != nil {
+		t.Fatalf("Create() unexpected error = %v", err)
+	}
+
+	if s.ProjectID != "shiny-wind-028834" || s.BranchID != "br-aged-salad-637688" {
+		t.Errorf("Create() project_id = %v, branch_id = %v, want the IDs from the tags", s.ProjectID, s.BranchID)
+	}
+}
+
+func Test_dbClient_Test_VerifyReplication(t *testing.T) {
+	tests := []struct {
+		name              string
+		verifyReplication bool
+		execErrs          map[string]error
+		wantQueries       []string
+		wantErr           bool
+	}{
+		{
+			name: "replication is not verified by default",
+		},
+		{
+			name:              "healthy replication",
+			verifyReplication: true,
+			wantQueries:       []string{queryCheckReplication},
+		},
+		{
+			name:              "unhealthy replication",
+			verifyReplication: true,
+			execErrs: map[string]error{
+				queryCheckReplication: &pq.Error{Code: "P0001", Message: "inactive logical replication slot"},
+			},
+			wantQueries: []string{queryCheckReplication},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				m := &mockRecordingDB{execErrs: tt.execErrs}
+				c := dbClient{
+					c:       newMockSDKClient(),
+					cfg:     Config{VerifyReplication: tt.verifyReplication, TestRetries: 2},
+					connect: m.connect,
+				}
+
+				err := c.Test(
+					context.TODO(), &SecretUser{
+						User:         "qux",
+						Password:     placeholderPassword,
+						Host:         "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+						DatabaseName: "baz",
+					},
+				)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("Test() error = %v, wantErr %v", err, tt.wantErr)
+				}
+
+				var got []string
+				for _, q := range m.queries {
+					got = append(got, q.query)
+				}
+				if !reflect.DeepEqual(got, tt.wantQueries) {
+					t.Errorf("Test() queries = %v, want %v", got, tt.wantQueries)
+				}
+			},
+		)
+	}
+}
+
+func Test_isHostAllowed(t *testing.T) {
+	tests := []struct {
+		name     string
+		host     string
+		suffixes []string
+		want     bool
+	}{
+		{
+			name: "any host is allowed by default",
+			host: "169.254.169.254",
+			want: true,
+		},
+		{
+			name:     "host of the domain",
+			host:     "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+			suffixes: []string{"example.com", ".neon.tech"},
+			want:     true,
+		},
+		{
+			name:     "host of the domain in upper case with trailing dot",
+			host:     "EP-FOO-BAR-123456.US-EAST-2.AWS.NEON.TECH.",
+			suffixes: []string{"neon.tech"},
+			want:     true,
+		},
+		{
+			name:     "host outside the domain",
+			host:     "169.254.169.254",
+			suffixes: []string{"neon.tech"},
+		},
+		{
+			name:     "host with the domain as a part of the name",
+			host:     "ep-foo-bar-123456.evilneon.tech",
+			suffixes: []string{"neon.tech"},
+		},
+		{
+			name:     "empty suffix",
+			host:     "localhost",
+			suffixes: []string{""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if got := isHostAllowed(tt.host, tt.suffixes); got != tt.want {
+					t.Errorf("isHostAllowed() = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func Test_dbClient_Test_AllowedHostSuffixes(t *testing.T) {
+	m := &mockRecordingDB{}
+	c := dbClient{
+		c:       newMockSDKClient(),
+		cfg:     Config{AllowedHostSuffixes: []string{"neon.tech"}},
+		connect: m.connect,
+	}
+
+	if err := c.Test(
+		context.TODO(), &SecretUser{
+			User:         "qux",
+			Password:     placeholderPassword,
+			Host:         "169.254.169.254",
+			DatabaseName: "baz",
+		},
+	); err == nil {
+		t.Fatalf("Test() expected error")
+	}
+
+	if len(m.opened) > 0 {
+		t.Errorf("Test() opened the connections %v to the host which is not allowed", m.opened)
+	}
+}
+
+func Test_dbClient_Test_PoolerMode(t *testing.T) {
+	tests := []struct {
+		name       string
+		poolerMode string
+		want       []recordedQuery
+		wantErr    bool
+	}{
+		{
+			name: "no probe by default",
+		},
+		{
+			name:       "transaction mode: no prepared statements",
+			poolerMode: PoolerModeTransaction,
+			want:       []recordedQuery{{query: "SELECT 1"}},
+		},
+		{
+			name:       "session mode: parametrised query",
+			poolerMode: PoolerModeSession,
+			want:       []recordedQuery{{query: "SELECT $1::int", args: []any{1}}},
+		},
+		{
+			name:       "unsupported mode",
+			poolerMode: "statement",
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				m := &mockRecordingDB{}
+				c := dbClient{
+					c:       newMockSDKClient(),
+					cfg:     Config{PoolerMode: tt.poolerMode},
+					connect: m.connect,
+				}
+
+				err := c.Test(
+					context.TODO(), &SecretUser{
+						User:         "qux",
+						Password:     placeholderPassword,
+						Host:         "ep-foo-bar-123456-pooler.us-east-2.aws.neon.tech",
+						DatabaseName: "baz",
+					},
+				)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("Test() error = %v, wantErr %v", err, tt.wantErr)
+				}
+
+				var got []recordedQuery
+				for _, q := range m.queries {
+					got = append(got, recordedQuery{query: q.query, args: q.args})
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("Test() queries = %v, want %v", got, tt.want)
+				}
+				if tt.poolerMode == PoolerModeTransaction {
+					for _, q := range got {
+						if len(q.args) > 0 || strings.Contains(q.query, "$") {
+							t.Errorf("Test() query %s is prepared in the transaction mode", q.query)
+						}
+					}
+				}
+			},
+		)
+	}
+}
+
+func Test_dbClient_Create_EmitConnectionURI(t *testing.T) {
+	c := dbClient{c: newMockSDKClient(), cfg: Config{EmitConnectionURI: true}}
+	s := &SecretUser{
+		User:         "qux",
+		Password:     placeholderPassword,
+		Host:         "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+		ProjectID:    "foo",
+		BranchID:     "br-bar",
+		DatabaseName: "baz",
+		Port:         5432,
+	}
+
+	if err := c.Create(context.TODO(), s); err != nil {
+		t.Fatalf("Create() unexpected error = %v", err)
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("Marshal() unexpected error = %v", err)
+	}
+
+	var got SecretUser
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal() unexpected error = %v", err)
+	}
+
+	u, err := url.Parse(got.ConnectionURI)
+	if err != nil {
+		t.Fatalf("faulty connection_uri %s: %v", got.ConnectionURI, err)
+	}
+	if password, _ := u.User.Password(); password != got.Password || password == placeholderPassword {
+		t.Errorf("connection_uri password = %v, want the rotated password %v", password, got.Password)
+	}
+	if want := "postgres://" + url.UserPassword("qux", got.Password).String() +
+		"@ep-foo-bar-123456.us-east-2.aws.neon.tech:5432/baz?sslmode=require"; got.ConnectionURI != want {
+		t.Errorf("connection_uri = %v, want %v", got.ConnectionURI, want)
+	}
+
+	// the password is kept in sync when the secret is encoded
+	got.Password = "bar"
+	data, _ = json.Marshal(got)
+	var gotSynced SecretUser
+	_ = json.Unmarshal(data, &gotSynced)
+	if u, _ := url.Parse(gotSynced.ConnectionURI); u == nil || u.User.String() != "qux:bar" {
+		t.Errorf("connection_uri = %v, want the password bar", gotSynced.ConnectionURI)
+	}
+}
+
+// mockRecoveryDB reports the hosts in recovery mode.
+type mockRecoveryDB struct {
+	mockRecordingDB
+	hostsInRecovery []string
+}
+
+func (m *mockRecoveryDB) connect(connStr string) (db, error) {
+	conn, _ := m.mockRecordingDB.connect(connStr)
+	for _, host := range m.hostsInRecovery {
+		if strings.Contains(connStr, "host="+host+" ") {
+			return mockRecoveryConn{conn.(*mockRecordingConn)}, nil
+		}
+	}
+	return conn, nil
+}
+
+type mockRecoveryConn struct {
+	*mockRecordingConn
+}
+
+func (c mockRecoveryConn) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	_, _ = c.mockRecordingConn.ExecContext(ctx, query, args...)
+	if query == queryCheckReadWrite {
+		return nil, &pq.Error{Code: sqlStateReadOnly, Message: "server is in recovery"}
+	}
+	return nil, nil
+}
+
+func Test_dbClient_Set_VerifyReadWrite(t *testing.T) {
+	const (
+		hostReplica = "ep-foo-bar-123456.us-east-2.aws.neon.tech"
+		hostPrimary = "ep-little-smoke-851426.us-east-2.aws.neon.tech"
+	)
+
+	tests := []struct {
+		name            string
+		cfg             Config
+		hostsInRecovery []string
+		wantErr         error
+		wantHosts       []string
+	}{
+		{
+			name:      "read-write mode is not verified by default",
+			cfg:       Config{},
+			wantHosts: nil,
+		},
+		{
+			name:      "happy path: host is writable",
+			cfg:       Config{VerifyReadWrite: true, TerminateExistingSessions: true},
+			wantHosts: []string{hostReplica, hostReplica},
+		},
+		{
+			name:            "unhappy path: host is in recovery",
+			cfg:             Config{VerifyReadWrite: true, TerminateExistingSessions: true},
+			hostsInRecovery: []string{hostReplica},
+			wantErr:         ErrReadOnly,
+			wantHosts:       []string{hostReplica},
+		},
+		{
+			name: "happy path: host is in recovery, the read_write endpoint is resolved",
+			cfg: Config{
+				VerifyReadWrite: true, ResolveReadWriteEndpoint: true, TerminateExistingSessions: true,
+			},
+			hostsInRecovery: []string{hostReplica},
+			wantHosts:       []string{hostReplica, hostPrimary, hostPrimary},
+		},
+		{
+			name: "unhappy path: the resolved read_write endpoint is in recovery",
+			cfg: Config{
+				VerifyReadWrite: true, ResolveReadWriteEndpoint: true, TerminateExistingSessions: true,
+			},
+			hostsInRecovery: []string{hostReplica, hostPrimary},
+			wantErr:         ErrReadOnly,
+			wantHosts:       []string{hostReplica, hostPrimary},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				m := &mockRecoveryDB{hostsInRecovery: tt.hostsInRecovery}
+				c := dbClient{c: newMockSDKClient(), cfg: tt.cfg, connect: m.connect}
+
+				pending := &SecretUser{
+					User:         "qux",
+					Password:     placeholderPassword + "new",
+					Host:         hostReplica,
+					ProjectID:    "shiny-wind-028834",
+					BranchID:     "br-aged-salad-637688",
+					DatabaseName: "baz",
+				}
+
+				err := c.Set(context.TODO(), &SecretUser{}, pending, &SecretUser{})
+				if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+					t.Fatalf("Set() error = %v, wantErr %v", err, tt.wantErr)
+				}
+
+				var gotHosts []string
+				for _, q := range m.queries {
+					for _, kv := range strings.Fields(q.connStr) {
+						if strings.HasPrefix(kv, "host=") {
+							gotHosts = append(gotHosts, strings.TrimPrefix(kv, "host="))
+						}
+					}
+				}
+				if !reflect.DeepEqual(gotHosts, tt.wantHosts) {
+					t.Errorf("Set() queried hosts = %v, want %v", gotHosts, tt.wantHosts)
+				}
+
+				if pending.Host != hostReplica {
+					t.Errorf("Set() shall not mutate the pending secret")
+				}
+			},
+		)
+	}
+}
+
+func Test_dbClient_Test_Hosts(t *testing.T) {
+	const (
+		hostA = "ep-foo-bar-123456.us-east-2.aws.neon.tech"
+		hostB = "ep-baz-qux-654321.us-east-2.aws.neon.tech"
+	)
+
+	tests := []struct {
+		name            string
+		requireAllHosts bool
+		hostsDown       []string
+		wantErr         bool
+	}{
+		{
+			name: "happy path: all hosts passed",
+		},
+		{
+			name:      "happy path: quorum of one host",
+			hostsDown: []string{hostA},
+		},
+		{
+			name:      "unhappy path: no host passed",
+			hostsDown: []string{hostA, hostB},
+			wantErr:   true,
+		},
+		{
+			name:            "happy path: all hosts required and passed",
+			requireAllHosts: true,
+		},
+		{
+			name:            "unhappy path: all hosts required, one host failed",
+			requireAllHosts: true,
+			hostsDown:       []string{hostB},
+			wantErr:         true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				m := &mockRecordingDB{}
+				c := dbClient{
+					c:   newMockSDKClient(),
+					cfg: Config{RequireAllHosts: tt.requireAllHosts},
+					connect: func(connStr string) (db, error) {
+						for _, host := range tt.hostsDown {
+							if strings.Contains(connStr, "host="+host+" ") {
+								return nil, errors.New("connection refused")
+							}
+						}
+						return m.connect(connStr)
+					},
+				}
+
+				err := c.Test(
+					context.TODO(), &SecretUser{
+						User:         "qux",
+						Password:     placeholderPassword,
+						Host:         hostA,
+						Hosts:        []string{hostA, hostB},
+						ProjectID:    "foo",
+						BranchID:     "br-bar",
+						DatabaseName: "baz",
+					},
+				)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("Test() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			},
+		)
+	}
+}
+
+func Test_dbClient_Set_Hosts(t *testing.T) {
+	const (
+		hostReplica = "ep-foo-bar-123456.us-east-2.aws.neon.tech"
+		hostWriter  = "ep-baz-qux-654321.us-east-2.aws.neon.tech"
+	)
+
+	m := &mockRecoveryDB{hostsInRecovery: []string{hostReplica}}
+	c := dbClient{c: newMockSDKClient(), cfg: Config{TerminateExistingSessions: true}, connect: m.connect}
+
+	if err := c.Set(
+		context.TODO(), &SecretUser{}, &SecretUser{
+			User:         "qux",
+			Password:     placeholderPassword + "new",
+			Host:         hostReplica,
+			Hosts:        []string{hostWriter},
+			ProjectID:    "foo",
+			BranchID:     "br-bar",
+			DatabaseName: "baz",
+		}, &SecretUser{},
+	); err != nil {
+		t.Fatalf("Set() unexpected error = %v", err)
+	}
+
+	last := m.queries[len(m.queries)-1]
+	if last.query != queryTerminateSessions || !strings.Contains(last.connStr, "host="+hostWriter+" ") {
+		t.Errorf("Set() shall terminate the sessions via the writer %s, got %v", hostWriter, last)
+	}
+}
+
+func Test_dbClient_Test_authErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantErr     error
+		wantRevoked bool
+	}{
+		{
+			name:        "wrong password",
+			err:         &pq.Error{Code: "28P01", Message: `password authentication failed for user "qux"`},
+			wantErr:     ErrInvalidPassword,
+			wantRevoked: true,
+		},
+		{
+			name:    "role without the LOGIN attribute",
+			err:     &pq.Error{Code: "28000", Message: `role "qux" is not permitted to log in`},
+			wantErr: ErrNoLoginPrivilege,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				m := &mockPingErrorsDB{errs: []error{tt.err}}
+				c := dbClient{c: newMockSDKClient(), connect: m.connect}
+
+				err := c.Test(
+					context.TODO(), &SecretUser{
+						User:         "qux",
+						Password:     placeholderPassword,
+						Host:         "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+						DatabaseName: "baz",
+					},
+				)
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Test() error = %v, want %v", err, tt.wantErr)
+				}
+				if errors.Is(err, lambda.ErrAuthFailed) != tt.wantRevoked {
+					t.Errorf("Test() error = %v, shall wrap %v: %v", err, lambda.ErrAuthFailed, tt.wantRevoked)
+				}
+				if err != nil && !strings.Contains(err.Error(), tt.err.(*pq.Error).Message) {
+					t.Errorf("Test() error = %v, shall contain the server's message", err)
+				}
+			},
+		)
+	}
+}
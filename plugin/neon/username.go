@@ -0,0 +1,208 @@
+package neon
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	lambda "github.com/kislerdm/aws-lambda-secret-rotation"
+	neon "github.com/kislerdm/neon-sdk-go"
+	"github.com/lib/pq"
+)
+
+// roleNameSuffixLength the length of the role name's suffix derived from the rotation token.
+const roleNameSuffixLength = 12
+
+// roleNameSuffix returns the role name's suffix derived from the rotation token, so the retried rotation
+// with the same token derives the same role name.
+func roleNameSuffix(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])[:roleNameSuffixLength]
+}
+
+// createRole creates the role with the name derived from the secret's base role name and the rotation token,
+// the role's name and password are set to the secret. The secret's role is stored as the base role name
+// on the first rotation. The role created by the preceding attempt with the same token is reused,
+// and its password is reset, e.g. when the secret is regenerated, or createSecret is retried.
+func (c dbClient) createRole(ctx context.Context, s *SecretUser) error {
+	if s.DSN != "" {
+		return errors.New("the attribute dsn is not supported when the username is rotated")
+	}
+	if s.ConnectionURI != "" && !c.cfg.EmitConnectionURI {
+		return errors.New(
+			"the attribute connection_uri is supported only with EmitConnectionURI when the username is rotated",
+		)
+	}
+
+	token := lambda.RotationTokenFromContext(ctx)
+	if token == "" {
+		return errors.New("the rotation token is required to derive the role name when the username is rotated")
+	}
+
+	if s.BaseUser == "" {
+		s.BaseUser = s.User
+	}
+
+	name, err := RoleName(s.BaseUser, roleNameSuffix(token))
+	if err != nil {
+		return err
+	}
+	if name == s.User {
+		return errors.New("role " + name + " exists already")
+	}
+
+	var e neon.Error
+	switch _, err := c.c.GetProjectBranchRole(s.ProjectID, s.BranchID, name); {
+	case err == nil:
+		o, err := c.c.ResetProjectBranchRolePassword(s.ProjectID, s.BranchID, name)
+		if err != nil {
+			return errors.New("failed to reset the password of the role " + name + ": " + err.Error())
+		}
+		log.Println("[INFO] role " + name + " was created by the preceding attempt, its password is reset")
+		s.Password = o.RoleResponse.Role.Password
+
+	case errors.As(err, &e) && e.HTTPCode == http.StatusNotFound:
+		o, err := c.c.CreateProjectBranchRole(
+			s.ProjectID, s.BranchID, neon.RoleCreateRequest{Role: neon.RoleCreateRequestRole{Name: name}},
+		)
+		if err != nil {
+			return errors.New("failed to create the role " + name + ": " + err.Error())
+		}
+		s.Password = o.RoleResponse.Role.Password
+
+	default:
+		return errors.New("failed to read the role " + name + ": " + err.Error())
+	}
+	lambda.RecordFeature(ctx, featureRotateUsername)
+
+	s.User = name
+	return nil
+}
+
+// queryCloneRole returns the query which grants the privileges of the role `from` to the role `to`:
+// the memberships, the privileges on the schemas, tables and sequences, and the membership in the role `from`,
+// so the role `to` can take over the objects owned by the role `from` when it's dropped, see queryReassignOwned.
+// The query must be run by the role `from`.
+func queryCloneRole(from, to string) (string, error) {
+	if strings.Contains(from, "$") || strings.Contains(to, "$") {
+		return "", errors.New("role name must not contain $")
+	}
+	fromLiteral, toLiteral := pq.QuoteLiteral(from), pq.QuoteLiteral(to)
+	return `DO $$ DECLARE r record; BEGIN ` +
+		`FOR r IN SELECT m.roleid::regrole AS role FROM pg_auth_members m ` +
+		`WHERE m.member = ` + fromLiteral + `::regrole LOOP ` +
+		`EXECUTE format('GRANT %s TO %I', r.role, ` + toLiteral + `); END LOOP; ` +
+		`FOR r IN SELECT n.nspname, p.privilege FROM pg_namespace n ` +
+		`CROSS JOIN (VALUES ('USAGE'), ('CREATE')) AS p(privilege) ` +
+		`WHERE n.nspname NOT LIKE 'pg\_%' AND n.nspname <> 'information_schema' ` +
+		`AND has_schema_privilege(` + fromLiteral + `, n.oid, p.privilege) LOOP ` +
+		`EXECUTE format('GRANT %s ON SCHEMA %I TO %I', r.privilege, r.nspname, ` + toLiteral + `); END LOOP; ` +
+		`FOR r IN SELECT DISTINCT table_schema, table_name, privilege_type FROM information_schema.role_table_grants ` +
+		`WHERE grantee = ` + fromLiteral + ` LOOP ` +
+		`EXECUTE format('GRANT %s ON TABLE %I.%I TO %I', r.privilege_type, r.table_schema, r.table_name, ` +
+		toLiteral + `); END LOOP; ` +
+		`FOR r IN SELECT DISTINCT object_schema, object_name FROM information_schema.role_usage_grants ` +
+		`WHERE grantee = ` + fromLiteral + ` AND object_type = 'SEQUENCE' LOOP ` +
+		`EXECUTE format('GRANT USAGE ON SEQUENCE %I.%I TO %I', r.object_schema, r.object_name, ` +
+		toLiteral + `); END LOOP; ` +
+		`EXECUTE format('GRANT %I TO %I', ` + fromLiteral + `, ` + toLiteral + `); ` +
+		`END $$`, nil
+}
+
+// queryReassignOwned returns the query which transfers the objects owned by the role `from` to the role `to`,
+// and revokes the privileges of the role `from` in the session's database. It's noop if the role `from`
+// does not exist, e.g. when the query is retried.
+func queryReassignOwned(from, to string) (string, error) {
+	if strings.Contains(from, "$") || strings.Contains(to, "$") {
+		return "", errors.New("role name must not contain $")
+	}
+	return `DO $$ BEGIN ` +
+		`IF EXISTS (SELECT FROM pg_roles WHERE rolname = ` + pq.QuoteLiteral(from) + `) THEN ` +
+		`REASSIGN OWNED BY ` + pq.QuoteIdentifier(from) + ` TO ` + pq.QuoteIdentifier(to) + `; ` +
+		`DROP OWNED BY ` + pq.QuoteIdentifier(from) + `; ` +
+		`END IF; END $$`, nil
+}
+
+// cloneRole grants the privileges of the current secret's role to the pending secret's role.
+// The query runs over the connection of the current secret's role to the pending secret's host.
+func (c dbClient) cloneRole(ctx context.Context, current, pending *SecretUser) error {
+	if current.User == "" || current.User == pending.User {
+		return nil
+	}
+
+	query, err := queryCloneRole(current.User, pending.User)
+	if err != nil {
+		return err
+	}
+
+	v := *current
+	v.Host = pending.Host
+	v.Port = pending.Port
+	v.DatabaseName = pending.DatabaseName
+	db, err := c.openDBConnection(&v)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	if _, err := db.ExecContext(ctx, query); err != nil {
+		return errors.New("failed to grant the privileges of the role " + current.User + " to " + pending.User +
+			": " + err.Error())
+	}
+	return nil
+}
+
+// Finish drops the previous secret's role once the secret with the new role is promoted, the objects owned
+// by the previous role are transferred to the new role. It's noop unless the username is rotated.
+func (c dbClient) Finish(ctx context.Context, secretCurrent, secretPrevious any) error {
+	if !c.cfg.RotateUsername {
+		return nil
+	}
+	defer c.connections.watch(c.metrics(), "Finish")()
+
+	current, ok := secretCurrent.(*SecretUser)
+	if !ok {
+		return errors.New("wrong secret type")
+	}
+	previous, ok := secretPrevious.(*SecretUser)
+	if !ok {
+		return errors.New("wrong secret type")
+	}
+	if previous.User == "" || previous.User == current.User {
+		return nil
+	}
+
+	if err := setIDsFromTags(ctx, current); err != nil {
+		return err
+	}
+
+	query, err := queryReassignOwned(previous.User, current.User)
+	if err != nil {
+		return err
+	}
+
+	db, err := c.openDBConnection(current)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	if _, err := db.ExecContext(ctx, query); err != nil {
+		return errors.New("failed to reassign the objects owned by the role " + previous.User + ": " + err.Error())
+	}
+
+	if _, err := c.c.DeleteProjectBranchRole(current.ProjectID, current.BranchID, previous.User); err != nil {
+		var e neon.Error
+		if errors.As(err, &e) && e.HTTPCode == http.StatusNotFound {
+			log.Println("[INFO] role " + previous.User + " was deleted already")
+			return nil
+		}
+		return errors.New("failed to delete the role " + previous.User + ": " + err.Error())
+	}
+	log.Println("[INFO] role " + previous.User + " is deleted")
+	return nil
+}
@@ -0,0 +1,377 @@
+package neon
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	lambda "github.com/kislerdm/aws-lambda-secret-rotation"
+	sdk "github.com/kislerdm/neon-sdk-go"
+)
+
+// mockRolesSDKClient records the roles created, reset and deleted using Neon API.
+// The roles which were not created by the client are not found.
+type mockRolesSDKClient struct {
+	sdk.Client
+	created   []string
+	reset     []string
+	deleted   []string
+	deleteErr error
+}
+
+func (m *mockRolesSDKClient) GetProjectBranchRole(
+	projectID string, branchID string, roleName string,
+) (sdk.RoleResponse, error) {
+	for _, name := range m.created {
+		if name == roleName {
+			return m.Client.GetProjectBranchRole(projectID, branchID, roleName)
+		}
+	}
+	return sdk.RoleResponse{}, sdk.Error{HTTPCode: http.StatusNotFound}
+}
+
+func (m *mockRolesSDKClient) ResetProjectBranchRolePassword(
+	projectID string, branchID string, roleName string,
+) (sdk.RoleOperations, error) {
+	m.reset = append(m.reset, roleName)
+	return m.Client.ResetProjectBranchRolePassword(projectID, branchID, roleName)
+}
+
+func (m *mockRolesSDKClient) CreateProjectBranchRole(
+	projectID string, branchID string, cfg sdk.RoleCreateRequest,
+) (sdk.RoleOperations, error) {
+	m.created = append(m.created, cfg.Role.Name)
+	return m.Client.CreateProjectBranchRole(projectID, branchID, cfg)
+}
+
+func (m *mockRolesSDKClient) DeleteProjectBranchRole(
+	projectID string, branchID string, roleName string,
+) (sdk.RoleOperations, error) {
+	m.deleted = append(m.deleted, roleName)
+	if m.deleteErr != nil {
+		return sdk.RoleOperations{}, m.deleteErr
+	}
+	return m.Client.DeleteProjectBranchRole(projectID, branchID, roleName)
+}
+
+func Test_dbClient_Create_RotateUsername(t *testing.T) {
+	tests := []struct {
+		name         string
+		secret       SecretUser
+		wantBaseUser string
+		wantErr      bool
+	}{
+		{
+			name: "first rotation derives the role from the secret's role",
+			secret: SecretUser{
+				User: "qux", Password: placeholderPassword, ProjectID: "foo", BranchID: "br-bar",
+			},
+			wantBaseUser: "qux",
+		},
+		{
+			name: "next rotation derives the role from the base role",
+			secret: SecretUser{
+				User: "qux_20230101000000", Password: placeholderPassword, ProjectID: "foo", BranchID: "br-bar",
+				BaseUser: "qux",
+			},
+			wantBaseUser: "qux",
+		},
+		{
+			name: "dsn is not supported",
+			secret: SecretUser{
+				User: "qux", Password: placeholderPassword, ProjectID: "foo", BranchID: "br-bar",
+				DSN: "user=qux password=" + placeholderPassword,
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				client := &mockRolesSDKClient{Client: newMockSDKClient()}
+				c := dbClient{
+					c:   client,
+					cfg: Config{RotateUsername: true},
+					connect: func(string) (db, error) {
+						t.Fatal("Create() shall not connect to the database")
+						return nil, nil
+					},
+				}
+
+				s := tt.secret
+				err := c.Create(lambda.WithRotationToken(context.TODO(), "bar"), &s)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("Create() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if tt.wantErr {
+					if len(client.created) > 0 {
+						t.Errorf("Create() shall not create the role")
+					}
+					return
+				}
+
+				if s.BaseUser != tt.wantBaseUser {
+					t.Errorf("Create() base role = %s, want %s", s.BaseUser, tt.wantBaseUser)
+				}
+				if want := tt.wantBaseUser + "_" + roleNameSuffix("bar"); s.User != want {
+					t.Errorf("Create() role = %s, want %s", s.User, want)
+				}
+				if !reflect.DeepEqual(client.created, []string{s.User}) {
+					t.Errorf("Create() created roles = %v, want %v", client.created, []string{s.User})
+				}
+				if s.Password == "" || s.Password == placeholderPassword {
+					t.Errorf("Create() shall set the new role's password")
+				}
+			},
+		)
+	}
+}
+
+func Test_dbClient_Create_RotateUsername_retry(t *testing.T) {
+	client := &mockRolesSDKClient{Client: newMockSDKClient()}
+	c := dbClient{c: client, cfg: Config{RotateUsername: true}}
+	current := SecretUser{User: "qux", Password: placeholderPassword, ProjectID: "foo", BranchID: "br-bar"}
+
+	// the secret is regenerated, or createSecret is retried with the same token
+	ctx := lambda.WithRotationToken(context.TODO(), "bar")
+	first, retried := current, current
+	if err := c.Create(ctx, &first); err != nil {
+		t.Fatalf("Create() unexpected error = %v", err)
+	}
+	if err := c.Create(ctx, &retried); err != nil {
+		t.Fatalf("Create() unexpected error = %v", err)
+	}
+
+	if retried.User != first.User {
+		t.Errorf("Create() role = %s, want the role %s of the preceding attempt", retried.User, first.User)
+	}
+	if !reflect.DeepEqual(client.created, []string{first.User}) {
+		t.Errorf("Create() created roles = %v, want %v", client.created, []string{first.User})
+	}
+	if !reflect.DeepEqual(client.reset, []string{first.User}) {
+		t.Errorf("Create() reset the passwords of the roles %v, want %v", client.reset, []string{first.User})
+	}
+	if retried.Password == "" || retried.Password == placeholderPassword {
+		t.Errorf("Create() shall set the reset password of the role")
+	}
+
+	// the next rotation creates the new role
+	next := current
+	if err := c.Create(lambda.WithRotationToken(context.TODO(), "baz"), &next); err != nil {
+		t.Fatalf("Create() unexpected error = %v", err)
+	}
+	if next.User == first.User || len(client.created) != 2 {
+		t.Errorf("Create() shall create the new role for the new token, created roles = %v", client.created)
+	}
+
+	// the token is required
+	noToken := current
+	if err := c.Create(context.TODO(), &noToken); err == nil {
+		t.Errorf("Create() shall fail without the rotation token")
+	}
+}
+
+func Test_dbClient_Set_RotateUsername(t *testing.T) {
+	current := &SecretUser{
+		User:         "qux",
+		Password:     placeholderPassword,
+		Host:         "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+		DatabaseName: "baz",
+	}
+	pending := &SecretUser{
+		User:         "qux_20230101000000",
+		Password:     placeholderPassword + "new",
+		Host:         "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+		DatabaseName: "baz",
+		BaseUser:     "qux",
+	}
+
+	wantQuery, err := queryCloneRole("qux", "qux_20230101000000")
+	if err != nil {
+		t.Fatalf("queryCloneRole() unexpected error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		current     *SecretUser
+		execErrs    map[string]error
+		wantQueries []recordedQuery
+		wantErr     bool
+	}{
+		{
+			name:    "privileges are granted by the current role to the new role",
+			current: current,
+			wantQueries: []recordedQuery{
+				{
+					connStr: "user=qux dbname=baz host=ep-foo-bar-123456.us-east-2.aws.neon.tech sslmode=verify-full" +
+						" password=" + placeholderPassword,
+					query: wantQuery,
+				},
+			},
+		},
+		{
+			name:     "failed grant fails the step",
+			current:  current,
+			execErrs: map[string]error{wantQuery: errors.New("permission denied")},
+			wantQueries: []recordedQuery{
+				{
+					connStr: "user=qux dbname=baz host=ep-foo-bar-123456.us-east-2.aws.neon.tech sslmode=verify-full" +
+						" password=" + placeholderPassword,
+					query: wantQuery,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name:        "same role is not cloned",
+			current:     pending,
+			wantQueries: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				m := &mockRecordingDB{execErrs: tt.execErrs}
+				c := dbClient{c: newMockSDKClient(), cfg: Config{RotateUsername: true}, connect: m.connect}
+
+				if err := c.Set(context.TODO(), tt.current, pending, &SecretUser{}); (err != nil) != tt.wantErr {
+					t.Fatalf("Set() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if !reflect.DeepEqual(m.queries, tt.wantQueries) {
+					t.Errorf("Set() queries = %v, want %v", m.queries, tt.wantQueries)
+				}
+			},
+		)
+	}
+}
+
+func Test_queryCloneRole(t *testing.T) {
+	got, err := queryCloneRole(`qux"'`, "qux_20230101000000")
+	if err != nil {
+		t.Fatalf("queryCloneRole() unexpected error = %v", err)
+	}
+
+	for _, want := range []string{
+		`WHERE m.member = 'qux"'''::regrole`,
+		`GRANT %s ON SCHEMA %I TO %I`,
+		`GRANT %s ON TABLE %I.%I TO %I`,
+		`GRANT USAGE ON SEQUENCE %I.%I TO %I`,
+		`EXECUTE format('GRANT %I TO %I', 'qux"''', 'qux_20230101000000')`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("queryCloneRole() = %s, want to contain %s", got, want)
+		}
+	}
+
+	if _, err := queryCloneRole("qux$$; DROP TABLE foo; --", "qux_20230101000000"); err == nil {
+		t.Errorf("queryCloneRole() expected error for the role name with $")
+	}
+}
+
+func Test_dbClient_Finish(t *testing.T) {
+	current := &SecretUser{
+		User:         "qux_20230101000000",
+		Password:     placeholderPassword + "new",
+		Host:         "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+		ProjectID:    "foo",
+		BranchID:     "br-bar",
+		DatabaseName: "baz",
+		BaseUser:     "qux",
+	}
+	previous := &SecretUser{
+		User:         "qux",
+		Password:     placeholderPassword,
+		Host:         "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+		ProjectID:    "foo",
+		BranchID:     "br-bar",
+		DatabaseName: "baz",
+	}
+
+	wantQuery, err := queryReassignOwned("qux", "qux_20230101000000")
+	if err != nil {
+		t.Fatalf("queryReassignOwned() unexpected error = %v", err)
+	}
+	wantReassign := []recordedQuery{
+		{
+			connStr: "user=qux_20230101000000 dbname=baz host=ep-foo-bar-123456.us-east-2.aws.neon.tech " +
+				"sslmode=verify-full password=" + placeholderPassword + "new",
+			query: wantQuery,
+		},
+	}
+
+	tests := []struct {
+		name        string
+		cfg         Config
+		previous    *SecretUser
+		execErrs    map[string]error
+		deleteErr   error
+		wantQueries []recordedQuery
+		wantDeleted []string
+		wantErr     bool
+	}{
+		{
+			name:        "previous role is dropped after its objects are reassigned",
+			cfg:         Config{RotateUsername: true},
+			previous:    previous,
+			wantQueries: wantReassign,
+			wantDeleted: []string{"qux"},
+		},
+		{
+			name:        "previous role deleted already",
+			cfg:         Config{RotateUsername: true},
+			previous:    previous,
+			deleteErr:   sdk.Error{HTTPCode: http.StatusNotFound},
+			wantQueries: wantReassign,
+			wantDeleted: []string{"qux"},
+		},
+		{
+			name:        "previous role is kept if its objects cannot be reassigned",
+			cfg:         Config{RotateUsername: true},
+			previous:    previous,
+			execErrs:    map[string]error{wantQuery: errors.New("permission denied")},
+			wantQueries: wantReassign,
+			wantDeleted: nil,
+			wantErr:     true,
+		},
+		{
+			name:        "failed deletion fails the step",
+			cfg:         Config{RotateUsername: true},
+			previous:    previous,
+			deleteErr:   sdk.Error{HTTPCode: http.StatusInternalServerError},
+			wantQueries: wantReassign,
+			wantDeleted: []string{"qux"},
+			wantErr:     true,
+		},
+		{
+			name:     "same role is not dropped",
+			cfg:      Config{RotateUsername: true},
+			previous: current,
+		},
+		{
+			name:     "noop unless the username is rotated",
+			previous: previous,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				m := &mockRecordingDB{execErrs: tt.execErrs}
+				client := &mockRolesSDKClient{Client: newMockSDKClient(), deleteErr: tt.deleteErr}
+				c := dbClient{c: client, cfg: tt.cfg, connect: m.connect, connections: &connectionBalance{}}
+
+				if err := c.Finish(context.TODO(), current, tt.previous); (err != nil) != tt.wantErr {
+					t.Fatalf("Finish() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if !reflect.DeepEqual(m.queries, tt.wantQueries) {
+					t.Errorf("Finish() queries = %v, want %v", m.queries, tt.wantQueries)
+				}
+				if !reflect.DeepEqual(client.deleted, tt.wantDeleted) {
+					t.Errorf("Finish() deleted roles = %v, want %v", client.deleted, tt.wantDeleted)
+				}
+			},
+		)
+	}
+}
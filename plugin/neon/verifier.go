@@ -0,0 +1,34 @@
+package neon
+
+import (
+	"context"
+
+	lambda "github.com/kislerdm/aws-lambda-secret-rotation"
+)
+
+// Verifier tries to connect to the service using the secret, e.g. to the database over the protocol other than
+// Postgres, or to the connection pooler's admin console. The error fails the secret's test.
+type Verifier func(ctx context.Context, secret *SecretUser) error
+
+// Verifiers the registry of the Verifier instances by the kind of the rotated secret, see lambda.SecretKind.
+// The Postgres verifier is used for the kinds without registered Verifier.
+type Verifiers map[lambda.SecretKind]Verifier
+
+// Register registers the Verifier for the secret's kind, it replaces the previously registered Verifier.
+func (v Verifiers) Register(kind lambda.SecretKind, verifier Verifier) Verifiers {
+	if v == nil {
+		v = Verifiers{}
+	}
+	v[kind] = verifier
+	return v
+}
+
+// verifier returns the Verifier registered for the kind of the rotated secret, or the Postgres verifier.
+func (c dbClient) verifier(ctx context.Context) Verifier {
+	if v, ok := c.cfg.Verifiers[lambda.SecretKindFromContext(ctx)]; ok && v != nil {
+		return v
+	}
+	return func(ctx context.Context, secret *SecretUser) error {
+		return c.ping(ctx, secret)
+	}
+}
@@ -0,0 +1,87 @@
+package neon
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	lambda "github.com/kislerdm/aws-lambda-secret-rotation"
+)
+
+func Test_dbClient_Test_Verifiers(t *testing.T) {
+	const kindMySQL lambda.SecretKind = "mysql"
+
+	tests := []struct {
+		name          string
+		kind          lambda.SecretKind
+		verifierErr   error
+		wantVerified  []string
+		wantConnected int
+		wantErr       bool
+	}{
+		{
+			name:         "custom verifier is selected by the secret's kind",
+			kind:         kindMySQL,
+			wantVerified: []string{"qux"},
+		},
+		{
+			name:         "custom verifier's error fails the test",
+			kind:         kindMySQL,
+			verifierErr:  errors.New("access denied"),
+			wantVerified: []string{"qux"},
+			wantErr:      true,
+		},
+		{
+			name:          "Postgres verifier is used for the kind without registered verifier",
+			kind:          lambda.SecretKindNeon,
+			wantConnected: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				var verified []string
+				m := &mockRecordingDB{}
+				c := dbClient{
+					c: newMockSDKClient(),
+					cfg: Config{
+						Verifiers: Verifiers{}.Register(
+							kindMySQL, func(_ context.Context, secret *SecretUser) error {
+								verified = append(verified, secret.User)
+								return tt.verifierErr
+							},
+						),
+					},
+					connect: m.connect,
+				}
+
+				s := &SecretUser{
+					User:         "qux",
+					Password:     placeholderPassword,
+					Host:         "ep-foo-bar-123456.us-east-2.aws.neon.tech",
+					ProjectID:    "foo",
+					BranchID:     "br-bar",
+					DatabaseName: "baz",
+				}
+				if err := c.Test(lambda.WithSecretKind(context.TODO(), tt.kind), s); (err != nil) != tt.wantErr {
+					t.Fatalf("Test() error = %v, wantErr %v", err, tt.wantErr)
+				}
+
+				if len(verified) != len(tt.wantVerified) || (len(verified) > 0 && verified[0] != tt.wantVerified[0]) {
+					t.Errorf("custom verifier tested %v, want %v", verified, tt.wantVerified)
+				}
+				if len(m.opened) != tt.wantConnected {
+					t.Errorf("Postgres connections opened %d, want %d", len(m.opened), tt.wantConnected)
+				}
+			},
+		)
+	}
+}
+
+func TestVerifiers_Register(t *testing.T) {
+	var v Verifiers
+	v = v.Register("mysql", func(context.Context, *SecretUser) error { return nil })
+	if _, ok := v["mysql"]; !ok {
+		t.Errorf("Register() shall register the verifier to the empty registry")
+	}
+}
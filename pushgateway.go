@@ -0,0 +1,168 @@
+package lambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// defaultPushgatewayJob the Pushgateway's job label of the pushed metrics if PushgatewayJob is not set.
+const defaultPushgatewayJob = "secret_rotation"
+
+// pushgatewayMetrics collects the metrics of the invocation to push them to the Prometheus Pushgateway.
+// The counters are pushed as the counters, and the durations as the gauges in seconds. The metrics are
+// forwarded to the next Metrics as well.
+type pushgatewayMetrics struct {
+	next Metrics
+
+	mu       sync.Mutex
+	counters map[string]float64
+	gauges   map[string]float64
+	types    map[string]string
+}
+
+func newPushgatewayMetrics(next Metrics) *pushgatewayMetrics {
+	return &pushgatewayMetrics{
+		next:     next,
+		counters: map[string]float64{},
+		gauges:   map[string]float64{},
+		types:    map[string]string{},
+	}
+}
+
+func (m *pushgatewayMetrics) IncCounter(name string, dimensions map[string]string) {
+	m.next.IncCounter(name, dimensions)
+
+	family := prometheusName(metricsNamespace) + "_" + prometheusName(name) + "_total"
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.types[family] = "counter"
+	m.counters[family+prometheusLabels(dimensions)]++
+}
+
+func (m *pushgatewayMetrics) ObserveDuration(name string, d time.Duration, dimensions map[string]string) {
+	m.next.ObserveDuration(name, d, dimensions)
+
+	family := prometheusName(metricsNamespace) + "_" + prometheusName(name) + "_seconds"
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.types[family] = "gauge"
+	m.gauges[family+prometheusLabels(dimensions)] = d.Seconds()
+}
+
+// exposition returns the collected metrics in the Prometheus text exposition format.
+func (m *pushgatewayMetrics) exposition() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	families := make([]string, 0, len(m.types))
+	for family := range m.types {
+		families = append(families, family)
+	}
+	sort.Strings(families)
+
+	var buf bytes.Buffer
+	for _, family := range families {
+		samples := m.counters
+		if m.types[family] == "gauge" {
+			samples = m.gauges
+		}
+
+		var series []string
+		for k := range samples {
+			if k == family || strings.HasPrefix(k, family+"{") {
+				series = append(series, k)
+			}
+		}
+		sort.Strings(series)
+
+		buf.WriteString("# TYPE " + family + " " + m.types[family] + "\n")
+		for _, k := range series {
+			buf.WriteString(k + " " + strconv.FormatFloat(samples[k], 'g', -1, 64) + "\n")
+		}
+	}
+	return buf.Bytes()
+}
+
+// push replaces the metrics of the group in the Pushgateway with the collected metrics. The group is keyed by
+// the job and the secret's name, so the invocations for different secrets do not overwrite each other's metrics.
+func (m *pushgatewayMetrics) push(ctx context.Context, gatewayURL, job, secret string) error {
+	if job == "" {
+		job = defaultPushgatewayJob
+	}
+
+	u, err := url.Parse(gatewayURL)
+	if err != nil {
+		return errors.New("faulty Pushgateway URL: " + err.Error())
+	}
+	u = u.JoinPath("metrics", "job", job)
+	u = u.JoinPath(pushgatewayGroupingLabel("secret", secret)...)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(m.exposition()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode/100 != 2 {
+		return errors.New("Pushgateway responded with the status " + resp.Status)
+	}
+	return nil
+}
+
+// pushgatewayGroupingLabel returns the path segments of the grouping key's label. The value is encoded
+// with base64url if it cannot be used as the path segment as is, e.g. the secret's name foo/bar.
+func pushgatewayGroupingLabel(name, value string) []string {
+	if value == "" {
+		return []string{name + "@base64", "="}
+	}
+	if strings.Contains(value, "/") {
+		return []string{name + "@base64", base64.URLEncoding.EncodeToString([]byte(value))}
+	}
+	return []string{name, value}
+}
+
+// prometheusName converts the CamelCase name to the snake_case Prometheus name, e.g. StepSuccess to step_success.
+func prometheusName(name string) string {
+	var o strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				o.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		o.WriteRune(r)
+	}
+	return o.String()
+}
+
+// prometheusLabels returns the dimensions as the Prometheus labels sorted by name, e.g. {step="createSecret"}.
+func prometheusLabels(dimensions map[string]string) string {
+	if len(dimensions) == 0 {
+		return ""
+	}
+
+	labels := make([]string, 0, len(dimensions))
+	for k, v := range dimensions {
+		v = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+		labels = append(labels, prometheusName(k)+`="`+v+`"`)
+	}
+	sort.Strings(labels)
+	return "{" + strings.Join(labels, ",") + "}"
+}
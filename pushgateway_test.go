@@ -0,0 +1,190 @@
+package lambda
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// mockPushgateway records the pushed metrics.
+type mockPushgateway struct {
+	mu     sync.Mutex
+	method string
+	path   string
+	body   string
+}
+
+func (m *mockPushgateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, _ := io.ReadAll(r.Body)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.method, m.path, m.body = r.Method, r.URL.Path, string(b)
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestNewHandler_PushgatewayURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		testErr   error
+		job       string
+		wantPath  string
+		wantLines []string
+	}{
+		{
+			name:     "step success",
+			wantPath: "/metrics/job/secret_rotation/secret@base64/Zm9vL2Jhcg==",
+			wantLines: []string{
+				"# TYPE secret_rotation_step_success_total counter",
+				`secret_rotation_step_success_total{step="testSecret"} 1`,
+				"# TYPE secret_rotation_step_duration_seconds gauge",
+				`secret_rotation_step_duration_seconds{step="testSecret"} `,
+			},
+		},
+		{
+			name:     "step failure",
+			testErr:  errors.New("connection refused"),
+			job:      "neon",
+			wantPath: "/metrics/job/neon/secret@base64/Zm9vL2Jhcg==",
+			wantLines: []string{
+				"# TYPE secret_rotation_step_failure_total counter",
+				`secret_rotation_step_failure_total{step="testSecret"} 1`,
+				"# TYPE secret_rotation_step_duration_seconds gauge",
+				`secret_rotation_step_duration_seconds{step="testSecret"} `,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				gateway := &mockPushgateway{}
+				server := httptest.NewServer(gateway)
+				defer server.Close()
+
+				metrics := &mockMetrics{}
+				h, err := NewHandler(
+					Config{
+						SecretsmanagerClient: &mockSecretsmanagerClient{
+							secretAWSCurrent: placeholderSecretUserStr,
+							secretByID: map[string]map[string]string{
+								"foo": {"AWSCURRENT": placeholderSecretUserStr},
+								"bar": {"AWSPENDING": placeholderSecretUserNewStr},
+							},
+							rotationEnabled: aws.Bool(true),
+						},
+						ServiceClient:  &mockDBClient{testErr: tt.testErr},
+						SecretObj:      &mockObj{},
+						Metrics:        metrics,
+						PushgatewayURL: server.URL,
+						PushgatewayJob: tt.job,
+					},
+				)
+				if err != nil {
+					t.Fatalf("NewHandler() unexpected error = %v", err)
+				}
+
+				if err := h(
+					context.TODO(), SecretsmanagerTriggerPayload{
+						SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+						Token:     "bar",
+						Step:      "testSecret",
+					},
+				); (err != nil) != (tt.testErr != nil) {
+					t.Fatalf("handler() error = %v, wantErr %v", err, tt.testErr)
+				}
+
+				if gateway.method != http.MethodPut || gateway.path != tt.wantPath {
+					t.Errorf("pushed with %s %s, want PUT %s", gateway.method, gateway.path, tt.wantPath)
+				}
+				for _, want := range tt.wantLines {
+					if !strings.Contains(gateway.body, "\n"+want) && !strings.HasPrefix(gateway.body, want) {
+						t.Errorf("pushed metrics %q do not contain %q", gateway.body, want)
+					}
+				}
+
+				if len(metrics.durations) != 1 {
+					t.Errorf("metrics shall be forwarded to Metrics as well")
+				}
+			},
+		)
+	}
+}
+
+func Test_pushgatewayMetrics_push_contextTimeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-done:
+				case <-time.After(time.Second):
+				}
+			},
+		),
+	)
+	defer server.Close()
+	defer close(done)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+
+	m := newPushgatewayMetrics(NoopMetrics{})
+	m.IncCounter(metricStepSuccess, map[string]string{"Step": "testSecret"})
+
+	start := time.Now()
+	if err := m.push(ctx, server.URL, "", "foo"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("push() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if time.Since(start) >= time.Second {
+		t.Errorf("push() shall return once the context is done")
+	}
+}
+
+func Test_pushgatewayGroupingLabel(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  []string
+	}{
+		{
+			name:  "plain value",
+			value: "foo",
+			want:  []string{"secret", "foo"},
+		},
+		{
+			name:  "value with slash is encoded",
+			value: "foo/bar",
+			want:  []string{"secret@base64", "Zm9vL2Jhcg=="},
+		},
+		{
+			name:  "empty value",
+			value: "",
+			want:  []string{"secret@base64", "="},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if got := pushgatewayGroupingLabel("secret", tt.value); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("pushgatewayGroupingLabel() = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func Test_prometheusLabels(t *testing.T) {
+	got := prometheusLabels(map[string]string{"Step": "testSecret", "Feature": `Foo"Bar\`})
+	want := `{feature="Foo\"Bar\\",step="testSecret"}`
+	if got != want {
+		t.Errorf("prometheusLabels() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,98 @@
+package lambda
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// requestIDLoggingClient logs the request IDs of the AWS Secretsmanager API calls, e.g. for support escalation.
+type requestIDLoggingClient struct {
+	client SecretsmanagerClient
+}
+
+func (c requestIDLoggingClient) GetSecretValue(
+	ctx context.Context, input *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.GetSecretValueOutput, error) {
+	o, err := c.client.GetSecretValue(ctx, input, optFns...)
+	var metadata middleware.Metadata
+	if o != nil {
+		metadata = o.ResultMetadata
+	}
+	logRequestID("GetSecretValue", metadata, err)
+	return o, err
+}
+
+func (c requestIDLoggingClient) PutSecretValue(
+	ctx context.Context, input *secretsmanager.PutSecretValueInput, optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.PutSecretValueOutput, error) {
+	o, err := c.client.PutSecretValue(ctx, input, optFns...)
+	var metadata middleware.Metadata
+	if o != nil {
+		metadata = o.ResultMetadata
+	}
+	logRequestID("PutSecretValue", metadata, err)
+	return o, err
+}
+
+func (c requestIDLoggingClient) DescribeSecret(
+	ctx context.Context, input *secretsmanager.DescribeSecretInput, optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.DescribeSecretOutput, error) {
+	o, err := c.client.DescribeSecret(ctx, input, optFns...)
+	var metadata middleware.Metadata
+	if o != nil {
+		metadata = o.ResultMetadata
+	}
+	logRequestID("DescribeSecret", metadata, err)
+	return o, err
+}
+
+func (c requestIDLoggingClient) UpdateSecretVersionStage(
+	ctx context.Context, input *secretsmanager.UpdateSecretVersionStageInput,
+	optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.UpdateSecretVersionStageOutput, error) {
+	o, err := c.client.UpdateSecretVersionStage(ctx, input, optFns...)
+	var metadata middleware.Metadata
+	if o != nil {
+		metadata = o.ResultMetadata
+	}
+	logRequestID("UpdateSecretVersionStage", metadata, err)
+	return o, err
+}
+
+func (c requestIDLoggingClient) TagResource(
+	ctx context.Context, input *secretsmanager.TagResourceInput, optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.TagResourceOutput, error) {
+	client, ok := c.client.(SecretsmanagerTaggingClient)
+	if !ok {
+		return nil, errors.New("SecretsmanagerClient does not implement SecretsmanagerTaggingClient")
+	}
+	o, err := client.TagResource(ctx, input, optFns...)
+	var metadata middleware.Metadata
+	if o != nil {
+		metadata = o.ResultMetadata
+	}
+	logRequestID("TagResource", metadata, err)
+	return o, err
+}
+
+// requestID returns the AWS request ID of the API call from the result's metadata, or from the call's error.
+func requestID(metadata middleware.Metadata, err error) (string, bool) {
+	var e *awshttp.ResponseError
+	if errors.As(err, &e) && e.ServiceRequestID() != "" {
+		return e.ServiceRequestID(), true
+	}
+	return awsmiddleware.GetRequestIDMetadata(metadata)
+}
+
+// logRequestID logs the request ID of the API call at debug level if it's known.
+func logRequestID(operation string, metadata middleware.Metadata, err error) {
+	if id, ok := requestID(metadata, err); ok {
+		log.Println("[DEBUG] " + operation + " request ID: " + id)
+	}
+}
@@ -0,0 +1,133 @@
+package lambda
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/smithy-go/middleware"
+	smithyHttp "github.com/aws/smithy-go/transport/http"
+)
+
+// mockRequestIDClient returns the request ID with the results and the errors.
+type mockRequestIDClient struct {
+	*mockSecretsmanagerClient
+}
+
+func (m mockRequestIDClient) DescribeSecret(
+	ctx context.Context, input *secretsmanager.DescribeSecretInput, optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.DescribeSecretOutput, error) {
+	o, err := m.mockSecretsmanagerClient.DescribeSecret(ctx, input, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	awsmiddleware.SetRequestIDMetadata(&o.ResultMetadata, "describe-request-id")
+	return o, nil
+}
+
+func (m mockRequestIDClient) GetSecretValue(
+	context.Context, *secretsmanager.GetSecretValueInput, ...func(*secretsmanager.Options),
+) (*secretsmanager.GetSecretValueOutput, error) {
+	return nil, &awshttp.ResponseError{
+		ResponseError: &smithyHttp.ResponseError{
+			Response: &smithyHttp.Response{Response: &http.Response{StatusCode: http.StatusBadRequest}},
+			Err:      errors.New("no secret found"),
+		},
+		RequestID: "get-request-id",
+	}
+}
+
+func Test_requestID(t *testing.T) {
+	var metadata middleware.Metadata
+	awsmiddleware.SetRequestIDMetadata(&metadata, "foo")
+
+	errResponse := &awshttp.ResponseError{ResponseError: &smithyHttp.ResponseError{}, RequestID: "bar"}
+
+	tests := []struct {
+		name     string
+		metadata middleware.Metadata
+		err      error
+		want     string
+		wantOK   bool
+	}{
+		{
+			name: "no request ID",
+		},
+		{
+			name:     "request ID of the result",
+			metadata: metadata,
+			want:     "foo",
+			wantOK:   true,
+		},
+		{
+			name:   "request ID of the error",
+			err:    fmt.Errorf("foo: %w", errResponse),
+			want:   "bar",
+			wantOK: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, ok := requestID(tt.metadata, tt.err)
+				if got != tt.want || ok != tt.wantOK {
+					t.Errorf("requestID() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+				}
+			},
+		)
+	}
+}
+
+func TestNewHandler_LogRequestIDs(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	h, err := NewHandler(
+		Config{
+			SecretsmanagerClient: mockRequestIDClient{
+				&mockSecretsmanagerClient{
+					secretAWSCurrent: placeholderSecretUserStr,
+					secretByID:       map[string]map[string]string{"bar": {"AWSPENDING": placeholderSecretUserStr}},
+					rotationEnabled:  aws.Bool(true),
+				},
+			},
+			ServiceClient: &mockDBClient{},
+			SecretObj:     &mockObj{},
+			LogRequestIDs: true,
+			Metrics:       NoopMetrics{},
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewHandler() unexpected error = %v", err)
+	}
+
+	if err := h(
+		context.TODO(), SecretsmanagerTriggerPayload{
+			SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			Token:     "bar",
+			Step:      "createSecret",
+		},
+	); err == nil {
+		t.Fatalf("handler() expected error")
+	}
+
+	for _, want := range []string{
+		"[DEBUG] DescribeSecret request ID: describe-request-id",
+		"[DEBUG] GetSecretValue request ID: get-request-id",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("logs do not contain %q: %s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package lambda
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+const (
+	// defaultRetryerMaxAttempts the maximum number of attempts of the API call by DefaultRetryer.
+	defaultRetryerMaxAttempts = 5
+
+	// defaultRetryerMaxBackoff the maximum delay between the attempts by DefaultRetryer.
+	defaultRetryerMaxBackoff = 10 * time.Second
+)
+
+// DefaultRetryer returns the SDK's standard retryer with 5 attempts and the backoff of at most 10 seconds,
+// e.g. to tolerate the throttling of the API calls when many secrets are rotated at the same time.
+func DefaultRetryer() aws.Retryer {
+	return retry.NewStandard(
+		func(o *retry.StandardOptions) {
+			o.MaxAttempts = defaultRetryerMaxAttempts
+			o.MaxBackoff = defaultRetryerMaxBackoff
+		},
+	)
+}
+
+// retryerClient sets the retryer to every AWS Secretsmanager API call.
+type retryerClient struct {
+	client  SecretsmanagerClient
+	retryer aws.Retryer
+}
+
+func (c retryerClient) withRetryer(optFns []func(*secretsmanager.Options)) []func(*secretsmanager.Options) {
+	return append(optFns, func(o *secretsmanager.Options) { o.Retryer = retryRecorder{Retryer: c.retryer} })
+}
+
+// retryRecorder records the retried API calls of the invocation, see RecordRetry.
+type retryRecorder struct {
+	aws.Retryer
+}
+
+func (r retryRecorder) GetRetryToken(ctx context.Context, opErr error) (func(error) error, error) {
+	RecordRetry(ctx)
+	return r.Retryer.GetRetryToken(ctx, opErr)
+}
+
+func (c retryerClient) GetSecretValue(
+	ctx context.Context, input *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.GetSecretValueOutput, error) {
+	return c.client.GetSecretValue(ctx, input, c.withRetryer(optFns)...)
+}
+
+func (c retryerClient) PutSecretValue(
+	ctx context.Context, input *secretsmanager.PutSecretValueInput, optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.PutSecretValueOutput, error) {
+	return c.client.PutSecretValue(ctx, input, c.withRetryer(optFns)...)
+}
+
+func (c retryerClient) DescribeSecret(
+	ctx context.Context, input *secretsmanager.DescribeSecretInput, optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.DescribeSecretOutput, error) {
+	return c.client.DescribeSecret(ctx, input, c.withRetryer(optFns)...)
+}
+
+func (c retryerClient) UpdateSecretVersionStage(
+	ctx context.Context, input *secretsmanager.UpdateSecretVersionStageInput,
+	optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.UpdateSecretVersionStageOutput, error) {
+	return c.client.UpdateSecretVersionStage(ctx, input, c.withRetryer(optFns)...)
+}
+
+func (c retryerClient) TagResource(
+	ctx context.Context, input *secretsmanager.TagResourceInput, optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.TagResourceOutput, error) {
+	client, ok := c.client.(SecretsmanagerTaggingClient)
+	if !ok {
+		return nil, errors.New("SecretsmanagerClient does not implement SecretsmanagerTaggingClient")
+	}
+	return client.TagResource(ctx, input, c.withRetryer(optFns)...)
+}
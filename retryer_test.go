@@ -0,0 +1,132 @@
+package lambda
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// mockThrottlingHTTPClient responds to every request with the throttling error.
+type mockThrottlingHTTPClient struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (m *mockThrottlingHTTPClient) Do(*http.Request) (*http.Response, error) {
+	m.mu.Lock()
+	m.calls++
+	m.mu.Unlock()
+	return &http.Response{
+		StatusCode: http.StatusBadRequest,
+		Header:     http.Header{"Content-Type": []string{"application/x-amz-json-1.1"}},
+		Body:       io.NopCloser(strings.NewReader(`{"__type":"ThrottlingException","message":"Rate exceeded"}`)),
+	}, nil
+}
+
+// mockRetryer retries the errors according to retryable without delay.
+type mockRetryer struct {
+	retryable bool
+	checked   []error
+}
+
+func (m *mockRetryer) IsErrorRetryable(err error) bool {
+	m.checked = append(m.checked, err)
+	return m.retryable
+}
+
+func (m *mockRetryer) MaxAttempts() int {
+	return 3
+}
+
+func (m *mockRetryer) RetryDelay(int, error) (time.Duration, error) {
+	return 0, nil
+}
+
+func (m *mockRetryer) GetRetryToken(context.Context, error) (func(error) error, error) {
+	return func(error) error { return nil }, nil
+}
+
+func (m *mockRetryer) GetInitialToken() func(error) error {
+	return func(error) error { return nil }
+}
+
+func Test_retryerClient(t *testing.T) {
+	tests := []struct {
+		name      string
+		retryable bool
+		wantCalls int
+	}{
+		{
+			name:      "throttled call is retried",
+			retryable: true,
+			wantCalls: 3,
+		},
+		{
+			name:      "throttled call is not retried",
+			retryable: false,
+			wantCalls: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				httpClient := &mockThrottlingHTTPClient{}
+				retryer := &mockRetryer{retryable: tt.retryable}
+
+				c := retryerClient{
+					client: secretsmanager.New(
+						secretsmanager.Options{
+							Region:      "us-east-1",
+							Credentials: aws.AnonymousCredentials{},
+							HTTPClient:  httpClient,
+						},
+					),
+					retryer: retryer,
+				}
+
+				input := &secretsmanager.DescribeSecretInput{SecretId: aws.String("foo")}
+				if _, err := c.DescribeSecret(context.TODO(), input); err == nil {
+					t.Fatal("DescribeSecret() expected error")
+				}
+
+				if httpClient.calls != tt.wantCalls {
+					t.Errorf("DescribeSecret() calls = %d, want %d", httpClient.calls, tt.wantCalls)
+				}
+				if len(retryer.checked) == 0 || !strings.Contains(retryer.checked[0].Error(), "ThrottlingException") {
+					t.Errorf("retryer checked the errors %v, want ThrottlingException", retryer.checked)
+				}
+			},
+		)
+	}
+}
+
+func TestDefaultRetryer(t *testing.T) {
+	r := DefaultRetryer()
+	if r.MaxAttempts() != defaultRetryerMaxAttempts {
+		t.Errorf("MaxAttempts() = %d, want %d", r.MaxAttempts(), defaultRetryerMaxAttempts)
+	}
+	if !r.IsErrorRetryable(&mockThrottlingError{}) {
+		t.Errorf("IsErrorRetryable() shall retry the throttling error")
+	}
+	if r.IsErrorRetryable(errors.New("foo")) {
+		t.Errorf("IsErrorRetryable() shall not retry the unknown error")
+	}
+}
+
+type mockThrottlingError struct{}
+
+func (*mockThrottlingError) Error() string {
+	return "throttled"
+}
+
+func (*mockThrottlingError) ErrorCode() string {
+	return "ThrottlingException"
+}
@@ -0,0 +1,60 @@
+package lambda
+
+import (
+	"context"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// VersionStagesMetadataKey the key of the DescribeSecret output's ResultMetadata with the secret's version stages
+// of the type map[string][]string. It's read if the typed field VersionIdsToStages is not populated, e.g. when
+// the stages are set by the custom middleware for the SDK versions which do not deserialize the field.
+type VersionStagesMetadataKey struct{}
+
+// versionIdsToStages returns the stages of the secret's versions: the typed field VersionIdsToStages is preferred,
+// the output's ResultMetadata is read otherwise. nil is returned if neither source has the stages.
+func versionIdsToStages(v *secretsmanager.DescribeSecretOutput) map[string][]string {
+	if v == nil {
+		return nil
+	}
+	if len(v.VersionIdsToStages) > 0 {
+		return v.VersionIdsToStages
+	}
+	if stages, ok := v.ResultMetadata.Get(VersionStagesMetadataKey{}).(map[string][]string); ok && len(stages) > 0 {
+		return stages
+	}
+	return nil
+}
+
+// currentVersionStages returns the stages of the secret's version labeled with the stage AWSCURRENT.
+// It's the fallback to find the current version when DescribeSecret returns no stages, nil is returned
+// if the current version cannot be found either.
+func currentVersionStages(
+	ctx context.Context, client SecretsmanagerClient, secretARN string, debug bool,
+) map[string][]string {
+	o, err := getSecretValue(ctx, client, secretARN, StageCurrent, "")
+	if err != nil || o == nil || aws.ToString(o.VersionId) == "" {
+		if debug {
+			log.Println("[DEBUG] the version at the stage AWSCURRENT is not found")
+		}
+		return nil
+	}
+
+	stages := o.VersionStages
+	if !hasStage(stages, StageCurrent) {
+		stages = append(stages, StageCurrent)
+	}
+	return map[string][]string{*o.VersionId: stages}
+}
+
+// versionAtStage returns the ID of the secret's version labeled with the stage, or empty string if none.
+func versionAtStage(versionIdsToStages map[string][]string, stage string) string {
+	for version, stages := range versionIdsToStages {
+		if hasStage(stages, stage) {
+			return version
+		}
+	}
+	return ""
+}
@@ -0,0 +1,172 @@
+package lambda
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// mockStagesSourceClient returns the version stages of DescribeSecret from the typed field,
+// and/or from the ResultMetadata.
+type mockStagesSourceClient struct {
+	*mockSecretsmanagerClient
+	typed, metadata bool
+}
+
+func (m mockStagesSourceClient) DescribeSecret(
+	ctx context.Context, input *secretsmanager.DescribeSecretInput, optFns ...func(*secretsmanager.Options),
+) (*secretsmanager.DescribeSecretOutput, error) {
+	o, err := m.mockSecretsmanagerClient.DescribeSecret(ctx, input, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	if m.metadata {
+		o.ResultMetadata.Set(VersionStagesMetadataKey{}, o.VersionIdsToStages)
+	}
+	if !m.typed {
+		o.VersionIdsToStages = nil
+	}
+	return o, nil
+}
+
+func Test_versionIdsToStages(t *testing.T) {
+	typed := map[string][]string{"foo": {StageCurrent}}
+	metadata := map[string][]string{"bar": {StageCurrent}}
+
+	tests := []struct {
+		name     string
+		typed    map[string][]string
+		metadata any
+		want     map[string][]string
+	}{
+		{
+			name:     "typed field is preferred",
+			typed:    typed,
+			metadata: metadata,
+			want:     typed,
+		},
+		{
+			name:  "typed field only",
+			typed: typed,
+			want:  typed,
+		},
+		{
+			name:     "metadata only",
+			metadata: metadata,
+			want:     metadata,
+		},
+		{
+			name:     "metadata of unexpected type is ignored",
+			metadata: "foo",
+		},
+		{
+			name: "neither source",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				v := &secretsmanager.DescribeSecretOutput{VersionIdsToStages: tt.typed}
+				if tt.metadata != nil {
+					v.ResultMetadata.Set(VersionStagesMetadataKey{}, tt.metadata)
+				}
+				if got := versionIdsToStages(v); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("versionIdsToStages() = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func Test_finishSecret_VersionStagesSource(t *testing.T) {
+	tests := []struct {
+		name            string
+		typed, metadata bool
+		token           string
+		wantMoved       bool
+	}{
+		{
+			name:      "typed field and metadata",
+			typed:     true,
+			metadata:  true,
+			token:     "bar",
+			wantMoved: true,
+		},
+		{
+			name:      "typed field only",
+			typed:     true,
+			token:     "bar",
+			wantMoved: true,
+		},
+		{
+			name:      "metadata only",
+			metadata:  true,
+			token:     "bar",
+			wantMoved: true,
+		},
+		{
+			name:      "neither source, the current version is fetched",
+			token:     "bar",
+			wantMoved: true,
+		},
+		{
+			name:     "metadata only, resumed with the token at the stage AWSCURRENT",
+			metadata: true,
+			token:    "foo",
+		},
+		{
+			name:  "neither source, resumed with the token at the stage AWSCURRENT",
+			token: "foo",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				client := &mockSecretsmanagerClient{
+					secretAWSCurrent:          placeholderSecretUserStr,
+					secretAWSCurrentVersionID: "foo",
+					secretByID: map[string]map[string]string{
+						"foo": {StageCurrent: placeholderSecretUserStr},
+						"bar": {StagePending: placeholderSecretUserNewStr},
+					},
+				}
+				cfg := Config{
+					SecretsmanagerClient: mockStagesSourceClient{
+						mockSecretsmanagerClient: client, typed: tt.typed, metadata: tt.metadata,
+					},
+					ServiceClient: &mockDBClient{},
+					SecretObj:     &mockObj{},
+				}
+				event := SecretsmanagerTriggerPayload{
+					SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+					Token:     tt.token,
+					Step:      "finishSecret",
+				}
+				if err := finishSecret(context.TODO(), event, cfg); err != nil {
+					t.Fatalf("finishSecret() unexpected error = %v", err)
+				}
+
+				if !tt.wantMoved {
+					if len(client.updateSecretVersionStageInputs) > 0 {
+						t.Errorf("finishSecret() shall not move the stage AWSCURRENT")
+					}
+					return
+				}
+
+				if len(client.updateSecretVersionStageInputs) == 0 {
+					t.Fatalf("finishSecret() shall move the stage AWSCURRENT")
+				}
+				input := client.updateSecretVersionStageInputs[0]
+				if aws.ToString(input.MoveToVersionId) != "bar" || aws.ToString(input.RemoveFromVersionId) != "foo" {
+					t.Errorf(
+						"finishSecret() moved AWSCURRENT from %s to %s, want from foo to bar",
+						aws.ToString(input.RemoveFromVersionId), aws.ToString(input.MoveToVersionId),
+					)
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,75 @@
+package lambda
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// invocationSummary the structured summary of the invocation logged when it completes, see Config.LogSummary.
+type invocationSummary struct {
+	Step       string   `json:"step"`
+	Outcome    string   `json:"outcome"`
+	DurationMS float64  `json:"duration_ms"`
+	Secret     string   `json:"secret"`
+	Features   []string `json:"features"`
+	Retries    int      `json:"retries"`
+}
+
+const (
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+)
+
+// RecordRetry records the retried call of the invocation, e.g. the secret's test retried by the ServiceClient.
+// The retries are reported by the invocation's summary, see Config.LogSummary.
+// The call is no-op if the context does not belong to the handler's invocation.
+func RecordRetry(ctx context.Context) {
+	r, ok := ctx.Value(featureRecorderCtxKey{}).(*featureRecorder)
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.retries++
+}
+
+// logSummary logs the invocation's summary as a single line: "[INFO] summary: " followed by the JSON object.
+// The summary never includes the secret's value.
+func logSummary(event SecretsmanagerTriggerPayload, d time.Duration, features *featureRecorder, err error) {
+	o := invocationSummary{
+		Step:       event.Step,
+		Outcome:    outcomeSuccess,
+		DurationMS: milliseconds(d),
+		Secret:     secretShortName(event.SecretARN),
+		Features:   features.list(),
+		Retries:    features.retryCount(),
+	}
+	if err != nil {
+		o.Outcome = outcomeFailure
+	}
+
+	b, errMarshal := json.Marshal(o)
+	if errMarshal != nil {
+		log.Println("[ERROR] failed to serialise the invocation's summary: " + errMarshal.Error())
+		return
+	}
+	log.Println("[INFO] summary: " + string(b))
+}
+
+// secretARNSuffix the random suffix appended by AWS Secretsmanager to the secret's name in its ARN.
+var secretARNSuffix = regexp.MustCompile(`-[a-zA-Z0-9]{6}$`)
+
+// secretShortName returns the secret's name from its ARN, e.g. foo/bar for
+// arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8. The input is returned as is if it's not ARN.
+func secretShortName(secretARN string) string {
+	parts := strings.SplitN(secretARN, ":", 7)
+	if len(parts) < 7 || parts[0] != "arn" || parts[5] != "secret" {
+		return secretARN
+	}
+	return secretARNSuffix.ReplaceAllString(parts[6], "")
+}
@@ -0,0 +1,125 @@
+package lambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// mockRetryingClient reports the retries of the secret's set.
+type mockRetryingClient struct {
+	mockDBClient
+	retries int
+}
+
+func (m *mockRetryingClient) Set(ctx context.Context, secretCurrent, secretPending, secretPrevious any) error {
+	for i := 0; i < m.retries; i++ {
+		RecordRetry(ctx)
+	}
+	return m.mockDBClient.Set(ctx, secretCurrent, secretPending, secretPrevious)
+}
+
+func TestNewHandler_LogSummary(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	h, err := NewHandler(
+		Config{
+			SecretsmanagerClient: &mockSecretsmanagerClient{
+				secretAWSCurrent: placeholderSecretUserStr,
+				secretByID: map[string]map[string]string{
+					"foo": {"AWSCURRENT": placeholderSecretUserStr},
+					"bar": {"AWSPENDING": placeholderSecretUserNewStr},
+				},
+				rotationEnabled: aws.Bool(true),
+			},
+			ServiceClient: &mockRetryingClient{retries: 2},
+			SecretObj:     &mockObj{},
+			Metrics:       NoopMetrics{},
+			LogSummary:    true,
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewHandler() unexpected error = %v", err)
+	}
+
+	if err := h(
+		context.TODO(), SecretsmanagerTriggerPayload{
+			SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			Token:     "bar",
+			Step:      "setSecret",
+		},
+	); err != nil {
+		t.Fatalf("handler() unexpected error = %v", err)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if _, v, ok := strings.Cut(line, "[INFO] summary: "); ok {
+			lines = append(lines, v)
+		}
+	}
+	if len(lines) != 1 {
+		t.Fatalf("summary logged %d times, want 1: %s", len(lines), buf.String())
+	}
+	if strings.Contains(lines[0], placeholderPassword) {
+		t.Errorf("summary contains the secret's value: %s", lines[0])
+	}
+
+	var got invocationSummary
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatalf("faulty summary %s: %v", lines[0], err)
+	}
+	if got.DurationMS < 0 {
+		t.Errorf("summary duration = %v, want non-negative", got.DurationMS)
+	}
+	got.DurationMS = 0
+
+	want := invocationSummary{
+		Step:     "setSecret",
+		Outcome:  outcomeSuccess,
+		Secret:   "foo/bar",
+		Features: []string{},
+		Retries:  2,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("summary = %+v, want %+v", got, want)
+	}
+}
+
+func Test_secretShortName(t *testing.T) {
+	tests := []struct {
+		secretARN string
+		want      string
+	}{
+		{
+			secretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			want:      "foo/bar",
+		},
+		{
+			secretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo:bar-5BKPC8",
+			want:      "foo:bar",
+		},
+		{
+			secretARN: "foo/bar",
+			want:      "foo/bar",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.secretARN, func(t *testing.T) {
+				if got := secretShortName(tt.secretARN); got != tt.want {
+					t.Errorf("secretShortName() = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
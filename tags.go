@@ -0,0 +1,67 @@
+package lambda
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+type secretTagsCtxKey struct{}
+
+// secretTagsLoader fetches the secret's tags on the first call.
+type secretTagsLoader struct {
+	once sync.Once
+
+	client    SecretsmanagerClient
+	secretARN string
+
+	tags map[string]string
+	err  error
+}
+
+func (l *secretTagsLoader) load(ctx context.Context) (map[string]string, error) {
+	l.once.Do(
+		func() {
+			v, err := l.client.DescribeSecret(
+				ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(l.secretARN)},
+			)
+			if err != nil {
+				l.err = fmt.Errorf("describe secret %s: %w", l.secretARN, err)
+				return
+			}
+
+			l.tags = make(map[string]string, len(v.Tags))
+			for _, tag := range v.Tags {
+				l.tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+			}
+		},
+	)
+	return l.tags, l.err
+}
+
+// SecretTagsFromContext returns the tags of the rotated secret, e.g. to read the attributes missing in the secret's
+// value. The tags are fetched from AWS Secretsmanager on the first call, hence the ServiceClient which does not read
+// the tags does not incur the API call. Nil tags are returned if the context has no secret.
+func SecretTagsFromContext(ctx context.Context) (map[string]string, error) {
+	switch v := ctx.Value(secretTagsCtxKey{}).(type) {
+	case *secretTagsLoader:
+		return v.load(ctx)
+	case map[string]string:
+		return v, nil
+	default:
+		return nil, nil
+	}
+}
+
+// WithSecretTags sets the secret's tags to the context, e.g. to test the ServiceClient, or to invoke it directly.
+func WithSecretTags(ctx context.Context, tags map[string]string) context.Context {
+	return context.WithValue(ctx, secretTagsCtxKey{}, tags)
+}
+
+// withSecretTagsLoader sets the loader of the secret's tags to the context.
+func withSecretTagsLoader(ctx context.Context, client SecretsmanagerClient, secretARN string) context.Context {
+	return context.WithValue(ctx, secretTagsCtxKey{}, &secretTagsLoader{client: client, secretARN: secretARN})
+}
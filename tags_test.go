@@ -0,0 +1,136 @@
+package lambda
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+func TestSecretTagsFromContext(t *testing.T) {
+	client := &mockSecretsmanagerClient{
+		secretAWSCurrent: placeholderSecretUserStr,
+		tags:             []types.Tag{{Key: aws.String("project_id"), Value: aws.String("baz")}},
+	}
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "no tags in the context",
+			ctx:  context.TODO(),
+		},
+		{
+			name: "tags set explicitly",
+			ctx:  WithSecretTags(context.TODO(), map[string]string{"foo": "bar"}),
+			want: map[string]string{"foo": "bar"},
+		},
+		{
+			name: "tags fetched from Secretsmanager",
+			ctx: withSecretTagsLoader(
+				context.TODO(), client, "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			),
+			want: map[string]string{"project_id": "baz"},
+		},
+		{
+			name: "unhappy path: failed to fetch tags",
+			ctx: withSecretTagsLoader(
+				context.TODO(), &mockSecretsmanagerClient{},
+				"arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := SecretTagsFromContext(tt.ctx)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("SecretTagsFromContext() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("SecretTagsFromContext() got = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+// mockTagsFallbackClient sets the project ID missing in the secret from the secret's tags, and records it.
+type mockTagsFallbackClient struct {
+	mockDBClient
+	projectIDs []string
+}
+
+func (m *mockTagsFallbackClient) Create(ctx context.Context, secret any) error {
+	s := secret.(*mockObj)
+	if s.ProjectID == "" {
+		tags, err := SecretTagsFromContext(ctx)
+		if err != nil {
+			return err
+		}
+		s.ProjectID = tags["project_id"]
+	}
+	m.projectIDs = append(m.projectIDs, s.ProjectID)
+	s.Password = "new-password-" + s.ProjectID
+	return nil
+}
+
+func TestNewHandler_SecretObjNotShared(t *testing.T) {
+	client := &mockSecretsmanagerClient{
+		rotationEnabled: aws.Bool(true),
+		// the stages are not returned with the secret value, hence createSecret does not skip
+		emptyVersionStages: true,
+	}
+	serviceClient := &mockTagsFallbackClient{}
+
+	h, err := NewHandler(
+		Config{
+			SecretsmanagerClient: client,
+			ServiceClient:        serviceClient,
+			SecretObj:            &mockObj{},
+			Metrics:              NoopMetrics{},
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewHandler() unexpected error = %v", err)
+	}
+
+	for _, secret := range []struct {
+		arn, value string
+		tags       []types.Tag
+	}{
+		{
+			arn:   "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			value: `{"user":"bar","password":"quxx","host":"dev","project_id":"projA","dbname":"dbA"}`,
+		},
+		{
+			arn:   "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/baz-5BKPC8",
+			value: `{"user":"baz","password":"quxx","host":"dev","dbname":"dbB"}`,
+			tags:  []types.Tag{{Key: aws.String("project_id"), Value: aws.String("projB")}},
+		},
+	} {
+		client.secretAWSCurrent = secret.value
+		client.secretByID = map[string]map[string]string{
+			"foo": {"AWSCURRENT": secret.value},
+			// Secrets Manager stages the version to rotate before the rotation starts
+			"bar": {"AWSPENDING": secret.value},
+		}
+		client.tags = secret.tags
+
+		if err := h(
+			context.TODO(), SecretsmanagerTriggerPayload{SecretARN: secret.arn, Token: "bar", Step: "createSecret"},
+		); err != nil {
+			t.Fatalf("handler() secret %s unexpected error = %v", secret.arn, err)
+		}
+	}
+
+	if want := []string{"projA", "projB"}; !reflect.DeepEqual(serviceClient.projectIDs, want) {
+		t.Errorf("the secrets' project IDs = %v, want %v", serviceClient.projectIDs, want)
+	}
+}
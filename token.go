@@ -0,0 +1,19 @@
+package lambda
+
+import "context"
+
+type rotationTokenCtxKey struct{}
+
+// RotationTokenFromContext returns the token of the rotation, i.e. the version ID of the pending secret.
+// The token is the same for the retried invocations of the step, hence it allows the ServiceClient to make
+// the side effects of Create idempotent, e.g. to derive the name of the created resource. Empty string is returned
+// if the context has no token.
+func RotationTokenFromContext(ctx context.Context) string {
+	v, _ := ctx.Value(rotationTokenCtxKey{}).(string)
+	return v
+}
+
+// WithRotationToken sets the rotation token to the context, e.g. to test the ServiceClient, or to invoke it directly.
+func WithRotationToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, rotationTokenCtxKey{}, token)
+}
@@ -0,0 +1,61 @@
+package lambda
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// mockTokenRecordingClient records the rotation token passed to Test.
+type mockTokenRecordingClient struct {
+	mockDBClient
+	token string
+}
+
+func (m *mockTokenRecordingClient) Test(ctx context.Context, _ any) error {
+	m.token = RotationTokenFromContext(ctx)
+	return nil
+}
+
+func TestRotationTokenFromContext(t *testing.T) {
+	if got := RotationTokenFromContext(context.TODO()); got != "" {
+		t.Errorf("RotationTokenFromContext() = %s, want empty string", got)
+	}
+	if got := RotationTokenFromContext(WithRotationToken(context.TODO(), "bar")); got != "bar" {
+		t.Errorf("RotationTokenFromContext() = %s, want bar", got)
+	}
+
+	client := &mockTokenRecordingClient{}
+	handler, err := NewHandler(
+		Config{
+			SecretsmanagerClient: &mockSecretsmanagerClient{
+				secretAWSCurrent: placeholderSecretUserStr,
+				secretByID: map[string]map[string]string{
+					"foo": {"AWSCURRENT": placeholderSecretUserStr},
+					"bar": {"AWSPENDING": placeholderSecretUserNewStr},
+				},
+				rotationEnabled: aws.Bool(true),
+			},
+			ServiceClient: client,
+			SecretObj:     &mockObj{},
+			Metrics:       NoopMetrics{},
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewHandler() unexpected error = %v", err)
+	}
+
+	if err := handler(
+		context.TODO(), SecretsmanagerTriggerPayload{
+			SecretARN: "arn:aws:secretsmanager:us-east-1:000000000000:secret:foo/bar-5BKPC8",
+			Token:     "bar",
+			Step:      "testSecret",
+		},
+	); err != nil {
+		t.Fatalf("handler(ctx, event) unexpected error = %v", err)
+	}
+	if client.token != "bar" {
+		t.Errorf("the ServiceClient got the token %s, want bar", client.token)
+	}
+}